@@ -0,0 +1,370 @@
+package gitgo
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/Vikuuu/gitgo/internal/diff"
+)
+
+var ErrNoSuchPath = errors.New("no such path")
+
+// BlameOptions pick what Blame looks at.
+type BlameOptions struct {
+	// Rev is the revision the file is blamed at, HEAD when empty.
+	Rev string
+	// Start and End, counted from 1, restrict the blame to those lines,
+	// up to the end of the file when End is 0.
+	Start, End int
+	// IgnoreWhitespace compares the lines without their whitespace, a
+	// line only reindented staying with the commit that wrote it.
+	IgnoreWhitespace bool
+}
+
+// BlameLine is a line of the blamed file along with the commit it comes
+// from, where it was line OrigLine of the file Path.
+type BlameLine struct {
+	Line     int
+	Text     string
+	Commit   string
+	Path     string
+	OrigLine int
+}
+
+// BlameCommit is a commit lines are blamed on. Boundary is set for a
+// commit whose parents are not looked at, a root or shallow commit.
+// Previous and PreviousPath are the parent the file was in before it,
+// if any.
+type BlameCommit struct {
+	Commit       *Commit
+	Author       Signature
+	Committer    Signature
+	Boundary     bool
+	Previous     string
+	PreviousPath string
+}
+
+// Blame is the origin of every line of a file.
+type Blame struct {
+	Path    string
+	Lines   []BlameLine
+	Commits map[string]*BlameCommit
+}
+
+// blameSuspect is the version of a file in a commit that lines of the
+// blamed file may come from. lines are the indexes of those lines in
+// the blamed file and origins the indexes in this version.
+type blameSuspect struct {
+	oid     string
+	commit  *Commit
+	path    string
+	blob    string
+	lines   []int
+	origins []int
+}
+
+// Blame finds for every line of the file at path, absolute or relative
+// to the workspace, the commit that last changed it. Going down from
+// opts.Rev, the lines a commit has in common with a parent are passed
+// on to that parent, the others are its own. A file that is not in a
+// parent under its name is looked for among the files the commit
+// removed, as it may have been renamed.
+func (r Repository) Blame(path string, opts BlameOptions) (*Blame, error) {
+	path, err := r.relPath(path)
+	if err != nil {
+		return nil, err
+	}
+	oid, err := r.ResolveRevision(cmp.Or(opts.Rev, "HEAD"))
+	if err != nil {
+		return nil, err
+	}
+	db := r.ObjectDatabase()
+	start, err := newBlameSuspect(db, oid, path)
+	if err != nil {
+		return nil, err
+	}
+	if start == nil {
+		return nil, fmt.Errorf("%w '%s' in %s", ErrNoSuchPath, path, cmp.Or(opts.Rev, "HEAD"))
+	}
+	text, err := readBlameLines(db, start.blob)
+	if err != nil {
+		return nil, err
+	}
+	first, end := max(opts.Start, 1), len(text)
+	if opts.End > 0 {
+		first, end = min(first, opts.End), max(first, opts.End)
+	}
+	if end > len(text) || first > end && first > 1 {
+		return nil, fmt.Errorf("file %s has only %d lines", path, len(text))
+	}
+	for i := first - 1; i < end; i++ {
+		start.lines = append(start.lines, i)
+		start.origins = append(start.origins, i)
+	}
+
+	b := &Blame{Path: path, Commits: make(map[string]*BlameCommit)}
+	blamed := make(map[int]BlameLine)
+	queue := []*blameSuspect{start}
+	for len(queue) > 0 {
+		// the most recent commit first, so that the lines passed to a
+		// commit by all its children are looked at together
+		i, err := latestSuspect(queue)
+		if err != nil {
+			return nil, err
+		}
+		s := queue[i]
+		queue = slices.Delete(queue, i, i+1)
+
+		passed, info, err := passBlame(db, s, opts.IgnoreWhitespace)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range passed {
+			j := slices.IndexFunc(queue, func(q *blameSuspect) bool { return q.oid == p.oid && q.path == p.path })
+			if j < 0 {
+				queue = append(queue, p)
+				continue
+			}
+			queue[j].lines = append(queue[j].lines, p.lines...)
+			queue[j].origins = append(queue[j].origins, p.origins...)
+		}
+		if len(s.lines) == 0 {
+			continue
+		}
+		if b.Commits[s.oid] == nil {
+			b.Commits[s.oid] = info
+		}
+		for n, line := range s.lines {
+			blamed[line] = BlameLine{
+				Line:     line + 1,
+				Text:     text[line],
+				Commit:   s.oid,
+				Path:     s.path,
+				OrigLine: s.origins[n] + 1,
+			}
+		}
+	}
+	for i := first - 1; i < end; i++ {
+		b.Lines = append(b.Lines, blamed[i])
+	}
+	return b, nil
+}
+
+// newBlameSuspect returns the file path of the commit oid, nil when the
+// commit has no such file.
+func newBlameSuspect(db *Database, oid, path string) (*blameSuspect, error) {
+	commit, err := db.ReadCommit(oid)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := treeEntryAt(db, commit.Tree, path)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+	if entry.IsTree() || entry.Mode == gitlinkMode {
+		return nil, nil
+	}
+	return &blameSuspect{oid: oid, commit: commit, path: path, blob: entry.OID}, nil
+}
+
+// treeEntryAt returns the entry at path below the tree oid, nil when
+// there is none.
+func treeEntryAt(db *Database, oid, path string) (*TreeEntry, error) {
+	for {
+		name, rest, nested := strings.Cut(path, "/")
+		entries, err := db.ReadTree(oid)
+		if err != nil {
+			return nil, err
+		}
+		i := slices.IndexFunc(entries, func(e TreeEntry) bool { return e.Name == name })
+		switch {
+		case i < 0:
+			return nil, nil
+		case !nested:
+			return &entries[i], nil
+		case !entries[i].IsTree():
+			return nil, nil
+		}
+		oid, path = entries[i].OID, rest
+	}
+}
+
+func readBlameLines(db *Database, oid string) ([]string, error) {
+	data, err := db.readTyped(oid, TypeFile)
+	if err != nil {
+		return nil, err
+	}
+	return diff.Split(string(data)), nil
+}
+
+// latestSuspect returns the index of the suspect of the queue with the
+// most recent committer date.
+func latestSuspect(queue []*blameSuspect) (int, error) {
+	latest := 0
+	var when int64
+	for i, s := range queue {
+		committer, err := ParseSignature(s.commit.Committer)
+		if err != nil {
+			return 0, err
+		}
+		if i == 0 || committer.When.Unix() > when {
+			latest, when = i, committer.When.Unix()
+		}
+	}
+	return latest, nil
+}
+
+// passBlame moves the lines of s its parents have to suspects in them,
+// leaving in s the lines the commit brought, and describes the commit.
+func passBlame(db *Database, s *blameSuspect, ignoreWhitespace bool) ([]*blameSuspect, *BlameCommit, error) {
+	info := &BlameCommit{Commit: s.commit, Boundary: len(s.commit.Parents) == 0}
+	var err error
+	if info.Author, err = ParseSignature(s.commit.Author); err != nil {
+		return nil, nil, err
+	}
+	if info.Committer, err = ParseSignature(s.commit.Committer); err != nil {
+		return nil, nil, err
+	}
+
+	var passed []*blameSuspect
+	var lines []string
+	for _, parent := range s.commit.Parents {
+		if len(s.lines) == 0 {
+			break
+		}
+		p, err := findBlameOrigin(db, s, parent)
+		if err != nil {
+			return nil, nil, err
+		}
+		if p == nil {
+			continue
+		}
+		if info.Previous == "" {
+			info.Previous, info.PreviousPath = p.oid, p.path
+		}
+		passed = append(passed, p)
+		if p.blob == s.blob {
+			p.lines, p.origins = s.lines, s.origins
+			s.lines, s.origins = nil, nil
+			break
+		}
+
+		if lines == nil {
+			if lines, err = readBlameLines(db, s.blob); err != nil {
+				return nil, nil, err
+			}
+		}
+		parentLines, err := readBlameLines(db, p.blob)
+		if err != nil {
+			return nil, nil, err
+		}
+		// where every line of s is in the parent, -1 for a new one
+		inParent := make([]int, len(lines))
+		for i := range inParent {
+			inParent[i] = -1
+		}
+		for _, e := range diff.Lines(blameKeys(parentLines, ignoreWhitespace), blameKeys(lines, ignoreWhitespace)) {
+			if e.Op == diff.Equal {
+				inParent[e.B] = e.A
+			}
+		}
+		var keptLines, keptOrigins []int
+		for i, origin := range s.origins {
+			if a := inParent[origin]; a >= 0 {
+				p.lines = append(p.lines, s.lines[i])
+				p.origins = append(p.origins, a)
+			} else {
+				keptLines = append(keptLines, s.lines[i])
+				keptOrigins = append(keptOrigins, origin)
+			}
+		}
+		s.lines, s.origins = keptLines, keptOrigins
+	}
+	return passed, info, nil
+}
+
+// blameKeys returns the lines as compared, without their whitespace
+// when it is ignored.
+func blameKeys(lines []string, ignoreWhitespace bool) []string {
+	if !ignoreWhitespace {
+		return lines
+	}
+	keys := make([]string, len(lines))
+	for i, line := range lines {
+		keys[i] = strings.Map(func(r rune) rune {
+			if unicode.IsSpace(r) {
+				return -1
+			}
+			return r
+		}, line)
+	}
+	return keys
+}
+
+// findBlameOrigin returns the version of the file of s in the parent
+// commit, looking for it under its name then, if the commit removed
+// files, among them: the one with the same content or else the one
+// having the most lines in common with it, at least half of them.
+func findBlameOrigin(db *Database, s *blameSuspect, parent string) (*blameSuspect, error) {
+	p, err := newBlameSuspect(db, parent, s.path)
+	if err != nil || p != nil {
+		return p, err
+	}
+
+	parentCommit, err := db.ReadCommit(parent)
+	if err != nil {
+		return nil, err
+	}
+	parentFiles, err := db.ListTree(parentCommit.Tree)
+	if err != nil {
+		return nil, err
+	}
+	files, err := db.ListTree(s.commit.Tree)
+	if err != nil {
+		return nil, err
+	}
+	var removed []TreeEntry
+	for _, f := range parentFiles {
+		_, found := slices.BinarySearchFunc(files, f.Name, func(e TreeEntry, name string) int {
+			return strings.Compare(e.Name, name)
+		})
+		if !found && f.Mode != gitlinkMode {
+			if f.OID == s.blob {
+				return newBlameSuspect(db, parent, f.Name)
+			}
+			removed = append(removed, f)
+		}
+	}
+	if len(removed) == 0 {
+		return nil, nil
+	}
+
+	lines, err := readBlameLines(db, s.blob)
+	if err != nil {
+		return nil, err
+	}
+	best, bestScore := "", 0
+	for _, f := range removed {
+		old, err := readBlameLines(db, f.OID)
+		if err != nil {
+			return nil, err
+		}
+		common := 0
+		for _, e := range diff.Lines(old, lines) {
+			if e.Op == diff.Equal {
+				common++
+			}
+		}
+		if score := common * 100 / max(len(old), len(lines), 1); score >= 50 && score > bestScore {
+			best, bestScore = f.Name, score
+		}
+	}
+	if best == "" {
+		return nil, nil
+	}
+	return newBlameSuspect(db, parent, best)
+}
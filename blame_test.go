@@ -0,0 +1,126 @@
+package gitgo
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// blameCommits returns the commit of every line of the blame.
+func blameCommits(b *Blame) []string {
+	var oids []string
+	for _, line := range b.Lines {
+		oids = append(oids, line.Commit)
+	}
+	return oids
+}
+
+func TestBlame(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	assert.NoError(t, err)
+	first := commitFile(t, repo, "a.txt", "one\ntwo\nthree\n")
+	second := commitFile(t, repo, "a.txt", "one\n2\nthree\nfour\n")
+
+	b, err := repo.Blame("a.txt", BlameOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{first, second, first, second}, blameCommits(b))
+	assert.Equal(t, BlameLine{Line: 3, Text: "three", Commit: first, Path: "a.txt", OrigLine: 3}, b.Lines[2])
+	assert.Equal(t, BlameLine{Line: 4, Text: "four", Commit: second, Path: "a.txt", OrigLine: 4}, b.Lines[3])
+	assert.True(t, b.Commits[first].Boundary)
+	assert.False(t, b.Commits[second].Boundary)
+	assert.Equal(t, first, b.Commits[second].Previous)
+	assert.Equal(t, "a.txt", b.Commits[second].PreviousPath)
+
+	b, err = repo.Blame(filepath.Join(repo.Path, "a.txt"), BlameOptions{Start: 2, End: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{second, first}, blameCommits(b))
+	assert.Equal(t, 2, b.Lines[0].Line)
+	_, err = repo.Blame("a.txt", BlameOptions{Start: 3, End: 9})
+	assert.ErrorContains(t, err, "has only 4 lines")
+
+	// reindenting is a change unless whitespace is ignored
+	third := commitFile(t, repo, "a.txt", "  one\n2\nthree\nfour\n")
+	b, err = repo.Blame("a.txt", BlameOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, third, b.Lines[0].Commit)
+	b, err = repo.Blame("a.txt", BlameOptions{IgnoreWhitespace: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{first, second, first, second}, blameCommits(b))
+	assert.Equal(t, "  one", b.Lines[0].Text)
+
+	// an older revision
+	b, err = repo.Blame("a.txt", BlameOptions{Rev: second + "~1"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{first, first, first}, blameCommits(b))
+
+	_, err = repo.Blame("missing.txt", BlameOptions{})
+	assert.ErrorIs(t, err, ErrNoSuchPath)
+}
+
+func TestBlameFollowsRenames(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	assert.NoError(t, err)
+	first := commitFile(t, repo, "a.txt", "one\ntwo\nthree\nfour\n")
+
+	// a.txt becomes b.txt with a line changed
+	assert.NoError(t, os.Remove(filepath.Join(repo.Path, "a.txt")))
+	_, index, err := IndexHoldForUpdate(repo.Path, repo.GitPath)
+	assert.NoError(t, err)
+	index.remove("a.txt")
+	_, err = index.WriteUpdate()
+	assert.NoError(t, err)
+	second := commitFile(t, repo, "b.txt", "one\ntwo\n3\nfour\n")
+
+	b, err := repo.Blame("b.txt", BlameOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{first, first, second, first}, blameCommits(b))
+	assert.Equal(t, "a.txt", b.Lines[0].Path)
+	assert.Equal(t, "b.txt", b.Lines[2].Path)
+	assert.Equal(t, "a.txt", b.Commits[second].PreviousPath)
+}
+
+func TestBlameInteropWithGit(t *testing.T) {
+	dir := t.TempDir()
+	runGit(t, dir, "init", "-q", "-b", "main")
+	for _, step := range [][]string{
+		{"a.txt", "one\ntwo\nthree\n"},
+		{"a.txt", "one\n2\nthree\nfour\n"},
+		{"b.txt", "b\n"},
+		{"a.txt", "zero\none\n2\nthree\nfour\nfive\n"},
+	} {
+		commitWithGit(t, dir, step[0], step[1])
+	}
+	runGit(t, dir, "mv", "a.txt", "c.txt")
+	runGit(t, dir, "commit", "-q", "-m", "rename")
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "c.txt"), []byte("zero\none\n2\nthree\nfour\nfive\nsix\n"), 0644))
+	runGit(t, dir, "commit", "-q", "-am", "six")
+
+	repo, err := OpenWithGitDir(dir, GitDir)
+	assert.NoError(t, err)
+	for _, args := range [][]string{
+		{"c.txt"},
+		{"-L", "2,4", "c.txt"},
+		{"HEAD~1", "--", "c.txt"},
+	} {
+		opts := BlameOptions{}
+		path := args[len(args)-1]
+		if args[0] == "-L" {
+			opts.Start, opts.End = 2, 4
+		} else if len(args) > 1 {
+			opts.Rev = args[0]
+		}
+		b, err := repo.Blame(path, opts)
+		assert.NoError(t, err)
+		want := strings.Split(runGit(t, dir, append([]string{"blame", "--porcelain"}, args...)...), "\n")
+		var commits []string
+		for _, line := range want {
+			if oid, _, ok := strings.Cut(line, " "); ok && len(oid) == 40 && validOID(oid) == nil {
+				commits = append(commits, oid)
+			}
+		}
+		assert.Equal(t, commits, blameCommits(b), "%v", args)
+	}
+}
@@ -0,0 +1,325 @@
+package gitgo
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrNotABundle           = errors.New("not a bundle")
+	ErrEmptyBundle          = errors.New("refusing to create empty bundle")
+	ErrMissingPrerequisites = errors.New("missing prerequisite commits")
+)
+
+// BundleRef is a ref recorded in a bundle or, among its
+// prerequisites, a commit with its subject as Name.
+type BundleRef struct {
+	OID  string
+	Name string
+}
+
+// Bundle is the header of a bundle file, which ships a pack along with
+// the refs it holds. A bundle made of part of a history needs the
+// commits it builds on, its prerequisites, to be fetched from.
+type Bundle struct {
+	// Version is 2 or 3, the latter starting with capabilities.
+	Version       int
+	Capabilities  []string
+	Prerequisites []BundleRef
+	Refs          []BundleRef
+
+	path       string
+	packOffset int64
+}
+
+// BundleOptions pick how CreateBundle writes a bundle.
+type BundleOptions struct {
+	// Version is the format written, 2 unless set to 3.
+	Version int
+}
+
+// OpenBundle reads the header of the bundle file at path.
+func OpenBundle(path string) (*Bundle, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	b, err := readBundleHeader(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	b.path = path
+	return b, nil
+}
+
+func readBundleHeader(r *bufio.Reader) (*Bundle, error) {
+	b := &Bundle{}
+	line, err := r.ReadString('\n')
+	switch line {
+	case "# v2 git bundle\n":
+		b.Version = 2
+	case "# v3 git bundle\n":
+		b.Version = 3
+	default:
+		return nil, ErrNotABundle
+	}
+	b.packOffset = int64(len(line))
+
+	for {
+		if line, err = r.ReadString('\n'); err != nil {
+			return nil, fmt.Errorf("%w: header truncated", ErrNotABundle)
+		}
+		b.packOffset += int64(len(line))
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return b, nil
+		}
+
+		if capability, ok := strings.CutPrefix(line, "@"); ok && b.Version == 3 {
+			if len(b.Prerequisites) > 0 || len(b.Refs) > 0 {
+				return nil, fmt.Errorf("%w: capability after refs", ErrNotABundle)
+			}
+			if capability != "object-format=sha1" {
+				return nil, fmt.Errorf("%w: unsupported capability '%s'", ErrNotABundle, capability)
+			}
+			b.Capabilities = append(b.Capabilities, capability)
+			continue
+		}
+		prerequisite := strings.HasPrefix(line, "-")
+		oid, name, _ := strings.Cut(strings.TrimPrefix(line, "-"), " ")
+		if validOID(oid) != nil || !prerequisite && name == "" {
+			return nil, fmt.Errorf("%w: bad line %q", ErrNotABundle, line)
+		}
+		if prerequisite {
+			b.Prerequisites = append(b.Prerequisites, BundleRef{OID: oid, Name: name})
+		} else {
+			b.Refs = append(b.Refs, BundleRef{OID: oid, Name: name})
+		}
+	}
+}
+
+// Pack returns the pack that follows the header, to be closed by the
+// caller.
+func (b *Bundle) Pack() (io.ReadCloser, error) {
+	f, err := os.Open(b.path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(b.packOffset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// missing returns the prerequisites db does not have.
+func (b *Bundle) missing(db *Database) ([]BundleRef, error) {
+	var missing []BundleRef
+	for _, p := range b.Prerequisites {
+		if ok, err := db.Has(p.OID); err != nil {
+			return nil, err
+		} else if !ok {
+			missing = append(missing, p)
+		}
+	}
+	return missing, nil
+}
+
+// VerifyBundle checks that r has every prerequisite of the bundle, so
+// that it can be fetched from.
+func (r Repository) VerifyBundle(b *Bundle) error {
+	missing, err := b.missing(r.ObjectDatabase())
+	if err != nil {
+		return err
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	var oids []string
+	for _, p := range missing {
+		oids = append(oids, p.OID)
+	}
+	return fmt.Errorf("%w: %s", ErrMissingPrerequisites, strings.Join(oids, ", "))
+}
+
+// CreateBundle writes to w a bundle of the commits revs select and
+// what they hold. Every rev is a revision, ^<rev> to leave out what it
+// reaches, <a>..<b> for ^<a> <b>, or --all for all the refs and HEAD.
+// The revisions naming a ref or HEAD are the refs of the bundle.
+func (r Repository) CreateBundle(ctx context.Context, w io.Writer, revs []string, opts BundleOptions) (*Bundle, error) {
+	b := &Bundle{Version: 2}
+	if opts.Version == 3 {
+		b.Version = 3
+		b.Capabilities = []string{"object-format=sha1"}
+	}
+
+	var wants, haves []string
+	include := func(rev string) error {
+		oid, err := r.ResolveRevision(rev)
+		if err != nil {
+			return err
+		}
+		wants = append(wants, oid)
+		name := "HEAD"
+		if rev != "HEAD" {
+			if name, err = r.expandRef(rev); err != nil {
+				return err
+			}
+		}
+		if name != "" && !slices.ContainsFunc(b.Refs, func(ref BundleRef) bool { return ref.Name == name }) {
+			b.Refs = append(b.Refs, BundleRef{OID: oid, Name: name})
+		}
+		return nil
+	}
+	exclude := func(rev string) error {
+		oid, err := r.ResolveRevision(rev)
+		if err != nil {
+			return err
+		}
+		haves = append(haves, oid)
+		return nil
+	}
+	revs, err := r.expandAll(revs)
+	if err != nil {
+		return nil, err
+	}
+	for _, rev := range revs {
+		switch from, to, isRange := strings.Cut(rev, ".."); {
+		case isRange:
+			if err = exclude(cmp.Or(from, "HEAD")); err == nil {
+				err = include(cmp.Or(to, "HEAD"))
+			}
+		case strings.HasPrefix(rev, "^"):
+			err = exclude(rev[1:])
+		default:
+			err = include(rev)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(b.Refs) == 0 {
+		return nil, ErrEmptyBundle
+	}
+
+	db := r.ObjectDatabase()
+	oids, boundary, err := packObjects(db, wants, haves)
+	if err != nil {
+		return nil, err
+	}
+	if len(oids) == 0 {
+		return nil, ErrEmptyBundle
+	}
+	for _, oid := range boundary {
+		commit, err := db.ReadCommit(oid)
+		if err != nil {
+			return nil, err
+		}
+		b.Prerequisites = append(b.Prerequisites, BundleRef{OID: oid, Name: FirstLine(commit.Message)})
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# v%d git bundle\n", b.Version)
+	for _, c := range b.Capabilities {
+		fmt.Fprintf(bw, "@%s\n", c)
+	}
+	for _, p := range b.Prerequisites {
+		fmt.Fprintf(bw, "-%s %s\n", p.OID, p.Name)
+	}
+	for _, ref := range b.Refs {
+		fmt.Fprintf(bw, "%s %s\n", ref.OID, ref.Name)
+	}
+	bw.WriteString("\n")
+	if err := writePack(ctx, bw, db, oids, r.Progress); err != nil {
+		return nil, err
+	}
+	return b, bw.Flush()
+}
+
+// expandAll replaces --all in revs by HEAD and every ref.
+func (r Repository) expandAll(revs []string) ([]string, error) {
+	var expanded []string
+	for _, rev := range revs {
+		if rev != "--all" {
+			expanded = append(expanded, rev)
+			continue
+		}
+		refs := RefInitialize(r.Refs)
+		if refs.ReadHead() != "" {
+			expanded = append(expanded, "HEAD")
+		}
+		all, err := refs.ListRefs("refs/")
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, slices.Sorted(maps.Keys(all))...)
+	}
+	return expanded, nil
+}
+
+// bundleSource fetches from a bundle file.
+type bundleSource struct {
+	bundle *Bundle
+}
+
+// advertise gives the refs of the bundle. Its HEAD, which does not say
+// which branch it is, is taken to be the branch at the same commit,
+// DefaultBranch first.
+func (s bundleSource) advertise(ctx context.Context) (*advertisement, error) {
+	adv := &advertisement{refs: make(map[string]string)}
+	for _, ref := range s.bundle.Refs {
+		if ref.Name == "HEAD" {
+			adv.headOID = ref.OID
+		} else {
+			adv.refs[ref.Name] = ref.OID
+		}
+	}
+	if adv.headOID == "" {
+		return adv, nil
+	}
+	branches := slices.Sorted(maps.Keys(adv.refs))
+	branches = slices.DeleteFunc(branches, func(name string) bool {
+		return !strings.HasPrefix(name, "refs/heads/") || adv.refs[name] != adv.headOID
+	})
+	if slices.Contains(branches, "refs/heads/"+DefaultBranch) {
+		adv.head = "refs/heads/" + DefaultBranch
+	} else if len(branches) > 0 {
+		adv.head = branches[0]
+	}
+	return adv, nil
+}
+
+// fetch unpacks the whole pack of the bundle, once db is known to have
+// its prerequisites. A bundle holds what it holds, it cannot be
+// fetched from with a depth.
+func (s bundleSource) fetch(ctx context.Context, db *Database, req fetchRequest, progress Progress) (*fetchResponse, error) {
+	if req.depth > 0 {
+		return nil, fmt.Errorf("%w: shallow fetch from a bundle", errors.ErrUnsupported)
+	}
+	missing, err := s.bundle.missing(db)
+	if err != nil {
+		return nil, err
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrMissingPrerequisites, missing[0].OID)
+	}
+	pack, err := s.bundle.Pack()
+	if err != nil {
+		return nil, err
+	}
+	defer pack.Close()
+	n, err := unpackObjects(ctx, pack, db, progress)
+	if err != nil {
+		return nil, err
+	}
+	return &fetchResponse{objects: n}, nil
+}
@@ -0,0 +1,140 @@
+package gitgo
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeBundle creates the bundle of revs at path.
+func writeBundle(t *testing.T, repo *Repository, path string, revs ...string) *Bundle {
+	t.Helper()
+	var buf bytes.Buffer
+	b, err := repo.CreateBundle(context.Background(), &buf, revs, BundleOptions{})
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(path, buf.Bytes(), 0644))
+	return b
+}
+
+func TestBundleCloneAndFetch(t *testing.T) {
+	tmp := t.TempDir()
+	ctx := context.Background()
+	src, err := Init(filepath.Join(tmp, "src"))
+	assert.NoError(t, err)
+	first := commitFile(t, src, "a.txt", "one")
+	second := commitFile(t, src, "a.txt", "two")
+
+	full := filepath.Join(tmp, "full.bundle")
+	b := writeBundle(t, src, full, "--all")
+	assert.Equal(t, []BundleRef{{OID: second, Name: "HEAD"}, {OID: second, Name: "refs/heads/master"}}, b.Refs)
+	assert.Empty(t, b.Prerequisites)
+
+	opened, err := OpenBundle(full)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, opened.Version)
+	assert.Equal(t, b.Refs, opened.Refs)
+
+	clone := NewRepository(filepath.Join(tmp, "clone"))
+	res, err := clone.Clone(ctx, full, CloneOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 6, res.Objects)
+	assert.Equal(t, second, RefInitialize(clone.Refs).ReadHead())
+	head, err := RefInitialize(clone.Refs).HeadRef()
+	assert.NoError(t, err)
+	assert.Equal(t, "refs/heads/master", head)
+	data, err := os.ReadFile(filepath.Join(clone.Path, "a.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "two", string(data))
+
+	// an incremental bundle needs what it builds on
+	third := commitFile(t, src, "b.txt", "three")
+	incremental := filepath.Join(tmp, "incremental.bundle")
+	b = writeBundle(t, src, incremental, second+"..master")
+	assert.Equal(t, []BundleRef{{OID: second, Name: "two"}}, b.Prerequisites)
+	assert.Equal(t, []BundleRef{{OID: third, Name: "refs/heads/master"}}, b.Refs)
+
+	b, err = OpenBundle(incremental)
+	assert.NoError(t, err)
+	assert.NoError(t, clone.VerifyBundle(b))
+	empty, err := Init(filepath.Join(tmp, "empty"))
+	assert.NoError(t, err)
+	assert.ErrorIs(t, empty.VerifyBundle(b), ErrMissingPrerequisites)
+
+	_, err = clone.AddRemote("inc", incremental)
+	assert.NoError(t, err)
+	res, err = clone.Fetch(ctx, "inc", FetchOptions{})
+	assert.NoError(t, err)
+	// the commit, its tree and the new blob
+	assert.Equal(t, 3, res.Objects)
+	oid, err := RefInitialize(clone.Refs).ReadRef("refs/remotes/inc/master")
+	assert.NoError(t, err)
+	assert.Equal(t, third, oid)
+
+	_, err = empty.AddRemote("inc", incremental)
+	assert.NoError(t, err)
+	_, err = empty.Fetch(ctx, "inc", FetchOptions{})
+	assert.ErrorIs(t, err, ErrMissingPrerequisites)
+
+	var buf bytes.Buffer
+	_, err = src.CreateBundle(ctx, &buf, []string{"master.." + "master"}, BundleOptions{})
+	assert.ErrorIs(t, err, ErrEmptyBundle)
+	_, err = src.CreateBundle(ctx, &buf, []string{first}, BundleOptions{})
+	assert.ErrorIs(t, err, ErrEmptyBundle)
+}
+
+func TestBundleInteropWithGit(t *testing.T) {
+	tmp := t.TempDir()
+	ctx := context.Background()
+	src := filepath.Join(tmp, "src")
+	assert.NoError(t, os.MkdirAll(src, 0755))
+	runGit(t, src, "init", "-q", "-b", "main")
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "a.txt"), []byte("a\n"), 0644))
+	runGit(t, src, "add", ".")
+	runGit(t, src, "commit", "-q", "-m", "first")
+	head := runGit(t, src, "rev-parse", "HEAD")
+
+	// git's v3 bundles are read
+	runGit(t, src, "bundle", "create", "-q", "--version=3", filepath.Join(tmp, "git.bundle"), "main")
+	b, err := OpenBundle(filepath.Join(tmp, "git.bundle"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, b.Version)
+	assert.Equal(t, []string{"object-format=sha1"}, b.Capabilities)
+	clone := NewRepositoryWithGitDir(filepath.Join(tmp, "clone"), GitDir)
+	_, err = clone.Clone(ctx, filepath.Join(tmp, "git.bundle"), CloneOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, head, runGit(t, clone.Path, "rev-parse", "origin/main"))
+
+	// and git reads gitgo's
+	repo, err := OpenWithGitDir(src, GitDir)
+	assert.NoError(t, err)
+	for _, version := range []int{2, 3} {
+		path := filepath.Join(tmp, "gitgo.bundle")
+		var buf bytes.Buffer
+		_, err = repo.CreateBundle(ctx, &buf, []string{"main"}, BundleOptions{Version: version})
+		assert.NoError(t, err)
+		assert.NoError(t, os.WriteFile(path, buf.Bytes(), 0644))
+		out := runGit(t, src, "bundle", "verify", path)
+		assert.Contains(t, out, path+" is okay")
+		assert.Equal(t, head+" refs/heads/main", runGit(t, src, "bundle", "list-heads", path))
+	}
+}
+
+func TestOpenBundleErrors(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"signature":  "# v4 git bundle\n\n",
+		"truncated":  "# v2 git bundle\nce013625030ba8dba906f756967f9e9ca394464a refs/heads/master\n",
+		"bad oid":    "# v2 git bundle\nce0136 refs/heads/master\n\n",
+		"capability": "# v3 git bundle\n@object-format=sha256\n\n",
+		"v2 caps":    "# v2 git bundle\n@object-format=sha1\n\n",
+	} {
+		path := filepath.Join(dir, "bundle")
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+		_, err := OpenBundle(path)
+		assert.ErrorIs(t, err, ErrNotABundle, name)
+	}
+}
@@ -0,0 +1,262 @@
+package gitgo
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const treeExtension = "TREE"
+
+var errBadTreeExtension = errors.New("bad TREE extension")
+
+// ErrUnmergedFiles is returned when writing a tree of an index with
+// conflicts left to resolve.
+var ErrUnmergedFiles = errors.New("you have unmerged files")
+
+// cacheTree mirrors the directory structure of the index and remembers
+// the oid of every tree object written for it, so that a commit only
+// has to rebuild the directories whose entries changed. It is stored
+// in the index as the TREE extension.
+type cacheTree struct {
+	name string
+	// number of index entries below this directory, -1 when the
+	// directory changed since its tree was written.
+	entryCount int
+	oid        string
+	children   []*cacheTree
+}
+
+func newCacheTree(name string) *cacheTree {
+	return &cacheTree{name: name, entryCount: -1}
+}
+
+func (ct *cacheTree) valid() bool { return ct.entryCount >= 0 }
+
+func (ct *cacheTree) child(name string, create bool) *cacheTree {
+	for _, c := range ct.children {
+		if c.name == name {
+			return c
+		}
+	}
+	if !create {
+		return nil
+	}
+	c := newCacheTree(name)
+	ct.children = append(ct.children, c)
+	return c
+}
+
+// invalidate marks every directory leading to path as changed.
+func (ct *cacheTree) invalidate(path string) {
+	node := ct
+	node.entryCount = -1
+	parts := strings.Split(path, "/")
+	for _, part := range parts[:len(parts)-1] {
+		node = node.child(part, false)
+		if node == nil {
+			return
+		}
+		node.entryCount = -1
+	}
+}
+
+// update writes the tree objects of every invalid directory under
+// base, reusing the oids of the valid ones. entries must be sorted and
+// start with the first entry under base, the number of entries
+// consumed is returned.
+func (ct *cacheTree) update(ctx context.Context, db *Database, entries []Entries, base string) (int, error) {
+	if ct.valid() {
+		n := ct.entryCount
+		// a count that does not fit the entries means the extension
+		// went out of sync with them, so rebuild this directory. Only
+		// an empty index has an empty tree, a directory with none
+		// would never let its parent move past it.
+		fits := n > 0 && n <= len(entries) && strings.HasPrefix(entries[n-1].Path, base)
+		if fits || n == 0 && len(entries) == 0 {
+			return n, nil
+		}
+		ct.entryCount = -1
+	}
+
+	var treeEntries []Entries
+	seen := make(map[string]bool)
+	i := 0
+	for i < len(entries) {
+		path := entries[i].Path
+		if !strings.HasPrefix(path, base) {
+			break
+		}
+		rest := path[len(base):]
+		name, _, isDir := strings.Cut(rest, "/")
+		if !isDir {
+			treeEntries = append(treeEntries, Entries{
+				Path: rest,
+				OID:  entries[i].OID,
+				Stat: entries[i].Stat,
+			})
+			i++
+			continue
+		}
+
+		sub := ct.child(name, true)
+		n, err := sub.update(ctx, db, entries[i:], base+name+"/")
+		if err != nil {
+			return 0, err
+		}
+		i += n
+		seen[name] = true
+		treeEntries = append(treeEntries, Entries{Path: name, OID: sub.oid, Stat: dirMode})
+	}
+
+	// drop directories that no longer have any entry
+	ct.children = slices.DeleteFunc(ct.children, func(c *cacheTree) bool {
+		return !seen[c.name]
+	})
+
+	db.Data(TypeTree, CreateTreeEntry(treeEntries))
+	oid, err := db.StoreContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	ct.oid = oid
+	ct.entryCount = i
+	return i, nil
+}
+
+// Children are written the way git orders them, shorter names first.
+func (ct *cacheTree) sortChildren() {
+	slices.SortFunc(ct.children, func(a, b *cacheTree) int {
+		if len(a.name) != len(b.name) {
+			return len(a.name) - len(b.name)
+		}
+		return strings.Compare(a.name, b.name)
+	})
+}
+
+func (ct *cacheTree) encode(buf *bytes.Buffer) error {
+	ct.sortChildren()
+	buf.WriteString(ct.name)
+	buf.WriteByte(0)
+	fmt.Fprintf(buf, "%d %d\n", ct.entryCount, len(ct.children))
+	if ct.valid() {
+		oid, err := hex.DecodeString(ct.oid)
+		if err != nil || len(oid) != 20 {
+			return fmt.Errorf("cache tree %q: bad oid %q", ct.name, ct.oid)
+		}
+		buf.Write(oid)
+	}
+	for _, c := range ct.children {
+		if err := c.encode(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseCacheTree(data []byte) (*cacheTree, error) {
+	ct, rest, err := decodeCacheTree(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%w: %d trailing bytes", errBadTreeExtension, len(rest))
+	}
+	return ct, nil
+}
+
+func decodeCacheTree(data []byte) (*cacheTree, []byte, error) {
+	nul := bytes.IndexByte(data, 0)
+	if nul == -1 {
+		return nil, nil, errBadTreeExtension
+	}
+	ct := &cacheTree{name: string(data[:nul])}
+	data = data[nul+1:]
+
+	nl := bytes.IndexByte(data, '\n')
+	if nl == -1 {
+		return nil, nil, errBadTreeExtension
+	}
+	countStr, subStr, ok := strings.Cut(string(data[:nl]), " ")
+	if !ok {
+		return nil, nil, errBadTreeExtension
+	}
+	count, err := strconv.Atoi(countStr)
+	if err != nil || count < -1 {
+		return nil, nil, errBadTreeExtension
+	}
+	subtrees, err := strconv.Atoi(subStr)
+	if err != nil || subtrees < 0 {
+		return nil, nil, errBadTreeExtension
+	}
+	ct.entryCount = count
+	data = data[nl+1:]
+
+	if ct.valid() {
+		if len(data) < 20 {
+			return nil, nil, errBadTreeExtension
+		}
+		ct.oid = hex.EncodeToString(data[:20])
+		data = data[20:]
+	}
+
+	for range subtrees {
+		var child *cacheTree
+		child, data, err = decodeCacheTree(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		ct.children = append(ct.children, child)
+	}
+	return ct, data, nil
+}
+
+// WriteTree stores the tree objects for the index content and returns
+// the oid of the root tree. Directories that did not change since the
+// last call, possibly from an earlier process through the TREE
+// extension, are not rebuilt. An index with conflicts left by a merge
+// gives ErrUnmergedFiles.
+func (i *Index) WriteTree(db *Database) (string, error) {
+	return i.WriteTreeContext(context.Background(), db)
+}
+
+// WriteTreeContext is WriteTree that stops storing trees once ctx is
+// done. The trees written so far stay cached.
+func (i *Index) WriteTreeContext(ctx context.Context, db *Database) (string, error) {
+	if len(i.unmerged) > 0 {
+		return "", ErrUnmergedFiles
+	}
+	if i.tree == nil {
+		i.tree = newCacheTree("")
+	}
+	if i.tree.valid() {
+		return i.tree.oid, nil
+	}
+
+	if _, err := i.tree.update(ctx, db, i.Entries(), ""); err != nil {
+		return "", err
+	}
+	i.changed = true
+	oid := i.tree.oid
+
+	// git counts intent-to-add entries in a directory but leaves them
+	// out of its tree, which the counts above don't, so don't let
+	// those directories look valid.
+	for _, entry := range i.entries {
+		if entry.IntentToAdd() {
+			i.tree.invalidate(entry.Path)
+		}
+	}
+	return oid, nil
+}
+
+func (i *Index) invalidateTree(path string) {
+	if i.tree != nil {
+		i.tree.invalidate(path)
+	}
+}
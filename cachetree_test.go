@@ -0,0 +1,85 @@
+package gitgo
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingStore records the objects written through it.
+type countingStore struct {
+	*MemoryStore
+	writes int
+}
+
+func (s *countingStore) Write(oid string, data []byte) error {
+	s.writes++
+	return s.MemoryStore.Write(oid, data)
+}
+
+func fullTreeOID(t *testing.T, index *Index) string {
+	db := NewDatabaseWithStore(NewMemoryStore())
+	e, err := TraverseTree(db, BuildTree(index.Entries()), "")
+	assert.NoError(t, err)
+	db.Data(TypeTree, CreateTreeEntry(e))
+	oid, err := db.Store()
+	assert.NoError(t, err)
+	return oid
+}
+
+func TestWriteTreeReusesUnchangedSubtrees(t *testing.T) {
+	tmpDir := t.TempDir()
+	gitPath := filepath.Join(tmpDir, ".gitgo")
+	assert.NoError(t, os.MkdirAll(gitPath, 0755))
+
+	index := NewIndex(tmpDir, gitPath)
+	for _, p := range []string{"a/b/one.txt", "a/b/two.txt", "a/c/three.txt", "d/four.txt", "five.txt"} {
+		index.Add(p, randomOID(), thisFileStat(t))
+	}
+
+	store := &countingStore{MemoryStore: NewMemoryStore()}
+	db := NewDatabaseWithStore(store)
+	oid, err := index.WriteTree(db)
+	assert.NoError(t, err)
+	assert.Equal(t, fullTreeOID(t, index), oid)
+	// root, a, a/b, a/c and d
+	assert.Equal(t, 5, store.writes)
+
+	// the cached tree survives a write and a load of the index
+	_, err = index.WriteUpdate()
+	assert.NoError(t, err)
+	index = NewIndex(tmpDir, gitPath)
+	assert.NoError(t, index.Load())
+
+	store.writes = 0
+	again, err := index.WriteTree(db)
+	assert.NoError(t, err)
+	assert.Equal(t, oid, again)
+	assert.Equal(t, 0, store.writes)
+
+	// changing a/c/three.txt only rebuilds a/c, a and the root
+	index.Add("a/c/three.txt", randomOID(), thisFileStat(t))
+	store.writes = 0
+	changed, err := index.WriteTree(db)
+	assert.NoError(t, err)
+	assert.NotEqual(t, oid, changed)
+	assert.Equal(t, fullTreeOID(t, index), changed)
+	assert.Equal(t, 3, store.writes)
+}
+
+func TestCacheTreeEncodeRoundTrip(t *testing.T) {
+	root := &cacheTree{entryCount: 3, oid: randomOID(), children: []*cacheTree{
+		{name: "long", entryCount: -1},
+		{name: "a", entryCount: 1, oid: randomOID()},
+	}}
+
+	buf := new(bytes.Buffer)
+	assert.NoError(t, root.encode(buf))
+	decoded, err := parseCacheTree(buf.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, root, decoded)
+	assert.Equal(t, "a", decoded.children[0].name)
+}
@@ -0,0 +1,256 @@
+package gitgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+var ErrPathspecMismatch = errors.New("pathspec did not match any file known to gitgo")
+
+// Checkout overwrites the workspace files under paths, relative to the
+// workspace unless absolute, with their content in the index. It
+// returns the paths that were restored.
+func (r Repository) Checkout(paths ...string) ([]string, error) {
+	database := r.ObjectDatabase()
+	_, index, err := r.holdIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := index.IndexEntries()
+	names := slices.Sorted(maps.Keys(entries))
+	var restored []string
+	for _, path := range paths {
+		rel, err := r.relPath(path)
+		if err != nil {
+			index.Release()
+			return nil, err
+		}
+
+		matched := false
+		for _, name := range names {
+			if rel != "." && name != rel && !strings.HasPrefix(name, rel+"/") {
+				continue
+			}
+			matched = true
+			entry := entries[name]
+			if entry.IntentToAdd() {
+				continue
+			}
+			stat, err := checkoutFile(database, r.Path, name, entry.Oid, entry.Mode)
+			if err != nil {
+				index.Release()
+				return nil, fmt.Errorf("checkout %s: %w", name, err)
+			}
+			index.UpdateEntryStat(&entry, stat)
+			restored = append(restored, name)
+		}
+		if !matched {
+			index.Release()
+			return nil, fmt.Errorf("%w: '%s'", ErrPathspecMismatch, path)
+		}
+	}
+
+	if _, err := index.WriteUpdate(); err != nil {
+		return nil, err
+	}
+	return restored, nil
+}
+
+// relPath returns path relative to the workspace, with forward
+// slashes like index entries.
+func (r Repository) relPath(path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(r.Path, path)
+	}
+	rel, err := filepath.Rel(r.Path, path)
+	if err != nil {
+		return "", err
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("'%s' is outside repository", path)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// checkoutFile replaces whatever is at the workspace path name with the
+// blob oid, written as a symbolic link, an executable or a regular file
+// depending on mode. It returns the stat of the new file.
+func checkoutFile(db *Database, root, name, oid string, mode uint32) (os.FileInfo, error) {
+	if err := checkLeadingDirs(root, name); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(root, name)
+
+	// a submodule only gets its directory, `submodule update` fills it
+	if mode == gitlinkMode {
+		if stat, err := os.Lstat(path); err == nil && stat.IsDir() {
+			return stat, nil
+		}
+		os.Remove(path)
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return nil, err
+		}
+		return os.Lstat(path)
+	}
+
+	if stat, err := os.Lstat(path); err == nil {
+		if stat.IsDir() {
+			return nil, fmt.Errorf("a directory is in the way")
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	obj, err := db.OpenObject(oid)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+	if obj.Type != TypeFile {
+		return nil, fmt.Errorf("object %s is a %s, not a blob", oid, obj.Type)
+	}
+
+	if mode == symlinkMode {
+		target, err := io.ReadAll(obj)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Symlink(string(target), path); err != nil {
+			return nil, err
+		}
+		return os.Lstat(path)
+	}
+
+	perm := os.FileMode(0644)
+	if mode == executableMode {
+		perm = 0755
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(f, obj); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	return os.Lstat(path)
+}
+
+// CheckoutCommit makes the workspace and the index match commit oid,
+// removing the files it does not have, and detaches HEAD at it.
+// Changes to tracked files are overwritten.
+func (r Repository) CheckoutCommit(ctx context.Context, oid string) error {
+	if err := r.checkoutTree(ctx, oid); err != nil {
+		return err
+	}
+	return RefInitialize(r.Refs).DetachHead(oid)
+}
+
+// checkoutTree is CheckoutCommit leaving HEAD alone.
+func (r Repository) checkoutTree(ctx context.Context, oid string) error {
+	database := r.ObjectDatabase()
+	commit, err := database.ReadCommit(oid)
+	if err != nil {
+		return err
+	}
+	files, err := database.ListTree(commit.Tree)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		for _, name := range strings.Split(file.Name, "/") {
+			if !validTreeName(name) {
+				return fmt.Errorf("%w: bad tree entry name %q", ErrCorruptObject, file.Name)
+			}
+		}
+	}
+
+	_, index, err := r.holdIndex()
+	if err != nil {
+		return err
+	}
+	wanted := make(map[string]bool, len(files))
+	for _, file := range files {
+		wanted[file.Name] = true
+	}
+	for name := range index.IndexEntries() {
+		if !wanted[name] {
+			removeWorkspaceFile(r.Path, name)
+		}
+	}
+
+	index.clear()
+	progress := newProgressCounter(r.Progress, "Checking out files", len(files))
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			index.Release()
+			return err
+		}
+		stat, err := checkoutFile(database, r.Path, file.Name, file.OID, file.Mode)
+		if err != nil {
+			index.Release()
+			return fmt.Errorf("checkout %s: %w", file.Name, err)
+		}
+		entry := NewIndexEntry(file.Name, file.OID, stat)
+		entry.Mode = file.Mode
+		index.add(entry)
+		progress.add()
+	}
+	_, err = index.WriteUpdate()
+	return err
+}
+
+// checkLeadingDirs refuses a workspace path name whose leading
+// components under root are not all directories. Following a symbolic
+// link there would write or remove a file outside the workspace.
+// Components that do not exist yet are left for the caller to create.
+func checkLeadingDirs(root, name string) error {
+	dir := root
+	parts := strings.Split(name, "/")
+	for _, part := range parts[:len(parts)-1] {
+		dir = filepath.Join(dir, part)
+		stat, err := os.Lstat(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !stat.IsDir() {
+			return fmt.Errorf("%s is not a directory", filepath.ToSlash(dir[len(root)+1:]))
+		}
+	}
+	return nil
+}
+
+// removeWorkspaceFile deletes the file name and the directories it
+// leaves empty.
+func removeWorkspaceFile(root, name string) {
+	if checkLeadingDirs(root, name) != nil {
+		return
+	}
+	path := filepath.Join(root, name)
+	if err := os.Remove(path); err != nil {
+		return
+	}
+	for dir := filepath.Dir(path); dir != root && strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			return
+		}
+	}
+}
@@ -1,84 +1,73 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/Vikuuu/gitgo"
-	"github.com/Vikuuu/gitgo/internal/datastr"
 )
 
-var gitgoFolders []string
-
 func cmdInitHandler(cmd command) int {
-	gitgoFolders = []string{"objects", "refs"}
-
 	initPath := cmd.repo.Path
-	var arg string = ""
-	if len(cmd.args) > 0 {
-		arg = cmd.args[0]
+	if len(cmd.args) > 0 && cmd.args[0] != "" {
+		initPath = filepath.Join(initPath, cmd.args[0])
 	}
 
-	if arg != "" {
-		initPath = filepath.Join(initPath, arg)
-	}
-	gitPath := filepath.Join(initPath, ".gitgo")
-
-	for _, folder := range gitgoFolders {
-		err := os.MkdirAll(filepath.Join(gitPath, folder), 0755)
-		if err != nil {
-			fmt.Fprintf(cmd.stderr, "error: %v\n", err)
-			return 1
-		}
+	repo, err := gitgo.InitWithGitDir(initPath, filepath.Base(cmd.repo.GitPath))
+	if err != nil {
+		fmt.Fprintf(cmd.stderr, "error: %v\n", err)
+		return 1
 	}
 
-	fmt.Fprintf(cmd.stdout, "Initialized empty Gitgo repository in %s\n", gitPath)
+	fmt.Fprintf(cmd.stdout, "Initialized empty Gitgo repository in %s\n", repo.GitPath)
 	return 0
 }
 
 // The command `commit` reads the index file and then
-// creates and merkel tree, and store all the sub-trees on the way
+// creates and merkel tree, storing the sub-trees that changed since
+// the last commit on the way.
 // The blob files are already being stored during the
 // `add` command
 func cmdCommitHandler(cmd command) int {
-	database := gitgo.NewDatabase(cmd.repo.Database)
-	index := gitgo.NewIndex(cmd.repo.Path, cmd.repo.GitPath)
-	index.Load()
-	entries := index.Entries()
-	tree := gitgo.BuildTree(entries)
-	e, err := gitgo.TraverseTree(database, tree, cmd.repo.Database)
-	if err != nil {
-		fmt.Fprintf(cmd.stderr, "error: %v\n", err)
+	forceUnlock(&cmd)
+	message := gitgo.ReadStdinMsg(cmd.stdin)
+	opts := gitgo.CommitOptions{
+		Name:    cmd.env["name"],
+		Email:   cmd.env["email"],
+		Message: message,
+	}
+	if date := cmd.env["date"]; date != "" {
+		when, err := gitgo.ParseDate(date)
+		if err != nil {
+			fmt.Fprintf(cmd.stderr, "fatal: invalid GITGO_AUTHOR_DATE: %v\n", err)
+			return 128
+		}
+		opts.When = when
 	}
-	treeEntry := gitgo.CreateTreeEntry(e)
-	database.Data(gitgo.TypeTree, treeEntry)
-	treeHash, err := database.Store()
+	res, err := cmd.repo.CommitContext(cmd.ctx, opts)
 	if err != nil {
-		fmt.Fprintf(cmd.stderr, "error: %v\n", err)
-		return 1
+		return fatal(cmd, err)
 	}
 
-	author := gitgo.AuthorData(cmd.env["name"], cmd.env["email"], time.Now())
-	message := gitgo.ReadStdinMsg(cmd.stdin)
-	refs := gitgo.RefInitialize(cmd.repo.Refs)
-	parent := refs.ReadHead()
-
 	is_root := ""
-	if parent == "" {
+	if res.Parent == "" {
 		is_root = "(root-commit) "
 	}
-
-	commitData := gitgo.CommitData(parent, treeHash, author, message)
-	database.Data(gitgo.TypeCommit, commitData)
-	cHash, err := database.Store()
-	if err != nil {
-		fmt.Fprintf(cmd.stderr, "error: %v\n", err)
-		return 1
-	}
-	refs.UpdateHead([]byte(cHash))
-	fmt.Fprintf(cmd.stdout, "%s %s %s\n", is_root, cHash, gitgo.FirstLine(message))
+	fmt.Fprintf(cmd.stdout, "%s %s %s\n", is_root, res.OID, gitgo.FirstLine(message))
 
 	return 0
 }
@@ -90,104 +79,1220 @@ func cmdCatFileHandler(cmd command) int {
 	}
 	hash := cmd.args[0]
 
-	folderPath, filePath := hash[:2], hash[2:]
-	b, err := os.ReadFile(filepath.Join(cmd.repo.Database, folderPath, filePath))
+	obj, err := cmd.repo.ObjectDatabase().OpenObject(hash)
 	if err != nil {
 		fmt.Fprintf(cmd.stderr, "error: %v\n", err)
 		return 1
 	}
+	defer obj.Close()
 
-	data, err := gitgo.Decompress(b)
-	if err != nil {
+	if _, err := io.Copy(cmd.stdout, obj); err != nil {
 		fmt.Fprintf(cmd.stderr, "error: %v\n", err)
 		return 1
 	}
-
-	fmt.Fprintln(cmd.stdout, string(data))
 	return 0
 }
 
 func cmdAddHandler(cmd command) int {
-	database := gitgo.NewDatabase(cmd.repo.Database)
-	_, index, err := gitgo.IndexHoldForUpdate(cmd.repo.Path, cmd.repo.GitPath)
+	forceUnlock(&cmd)
+	all := false
+	var paths []string
+	for _, path := range cmd.args {
+		if path == "-A" || path == "--all" {
+			all = true
+			continue
+		}
+		paths = append(paths, filepath.Join(cmd.pwd, path))
+	}
+
+	add := cmd.repo.AddContext
+	if all {
+		// with no paths, -A takes the whole workspace
+		if len(paths) == 0 {
+			paths = []string{cmd.repo.Path}
+		}
+		add = cmd.repo.AddAllContext
+	}
+	if _, err := add(cmd.ctx, paths...); err != nil {
+		var aerr *gitgo.AddError
+		if errors.As(err, &aerr) && os.IsPermission(aerr.Err) {
+			fmt.Fprintf(cmd.stderr, "%v '%s'\nfatal: adding files failed", os.ErrPermission, aerr.Path)
+			return 1
+		}
+		return fatal(cmd, err)
+	}
+
+	fmt.Fprintln(cmd.stdout, "Written data to Index file")
+	return 0
+}
+
+func cmdCheckoutHandler(cmd command) int {
+	args := cmd.args
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		fmt.Fprintln(cmd.stderr, "usage: gitgo checkout [--] <paths>...")
+		return 2
+	}
+	paths := make([]string, len(args))
+	for i, path := range args {
+		paths[i] = filepath.Join(cmd.pwd, path)
+	}
+
+	restored, err := cmd.repo.Checkout(paths...)
 	if err != nil {
-		fmt.Fprintf(cmd.stderr, `
-Fatal: %v
+		return fatal(cmd, err)
+	}
 
-Another gitgo process seems to be running in this repository.
-Please make sure all processes are terminated then try again.
-If it still fails, a gitgo process may have crashed in this
-repository earlier: remove the file manually to continue.`, err)
-		return 1
+	if len(restored) == 1 {
+		fmt.Fprintln(cmd.stderr, "Updated 1 path from the index")
+	} else {
+		fmt.Fprintf(cmd.stderr, "Updated %d paths from the index\n", len(restored))
 	}
-	var filePaths []string
+	return 0
+}
 
-	// Add all the paths to a slice first
-	for _, path := range cmd.args {
-		absPath := filepath.Join(cmd.pwd, path)
-		expandPaths, err := gitgo.ListFiles(absPath, cmd.repo.Path)
+func cmdSubmoduleHandler(cmd command) int {
+	sub := "status"
+	if len(cmd.args) > 0 {
+		sub = cmd.args[0]
+	}
+
+	switch sub {
+	case "init":
+		registered, err := cmd.repo.SubmoduleInit()
+		if err != nil {
+			return fatal(cmd, err)
+		}
+		for _, s := range registered {
+			fmt.Fprintf(cmd.stdout, "Submodule '%s' (%s) registered for path '%s'\n", s.Name, s.URL, s.Path)
+		}
+	case "update":
+		updated, err := cmd.repo.SubmoduleUpdate(cmd.ctx)
+		if err != nil {
+			return fatal(cmd, err)
+		}
+		for _, s := range updated {
+			fmt.Fprintf(cmd.stdout, "Submodule path '%s': checked out '%s'\n", s.Path, s.OID)
+		}
+	case "status":
+		statuses, err := cmd.repo.SubmoduleStatus()
 		if err != nil {
-			index.Release()
-			fmt.Fprintf(cmd.stderr, "error: %v\n", err)
+			return fatal(cmd, err)
+		}
+		for _, s := range statuses {
+			oid := s.HEAD
+			if oid == "" {
+				oid = s.OID
+			}
+			fmt.Fprintf(cmd.stdout, "%c%s %s\n", s.State(), oid, s.Path)
+		}
+	default:
+		fmt.Fprintln(cmd.stderr, "usage: gitgo submodule [init | update | status]")
+		return 2
+	}
+	return 0
+}
+
+// cmdCloneHandler copies the repository at a path, file:// URL or
+// http(s) URL into a new directory, named after it unless given.
+func cmdCloneHandler(cmd command) int {
+	opts := gitgo.CloneOptions{}
+	var args []string
+	for i := 0; i < len(cmd.args); i++ {
+		depth, ok, err := intFlag(cmd.args, &i, "--depth")
+		switch {
+		case err != nil:
+			fmt.Fprintf(cmd.stderr, "fatal: %v\n", err)
+			return 128
+		case ok:
+			opts.Depth = depth
+		default:
+			args = append(args, cmd.args[i])
+		}
+	}
+	if len(args) < 1 || len(args) > 2 {
+		fmt.Fprintln(cmd.stderr, "usage: gitgo clone [--depth <depth>] <repository> [<directory>]")
+		return 2
+	}
+	url := args[0]
+	remote := strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://")
+	if path := strings.TrimPrefix(url, "file://"); !remote && !filepath.IsAbs(path) {
+		url = filepath.Join(cmd.repo.Path, path)
+	}
+	dir := strings.TrimSuffix(filepath.Base(strings.TrimSuffix(url, "/")), ".git")
+	if len(args) == 2 {
+		dir = args[1]
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(cmd.repo.Path, dir)
+	}
+
+	repo := gitgo.NewRepositoryWithGitDir(dir, filepath.Base(cmd.repo.GitPath))
+	repo.Workers, repo.Progress, repo.RemoteProgress = cmd.repo.Workers, cmd.repo.Progress, cmd.repo.RemoteProgress
+	fmt.Fprintf(cmd.stderr, "Cloning into '%s'...\n", filepath.Base(dir))
+	res, err := repo.Clone(cmd.ctx, url, opts)
+	if err != nil {
+		return fatal(cmd, err)
+	}
+	if len(res.Updates) == 0 {
+		fmt.Fprintln(cmd.stderr, "warning: You appear to have cloned an empty repository.")
+	}
+	return 0
+}
+
+// cmdFetchHandler updates the remote-tracking refs of a remote, the
+// one the current branch tracks or origin by default.
+func cmdFetchHandler(cmd command) int {
+	opts := gitgo.FetchOptions{}
+	var args []string
+	for i := 0; i < len(cmd.args); i++ {
+		if cmd.args[i] == "--unshallow" {
+			opts.Unshallow = true
+			continue
+		}
+		depth, ok, err := intFlag(cmd.args, &i, "--depth")
+		if !ok && err == nil {
+			if opts.Deepen, ok, err = intFlag(cmd.args, &i, "--deepen"); ok {
+				depth = 0
+			}
+		}
+		switch {
+		case err != nil:
+			fmt.Fprintf(cmd.stderr, "fatal: %v\n", err)
+			return 128
+		case ok:
+			opts.Depth = max(opts.Depth, depth)
+		default:
+			args = append(args, cmd.args[i])
+		}
+	}
+	if len(args) > 1 || opts.Depth > 0 && (opts.Deepen > 0 || opts.Unshallow) || opts.Deepen > 0 && opts.Unshallow {
+		fmt.Fprintln(cmd.stderr, "usage: gitgo fetch [--depth <depth> | --deepen <depth> | --unshallow] [<remote>]")
+		return 2
+	}
+	name := defaultRemote(cmd)
+	if len(args) == 1 {
+		name = args[0]
+	}
+	res, err := cmd.repo.Fetch(cmd.ctx, name, opts)
+	if err != nil {
+		return fatal(cmd, err)
+	}
+	printRefUpdates(cmd, "From", res)
+	for _, u := range res.Updates {
+		if u.Err != nil {
 			return 1
 		}
-		filePaths = append(filePaths, expandPaths...)
 	}
+	return 0
+}
+
+func cmdPushHandler(cmd command) int {
+	opts := gitgo.PushOptions{}
+	var args []string
+	for _, arg := range cmd.args {
+		switch arg {
+		case "-f", "--force":
+			opts.Force = true
+		default:
+			args = append(args, arg)
+		}
+	}
+	if len(args) > 2 {
+		fmt.Fprintln(cmd.stderr, "usage: gitgo push [--force] [<remote> [<branch>]]")
+		return 2
+	}
+	name := defaultRemote(cmd)
+	if len(args) > 0 {
+		name = args[0]
+	}
+	if len(args) > 1 {
+		opts.Branch = args[1]
+	}
+
+	res, err := cmd.repo.Push(cmd.ctx, name, opts)
+	if res != nil {
+		if res.Updates[0].UpToDate() {
+			fmt.Fprintln(cmd.stderr, "Everything up-to-date")
+		} else {
+			printRefUpdates(cmd, "To", res)
+		}
+	}
+	if err != nil {
+		return fatal(cmd, err)
+	}
+	return 0
+}
 
-	for _, p := range filePaths {
-		ap := filepath.Join(cmd.pwd, p)
-		data, err := os.ReadFile(ap)
+func cmdRemoteHandler(cmd command) int {
+	sub := "list"
+	if len(cmd.args) > 0 {
+		sub = cmd.args[0]
+	}
+
+	switch {
+	case sub == "list" || sub == "-v" || sub == "--verbose":
+		remotes, err := cmd.repo.Remotes()
 		if err != nil {
-			if os.IsPermission(err) {
-				fmt.Fprintf(cmd.stderr, "%v '%s'\nfatal: adding files failed", os.ErrPermission, p)
-				return 1
+			return fatal(cmd, err)
+		}
+		verbose := slices.Contains(cmd.args, "-v") || slices.Contains(cmd.args, "--verbose")
+		for _, r := range remotes {
+			if verbose {
+				fmt.Fprintf(cmd.stdout, "%s\t%s (fetch)\n%s\t%s (push)\n", r.Name, r.URL, r.Name, r.URL)
+			} else {
+				fmt.Fprintln(cmd.stdout, r.Name)
 			}
-			fmt.Fprintf(cmd.stderr, "error: %v\n", err)
-			return 1
 		}
-		stat, err := os.Stat(ap)
+	case sub == "add" && len(cmd.args) == 3:
+		if _, err := cmd.repo.AddRemote(cmd.args[1], cmd.args[2]); err != nil {
+			return fatal(cmd, err)
+		}
+	case (sub == "remove" || sub == "rm") && len(cmd.args) == 2:
+		if err := cmd.repo.RemoveRemote(cmd.args[1]); err != nil {
+			return fatal(cmd, err)
+		}
+	default:
+		fmt.Fprintln(cmd.stderr, "usage: gitgo remote [list | -v | add <name> <url> | remove <name>]")
+		return 2
+	}
+	return 0
+}
+
+// cmdBundleHandler creates bundle files, checks that the repository
+// has what they build on and lists their refs.
+func cmdBundleHandler(cmd command) int {
+	if len(cmd.args) < 2 || cmd.args[0] == "create" && len(cmd.args) < 3 {
+		fmt.Fprintln(cmd.stderr, "usage: gitgo bundle (create <file> <rev>... | verify <file> | list-heads <file>)")
+		return 2
+	}
+	path := cmd.args[1]
+	if path != "-" && !filepath.IsAbs(path) {
+		path = filepath.Join(cmd.repo.Path, path)
+	}
+
+	switch cmd.args[0] {
+	case "create":
+		opts := gitgo.BundleOptions{}
+		var revs []string
+		for _, arg := range cmd.args[2:] {
+			if v, ok := strings.CutPrefix(arg, "--version="); ok {
+				opts.Version, _ = strconv.Atoi(v)
+				continue
+			}
+			revs = append(revs, arg)
+		}
+		var buf bytes.Buffer
+		if _, err := cmd.repo.CreateBundle(cmd.ctx, &buf, revs, opts); err != nil {
+			return fatal(cmd, err)
+		}
+		if path == "-" {
+			_, err := cmd.stdout.Write(buf.Bytes())
+			if err != nil {
+				return fatal(cmd, err)
+			}
+			return 0
+		}
+		if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			return fatal(cmd, err)
+		}
+	case "verify":
+		b, err := gitgo.OpenBundle(path)
 		if err != nil {
-			fmt.Fprintf(cmd.stderr, "error: %v\n", err)
+			return fatal(cmd, err)
+		}
+		if err := cmd.repo.VerifyBundle(b); err != nil {
+			fmt.Fprintln(cmd.stderr, "error: Repository lacks these prerequisite commits:")
+			for _, p := range b.Prerequisites {
+				if has, _ := cmd.repo.ObjectDatabase().Has(p.OID); !has {
+					fmt.Fprintf(cmd.stderr, "error: %s %s\n", p.OID, p.Name)
+				}
+			}
 			return 1
 		}
+		printBundleRefs(cmd, "contains", b.Refs)
+		if len(b.Prerequisites) == 0 {
+			fmt.Fprintln(cmd.stdout, "The bundle records a complete history.")
+		} else {
+			printBundleRefs(cmd, "requires", b.Prerequisites)
+		}
+		fmt.Fprintln(cmd.stdout, "The bundle uses this hash algorithm: sha1")
+		fmt.Fprintf(cmd.stderr, "%s is okay\n", cmd.args[1])
+	case "list-heads":
+		b, err := gitgo.OpenBundle(path)
+		if err != nil {
+			return fatal(cmd, err)
+		}
+		for _, ref := range b.Refs {
+			fmt.Fprintf(cmd.stdout, "%s %s\n", ref.OID, ref.Name)
+		}
+	default:
+		fmt.Fprintf(cmd.stderr, "fatal: unknown bundle subcommand '%s'\n", cmd.args[0])
+		return 2
+	}
+	return 0
+}
+
+func printBundleRefs(cmd command, verb string, refs []gitgo.BundleRef) {
+	if len(refs) == 1 {
+		fmt.Fprintf(cmd.stdout, "The bundle %s this ref:\n", verb)
+	} else {
+		fmt.Fprintf(cmd.stdout, "The bundle %s these %d refs:\n", verb, len(refs))
+	}
+	for _, ref := range refs {
+		fmt.Fprintf(cmd.stdout, "%s %s\n", ref.OID, ref.Name)
+	}
+}
+
+// cmdServeHandler serves the repositories under a directory, the
+// current one by default, over HTTP until interrupted.
+func cmdServeHandler(cmd command) int {
+	addr := "localhost:8080"
+	server := &gitgo.Server{Root: cmd.repo.Path}
+	var dirs []string
+	badFlag := false
+	for i := 0; i < len(cmd.args); i++ {
+		arg := cmd.args[i]
+		switch {
+		case arg == "--read-only":
+			server.ReadOnly = true
+		case arg == "--listen" && i+1 < len(cmd.args):
+			i++
+			addr = cmd.args[i]
+		case strings.HasPrefix(arg, "--listen="):
+			addr = strings.TrimPrefix(arg, "--listen=")
+		case strings.HasPrefix(arg, "-"):
+			badFlag = true
+		default:
+			dirs = append(dirs, arg)
+		}
+	}
+	if badFlag || len(dirs) > 1 {
+		fmt.Fprintln(cmd.stderr, "usage: gitgo serve [--listen <address>] [--read-only] [<directory>]")
+		return 2
+	}
+	if len(dirs) == 1 {
+		server.Root = dirs[0]
+		if !filepath.IsAbs(server.Root) {
+			server.Root = filepath.Join(cmd.repo.Path, server.Root)
+		}
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fatal(cmd, err)
+	}
+	srv := &http.Server{Handler: server}
+	fmt.Fprintf(cmd.stderr, "Serving %s on http://%s/\n", server.Root, ln.Addr())
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(ln) }()
+	select {
+	case err = <-done:
+		return fatal(cmd, err)
+	case <-cmd.ctx.Done():
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		return fatal(cmd, err)
+	}
+	return 0
+}
+
+// cmdLogHandler prints the history of HEAD the way `git log` and
+// `git log --oneline` do when not writing to a terminal.
+func cmdLogHandler(cmd command) int {
+	usage := func() int {
+		fmt.Fprintln(cmd.stderr, "usage: gitgo log [--oneline] [-n <number>] [--follow [-M<n>]] [[--] <path>]")
+		return 2
+	}
+	oneline, follow := false, false
+	limit := -1
+	renames := gitgo.RenameOptions{}
+	var paths []string
+	for i := 0; i < len(cmd.args); i++ {
+		switch arg := cmd.args[i]; {
+		case arg == "--oneline":
+			oneline = true
+		case arg == "--follow":
+			follow = true
+		case arg == "-n" && i+1 < len(cmd.args):
+			i++
+			n, err := strconv.Atoi(cmd.args[i])
+			if err != nil {
+				fmt.Fprintf(cmd.stderr, "fatal: '%s': not an integer\n", cmd.args[i])
+				return 128
+			}
+			limit = n
+		case arg == "--":
+			paths = append(paths, cmd.args[i+1:]...)
+			i = len(cmd.args)
+		case strings.HasPrefix(arg, "-"):
+			ok, err := renameFlag(arg, &renames)
+			if err != nil {
+				fmt.Fprintf(cmd.stderr, "fatal: %v\n", err)
+				return 128
+			}
+			if !ok {
+				return usage()
+			}
+		default:
+			paths = append(paths, arg)
+		}
+	}
+	if len(paths) > 1 || follow && len(paths) == 0 {
+		if follow {
+			fmt.Fprintln(cmd.stderr, "fatal: --follow requires exactly one pathspec")
+			return 128
+		}
+		return usage()
+	}
+	if follow {
+		renames.Renames = true
+	} else {
+		renames = gitgo.RenameOptions{}
+	}
+	walk := cmd.repo.Log
+	if len(paths) == 1 {
+		walk = func(fn func(oid string, commit *gitgo.Commit) error) error {
+			return cmd.repo.LogFile(filepath.Join(cmd.pwd, paths[0]), renames, fn)
+		}
+	}
 
-		database.Data(gitgo.TypeFile, data)
-		hash, err := database.Store()
+	out := bufio.NewWriter(cmd.stdout)
+	defer out.Flush()
+	count := 0
+	err := walk(func(oid string, commit *gitgo.Commit) error {
+		if count == limit {
+			return gitgo.ErrStopWalk
+		}
+		count++
+		if oneline {
+			fmt.Fprintf(out, "%s %s\n", oid[:7], gitgo.FirstLine(commit.Message))
+			return nil
+		}
+
+		author, err := gitgo.ParseSignature(commit.Author)
 		if err != nil {
-			fmt.Fprintf(cmd.stderr, "error: %v\n", err)
-			return 1
+			return err
 		}
+		if count > 1 {
+			fmt.Fprintln(out)
+		}
+		fmt.Fprintf(out, "commit %s\n", oid)
+		fmt.Fprintf(out, "Author: %s <%s>\n", author.Name, author.Email)
+		fmt.Fprintf(out, "Date:   %s\n\n", author.When.Format("Mon Jan 2 15:04:05 2006 -0700"))
+		for _, line := range strings.Split(strings.TrimRight(commit.Message, "\n"), "\n") {
+			fmt.Fprintf(out, "    %s\n", line)
+		}
+		return nil
+	})
+	if err != nil {
+		out.Flush()
+		return fatal(cmd, err)
+	}
+	return 0
+}
 
-		index.Add(p, hash, stat)
+// cmdMergeBaseHandler prints the best common ancestor of two commits,
+// or with --all every one of them. Like git, it exits with 1 when they
+// have none, which in a shallow repository can be that it is below the
+// shallow commits.
+func cmdMergeBaseHandler(cmd command) int {
+	usage := func() int {
+		fmt.Fprintln(cmd.stderr, "usage: gitgo merge-base [--all] <commit> <commit>")
+		return 2
+	}
+	all := false
+	var revs []string
+	for _, arg := range cmd.args {
+		switch {
+		case arg == "-a" || arg == "--all":
+			all = true
+		case strings.HasPrefix(arg, "-"):
+			return usage()
+		default:
+			revs = append(revs, arg)
+		}
+	}
+	if len(revs) != 2 {
+		return usage()
+	}
+	oids := make([]string, len(revs))
+	for i, rev := range revs {
+		oid, err := cmd.repo.ResolveRevision(rev)
+		if err != nil {
+			if errors.Is(err, gitgo.ErrUnknownRevision) || errors.Is(err, gitgo.ErrAmbiguousRevision) {
+				fmt.Fprintf(cmd.stderr, "fatal: %v\n", err)
+				return 128
+			}
+			return fatal(cmd, err)
+		}
+		oids[i] = oid
 	}
 
-	res, err := index.WriteUpdate()
+	bases, err := cmd.repo.MergeBases(oids[0], oids[1])
 	if err != nil {
-		fmt.Fprintf(cmd.stderr, "error: %v\n", err)
+		return fatal(cmd, err)
+	}
+	if len(bases) == 0 {
 		return 1
 	}
+	if !all {
+		bases = bases[:1]
+	}
+	for _, oid := range bases {
+		fmt.Fprintln(cmd.stdout, oid)
+	}
+	return 0
+}
+
+// cmdDiffHandler shows the changes between the workspace, the index
+// and commits, as a patch or as names with their status.
+func cmdDiffHandler(cmd command) int {
+	opts := gitgo.DiffOptions{RenameOptions: cmd.repo.RenameConfig("diff.renames")}
+	nameStatus := false
+	var revs []string
+	for _, arg := range cmd.args {
+		switch {
+		case arg == "--cached" || arg == "--staged":
+			opts.Cached = true
+		case arg == "--name-status":
+			nameStatus = true
+		case strings.HasPrefix(arg, "-"):
+			ok, err := renameFlag(arg, &opts.RenameOptions)
+			if err != nil {
+				fmt.Fprintf(cmd.stderr, "fatal: %v\n", err)
+				return 128
+			}
+			if !ok {
+				fmt.Fprintln(cmd.stderr, "usage: gitgo diff [--cached] [--name-status] [-M<n>] [-C<n>] [--no-renames] [<commit> [<commit>]]")
+				return 2
+			}
+		default:
+			revs = append(revs, arg)
+		}
+	}
+	if len(revs) > 2 || len(revs) == 2 && opts.Cached {
+		fmt.Fprintln(cmd.stderr, "usage: gitgo diff [--cached] [--name-status] [-M<n>] [-C<n>] [--no-renames] [<commit> [<commit>]]")
+		return 2
+	}
+	if len(revs) > 0 {
+		opts.From = revs[0]
+	}
+	if len(revs) > 1 {
+		opts.To = revs[1]
+	}
+
+	changes, err := cmd.repo.Diff(cmd.ctx, opts)
+	if err != nil {
+		if errors.Is(err, gitgo.ErrUnknownRevision) || errors.Is(err, gitgo.ErrAmbiguousRevision) {
+			fmt.Fprintf(cmd.stderr, "fatal: %v\n", err)
+			return 128
+		}
+		return fatal(cmd, err)
+	}
+	if !nameStatus {
+		if err := cmd.repo.WritePatch(cmd.stdout, changes); err != nil {
+			return fatal(cmd, err)
+		}
+		return 0
+	}
+	printNameStatus(cmd.stdout, changes)
+	return 0
+}
 
-	if res {
-		fmt.Fprintln(cmd.stdout, "Written data to Index file")
+func cmdBlameHandler(cmd command) int {
+	usage := func() int {
+		fmt.Fprintln(cmd.stderr, "usage: gitgo blame [-L <start>,<end>] [-w] [--porcelain] [<rev>] [--] <file>")
+		return 2
+	}
+	opts := gitgo.BlameOptions{}
+	porcelain := false
+	var args []string
+	for i := 0; i < len(cmd.args); i++ {
+		switch arg := cmd.args[i]; {
+		case arg == "--":
+			args = append(args, cmd.args[i+1:]...)
+			i = len(cmd.args)
+		case arg == "--porcelain":
+			porcelain = true
+		case arg == "-w":
+			opts.IgnoreWhitespace = true
+		case strings.HasPrefix(arg, "-L"):
+			spec := strings.TrimPrefix(arg, "-L")
+			if spec == "" && i+1 < len(cmd.args) {
+				i++
+				spec = cmd.args[i]
+			}
+			var err error
+			if opts.Start, opts.End, err = parseLineRange(spec); err != nil {
+				fmt.Fprintf(cmd.stderr, "fatal: %v\n", err)
+				return 128
+			}
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			return usage()
+		default:
+			args = append(args, arg)
+		}
 	}
+	switch len(args) {
+	case 1:
+		args = append([]string{""}, args...)
+	case 2:
+	default:
+		return usage()
+	}
+	opts.Rev = args[0]
 
+	blame, err := cmd.repo.Blame(filepath.Join(cmd.pwd, args[1]), opts)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return fatal(cmd, err)
+		}
+		fmt.Fprintf(cmd.stderr, "fatal: %v\n", err)
+		return 128
+	}
+	out := bufio.NewWriter(cmd.stdout)
+	defer out.Flush()
+	if porcelain {
+		printBlamePorcelain(out, blame)
+	} else {
+		printBlame(out, blame)
+	}
 	return 0
 }
 
+// parseLineRange reads the <start>,<end> of blame -L, where end may be
+// +<count> or left out for the end of the file.
+func parseLineRange(spec string) (int, int, error) {
+	from, to, _ := strings.Cut(spec, ",")
+	start, end := 1, 0
+	var err error
+	if from != "" {
+		if start, err = strconv.Atoi(from); err != nil || start < 1 {
+			return 0, 0, fmt.Errorf("invalid -L argument '%s'", spec)
+		}
+	}
+	if count, ok := strings.CutPrefix(to, "+"); ok {
+		n, err := strconv.Atoi(count)
+		if err != nil || n < 1 {
+			return 0, 0, fmt.Errorf("invalid -L argument '%s'", spec)
+		}
+		end = start + n - 1
+	} else if to != "" {
+		if end, err = strconv.Atoi(to); err != nil || end < 1 {
+			return 0, 0, fmt.Errorf("invalid -L argument '%s'", spec)
+		}
+	}
+	return start, end, nil
+}
+
+// blameGroups cuts the lines of a blame into runs of consecutive lines
+// of the same file in the same commit.
+func blameGroups(lines []gitgo.BlameLine) [][]gitgo.BlameLine {
+	var groups [][]gitgo.BlameLine
+	for i, line := range lines {
+		if i > 0 {
+			prev := lines[i-1]
+			if line.Commit == prev.Commit && line.Path == prev.Path && line.OrigLine == prev.OrigLine+1 {
+				groups[len(groups)-1] = append(groups[len(groups)-1], line)
+				continue
+			}
+		}
+		groups = append(groups, []gitgo.BlameLine{line})
+	}
+	return groups
+}
+
+// printBlamePorcelain writes the blame in git's porcelain format, the
+// details of a commit following its first line only.
+func printBlamePorcelain(out io.Writer, blame *gitgo.Blame) {
+	seen := make(map[string]bool)
+	for _, group := range blameGroups(blame.Lines) {
+		for i, line := range group {
+			if i == 0 {
+				fmt.Fprintf(out, "%s %d %d %d\n", line.Commit, line.OrigLine, line.Line, len(group))
+			} else {
+				fmt.Fprintf(out, "%s %d %d\n", line.Commit, line.OrigLine, line.Line)
+			}
+			if !seen[line.Commit] {
+				seen[line.Commit] = true
+				c := blame.Commits[line.Commit]
+				for _, sig := range []struct {
+					role string
+					gitgo.Signature
+				}{{"author", c.Author}, {"committer", c.Committer}} {
+					fmt.Fprintf(out, "%s %s\n", sig.role, sig.Name)
+					fmt.Fprintf(out, "%s-mail <%s>\n", sig.role, sig.Email)
+					fmt.Fprintf(out, "%s-time %d\n", sig.role, sig.When.Unix())
+					fmt.Fprintf(out, "%s-tz %s\n", sig.role, sig.When.Format("-0700"))
+				}
+				fmt.Fprintf(out, "summary %s\n", gitgo.FirstLine(c.Commit.Message))
+				if c.Boundary {
+					fmt.Fprintln(out, "boundary")
+				}
+				if c.Previous != "" {
+					fmt.Fprintf(out, "previous %s %s\n", c.Previous, c.PreviousPath)
+				}
+				fmt.Fprintf(out, "filename %s\n", line.Path)
+			}
+			fmt.Fprintf(out, "\t%s\n", line.Text)
+		}
+	}
+}
+
+// printBlame writes the blame like git does by default, every line
+// after its abbreviated commit, the file it was in when it moved, and
+// who wrote it when.
+func printBlame(out io.Writer, blame *gitgo.Blame) {
+	authorWidth, pathWidth, showPath := 0, 0, false
+	for _, line := range blame.Lines {
+		authorWidth = max(authorWidth, utf8.RuneCountInString(blame.Commits[line.Commit].Author.Name))
+		pathWidth = max(pathWidth, len(line.Path))
+		showPath = showPath || line.Path != blame.Path
+	}
+	numberWidth := 1
+	if len(blame.Lines) > 0 {
+		numberWidth = len(strconv.Itoa(blame.Lines[len(blame.Lines)-1].Line))
+	}
+
+	for _, line := range blame.Lines {
+		c := blame.Commits[line.Commit]
+		if c.Boundary {
+			fmt.Fprintf(out, "^%s", line.Commit[:7])
+		} else {
+			fmt.Fprint(out, line.Commit[:8])
+		}
+		if showPath {
+			fmt.Fprintf(out, " %-*s", pathWidth, line.Path)
+		}
+		name := c.Author.Name
+		fmt.Fprintf(out, " (%s%*s %s %*d) %s\n",
+			name, authorWidth-utf8.RuneCountInString(name), "",
+			c.Author.When.Format("2006-01-02 15:04:05 -0700"),
+			numberWidth, line.Line, line.Text)
+	}
+}
+
 func cmdStatusHandler(cmd command) int {
-	index := gitgo.NewIndex(cmd.repo.Path, cmd.repo.GitPath)
-	index.Load()
+	status, err := cmd.repo.StatusContext(cmd.ctx)
+	if err != nil {
+		return fatal(cmd, err)
+	}
+
+	printResult(cmd, status)
+	return 0
+}
+
+func cmdConfigHandler(cmd command) int {
+	cfg, err := cmd.repo.Config()
+	if err != nil {
+		fmt.Fprintf(cmd.stderr, "error: %v\n", err)
+		return 1
+	}
+
+	args := cmd.args
+	switch {
+	case len(args) == 1 && !strings.HasPrefix(args[0], "-"):
+		value, ok := cfg.Get(args[0])
+		if !ok {
+			return 1
+		}
+		fmt.Fprintln(cmd.stdout, value)
+		return 0
+	case len(args) == 2 && !strings.HasPrefix(args[0], "-"):
+		err = cfg.Set(args[0], args[1])
+	default:
+		fmt.Fprintln(cmd.stderr, "usage: gitgo config <name> [<value>]")
+		return 2
+	}
+	if err == nil {
+		err = cfg.Save()
+	}
+	if err != nil {
+		fmt.Fprintf(cmd.stderr, "error: %v\n", err)
+		return 1
+	}
+	return 0
+}
 
-	stats := make(map[string]os.FileInfo)
-	changed := datastr.NewSortedSet()
-	changes := make(map[string]WorkspaceUpdateType)
-	untracked := datastr.NewSortedSet()
+func cmdUpdateIndexHandler(cmd command) int {
+	forceUnlock(&cmd)
+	if len(cmd.args) != 1 || cmd.args[0] != "--force-rebuild" {
+		fmt.Fprintln(cmd.stderr, "usage: gitgo update-index [--force-unlock] --force-rebuild")
+		return 2
+	}
 
-	scanWorkspace(cmd, *untracked, "", index, stats)
-	detectWorkspaceChanges(cmd, changed, changes, index, stats)
+	_, err := gitgo.RebuildIndex(cmd.repo.Path, cmd.repo.GitPath, cmd.repo.ObjectDatabase(), workers(cmd))
+	if err != nil {
+		return fatal(cmd, err)
+	}
+	fmt.Fprintln(cmd.stdout, "Rebuilt index from HEAD")
+	return 0
+}
 
-	index.WriteUpdate()
+func cmdCherryPickHandler(cmd command) int {
+	return sequencerHandler(cmd, "cherry-pick")
+}
+
+func cmdRevertHandler(cmd command) int {
+	return sequencerHandler(cmd, "revert")
+}
 
-	printResult(cmd, changed, untracked, changes)
+// sequencerHandler runs cherry-pick and revert, which take the same
+// commits, or one of --continue, --skip and --abort to go on after
+// they stopped.
+func sequencerHandler(cmd command, name string) int {
+	usage := "usage: gitgo " + name + " [-x] <commit>... | --continue | --skip | --abort"
+	opts := gitgo.PickOptions{Name: cmd.env["name"], Email: cmd.env["email"]}
+	if date := cmd.env["date"]; date != "" {
+		when, err := gitgo.ParseDate(date)
+		if err != nil {
+			fmt.Fprintf(cmd.stderr, "fatal: invalid GITGO_AUTHOR_DATE: %v\n", err)
+			return 128
+		}
+		opts.When = when
+	}
+
+	var action string
+	var revs []string
+	for _, arg := range cmd.args {
+		switch {
+		case arg == "--continue" || arg == "--skip" || arg == "--abort":
+			action = arg
+		case arg == "-x" && name == "cherry-pick":
+			opts.RecordOrigin = true
+		case strings.HasPrefix(arg, "-"):
+			fmt.Fprintln(cmd.stderr, usage)
+			return 2
+		default:
+			revs = append(revs, arg)
+		}
+	}
+	if action == "" && len(revs) == 0 || action != "" && len(revs) > 0 {
+		fmt.Fprintln(cmd.stderr, usage)
+		return 2
+	}
+
+	var commits []string
+	var err error
+	switch action {
+	case "--continue":
+		commits, err = cmd.repo.ContinuePick(cmd.ctx, opts)
+	case "--skip":
+		commits, err = cmd.repo.SkipPick(cmd.ctx, opts)
+	case "--abort":
+		err = cmd.repo.AbortPick(cmd.ctx)
+	default:
+		if name == "revert" {
+			commits, err = cmd.repo.Revert(cmd.ctx, revs, opts)
+		} else {
+			commits, err = cmd.repo.CherryPick(cmd.ctx, revs, opts)
+		}
+	}
+
+	branch := "detached HEAD"
+	if ref, _ := gitgo.RefInitialize(cmd.repo.Refs).HeadRef(); ref != "" {
+		branch = gitgo.ShortRefName(ref)
+	}
+	for _, oid := range commits {
+		commit, err := cmd.repo.ObjectDatabase().ReadCommit(oid)
+		if err != nil {
+			return fatal(cmd, err)
+		}
+		fmt.Fprintf(cmd.stdout, "[%s %s] %s\n", branch, oid[:7], gitgo.FirstLine(commit.Message))
+	}
+
+	var perr *gitgo.PickError
+	switch {
+	case errors.As(err, &perr):
+		for _, path := range perr.Paths {
+			fmt.Fprintf(cmd.stdout, "CONFLICT (content): Merge conflict in %s\n", path)
+		}
+		fmt.Fprintf(cmd.stderr, "error: %v\n", perr)
+		if len(perr.Paths) > 0 {
+			fmt.Fprintf(cmd.stderr, "hint: after resolving the conflicts, mark the corrected paths\n"+
+				"hint: with 'gitgo add <paths>' and run 'gitgo %s --continue'.\n", name)
+		}
+		fmt.Fprintf(cmd.stderr, "hint: use 'gitgo %[1]s --skip' to skip this commit,\n"+
+			"hint: or 'gitgo %[1]s --abort' to go back to where you started.\n", name)
+		return 1
+	case errors.Is(err, gitgo.ErrLocalChanges):
+		fmt.Fprintf(cmd.stderr, "error: %v\nhint: commit your changes to proceed.\n", err)
+		return 1
+	case errors.Is(err, gitgo.ErrUnknownRevision):
+		fmt.Fprintf(cmd.stderr, "fatal: %v\n", err)
+		return 128
+	case err != nil:
+		return fatal(cmd, err)
+	}
+	return 0
+}
+
+// The command `rebase` replays the commits of the branch onto
+// upstream, or with -i the todo list the sequence editor leaves,
+// stopping at conflicts and edit lines until --continue, --skip or
+// --abort.
+func cmdRebaseHandler(cmd command) int {
+	usage := "usage: gitgo rebase [-i] [--autosquash] <upstream> | --continue | --skip | --abort"
+	opts := gitgo.RebaseOptions{
+		Name:  cmd.env["name"],
+		Email: cmd.env["email"],
+		EditTodo: func(path string) error {
+			return runShell(cmd, editor(cmd, true)+` "$@"`, path)
+		},
+		EditMessage: func(path string) error {
+			return runShell(cmd, editor(cmd, false)+` "$@"`, path)
+		},
+		Exec: func(command string) error {
+			fmt.Fprintf(cmd.stderr, "Executing: %s\n", command)
+			return runShell(cmd, command)
+		},
+	}
+	if date := cmd.env["date"]; date != "" {
+		when, err := gitgo.ParseDate(date)
+		if err != nil {
+			fmt.Fprintf(cmd.stderr, "fatal: invalid GITGO_AUTHOR_DATE: %v\n", err)
+			return 128
+		}
+		opts.When = when
+	}
+
+	var action string
+	var upstreams []string
+	for _, arg := range cmd.args {
+		switch {
+		case arg == "--continue" || arg == "--skip" || arg == "--abort":
+			action = arg
+		case arg == "-i" || arg == "--interactive":
+			opts.Interactive = true
+		case arg == "--autosquash":
+			opts.Autosquash = true
+		case strings.HasPrefix(arg, "-"):
+			fmt.Fprintln(cmd.stderr, usage)
+			return 2
+		default:
+			upstreams = append(upstreams, arg)
+		}
+	}
+	if action == "" && len(upstreams) != 1 || action != "" && len(upstreams) > 0 {
+		fmt.Fprintln(cmd.stderr, usage)
+		return 2
+	}
+
+	var res *gitgo.RebaseResult
+	var err error
+	switch action {
+	case "--continue":
+		res, err = cmd.repo.ContinueRebase(cmd.ctx, opts)
+	case "--skip":
+		res, err = cmd.repo.SkipRebase(cmd.ctx, opts)
+	case "--abort":
+		err = cmd.repo.AbortRebase(cmd.ctx, opts)
+	default:
+		res, err = cmd.repo.Rebase(cmd.ctx, upstreams[0], opts)
+	}
+
+	var perr *gitgo.PickError
+	var eerr *gitgo.RebaseExecError
+	switch {
+	case errors.As(err, &perr):
+		for _, path := range perr.Paths {
+			fmt.Fprintf(cmd.stdout, "CONFLICT (content): Merge conflict in %s\n", path)
+		}
+		fmt.Fprintf(cmd.stderr, "error: %v\n"+
+			"hint: Resolve all conflicts manually, mark them as resolved with\n"+
+			"hint: \"gitgo add <paths>\", then run \"gitgo rebase --continue\".\n"+
+			"hint: You can instead skip this commit: run \"gitgo rebase --skip\".\n"+
+			"hint: To abort and get back to the state before \"gitgo rebase\", run \"gitgo rebase --abort\".\n", perr)
+		return 1
+	case errors.As(err, &eerr):
+		fmt.Fprintf(cmd.stderr, "warning: %v\n"+
+			"You can fix the problem, and then run\n\n  gitgo rebase --continue\n\n", eerr)
+		return 1
+	case errors.Is(err, gitgo.ErrLocalChanges):
+		fmt.Fprintf(cmd.stderr, "error: cannot rebase: %v\nhint: commit your changes to proceed.\n", err)
+		return 1
+	case errors.Is(err, gitgo.ErrUnknownRevision):
+		fmt.Fprintf(cmd.stderr, "fatal: %v\n", err)
+		return 128
+	case err != nil:
+		return fatal(cmd, err)
+	case res == nil:
+		return 0
+	}
+
+	switch {
+	case res.UpToDate:
+		fmt.Fprintf(cmd.stdout, "Current branch %s is up to date.\n", gitgo.ShortRefName(cmp.Or(res.Branch, "HEAD")))
+	case res.Stopped != "":
+		commit, err := cmd.repo.ObjectDatabase().ReadCommit(res.Stopped)
+		if err != nil {
+			return fatal(cmd, err)
+		}
+		fmt.Fprintf(cmd.stderr, "Stopped at %s...  %s\n"+
+			"You can amend the commit now: change the files, add them with\n"+
+			"'gitgo add' and run 'gitgo rebase --continue' once you are satisfied.\n",
+			res.Stopped[:7], gitgo.FirstLine(commit.Message))
+	default:
+		fmt.Fprintf(cmd.stderr, "Successfully rebased and updated %s.\n", cmp.Or(res.Branch, "detached HEAD"))
+	}
+	return 0
+}
+
+// The command `stash` saves the changes of the index and the workspace
+// as a stash entry and takes them back to HEAD, lists, shows, applies
+// or drops the entries.
+func cmdStashHandler(cmd command) int {
+	usage := "usage: gitgo stash [push [-u] [-m <message>] [--] [<paths>...] | list | show [-p] [<stash>] |\n" +
+		"                    apply [<stash>] | pop [<stash>] | drop [<stash>] | clear]"
+	action, args := "push", cmd.args
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		action, args = args[0], args[1:]
+	}
+
+	if action == "push" {
+		return stashPush(cmd, args, usage)
+	}
+	patch := false
+	var revs []string
+	for _, arg := range args {
+		switch {
+		case (arg == "-p" || arg == "--patch") && action == "show":
+			patch = true
+		case strings.HasPrefix(arg, "-"):
+			fmt.Fprintln(cmd.stderr, usage)
+			return 2
+		default:
+			revs = append(revs, arg)
+		}
+	}
+	if len(revs) > 1 || len(revs) > 0 && (action == "list" || action == "clear") {
+		fmt.Fprintln(cmd.stderr, usage)
+		return 2
+	}
+	rev := ""
+	if len(revs) > 0 {
+		rev = revs[0]
+	}
+
+	var dropped *gitgo.StashEntry
+	var err error
+	switch action {
+	case "list":
+		entries, err := cmd.repo.StashList()
+		if err != nil {
+			return fatal(cmd, err)
+		}
+		for _, e := range entries {
+			fmt.Fprintf(cmd.stdout, "%s: %s\n", e.Name, e.Message)
+		}
+		return 0
+	case "show":
+		changes, err := cmd.repo.StashDiff(rev)
+		if err != nil {
+			return fatal(cmd, err)
+		}
+		if patch {
+			if err := cmd.repo.WritePatch(cmd.stdout, changes); err != nil {
+				return fatal(cmd, err)
+			}
+			return 0
+		}
+		printNameStatus(cmd.stdout, changes)
+		return 0
+	case "clear":
+		if err := cmd.repo.StashClear(); err != nil {
+			return fatal(cmd, err)
+		}
+		return 0
+	case "drop":
+		dropped, err = cmd.repo.StashDrop(rev)
+	case "apply":
+		_, err = cmd.repo.StashApply(cmd.ctx, rev)
+	case "pop":
+		dropped, err = cmd.repo.StashPop(cmd.ctx, rev)
+	default:
+		fmt.Fprintln(cmd.stderr, usage)
+		return 2
+	}
+
+	var cerr *gitgo.StashConflictError
+	switch {
+	case errors.As(err, &cerr):
+		for _, path := range cerr.Paths {
+			fmt.Fprintf(cmd.stdout, "CONFLICT (content): Merge conflict in %s\n", path)
+		}
+		if action == "pop" {
+			fmt.Fprintln(cmd.stdout, "The stash entry is kept in case you need it again.")
+		}
+		return 1
+	case errors.Is(err, gitgo.ErrLocalChanges):
+		fmt.Fprintf(cmd.stderr, "error: %v\nhint: commit your changes or stash them to proceed.\n", err)
+		return 1
+	case err != nil:
+		return fatal(cmd, err)
+	}
+	if action != "drop" {
+		status, err := cmd.repo.StatusContext(cmd.ctx)
+		if err != nil {
+			return fatal(cmd, err)
+		}
+		printResult(cmd, status)
+	}
+	if dropped != nil {
+		fmt.Fprintf(cmd.stdout, "Dropped %s (%s)\n", cmp.Or(rev, "refs/stash@{0}"), dropped.OID)
+	}
+	return 0
+}
+
+// stashPush saves the changes of the files args name, all without
+// any, as a stash entry.
+func stashPush(cmd command, args []string, usage string) int {
+	opts := gitgo.StashOptions{Name: cmd.env["name"], Email: cmd.env["email"]}
+	if date := cmd.env["date"]; date != "" {
+		when, err := gitgo.ParseDate(date)
+		if err != nil {
+			fmt.Fprintf(cmd.stderr, "fatal: invalid GITGO_AUTHOR_DATE: %v\n", err)
+			return 128
+		}
+		opts.When = when
+	}
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--":
+			opts.Paths = append(opts.Paths, args[i+1:]...)
+			i = len(args)
+		case arg == "-u" || arg == "--include-untracked":
+			opts.Untracked = true
+		case arg == "-m" || arg == "--message":
+			if i+1 == len(args) {
+				fmt.Fprintln(cmd.stderr, usage)
+				return 2
+			}
+			i++
+			opts.Message = args[i]
+		case strings.HasPrefix(arg, "--message="):
+			opts.Message = strings.TrimPrefix(arg, "--message=")
+		case strings.HasPrefix(arg, "-"):
+			fmt.Fprintln(cmd.stderr, usage)
+			return 2
+		default:
+			opts.Paths = append(opts.Paths, arg)
+		}
+	}
+
+	entry, err := cmd.repo.StashPush(cmd.ctx, opts)
+	if err != nil {
+		return fatal(cmd, err)
+	}
+	if entry == nil {
+		fmt.Fprintln(cmd.stdout, "No local changes to save")
+		return 0
+	}
+	fmt.Fprintf(cmd.stdout, "Saved working directory and index state %s\n", entry.Message)
 	return 0
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
@@ -8,6 +9,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/brianvoe/gofakeit/v7"
 	"github.com/stretchr/testify/assert"
@@ -191,7 +193,7 @@ func TestRepoInitialization(t *testing.T) {
 	assert.NoErrorf(t, err, "error reading .gitgo dir")
 
 	for _, dirInfo := range dirInfos {
-		assert.Contains(t, gitgoFolders, dirInfo.Name())
+		assert.Contains(t, gitgo.RepositoryDirs, dirInfo.Name())
 	}
 
 	tearDown(t, cmd)
@@ -908,3 +910,501 @@ func testReportDeleteFilesInDir(t *testing.T) {
 
 	tearDown(t, cmd)
 }
+
+func TestParallelAdd(t *testing.T) {
+	cmds, cmd := tearUp(t)
+	cmd.env["jobs"] = "4"
+
+	for i := range 50 {
+		dir := filepath.Join(cmd.pwd, fmt.Sprintf("dir%d", i%5))
+		err := os.MkdirAll(dir, 0755)
+		assert.NoError(t, err)
+		err = os.WriteFile(filepath.Join(dir, fmt.Sprintf("file%d.txt", i)), []byte(gofakeit.Sentence(4)), 0644)
+		assert.NoError(t, err)
+	}
+
+	cmd.name = "add"
+	cmd.args = []string{"."}
+	exitCode, err := cmds.run(cmd)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, exitCode)
+
+	index := gitgo.NewIndex(cmd.repo.Path, cmd.repo.GitPath)
+	assert.NoError(t, index.Load())
+	entries := index.Entries()
+	assert.Equal(t, 50, len(entries))
+	for i := 1; i < len(entries); i++ {
+		assert.Less(t, entries[i-1].Path, entries[i].Path)
+	}
+
+	cmd.name = "status"
+	cmd.args = []string{}
+	cmd.stdout = tempFile("stdout")
+	exitCode, err = cmds.run(cmd)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, exitCode)
+
+	// every file is staged and none differs from what was hashed
+	expected := ""
+	for _, entry := range entries {
+		expected += "A  " + entry.Path + "\n"
+	}
+	cmd.stdout.Seek(0, 0)
+	stdoutCon, _ := io.ReadAll(cmd.stdout)
+	assert.Equal(t, expected, string(stdoutCon))
+
+	tearDown(t, cmd)
+}
+
+func TestAddWithIndexLocked(t *testing.T) {
+	cmds, cmd := tearUp(t)
+
+	err := os.WriteFile(filepath.Join(cmd.pwd, "file.txt"), []byte("content"), 0644)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(cmd.repo.GitPath, "index.lock"), nil, 0644)
+	assert.NoError(t, err)
+
+	cmd.name = "add"
+	cmd.args = []string{"file.txt"}
+	exitCode, err := cmds.run(cmd)
+	assert.NoError(t, err)
+	assert.Equal(t, 128, exitCode)
+
+	cmd.stderr.Seek(0, 0)
+	stderrCon, _ := io.ReadAll(cmd.stderr)
+	assert.Contains(t, string(stderrCon), "Another gitgo process seems to be running")
+
+	tearDown(t, cmd)
+}
+
+func TestAddForceUnlock(t *testing.T) {
+	cmds, cmd := tearUp(t)
+
+	err := os.WriteFile(filepath.Join(cmd.pwd, "file.txt"), []byte("content"), 0644)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(cmd.repo.GitPath, "index.lock"), nil, 0644)
+	assert.NoError(t, err)
+
+	cmd.name = "add"
+	cmd.args = []string{"--force-unlock", "file.txt"}
+	exitCode, err := cmds.run(cmd)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, exitCode)
+
+	cmd.stderr.Seek(0, 0)
+	stderrCon, _ := io.ReadAll(cmd.stderr)
+	assert.Contains(t, string(stderrCon), "warning: removed lock")
+
+	_, index, err := gitgo.IndexHoldForUpdate(cmd.repo.Path, cmd.repo.GitPath)
+	assert.NoError(t, err)
+	assert.True(t, index.IsTracked("file.txt"))
+	index.Release()
+
+	tearDown(t, cmd)
+}
+
+func TestLockOptions(t *testing.T) {
+	cmds, cmd := indexWorkspaceChange(t)
+	defer tearDown(t, cmd)
+
+	code, _, _ := runCommand(t, cmds, cmd.repo, "", "config", "core.lockTimeout", "soon")
+	assert.Equal(t, 0, code)
+	code, _, stderr := runCommand(t, cmds, cmd.repo, "", "status")
+	assert.Equal(t, 128, code)
+	assert.Equal(t, "fatal: bad duration \"soon\" for core.lockTimeout\n", stderr)
+
+	code, _, _ = runCommand(t, cmds, cmd.repo, "", "config", "core.lockTimeout", "2s")
+	assert.Equal(t, 0, code)
+	code, _, stderr = runCommand(t, cmds, cmd.repo, "", "status")
+	assert.Equal(t, 0, code, stderr)
+
+	// the options of a run are its own
+	assert.Equal(t, time.Duration(0), gitgo.DefaultLockOptions().Timeout)
+	assert.Nil(t, gitgo.DefaultLockOptions().Warn)
+}
+
+func TestCheckoutRestoresFiles(t *testing.T) {
+	cmds, cmd := indexWorkspaceChange(t)
+
+	err := os.Remove(filepath.Join(cmd.repo.Path, "1.txt"))
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(cmd.repo.Path, "a", "b", "3.txt"), []byte("changed"), 0644)
+	assert.NoError(t, err)
+
+	cmd.name = "checkout"
+	cmd.args = []string{"--", "1.txt", "a"}
+	exitCode, err := cmds.run(cmd)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, exitCode)
+
+	data, err := os.ReadFile(filepath.Join(cmd.repo.Path, "1.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "one", string(data))
+	data, err = os.ReadFile(filepath.Join(cmd.repo.Path, "a", "b", "3.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "three", string(data))
+
+	cmd.stderr.Seek(0, 0)
+	stderrCon, _ := io.ReadAll(cmd.stderr)
+	assert.Contains(t, string(stderrCon), "Updated 3 paths from the index")
+
+	tearDown(t, cmd)
+}
+
+func TestMetadataDir(t *testing.T) {
+	config := filepath.Join(t.TempDir(), ".gitgoconfig")
+	assert.Equal(t, ".gitgo", metadataDir(false, map[string]string{"globalConfig": config}))
+	assert.Equal(t, ".git", metadataDir(true, map[string]string{}))
+	assert.Equal(t, ".git", metadataDir(false, map[string]string{"interop": "1"}))
+
+	err := os.WriteFile(config, []byte("[gitgo]\n\tinterop = true\n"), 0644)
+	assert.NoError(t, err)
+	assert.Equal(t, ".git", metadataDir(false, map[string]string{"globalConfig": config}))
+	assert.Equal(t, ".gitgo", metadataDir(false, map[string]string{"globalConfig": config, "interop": "false"}))
+}
+
+func TestForceRebuildIndex(t *testing.T) {
+	cmds, cmd := indexWorkspaceChange(t)
+
+	indexPath := filepath.Join(cmd.repo.GitPath, "index")
+	data, err := os.ReadFile(indexPath)
+	assert.NoError(t, err)
+	data[len(data)-1] ^= 0xff
+	assert.NoError(t, os.WriteFile(indexPath, data, 0644))
+
+	cmd.name = "status"
+	cmd.args = []string{}
+	cmd.stderr = tempFile("stderr")
+	exitCode, err := cmds.run(cmd)
+	assert.NoError(t, err)
+	assert.Equal(t, 128, exitCode)
+	cmd.stderr.Seek(0, 0)
+	stderrCon, _ := io.ReadAll(cmd.stderr)
+	assert.Contains(t, string(stderrCon), "checksum mismatch")
+
+	err = os.WriteFile(filepath.Join(cmd.repo.Path, "a", "2.txt"), []byte("changed"), 0644)
+	assert.NoError(t, err)
+
+	cmd.name = "update-index"
+	cmd.args = []string{"--force-rebuild"}
+	exitCode, err = cmds.run(cmd)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, exitCode)
+
+	cmd.name = "status"
+	cmd.args = []string{}
+	cmd.stdout = tempFile("stdout")
+	exitCode, err = cmds.run(cmd)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, exitCode)
+
+	cmd.stdout.Seek(0, 0)
+	stdoutCon, _ := io.ReadAll(cmd.stdout)
+	assert.Equal(t, " M a/2.txt\n", string(stdoutCon))
+
+	tearDown(t, cmd)
+}
+
+// runCommand runs a command on repo and returns its exit code, stdout
+// and stderr.
+func runCommand(t *testing.T, cmds *commands, repo gitgo.Repository, stdin, name string, args ...string) (int, string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	files := make([]*os.File, 3)
+	for i, f := range []string{"stdin", "stdout", "stderr"} {
+		file, err := os.Create(filepath.Join(dir, f))
+		assert.NoError(t, err)
+		defer file.Close()
+		files[i] = file
+	}
+	files[0].WriteString(stdin)
+	files[0].Seek(0, 0)
+
+	exitCode, err := cmds.run(command{
+		name:   name,
+		args:   args,
+		env:    testGitgoVar(),
+		pwd:    repo.Path,
+		stdin:  files[0],
+		stdout: files[1],
+		stderr: files[2],
+		repo:   repo,
+	})
+	assert.NoError(t, err)
+	files[1].Seek(0, 0)
+	stdout, _ := io.ReadAll(files[1])
+	files[2].Seek(0, 0)
+	stderr, _ := io.ReadAll(files[2])
+	return exitCode, string(stdout), string(stderr)
+}
+
+func TestClonePushFetch(t *testing.T) {
+	cmds, cmd := indexWorkspaceChange(t)
+	defer tearDown(t, cmd)
+	upstream := cmd.repo
+	parent := t.TempDir()
+
+	code, _, stderr := runCommand(t, cmds, gitgo.NewRepository(parent), "", "clone", upstream.Path, "copy")
+	assert.Equal(t, 0, code, stderr)
+	assert.Equal(t, "Cloning into 'copy'...\n", stderr)
+	clone := gitgo.NewRepository(filepath.Join(parent, "copy"))
+	data, err := os.ReadFile(filepath.Join(clone.Path, "a", "b", "3.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "three", string(data))
+
+	_, stdout, _ := runCommand(t, cmds, clone, "", "remote", "-v")
+	assert.Equal(t, fmt.Sprintf("origin\t%s (fetch)\norigin\t%s (push)\n", upstream.Path, upstream.Path), stdout)
+
+	old := gitgo.RefInitialize(clone.Refs).ReadHead()
+	assert.NoError(t, os.WriteFile(filepath.Join(clone.Path, "1.txt"), []byte("changed"), 0644))
+	runCommand(t, cmds, clone, "", "add", "1.txt")
+	runCommand(t, cmds, clone, "change\n", "commit")
+	updated := gitgo.RefInitialize(clone.Refs).ReadHead()
+
+	code, _, stderr = runCommand(t, cmds, clone, "", "push")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, " ! [remote rejected] master -> master (branch is currently checked out)\n")
+
+	runCommand(t, cmds, upstream, "", "config", "receive.denyCurrentBranch", "ignore")
+	code, _, stderr = runCommand(t, cmds, clone, "", "push", "origin", "master")
+	assert.Equal(t, 0, code, stderr)
+	assert.Equal(t, fmt.Sprintf("To %s\n   %s..%s  master -> master\n", upstream.Path, old[:7], updated[:7]), stderr)
+	_, _, stderr = runCommand(t, cmds, clone, "", "push")
+	assert.Equal(t, "Everything up-to-date\n", stderr)
+
+	_, _, stderr = runCommand(t, cmds, clone, "", "fetch")
+	assert.Empty(t, stderr)
+
+	runCommand(t, cmds, clone, "", "remote", "add", "other", upstream.Path)
+	code, _, stderr = runCommand(t, cmds, clone, "", "fetch", "other")
+	assert.Equal(t, 0, code, stderr)
+	assert.Equal(t, fmt.Sprintf("From %s\n * [new branch]      master -> other/master\n", upstream.Path), stderr)
+
+	runCommand(t, cmds, clone, "", "remote", "remove", "other")
+	_, stdout, _ = runCommand(t, cmds, clone, "", "remote")
+	assert.Equal(t, "origin\n", stdout)
+}
+
+func TestShallowClone(t *testing.T) {
+	cmds, cmd := indexWorkspaceChange(t)
+	defer tearDown(t, cmd)
+	upstream := cmd.repo
+	runCommand(t, cmds, upstream, "first\n", "commit")
+	assert.NoError(t, os.WriteFile(filepath.Join(upstream.Path, "1.txt"), []byte("changed"), 0644))
+	runCommand(t, cmds, upstream, "", "add", "1.txt")
+	runCommand(t, cmds, upstream, "second\n", "commit")
+	parent := t.TempDir()
+
+	first, err := upstream.ResolveRevision("HEAD~1")
+	assert.NoError(t, err)
+	code, stdout, stderr := runCommand(t, cmds, upstream, "", "merge-base", "--all", "HEAD", "HEAD~1")
+	assert.Equal(t, 0, code, stderr)
+	assert.Equal(t, first+"\n", stdout)
+	code, _, _ = runCommand(t, cmds, upstream, "", "merge-base", "HEAD")
+	assert.Equal(t, 2, code)
+	code, _, stderr = runCommand(t, cmds, upstream, "", "merge-base", "HEAD", "nope")
+	assert.Equal(t, 128, code)
+	assert.Contains(t, stderr, "fatal: unknown revision")
+
+	code, _, stderr = runCommand(t, cmds, gitgo.NewRepository(parent), "", "clone", "--depth", "x", upstream.Path, "copy")
+	assert.Equal(t, 128, code)
+	assert.Equal(t, "fatal: depth x is not a positive number\n", stderr)
+	code, _, stderr = runCommand(t, cmds, gitgo.NewRepository(parent), "", "clone", "--depth=1", upstream.Path, "copy")
+	assert.Equal(t, 0, code, stderr)
+	clone := gitgo.NewRepository(filepath.Join(parent, "copy"))
+	shallow, err := clone.Shallow()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{gitgo.RefInitialize(upstream.Refs).ReadHead()}, shallow)
+
+	code, _, stderr = runCommand(t, cmds, clone, "", "fetch", "--deepen", "1", "--unshallow")
+	assert.Equal(t, 2, code, stderr)
+	code, _, stderr = runCommand(t, cmds, clone, "", "fetch", "--unshallow")
+	assert.Equal(t, 0, code, stderr)
+	shallow, err = clone.Shallow()
+	assert.NoError(t, err)
+	assert.Empty(t, shallow)
+}
+
+func TestBlameErrors(t *testing.T) {
+	cmds, cmd := indexWorkspaceChange(t)
+	defer tearDown(t, cmd)
+	runCommand(t, cmds, cmd.repo, "first\n", "commit")
+
+	code, _, stderr := runCommand(t, cmds, cmd.repo, "", "blame", "missing.txt")
+	assert.Equal(t, 128, code)
+	assert.Equal(t, "fatal: no such path 'missing.txt' in HEAD\n", stderr)
+	code, _, stderr = runCommand(t, cmds, cmd.repo, "", "blame", "-L", "2,3", "1.txt")
+	assert.Equal(t, 128, code)
+	assert.Equal(t, "fatal: file 1.txt has only 1 lines\n", stderr)
+	code, _, stderr = runCommand(t, cmds, cmd.repo, "", "blame", "-L", "x", "1.txt")
+	assert.Equal(t, 128, code)
+	assert.Equal(t, "fatal: invalid -L argument 'x'\n", stderr)
+	code, _, _ = runCommand(t, cmds, cmd.repo, "", "blame")
+	assert.Equal(t, 2, code)
+	code, stdout, _ := runCommand(t, cmds, cmd.repo, "", "blame", "--porcelain", "--", "a/2.txt")
+	assert.Equal(t, 0, code)
+	assert.Contains(t, stdout, "\nboundary\nfilename a/2.txt\n\ttwo\n")
+}
+
+func TestCherryPickErrors(t *testing.T) {
+	cmds, cmd := indexWorkspaceChange(t)
+	defer tearDown(t, cmd)
+
+	code, _, _ := runCommand(t, cmds, cmd.repo, "", "cherry-pick")
+	assert.Equal(t, 2, code)
+	code, _, _ = runCommand(t, cmds, cmd.repo, "", "revert", "-x", "HEAD")
+	assert.Equal(t, 2, code)
+	code, _, stderr := runCommand(t, cmds, cmd.repo, "", "cherry-pick", "--continue")
+	assert.Equal(t, 1, code)
+	assert.Equal(t, "error: no cherry-pick or revert in progress\n", stderr)
+	code, _, stderr = runCommand(t, cmds, cmd.repo, "", "revert", "nope")
+	assert.Equal(t, 128, code)
+	assert.Contains(t, stderr, "fatal: unknown revision")
+
+	// reverting the root commit empties the tree
+	code, stdout, _ := runCommand(t, cmds, cmd.repo, "", "revert", "HEAD")
+	assert.Equal(t, 0, code)
+	assert.Regexp(t, `^\[master [0-9a-f]{7}\] Revert ".*"\n$`, stdout)
+	assert.NoFileExists(t, filepath.Join(cmd.repo.Path, "1.txt"))
+}
+
+func TestRebaseErrors(t *testing.T) {
+	cmds, cmd := indexWorkspaceChange(t)
+	defer tearDown(t, cmd)
+
+	code, _, _ := runCommand(t, cmds, cmd.repo, "", "rebase")
+	assert.Equal(t, 2, code)
+	code, _, _ = runCommand(t, cmds, cmd.repo, "", "rebase", "--continue", "HEAD")
+	assert.Equal(t, 2, code)
+	code, _, stderr := runCommand(t, cmds, cmd.repo, "", "rebase", "--abort")
+	assert.Equal(t, 1, code)
+	assert.Equal(t, "error: no rebase in progress\n", stderr)
+	code, _, stderr = runCommand(t, cmds, cmd.repo, "", "rebase", "nope")
+	assert.Equal(t, 128, code)
+	assert.Contains(t, stderr, "fatal: unknown revision")
+	code, stdout, _ := runCommand(t, cmds, cmd.repo, "", "rebase", "HEAD")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "Current branch master is up to date.\n", stdout)
+
+	// the todo list comes from the sequence editor, exec lines run in
+	// the workspace
+	code, _, _ = runCommand(t, cmds, cmd.repo, "", "config", "sequence.editor", `printf 'exec echo ran > ran.txt\n' >`)
+	assert.Equal(t, 0, code)
+	code, _, stderr = runCommand(t, cmds, cmd.repo, "", "rebase", "-i", "HEAD")
+	assert.Equal(t, 0, code, stderr)
+	assert.Equal(t, "Executing: echo ran > ran.txt\nSuccessfully rebased and updated refs/heads/master.\n", stderr)
+	data, err := os.ReadFile(filepath.Join(cmd.repo.Path, "ran.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "ran\n", string(data))
+}
+
+func TestStash(t *testing.T) {
+	cmds, cmd := indexWorkspaceChange(t)
+	defer tearDown(t, cmd)
+
+	code, _, _ := runCommand(t, cmds, cmd.repo, "", "stash", "push", "-x")
+	assert.Equal(t, 2, code)
+	code, _, _ = runCommand(t, cmds, cmd.repo, "", "stash", "list", "stash@{0}")
+	assert.Equal(t, 2, code)
+	code, stdout, _ := runCommand(t, cmds, cmd.repo, "", "stash")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "No local changes to save\n", stdout)
+	code, _, stderr := runCommand(t, cmds, cmd.repo, "", "stash", "pop")
+	assert.Equal(t, 1, code)
+	assert.Equal(t, "error: no stash entries found\n", stderr)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(cmd.repo.Path, "1.txt"), []byte("1\n"), 0644))
+	code, stdout, _ = runCommand(t, cmds, cmd.repo, "", "stash", "-m", "one")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "Saved working directory and index state On master: one\n", stdout)
+	code, stdout, _ = runCommand(t, cmds, cmd.repo, "", "stash", "list")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "stash@{0}: On master: one\n", stdout)
+	code, stdout, _ = runCommand(t, cmds, cmd.repo, "", "stash", "show")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "M\t1.txt\n", stdout)
+	code, stdout, _ = runCommand(t, cmds, cmd.repo, "", "stash", "show", "-p", "stash@{0}")
+	assert.Equal(t, 0, code)
+	assert.Contains(t, stdout, "-one\n\\ No newline at end of file\n+1\n")
+	code, _, stderr = runCommand(t, cmds, cmd.repo, "", "stash", "apply", "stash@{1}")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, "is not a stash entry")
+
+	oid, err := cmd.repo.ResolveRevision("refs/stash")
+	assert.NoError(t, err)
+	code, stdout, _ = runCommand(t, cmds, cmd.repo, "", "stash", "pop")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, " M 1.txt\nDropped refs/stash@{0} ("+oid+")\n", stdout)
+	code, stdout, _ = runCommand(t, cmds, cmd.repo, "", "stash", "list")
+	assert.Equal(t, 0, code)
+	assert.Empty(t, stdout)
+}
+
+func TestServe(t *testing.T) {
+	cmds, cmd := indexWorkspaceChange(t)
+	defer tearDown(t, cmd)
+	runCommand(t, cmds, cmd.repo, "first\n", "commit")
+
+	stderr, err := os.Create(filepath.Join(t.TempDir(), "stderr"))
+	assert.NoError(t, err)
+	defer stderr.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	exit := make(chan int)
+	go func() {
+		code, _ := cmds.run(command{
+			ctx:    ctx,
+			name:   "serve",
+			args:   []string{"--listen", "127.0.0.1:0"},
+			env:    testGitgoVar(),
+			pwd:    cmd.repo.Path,
+			stdout: stderr,
+			stderr: stderr,
+			repo:   cmd.repo,
+		})
+		exit <- code
+	}()
+
+	var url string
+	assert.Eventually(t, func() bool {
+		out, _ := os.ReadFile(stderr.Name())
+		_, url, _ = strings.Cut(strings.TrimSpace(string(out)), " on ")
+		return url != ""
+	}, 5*time.Second, 10*time.Millisecond)
+
+	parent := t.TempDir()
+	code, _, errOut := runCommand(t, cmds, gitgo.NewRepository(parent), "", "clone", url, "copy")
+	assert.Equal(t, 0, code, errOut)
+	data, err := os.ReadFile(filepath.Join(parent, "copy", "a", "b", "3.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "three", string(data))
+
+	cancel()
+	assert.Equal(t, 0, <-exit)
+}
+
+func TestBundle(t *testing.T) {
+	cmds, cmd := indexWorkspaceChange(t)
+	defer tearDown(t, cmd)
+	runCommand(t, cmds, cmd.repo, "first\n", "commit")
+	head := gitgo.RefInitialize(cmd.repo.Refs).ReadHead()
+	path := filepath.Join(t.TempDir(), "repo.bundle")
+
+	code, _, stderr := runCommand(t, cmds, cmd.repo, "", "bundle", "create", path, "master")
+	assert.Equal(t, 0, code, stderr)
+	code, stdout, stderr := runCommand(t, cmds, cmd.repo, "", "bundle", "verify", path)
+	assert.Equal(t, 0, code, stderr)
+	assert.Equal(t, "The bundle contains this ref:\n"+head+" refs/heads/master\n"+
+		"The bundle records a complete history.\nThe bundle uses this hash algorithm: sha1\n", stdout)
+	assert.Equal(t, path+" is okay\n", stderr)
+	_, stdout, _ = runCommand(t, cmds, cmd.repo, "", "bundle", "list-heads", path)
+	assert.Equal(t, head+" refs/heads/master\n", stdout)
+
+	code, _, stderr = runCommand(t, cmds, cmd.repo, "", "bundle", "create", path, "master..master")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, "empty bundle")
+
+	parent := t.TempDir()
+	code, _, stderr = runCommand(t, cmds, gitgo.NewRepository(parent), "", "clone", path, "copy")
+	assert.Equal(t, 0, code, stderr)
+	assert.Equal(t, head, gitgo.RefInitialize(filepath.Join(parent, "copy", ".gitgo")).ReadHead())
+}
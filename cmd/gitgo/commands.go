@@ -1,13 +1,16 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"os"
 
 	"github.com/Vikuuu/gitgo"
 )
 
 type command struct {
+	ctx    context.Context
 	name   string
 	pwd    string
 	env    map[string]string
@@ -41,6 +44,19 @@ func (c *commands) run(cmd command) (int, error) {
 	if !ok {
 		return 0, errors.New("command not found")
 	}
+	if cmd.ctx == nil {
+		cmd.ctx = context.Background()
+	}
+	locks, err := lockOptions(cmd)
+	// config stays usable to fix a bad value
+	if err != nil && cmd.name != "config" {
+		fmt.Fprintf(cmd.stderr, "fatal: %v\n", err)
+		return 128, nil
+	}
+	cmd.repo.Locks = &locks
+	cmd.repo.Workers = workers(cmd)
+	cmd.repo.Progress = progressMeter(cmd)
+	cmd.repo.RemoteProgress = remoteOutput(cmd)
 	exitCode := ci.handler(cmd)
 	return exitCode, nil
 }
@@ -1,243 +1,323 @@
 package main
 
 import (
-	"encoding/hex"
+	"bufio"
+	"cmp"
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"os"
+	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Vikuuu/gitgo"
-	"github.com/Vikuuu/gitgo/internal/datastr"
+	"github.com/Vikuuu/gitgo/internal/workpool"
 )
 
-type WorkspaceUpdateType int
-
-const (
-	WorkspaceModified WorkspaceUpdateType = iota
-	WorkspaceDeleted
-)
-
-func scanWorkspace(
-	cmd command,
-	untracked datastr.SortedSet,
-	prefix string,
-	index *gitgo.Index,
-	stats map[string]os.FileInfo,
-) error {
-	fileStats, err := listDir(cmd.repo.Path, prefix)
-	if err != nil {
-		return err
-	}
-	for rel, stat := range fileStats {
-		if index.IsTracked(rel) {
-			if stat.IsDir() {
-				if err := scanWorkspace(cmd, untracked, rel, index, stats); err != nil {
-					return err
-				}
-			} else {
-				stats[rel] = stat
-			}
-		} else {
-			trackablefile, err := trackableFile(rel, stat, index, cmd)
-			if err != nil {
-				return err
-			}
-			if trackablefile {
-				if stat.IsDir() {
-					rel += string(filepath.Separator)
-				}
-				untracked.Add(rel)
-			}
-		}
-	}
-
-	return nil
-}
-
-func listDir(base, dirname string) (map[string]os.FileInfo, error) {
-	path := filepath.Join(base, dirname)
-
-	dirEntries, err := os.ReadDir(path)
-	if err != nil {
-		return nil, err
+// fatal reports err and returns the exit code it maps to. Like git,
+// problems with the repository itself, such as a held lock or a
+// corrupt index, exit with 128 and everything else with 1.
+func fatal(cmd command, err error) int {
+	var lockErr *gitgo.LockError
+	switch {
+	case errors.Is(err, context.Canceled):
+		fmt.Fprintln(cmd.stderr, "fatal: interrupted")
+		return 130
+	case errors.Is(err, gitgo.ErrLockDenied):
+		fmt.Fprintf(cmd.stderr, `fatal: %v
+
+Another gitgo process seems to be running in this repository.
+Please make sure all processes are terminated then try again.
+If it still fails, a gitgo process may have crashed in this
+repository earlier: remove the file manually or run the command
+again with --force-unlock to continue.
+`, err)
+		return 128
+	case errors.Is(err, gitgo.ErrCorruptIndex):
+		fmt.Fprintf(cmd.stderr, "fatal: %v\nhint: run 'gitgo update-index --force-rebuild' to recover it from HEAD\n", err)
+		return 128
+	case errors.As(err, &lockErr), errors.Is(err, gitgo.ErrUnsupportedVersion):
+		fmt.Fprintf(cmd.stderr, "fatal: %v\n", err)
+		return 128
 	}
-	stats := make(map[string]os.FileInfo, len(dirEntries))
-
-	for _, dirEntry := range dirEntries {
-		name := dirEntry.Name()
-		if _, skip := gitgo.G_ignore[name]; skip {
-			continue
-		}
+	fmt.Fprintf(cmd.stderr, "error: %v\n", err)
+	return 1
+}
 
-		rel, err := filepath.Rel(base, filepath.Join(path, name))
-		if err != nil {
-			return nil, err
-		}
+// workers returns the size of the hashing pool, GITGO_JOBS when set
+// and GOMAXPROCS otherwise.
+func workers(cmd command) int {
+	n, _ := strconv.Atoi(cmd.env["jobs"])
+	return workpool.Size(n)
+}
 
-		info, err := dirEntry.Info()
-		if err != nil {
-			return nil, err
+func printResult(cmd command, status *gitgo.Status) {
+	out := ""
+	for _, entry := range status.Changed {
+		path := entry.Path
+		if entry.From != "" {
+			path = entry.From + " -> " + path
 		}
-		stats[rel] = info
+		out += fmt.Sprintf("%c%c %s\n", entry.Index.Code(), entry.Workspace.Code(), path)
+	}
+	for _, path := range status.Untracked {
+		out += fmt.Sprintf("?? %s\n", path)
 	}
 
-	return stats, nil
+	fmt.Fprintf(cmd.stdout, "%s", out)
 }
 
-func trackableFile(path string, stat os.FileInfo, index *gitgo.Index, cmd command) (bool, error) {
-	if stat == nil {
-		return false, nil
+// lockOptions builds the lock settings of the repository from
+// core.lockTimeout, core.staleLockAge and core.takeoverStaleLocks,
+// GITGO_LOCK_TIMEOUT overriding the first one. Durations are either Go
+// durations like "5s" or a plain number of milliseconds.
+func lockOptions(cmd command) (gitgo.LockOptions, error) {
+	opts := gitgo.DefaultLockOptions()
+	opts.Warn = func(msg string) {
+		fmt.Fprintf(cmd.stderr, "warning: %s\n", msg)
 	}
 
-	if !stat.IsDir() {
-		return !index.IsTracked(path), nil
-	}
-
-	items, err := listDir(cmd.repo.Path, path)
+	config, err := cmd.repo.Config()
 	if err != nil {
-		return false, err
+		return opts, nil
 	}
-	files := make(map[string]os.FileInfo)
-	dirs := make(map[string]os.FileInfo)
-	// dirs := []os.FileInfo{}
-
-	for rel, item := range items {
-		if item.IsDir() {
-			dirs[rel] = item
-			// dirs = append(dirs, item)
-		} else {
-			files[rel] = item
-			// files = append(files, item)
+	duration := func(name, value string, d *time.Duration) error {
+		var err error
+		if *d, err = parseDuration(value); err != nil {
+			return fmt.Errorf("bad duration %q for %s", value, name)
 		}
+		return nil
 	}
-
-	for filePath, file := range files {
-		ok, err := trackableFile(filePath, file, index, cmd)
-		if err != nil {
-			return false, err
-		}
-		if ok {
-			return true, nil
+	if v, ok := config.Get("core.lockTimeout"); ok {
+		if err := duration("core.lockTimeout", v, &opts.Timeout); err != nil {
+			return opts, err
 		}
 	}
-
-	for dirPath, dir := range dirs {
-		ok, err := trackableFile(dirPath, dir, index, cmd)
-		if err != nil {
-			return false, err
+	if v := cmd.env["lockTimeout"]; v != "" {
+		if err := duration("GITGO_LOCK_TIMEOUT", v, &opts.Timeout); err != nil {
+			return opts, err
 		}
-		if ok {
-			return true, nil
+	}
+	if v, ok := config.Get("core.staleLockAge"); ok {
+		if err := duration("core.staleLockAge", v, &opts.StaleAge); err != nil {
+			return opts, err
 		}
 	}
-
-	return false, nil
+	if opts.Takeover, err = config.GetBool("core.takeoverStaleLocks", opts.Takeover); err != nil {
+		return opts, err
+	}
+	return opts, nil
 }
 
-func detectWorkspaceChanges(
-	cmd command,
-	changed *datastr.SortedSet,
-	changes map[string]WorkspaceUpdateType,
-	index *gitgo.Index,
-	stats map[string]os.FileInfo,
-) {
-	for name, entry := range index.IndexEntries() {
-		checkIndexEntry(index, changed, changes, stats, &entry, name, cmd.repo.Path)
+func parseDuration(v string) (time.Duration, error) {
+	if ms, err := strconv.Atoi(v); err == nil {
+		return time.Duration(ms) * time.Millisecond, nil
 	}
+	return time.ParseDuration(v)
 }
 
-func checkIndexEntry(
-	index *gitgo.Index,
-	changed *datastr.SortedSet,
-	changes map[string]WorkspaceUpdateType,
-	stats map[string]os.FileInfo,
-	entry *gitgo.IndexEntry,
-	name, path string,
-) {
-	// Check's if the file size has changed or something
-	// noticable.
-	stat := stats[name]
-	if stat == nil {
-		recordChange(changed, changes, entry.Path, WorkspaceDeleted)
-		return
+// forceUnlock handles the --force-unlock flag of the commands that
+// write the index: the index lock is removed whoever holds it, and the
+// flag is dropped from the arguments.
+func forceUnlock(cmd *command) {
+	args := cmd.args[:0:0]
+	unlock := false
+	for i, arg := range cmd.args {
+		if arg == "--" {
+			args = append(args, cmd.args[i:]...)
+			break
+		}
+		if arg == "--force-unlock" {
+			unlock = true
+			continue
+		}
+		args = append(args, arg)
 	}
-
-	if !entry.StatMatch(stat) {
-		recordChange(changed, changes, entry.Path, WorkspaceModified)
-		changed.Add(name)
+	cmd.args = args
+	if !unlock {
 		return
 	}
 
-	// Check with the time stamp
-	if entry.TimeMatch(stat) {
+	indexPath := filepath.Join(cmd.repo.GitPath, "index")
+	if err := gitgo.ForceUnlock(indexPath); err != nil {
+		fmt.Fprintf(cmd.stderr, "warning: could not remove '%s.lock': %v\n", indexPath, err)
 		return
 	}
+	fmt.Fprintf(cmd.stderr, "warning: removed lock on '%s'\n", indexPath)
+}
 
-	// If that is not the case we will check the content of
-	// the file in question.
-	f, err := os.OpenFile(filepath.Join(path, name), os.O_RDONLY, 0644)
-	if err != nil {
-		panic("err checkIndexEntry opening file: " + err.Error())
+// defaultRemote is the remote the current branch tracks, origin when
+// it tracks none.
+func defaultRemote(cmd command) string {
+	branch, _ := gitgo.RefInitialize(cmd.repo.Refs).HeadRef()
+	config, err := cmd.repo.Config()
+	if err == nil && branch != "" {
+		if name, ok := config.Get("branch." + gitgo.ShortRefName(branch) + ".remote"); ok {
+			return name
+		}
 	}
-	data, err := io.ReadAll(f)
-	if err != nil {
-		panic("err checkIndexEntry reading file: " + err.Error())
+	return gitgo.DefaultRemote
+}
+
+// printRefUpdates writes the table of refs a fetch or push moved, the
+// way git does. Refs that were already up to date are left out.
+func printRefUpdates(cmd command, header string, res *gitgo.TransferResult) {
+	printed := false
+	for _, u := range res.Updates {
+		if u.UpToDate() {
+			continue
+		}
+		if !printed {
+			fmt.Fprintf(cmd.stderr, "%s %s\n", header, res.URL)
+			printed = true
+		}
+
+		flag, summary, reason := ' ', abbrev(u.Old)+".."+abbrev(u.New), ""
+		switch {
+		case errors.Is(u.Err, gitgo.ErrNonFastForward):
+			flag, summary, reason = '!', "[rejected]", " (non-fast-forward)"
+		case u.Err != nil:
+			flag, summary, reason = '!', "[remote rejected]", fmt.Sprintf(" (%v)", u.Err)
+		case u.Old == "" && strings.HasPrefix(u.Src, "refs/tags/"):
+			flag, summary = '*', "[new tag]"
+		case u.Old == "":
+			flag, summary = '*', "[new branch]"
+		case u.Forced:
+			flag, summary, reason = '+', abbrev(u.Old)+"..."+abbrev(u.New), " (forced update)"
+		}
+		fmt.Fprintf(cmd.stderr, " %c %-17s %s -> %s%s\n",
+			flag, summary, gitgo.ShortRefName(u.Src), gitgo.ShortRefName(u.Dst), reason)
 	}
-	// blob := gitgo.Blob{Data: data}.Init()
-	blob := gitgo.BlobData(data)
-	oid := hex.EncodeToString(gitgo.Hash(blob))
+}
 
-	if oid == entry.Oid {
-		// If the file content is same, but the file has different
-		// metadata on the disk, update them so that we can
-		// use them next time.
-		index.UpdateEntryStat(entry, stat)
-	} else {
-		recordChange(changed, changes, entry.Path, WorkspaceModified)
+// printNameStatus prints a line per change with its status letter and
+// the paths it is about, like `git diff --name-status`.
+func printNameStatus(w io.Writer, changes []gitgo.FileChange) {
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+	for _, c := range changes {
+		switch c.Type {
+		case gitgo.Renamed, gitgo.Copied:
+			fmt.Fprintf(out, "%c%03d\t%s\t%s\n", c.Type.Code(), c.Similarity, c.From.Name, c.To.Name)
+		default:
+			fmt.Fprintf(out, "%c\t%s\n", c.Type.Code(), c.Path())
+		}
 	}
 }
 
-func recordChange(
-	changed *datastr.SortedSet,
-	changes map[string]WorkspaceUpdateType,
-	path string,
-	typ WorkspaceUpdateType,
-) {
-	changed.Add(path)
-	changes[path] = typ
+func abbrev(oid string) string {
+	return oid[:min(len(oid), 7)]
 }
 
-func printResult(
-	cmd command,
-	changed, untracked *datastr.SortedSet,
-	changes map[string]WorkspaceUpdateType,
-) {
-	out := ""
-	it := changed.Iterator()
-	for it.Next() {
-		status := statusFor(it.Key(), changes)
-		out += fmt.Sprintf(" %c %s\n", status, it.Key())
+// intFlag reads the option name at args[*i], given as "--name <n>" or
+// "--name=<n>" with n a positive number, moving *i past its value. ok
+// is false when args[*i] is another argument.
+func intFlag(args []string, i *int, name string) (n int, ok bool, err error) {
+	value, found := strings.CutPrefix(args[*i], name+"=")
+	if !found {
+		if args[*i] != name || *i+1 >= len(args) {
+			return 0, false, nil
+		}
+		*i++
+		value = args[*i]
 	}
-
-	iter := untracked.Iterator()
-	for iter.Next() {
-		out += fmt.Sprintf("?? %s\n", iter.Key())
+	if n, err = strconv.Atoi(value); err != nil || n <= 0 {
+		return 0, true, fmt.Errorf("%s %s is not a positive number", strings.TrimLeft(name, "-"), value)
 	}
+	return n, true, nil
+}
 
-	fmt.Fprintf(cmd.stdout, "%s", out)
+// renameFlag reads into opts the rename detection options of diff and
+// log: -M[<n>] and -C[<n>], their long forms --find-renames[=<n>] and
+// --find-copies[=<n>], and --no-renames. It returns false for other
+// arguments.
+func renameFlag(arg string, opts *gitgo.RenameOptions) (bool, error) {
+	var value string
+	switch {
+	case arg == "--no-renames":
+		*opts = gitgo.RenameOptions{}
+		return true, nil
+	case strings.HasPrefix(arg, "-M"), strings.HasPrefix(arg, "-C"):
+		value = arg[2:]
+	case strings.HasPrefix(arg, "--find-renames"), strings.HasPrefix(arg, "--find-copies"):
+		name, v, _ := strings.Cut(arg, "=")
+		if name != "--find-renames" && name != "--find-copies" {
+			return false, nil
+		}
+		value = v
+	default:
+		return false, nil
+	}
+	opts.Renames = true
+	if strings.HasPrefix(arg, "-C") || strings.HasPrefix(arg, "--find-copies") {
+		opts.Copies = true
+	}
+	if value == "" {
+		return true, nil
+	}
+	threshold, err := parseSimilarity(value)
+	if err != nil {
+		return true, err
+	}
+	opts.Threshold = &threshold
+	return true, nil
 }
 
-func statusFor(path string, changes map[string]WorkspaceUpdateType) rune {
-	change := changes[path]
-	res := ' '
+// parseSimilarity reads a similarity like git does, in percent with a
+// % sign and as the digits of a fraction otherwise, 5 and 50% being
+// the same.
+func parseSimilarity(value string) (int, error) {
+	if percent, ok := strings.CutSuffix(value, "%"); ok {
+		n, err := strconv.Atoi(percent)
+		if err != nil || n < 0 || n > 100 {
+			return 0, fmt.Errorf("invalid similarity '%s'", value)
+		}
+		return n, nil
+	}
+	if strings.Trim(value, "0123456789") != "" {
+		return 0, fmt.Errorf("invalid similarity '%s'", value)
+	}
+	f, err := strconv.ParseFloat("0."+value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid similarity '%s'", value)
+	}
+	return int(f*100 + 1e-9), nil
+}
 
-	switch change {
-	case WorkspaceModified:
-		res = 'M'
-	case WorkspaceDeleted:
-		res = 'D'
+// editor returns the editor to run on files, like git looks it up:
+// GITGO_EDITOR, core.editor, then $VISUAL and $EDITOR, and vi. The todo
+// list of a rebase takes GITGO_SEQUENCE_EDITOR and sequence.editor
+// first.
+func editor(cmd command, sequence bool) string {
+	config, err := cmd.repo.Config()
+	if err != nil {
+		config = gitgo.NewConfig("")
+	}
+	if sequence {
+		if cmd.env["sequenceEditor"] != "" {
+			return cmd.env["sequenceEditor"]
+		}
+		if v, ok := config.Get("sequence.editor"); ok {
+			return v
+		}
+	}
+	if cmd.env["editor"] != "" {
+		return cmd.env["editor"]
 	}
+	if v, ok := config.Get("core.editor"); ok {
+		return v
+	}
+	return cmp.Or(cmd.env["defaultEditor"], "vi")
+}
 
-	return res
+// runShell runs the shell command line in the workspace, with the
+// arguments args, on the terminal of cmd.
+func runShell(cmd command, line string, args ...string) error {
+	c := exec.CommandContext(cmd.ctx, "sh", append([]string{"-c", line, line}, args...)...)
+	c.Dir = cmd.repo.Path
+	c.Stdin, c.Stdout, c.Stderr = cmd.stdin, cmd.stdout, cmd.stderr
+	return c.Run()
 }
@@ -0,0 +1,544 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Vikuuu/gitgo"
+)
+
+// harness runs the same steps through gitgo, in interop mode, and
+// through the git binary, each in its own directory, so that what they
+// write can be compared.
+type harness struct {
+	t     *testing.T
+	cmds  *commands
+	gitgo string
+	git   string
+	// date is the author and committer date of the next commit, it
+	// moves one minute forward every commit.
+	date time.Time
+}
+
+func newHarness(t *testing.T) *harness {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	h := &harness{
+		t:     t,
+		cmds:  cmdInit(),
+		gitgo: t.TempDir(),
+		git:   t.TempDir(),
+		date:  time.Date(2024, 3, 1, 12, 0, 0, 0, time.FixedZone("", 5*3600+30*60)),
+	}
+	h.runGitgo("", "init")
+	h.runGit(h.git, "init", "-q", "-b", "master")
+	return h
+}
+
+// runGitgo runs a gitgo command on the gitgo directory and returns its
+// standard output.
+func (h *harness) runGitgo(stdin string, name string, args ...string) string {
+	h.t.Helper()
+	exitCode, stdout, stderr := h.execGitgo(stdin, name, args...)
+	assert.Equal(h.t, 0, exitCode, "gitgo %s: %s", name, stderr)
+	return stdout
+}
+
+// execGitgo runs a gitgo command on the gitgo directory, which may
+// fail, and returns its exit code and output.
+func (h *harness) execGitgo(stdin string, name string, args ...string) (int, string, string) {
+	h.t.Helper()
+	dir := h.t.TempDir()
+	files := make([]*os.File, 3)
+	for i, f := range []string{"stdin", "stdout", "stderr"} {
+		file, err := os.Create(filepath.Join(dir, f))
+		assert.NoError(h.t, err)
+		defer file.Close()
+		files[i] = file
+	}
+	files[0].WriteString(stdin)
+	files[0].Seek(0, 0)
+
+	env := testGitgoVar()
+	env["name"] = "A U Thor"
+	env["email"] = "author@example.com"
+	env["date"] = gitgo.FormatDate(h.date)
+	exitCode, err := h.cmds.run(command{
+		name:   name,
+		args:   args,
+		env:    env,
+		pwd:    h.gitgo,
+		stdin:  files[0],
+		stdout: files[1],
+		stderr: files[2],
+		repo:   gitgo.NewRepositoryWithGitDir(h.gitgo, gitgo.GitDir),
+	})
+	assert.NoError(h.t, err)
+	files[1].Seek(0, 0)
+	stdout, _ := io.ReadAll(files[1])
+	files[2].Seek(0, 0)
+	stderr, _ := io.ReadAll(files[2])
+	return exitCode, string(stdout), string(stderr)
+}
+
+// runGit runs git in dir and returns its standard output.
+func (h *harness) runGit(dir string, args ...string) string {
+	h.t.Helper()
+	cmd := h.gitCommand(dir, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	assert.NoError(h.t, err, "git %s: %s", strings.Join(args, " "), stderr.String())
+	return string(out)
+}
+
+// gitCommand prepares git to run in dir with the identity and date of
+// the harness.
+func (h *harness) gitCommand(dir string, args ...string) *exec.Cmd {
+	date := gitgo.FormatDate(h.date)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	// the editors come from the config of the repository
+	env := slices.DeleteFunc(os.Environ(), func(v string) bool {
+		return strings.HasPrefix(v, "GIT_EDITOR=") || strings.HasPrefix(v, "GIT_SEQUENCE_EDITOR=")
+	})
+	cmd.Env = append(env,
+		"GIT_CONFIG_NOSYSTEM=1",
+		"GIT_CONFIG_GLOBAL=/dev/null",
+		"GIT_AUTHOR_NAME=A U Thor",
+		"GIT_AUTHOR_EMAIL=author@example.com",
+		"GIT_AUTHOR_DATE="+date,
+		"GIT_COMMITTER_NAME=A U Thor",
+		"GIT_COMMITTER_EMAIL=author@example.com",
+		"GIT_COMMITTER_DATE="+date,
+	)
+	return cmd
+}
+
+// write writes the file in both directories. Its modification time is
+// set in the past so that no index entry is racily clean.
+func (h *harness) write(name, content string, perm os.FileMode) {
+	h.t.Helper()
+	for _, dir := range []string{h.gitgo, h.git} {
+		path := filepath.Join(dir, name)
+		assert.NoError(h.t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(h.t, os.WriteFile(path, []byte(content), perm))
+		assert.NoError(h.t, os.Chmod(path, perm))
+		past := time.Now().Add(-time.Hour)
+		assert.NoError(h.t, os.Chtimes(path, past, past))
+	}
+}
+
+func (h *harness) remove(name string) {
+	h.t.Helper()
+	for _, dir := range []string{h.gitgo, h.git} {
+		assert.NoError(h.t, os.Remove(filepath.Join(dir, name)))
+	}
+}
+
+// add stages paths in both repositories, removed files included as
+// git does.
+func (h *harness) add(paths ...string) {
+	h.t.Helper()
+	h.runGitgo("", "add", append([]string{"-A"}, paths...)...)
+	h.runGit(h.git, append([]string{"add", "--"}, paths...)...)
+	h.checkIndex(paths...)
+}
+
+func (h *harness) commit(message string) {
+	h.t.Helper()
+	// as given by `echo`, git adds the final newline itself
+	h.runGitgo(message+"\n", "commit")
+	h.runGit(h.git, "commit", "-q", "-m", message)
+	h.date = h.date.Add(time.Minute)
+
+	assert.Equal(h.t,
+		h.runGit(h.git, "rev-parse", "HEAD"),
+		h.runGit(h.gitgo, "rev-parse", "HEAD"),
+		"commit %q", message)
+	assert.Empty(h.t, h.runGit(h.gitgo, "fsck", "--strict", "--no-dangling"))
+}
+
+// checkIndex compares the index gitgo wrote with the one git writes
+// for the same files. Stat data only matches within a directory, so
+// git's index is written over gitgo's own workspace.
+func (h *harness) checkIndex(paths ...string) {
+	h.t.Helper()
+	indexPath := filepath.Join(h.gitgo, gitgo.GitDir, "index")
+	got, err := os.ReadFile(indexPath)
+	assert.NoError(h.t, err)
+
+	// git keeps a cache of trees written by the last commit, rebuild
+	// the index from HEAD without it before adding.
+	if gitgo.RefInitialize(filepath.Join(h.gitgo, gitgo.GitDir)).ReadHead() == "" {
+		assert.NoError(h.t, os.Remove(indexPath))
+	} else {
+		h.runGit(h.gitgo, "reset", "-q")
+	}
+	h.runGit(h.gitgo, append([]string{"add", "--"}, paths...)...)
+	want, err := os.ReadFile(indexPath)
+	assert.NoError(h.t, err)
+	assert.Equal(h.t, want, got, "index after adding %v", paths)
+
+	// put gitgo's index back, so that the next step starts from it
+	assert.NoError(h.t, os.WriteFile(indexPath, got, 0644))
+}
+
+// checkOutput compares the output of the gitgo command to that of git.
+func (h *harness) checkOutput(gitgoArgs, gitArgs []string) {
+	h.t.Helper()
+	assert.Equal(h.t,
+		h.runGit(h.git, gitArgs...),
+		h.runGitgo("", gitgoArgs[0], gitgoArgs[1:]...),
+		"gitgo %s", strings.Join(gitgoArgs, " "))
+}
+
+func TestAgainstGit(t *testing.T) {
+	h := newHarness(t)
+
+	h.write("README", "hello\n", 0644)
+	h.write("src/main.go", "package main\n", 0644)
+	h.write("src/a-b.go", "package main\n", 0644)
+	h.write("src/a/b.go", "package a\n", 0644)
+	h.write("run.sh", "#!/bin/sh\necho\n", 0755)
+	h.add("README", "src", "run.sh")
+	h.commit("Initial commit")
+
+	h.write("README", "hello, world\n", 0644)
+	h.write("docs/guide.txt", "guide\n", 0644)
+	h.add("README", "docs")
+	h.commit("Update the readme\n\nand add a guide.")
+
+	h.write("src/a/b.go", "package a\n\nfunc B() {}\n", 0644)
+	h.write("new.txt", "new\n", 0644)
+	h.remove("run.sh")
+	h.add("src/a/b.go")
+	h.write("src/a/b.go", "package a\n", 0644)
+
+	h.checkOutput([]string{"status"}, []string{"status", "--porcelain"})
+	h.checkOutput([]string{"log"}, []string{"log"})
+	h.checkOutput([]string{"log", "--oneline"}, []string{"log", "--oneline"})
+	h.checkOutput([]string{"log", "-n", "1"}, []string{"log", "-n", "1"})
+}
+
+func TestBlameAgainstGit(t *testing.T) {
+	h := newHarness(t)
+
+	h.write("a.txt", "one\ntwo\nthree\n", 0644)
+	h.add("a.txt")
+	h.commit("Initial commit")
+	h.write("a.txt", "one\n2\nthree\nfour\n", 0644)
+	h.add("a.txt")
+	h.commit("Change two")
+	h.write("a.txt", "zero\none\n2\n  three\nfour\nfive\nsix\nseven\neight\nnine\n", 0644)
+	h.add("a.txt")
+	h.commit("Add more lines")
+
+	for _, args := range [][]string{
+		{"blame", "a.txt"},
+		{"blame", "--porcelain", "a.txt"},
+		{"blame", "-w", "a.txt"},
+		{"blame", "-L", "2,+3", "--porcelain", "a.txt"},
+		{"blame", "HEAD~1", "--", "a.txt"},
+	} {
+		h.checkOutput(args, args)
+	}
+}
+
+func TestDiffAgainstGit(t *testing.T) {
+	h := newHarness(t)
+
+	lines := "one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten\n"
+	h.write("a.txt", lines, 0644)
+	h.write("b.txt", "b\n", 0644)
+	h.write("run.sh", "#!/bin/sh\n", 0644)
+	h.add("a.txt", "b.txt", "run.sh")
+	h.commit("Initial commit")
+
+	// a.txt moves with a line changed, b.txt moves as it is
+	h.remove("a.txt")
+	h.remove("b.txt")
+	h.write("c.txt", strings.Replace(lines, "five", "5", 1), 0644)
+	h.write("dir/b.txt", "b\n", 0644)
+	h.write("run.sh", "#!/bin/sh\necho\n", 0755)
+	h.add("a.txt", "b.txt", "c.txt", "dir", "run.sh")
+	h.checkOutput([]string{"status"}, []string{"status", "--porcelain"})
+	h.checkOutput([]string{"diff", "--cached"}, []string{"diff", "--cached"})
+	h.commit("Move files")
+
+	// a copy of c.txt, and changes left in the workspace
+	h.write("d.txt", strings.Replace(lines, "nine", "9", 1), 0644)
+	h.add("d.txt")
+	h.commit("Copy c.txt")
+	h.write("c.txt", "zero\n"+lines+"eleven", 0644)
+	h.remove("dir/b.txt")
+
+	for _, args := range [][]string{
+		{"diff"},
+		{"diff", "HEAD~2"},
+		{"diff", "HEAD~2", "HEAD"},
+		{"diff", "--no-renames", "HEAD~2", "HEAD~1"},
+		{"diff", "--name-status", "HEAD~2", "HEAD"},
+		{"diff", "--name-status", "-M90%", "HEAD~2", "HEAD"},
+		{"diff", "--name-status", "-C", "HEAD~1", "HEAD"},
+		{"diff", "--name-status", "-C", "-C", "HEAD~2", "HEAD"},
+	} {
+		h.checkOutput(args, args)
+	}
+	h.checkOutput([]string{"log", "--oneline", "--follow", "c.txt"}, []string{"log", "--oneline", "--follow", "c.txt"})
+	h.checkOutput([]string{"log", "--oneline", "c.txt"}, []string{"log", "--oneline", "--", "c.txt"})
+}
+
+func TestCherryPickAgainstGit(t *testing.T) {
+	h := newHarness(t)
+	both := func(args ...string) {
+		t.Helper()
+		h.runGitgo("", args[0], args[1:]...)
+		h.runGit(h.git, args...)
+		h.date = h.date.Add(time.Minute)
+		assert.Equal(t, h.runGit(h.git, "rev-parse", "HEAD"), h.runGit(h.gitgo, "rev-parse", "HEAD"), "%v", args)
+	}
+
+	lines := "one\ntwo\nthree\nfour\nfive\nsix\nseven\n"
+	h.write("a.txt", lines, 0644)
+	h.add("a.txt")
+	h.commit("Initial commit")
+	h.write("a.txt", strings.Replace(lines, "six", "6", 1), 0644)
+	h.add("a.txt")
+	h.commit("Change six")
+	h.write("b.txt", "b\n", 0644)
+	h.add("b.txt")
+	h.commit("Add b")
+	h.write("a.txt", strings.Replace(lines, "two", "2nd", 1), 0644)
+	h.add("a.txt")
+	h.commit("Change two")
+	oids := strings.Fields(h.runGit(h.git, "rev-list", "HEAD"))
+
+	for _, dir := range []string{h.gitgo, h.git} {
+		h.runGit(dir, "reset", "-q", "--hard", oids[3])
+	}
+	h.write("a.txt", strings.Replace(lines, "one", "one!", 1), 0644)
+	h.add("a.txt")
+	h.commit("Change one")
+
+	both("cherry-pick", oids[1], oids[2])
+	both("revert", "HEAD~1")
+
+	// a conflict, resolved
+	h.write("a.txt", strings.NewReplacer("one", "one!", "six", "6", "two", "two!").Replace(lines), 0644)
+	h.add("a.txt")
+	h.commit("Change two too")
+	exitCode, _, _ := h.execGitgo("", "cherry-pick", "-x", oids[0])
+	assert.Equal(t, 1, exitCode)
+	assert.Error(t, h.gitCommand(h.git, "cherry-pick", "-x", oids[0]).Run())
+	for _, name := range []string{"a.txt", ".git/CHERRY_PICK_HEAD"} {
+		want, err := os.ReadFile(filepath.Join(h.git, name))
+		assert.NoError(t, err)
+		got, err := os.ReadFile(filepath.Join(h.gitgo, name))
+		assert.NoError(t, err)
+		assert.Equal(t, string(want), string(got), name)
+	}
+	h.checkOutput([]string{"status"}, []string{"status", "--porcelain"})
+	assert.Equal(t, h.runGit(h.git, "ls-files", "--stage"), h.runGit(h.gitgo, "ls-files", "--stage"))
+
+	h.write("a.txt", strings.NewReplacer("one", "one!", "six", "6", "two", "2!").Replace(lines), 0644)
+	h.runGitgo("", "add", "a.txt")
+	h.runGit(h.git, "add", "a.txt")
+	h.runGitgo("", "cherry-pick", "--continue")
+	h.runGit(h.git, "-c", "core.editor=true", "cherry-pick", "--continue")
+	assert.Equal(t, h.runGit(h.git, "rev-parse", "HEAD"), h.runGit(h.gitgo, "rev-parse", "HEAD"))
+	assert.Empty(t, h.runGit(h.gitgo, "status", "--porcelain"))
+}
+
+func TestRebaseAgainstGit(t *testing.T) {
+	h := newHarness(t)
+	both := func(args ...string) {
+		t.Helper()
+		h.runGitgo("", args[0], args[1:]...)
+		h.runGit(h.git, args...)
+		h.date = h.date.Add(time.Minute)
+		assert.Equal(t, h.runGit(h.git, "rev-parse", "HEAD"), h.runGit(h.gitgo, "rev-parse", "HEAD"), "%v", args)
+	}
+	reflog := func(dir, name string) string {
+		data, err := os.ReadFile(filepath.Join(dir, ".git", "logs", name))
+		assert.NoError(t, err)
+		var lines []string
+		for _, line := range strings.SplitAfter(string(data), "\n") {
+			if strings.Contains(line, "\trebase") {
+				lines = append(lines, line)
+			}
+		}
+		return strings.Join(lines, "")
+	}
+	checkReflogs := func() {
+		t.Helper()
+		for _, name := range []string{"HEAD", "refs/heads/side"} {
+			assert.Equal(t, reflog(h.git, name), reflog(h.gitgo, name), name)
+		}
+	}
+
+	lines := "one\ntwo\nthree\nfour\nfive\n"
+	h.write("a.txt", lines, 0644)
+	h.add("a.txt")
+	h.commit("Initial commit")
+	h.write("a.txt", strings.Replace(lines, "five", "5", 1), 0644)
+	h.add("a.txt")
+	h.commit("Change five")
+	for _, dir := range []string{h.gitgo, h.git} {
+		h.runGit(dir, "checkout", "-q", "-b", "side", "HEAD~1")
+		h.runGit(dir, "config", "sequence.editor", `sed -i -e 's/^pick \(.* Add c\)$/reword \1/'`)
+		h.runGit(dir, "config", "core.editor", `sed -i -e 's/^Add c$/Add c, reworded/'`)
+	}
+	h.write("a.txt", strings.Replace(lines, "one", "1", 1), 0644)
+	h.add("a.txt")
+	h.commit("Change one")
+	h.write("b.txt", "b\n", 0644)
+	h.add("b.txt")
+	h.commit("Add b")
+	h.write("a.txt", strings.Replace(lines, "one", "1!", 1), 0644)
+	h.add("a.txt")
+	h.commit("fixup! Change one")
+	h.write("c.txt", "c\n", 0644)
+	h.add("c.txt")
+	h.commit("Add c")
+
+	both("rebase", "master")
+	checkReflogs()
+	h.checkOutput([]string{"rebase", "master"}, []string{"rebase", "master"})
+
+	both("rebase", "-i", "--autosquash", "HEAD~4")
+	checkReflogs()
+	assert.Equal(t, h.runGit(h.git, "log", "--format=%s"), h.runGit(h.gitgo, "log", "--format=%s"))
+
+	// a conflict, resolved
+	h.write("a.txt", strings.Replace(lines, "two", "2nd", 1), 0644)
+	h.add("a.txt")
+	h.commit("Change two")
+	for _, dir := range []string{h.gitgo, h.git} {
+		h.runGit(dir, "checkout", "-q", "master")
+		h.runGit(dir, "config", "--unset", "sequence.editor")
+		h.runGit(dir, "config", "core.editor", "true")
+	}
+	h.write("a.txt", strings.NewReplacer("five", "5", "three", "three!").Replace(lines), 0644)
+	h.add("a.txt")
+	h.commit("Change two too")
+	for _, dir := range []string{h.gitgo, h.git} {
+		h.runGit(dir, "checkout", "-q", "side")
+	}
+	exitCode, _, _ := h.execGitgo("", "rebase", "master")
+	assert.Equal(t, 1, exitCode)
+	assert.Error(t, h.gitCommand(h.git, "rebase", "master").Run())
+	for _, name := range []string{"a.txt", ".git/REBASE_HEAD", ".git/rebase-merge/head-name", ".git/rebase-merge/onto", ".git/rebase-merge/orig-head"} {
+		want, err := os.ReadFile(filepath.Join(h.git, name))
+		assert.NoError(t, err)
+		got, err := os.ReadFile(filepath.Join(h.gitgo, name))
+		assert.NoError(t, err)
+		assert.Equal(t, string(want), string(got), name)
+	}
+	h.checkOutput([]string{"status"}, []string{"status", "--porcelain"})
+
+	h.write("a.txt", strings.NewReplacer("one", "1!", "two", "2nd", "three", "three!", "five", "5").Replace(lines), 0644)
+	h.runGitgo("", "add", "a.txt")
+	h.runGit(h.git, "add", "a.txt")
+	both("rebase", "--continue")
+	checkReflogs()
+	assert.Empty(t, h.runGit(h.gitgo, "status", "--porcelain"))
+	assert.NoDirExists(t, filepath.Join(h.gitgo, ".git", "rebase-merge"))
+}
+
+func TestStashAgainstGit(t *testing.T) {
+	h := newHarness(t)
+	both := func(args ...string) {
+		t.Helper()
+		h.runGitgo("", "stash", args...)
+		h.runGit(h.git, append([]string{"stash"}, args...)...)
+		h.date = h.date.Add(time.Minute)
+	}
+	checkStash := func() {
+		t.Helper()
+		for _, rev := range []string{"stash@{0}", "stash@{0}^2"} {
+			assert.Equal(t, h.runGit(h.git, "rev-parse", rev), h.runGit(h.gitgo, "rev-parse", rev), rev)
+		}
+		h.checkOutput([]string{"stash", "list"}, []string{"stash", "list"})
+		assert.Equal(t, h.runGit(h.git, "status", "--porcelain"), h.runGit(h.gitgo, "status", "--porcelain"))
+	}
+	checkFiles := func(names ...string) {
+		t.Helper()
+		for _, name := range names {
+			want, err := os.ReadFile(filepath.Join(h.git, name))
+			assert.NoError(t, err)
+			got, err := os.ReadFile(filepath.Join(h.gitgo, name))
+			assert.NoError(t, err)
+			assert.Equal(t, string(want), string(got), name)
+		}
+	}
+
+	lines := "one\ntwo\nthree\nfour\nfive\n"
+	h.write("a.txt", lines, 0644)
+	h.write("b.txt", "b\n", 0644)
+	h.add("a.txt", "b.txt")
+	h.commit("Initial commit")
+
+	// a staged change with another on top, an unstaged one, a new file
+	// and an untracked one
+	h.write("a.txt", strings.Replace(lines, "one", "1", 1), 0644)
+	h.add("a.txt")
+	h.write("a.txt", strings.NewReplacer("one", "1", "five", "5").Replace(lines), 0644)
+	h.write("b.txt", "b!\n", 0644)
+	h.write("new.txt", "new\n", 0644)
+	h.runGitgo("", "add", "new.txt")
+	h.runGit(h.git, "add", "new.txt")
+	h.write("u/x.txt", "x\n", 0644)
+	both("push", "-u")
+	checkStash()
+	assert.Equal(t, h.runGit(h.git, "rev-parse", "stash@{0}^3"), h.runGit(h.gitgo, "rev-parse", "stash@{0}^3"))
+	assert.NoFileExists(t, filepath.Join(h.gitgo, "u", "x.txt"))
+	h.checkOutput([]string{"stash", "show", "-p"}, []string{"stash", "show", "-p"})
+
+	h.write("b.txt", "b?\n", 0644)
+	h.write("a.txt", strings.Replace(lines, "two", "2", 1), 0644)
+	both("push", "-m", "only b", "--", "b.txt")
+	checkStash()
+	checkFiles("a.txt", "b.txt")
+
+	for _, dir := range []string{h.gitgo, h.git} {
+		h.runGit(dir, "checkout", "--", "a.txt")
+	}
+	both("apply", "stash@{1}")
+	checkFiles("a.txt", "b.txt", "new.txt", "u/x.txt")
+	assert.Equal(t, h.runGit(h.git, "status", "--porcelain"), h.runGit(h.gitgo, "status", "--porcelain"))
+
+	both("drop", "stash@{1}")
+	checkStash()
+	h.checkOutput([]string{"stash", "show", "-p"}, []string{"stash", "show", "-p"})
+	for _, dir := range []string{h.gitgo, h.git} {
+		h.runGit(dir, "reset", "-q", "--hard")
+		os.RemoveAll(filepath.Join(dir, "u"))
+		os.Remove(filepath.Join(dir, "new.txt"))
+	}
+
+	// the stash conflicts with HEAD, it is kept
+	h.write("b.txt", "b.\n", 0644)
+	h.add("b.txt")
+	h.commit("Change b")
+	exitCode, stdout, _ := h.execGitgo("", "stash", "pop")
+	assert.Equal(t, 1, exitCode)
+	assert.Contains(t, stdout, "CONFLICT (content): Merge conflict in b.txt\n")
+	assert.Error(t, h.gitCommand(h.git, "stash", "pop").Run())
+	checkFiles("a.txt", "b.txt")
+	checkStash()
+
+	both("clear")
+	assert.Empty(t, h.runGit(h.gitgo, "stash", "list"))
+	assert.NoFileExists(t, filepath.Join(h.gitgo, ".git", "refs", "stash"))
+}
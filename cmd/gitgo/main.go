@@ -1,8 +1,13 @@
 package main
 
 import (
+	"cmp"
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 
 	"github.com/Vikuuu/gitgo"
 )
@@ -13,16 +18,28 @@ func main() {
 	}
 	cmds.initializeCommands()
 
-	if len(os.Args) < 2 {
-		fmt.Println("Usage: gitgo <command> [args...]")
+	args := os.Args[1:]
+	interop := false
+	for len(args) > 0 && args[0] == "--interop" {
+		interop = true
+		args = args[1:]
+	}
+	if len(args) < 1 {
+		fmt.Println("Usage: gitgo [--interop] <command> [args...]")
+		os.Exit(1)
 	}
 
-	cmdName := os.Args[1]
-	cmdArgs := os.Args[2:]
+	cmdName := args[0]
+	cmdArgs := args[1:]
 
 	env := GetGitgoVar()
 
+	// Interrupting gitgo cancels the running command, which then
+	// cleans up its locks on the way out.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+
 	cmd := command{
+		ctx:    ctx,
 		name:   cmdName,
 		args:   cmdArgs,
 		env:    env,
@@ -30,10 +47,11 @@ func main() {
 		stdin:  os.Stdin,
 		stdout: os.Stdout,
 		stderr: os.Stderr,
-		repo:   gitgo.NewRepository(os.Getenv("PWD")),
+		repo:   gitgo.NewRepositoryWithGitDir(os.Getenv("PWD"), metadataDir(interop, env)),
 	}
 
 	exitCode, err := cmds.run(cmd)
+	stop()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v", err)
 		os.Exit(1)
@@ -49,17 +67,62 @@ func (c *commands) initializeCommands() {
 		return 0
 	}, "help", "Displays all available commands and their usage")
 
-	c.register("commit", cmdCommitHandler, "commit", "Commits the files in staging area")
+	c.register("commit", cmdCommitHandler, "commit [--force-unlock]", "Commits the files in staging area")
 	c.register("init", cmdInitHandler, "init", "Initialize gitgo repository in the directory.")
-	c.register("add", cmdAddHandler, "add", "Add files to staging area.")
+	c.register("add", cmdAddHandler, "add [--force-unlock] [-A] <paths>...", "Add files to staging area, with -A staging the removal of files gone from them.")
 	c.register("cat-file", cmdCatFileHandler, "cat-file", "Get the blob content.")
+	c.register("checkout", cmdCheckoutHandler, "checkout [--] <paths>...", "Restore workspace files from the index.")
+	c.register("submodule", cmdSubmoduleHandler, "submodule [init | update | status]", "Initialize, update or inspect submodules.")
+	c.register("clone", cmdCloneHandler, "clone [--depth <depth>] <repository> [<directory>]", "Copy a repository into a new directory.")
+	c.register("fetch", cmdFetchHandler, "fetch [--depth <depth> | --deepen <depth> | --unshallow] [<remote>]", "Download the branches of a remote.")
+	c.register("push", cmdPushHandler, "push [--force] [<remote> [<branch>]]", "Update a remote branch with a local one.")
+	c.register("remote", cmdRemoteHandler, "remote [list | -v | add <name> <url> | remove <name>]", "Manage the repositories tracked as remotes.")
+	c.register("bundle", cmdBundleHandler, "bundle (create <file> <rev>... | verify <file> | list-heads <file>)", "Pack commits into a file to fetch from, or inspect one.")
+	c.register("serve", cmdServeHandler, "serve [--listen <address>] [--read-only] [<directory>]", "Serve repositories over HTTP for clones, fetches and pushes.")
+	c.register("log", cmdLogHandler, "log [--oneline] [-n <number>] [--follow [-M<n>]] [[--] <path>]", "Show the commits leading to HEAD.")
+	c.register("merge-base", cmdMergeBaseHandler, "merge-base [--all] <commit> <commit>", "Find the best common ancestors of two commits.")
+	c.register("diff", cmdDiffHandler, "diff [--cached] [--name-status] [-M<n>] [-C<n>] [--no-renames] [<commit> [<commit>]]", "Show changes between the workspace, the index and commits.")
+	c.register("blame", cmdBlameHandler, "blame [-L <start>,<end>] [-w] [--porcelain] [<rev>] [--] <file>", "Show the commit that last changed every line of a file.")
+	c.register("cherry-pick", cmdCherryPickHandler, "cherry-pick [-x] <commit>... | --continue | --skip | --abort", "Apply the changes of commits on top of HEAD.")
+	c.register("revert", cmdRevertHandler, "revert <commit>... | --continue | --skip | --abort", "Commit the reverse of the changes of commits.")
+	c.register("rebase", cmdRebaseHandler, "rebase [-i] [--autosquash] <upstream> | --continue | --skip | --abort", "Replay the commits of the branch on top of another commit.")
+	c.register("stash", cmdStashHandler, "stash [push [-u] [-m <message>] [--] [<paths>...] | list | show [-p] | apply | pop | drop | clear]", "Save changes away and apply them back later.")
 	c.register("status", cmdStatusHandler, "status", "Display the status of the repo.")
+	c.register("update-index", cmdUpdateIndexHandler, "update-index [--force-unlock] --force-rebuild", "Rebuild a corrupt index from HEAD and the workspace.")
+	c.register("config", cmdConfigHandler, "config <name> [<value>]", "Get and set repository options.")
 }
 
 func GetGitgoVar() map[string]string {
 	env := make(map[string]string)
 	env["name"] = os.Getenv("GITGO_AUTHOR_NAME")
 	env["email"] = os.Getenv("GITGO_AUTHOR_EMAIL")
+	env["date"] = os.Getenv("GITGO_AUTHOR_DATE")
+	env["jobs"] = os.Getenv("GITGO_JOBS")
+	env["lockTimeout"] = os.Getenv("GITGO_LOCK_TIMEOUT")
+	env["interop"] = os.Getenv("GITGO_INTEROP")
+	env["editor"] = os.Getenv("GITGO_EDITOR")
+	env["sequenceEditor"] = os.Getenv("GITGO_SEQUENCE_EDITOR")
+	env["defaultEditor"] = cmp.Or(os.Getenv("VISUAL"), os.Getenv("EDITOR"))
+	if home, err := os.UserHomeDir(); err == nil {
+		env["globalConfig"] = filepath.Join(home, ".gitgoconfig")
+	}
 
 	return env
 }
+
+// metadataDir returns .git instead of .gitgo when interop mode is
+// asked for by --interop, GITGO_INTEROP or gitgo.interop in
+// ~/.gitgoconfig, in that order.
+func metadataDir(interop bool, env map[string]string) string {
+	if !interop {
+		if v, err := strconv.ParseBool(env["interop"]); err == nil {
+			interop = v
+		} else if config, err := gitgo.LoadConfig(env["globalConfig"]); err == nil && env["globalConfig"] != "" {
+			interop, _ = config.GetBool("gitgo.interop", false)
+		}
+	}
+	if interop {
+		return gitgo.GitDir
+	}
+	return gitgo.GitgoDir
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Vikuuu/gitgo"
+)
+
+// progressMeter returns the progress display of the command, nil when
+// stderr is not a terminal so that scripts don't get it in their logs.
+func progressMeter(cmd command) gitgo.Progress {
+	if !isTerminal(cmd.stderr) {
+		return nil
+	}
+	return &meter{w: cmd.stderr}
+}
+
+func isTerminal(f *os.File) bool {
+	if f == nil {
+		return false
+	}
+	stat, err := f.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
+
+// meter draws "op: 42% (21/50)" on a single line, redrawn only when
+// the percentage moves, and ends it with ", done." like git does.
+type meter struct {
+	w       io.Writer
+	op      string
+	percent int
+}
+
+func (m *meter) Progress(op string, done, total int) {
+	if total == 0 {
+		return
+	}
+	percent := done * 100 / total
+	if op == m.op && percent == m.percent && done != total {
+		return
+	}
+	m.op, m.percent = op, percent
+
+	fmt.Fprintf(m.w, "\r%s: %3d%% (%d/%d)", op, percent, done, total)
+	if done == total {
+		fmt.Fprint(m.w, ", done.\n")
+	}
+}
+
+// remoteOutput returns where the progress messages of servers go, nil
+// like progressMeter when stderr is not a terminal.
+func remoteOutput(cmd command) io.Writer {
+	if !isTerminal(cmd.stderr) {
+		return nil
+	}
+	return &remotePrefixer{w: cmd.stderr, start: true}
+}
+
+// remotePrefixer starts every line a server sends with "remote: ",
+// lines being ended by '\r' for the ones redrawn in place.
+type remotePrefixer struct {
+	w     io.Writer
+	start bool
+}
+
+func (p *remotePrefixer) Write(b []byte) (int, error) {
+	var out []byte
+	for _, c := range b {
+		if p.start {
+			out = append(out, "remote: "...)
+			p.start = false
+		}
+		out = append(out, c)
+		p.start = c == '\n' || c == '\r'
+	}
+	if _, err := p.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMeterRedrawsOnPercentChange(t *testing.T) {
+	var buf bytes.Buffer
+	m := &meter{w: &buf}
+	for i := 0; i <= 400; i++ {
+		m.Progress("Adding files", i, 400)
+	}
+
+	out := buf.String()
+	assert.Equal(t, 101, bytes.Count(buf.Bytes(), []byte("\r")))
+	assert.Contains(t, out, "\rAdding files:  50% (200/400)")
+	assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("\rAdding files: 100% (400/400), done.\n")))
+}
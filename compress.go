@@ -7,21 +7,22 @@ import (
 	"io"
 )
 
-func Compress(data []byte) []byte {
+func Compress(data []byte) ([]byte, error) {
 	var b bytes.Buffer
 	w := zlib.NewWriter(&b)
 
 	if _, err := w.Write(data); err != nil {
-		panic("error compressing: " + err.Error())
+		return nil, fmt.Errorf("error compressing: %w", err)
 	}
 	if err := w.Close(); err != nil {
-		panic("error closing zlib comp: " + err.Error())
+		return nil, fmt.Errorf("error closing zlib comp: %w", err)
 	}
 
-	return b.Bytes()
+	return b.Bytes(), nil
 }
 
-func Decompress(data []byte) ([]byte, error) {
+// Inflate returns the whole decompressed object, header included.
+func Inflate(data []byte) ([]byte, error) {
 	r, err := zlib.NewReader(bytes.NewReader(data))
 	if err != nil {
 		return nil, err
@@ -32,11 +33,18 @@ func Decompress(data []byte) ([]byte, error) {
 	if _, err := io.Copy(&d, r); err != nil {
 		return nil, err
 	}
+	return d.Bytes(), nil
+}
+
+func Decompress(data []byte) ([]byte, error) {
+	res, err := Inflate(data)
+	if err != nil {
+		return nil, err
+	}
 
-	res := d.Bytes()
 	idx := bytes.IndexByte(res, byte(0))
 	if idx == -1 {
 		return nil, fmt.Errorf("no null byte in blob")
 	}
-	return res[idx:], nil
+	return res[idx+1:], nil
 }
@@ -0,0 +1,359 @@
+package gitgo
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var ErrConfigSyntax = errors.New("bad config syntax")
+
+// Config reads and writes git style configuration files:
+//
+//	[core]
+//		bare = false
+//	[remote "origin"]
+//		url = ../other
+//
+// Variables are addressed as "section.key" or
+// "section.subsection.key". Section and key names are case
+// insensitive, subsections are not.
+type Config struct {
+	path     string
+	sections []*configSection
+}
+
+type configSection struct {
+	name       string
+	subsection string
+	vars       []configVar
+}
+
+type configVar struct {
+	key   string
+	value string
+}
+
+func NewConfig(path string) *Config {
+	return &Config{path: path}
+}
+
+// LoadConfig parses the file at path, a missing file gives an empty
+// config that will be created on Save.
+func LoadConfig(path string) (*Config, error) {
+	c := NewConfig(path)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c, nil
+		}
+		return nil, err
+	}
+	if err := c.parse(data); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+func (c *Config) parse(data []byte) error {
+	var current *configSection
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		if line[0] == '[' {
+			end := strings.LastIndexByte(line, ']')
+			if end == -1 {
+				return fmt.Errorf("%w: line %d", ErrConfigSyntax, lineNo)
+			}
+			name, sub, err := parseSectionHeader(line[1:end])
+			if err != nil {
+				return fmt.Errorf("%w: line %d", err, lineNo)
+			}
+			current = c.section(name, sub, true)
+			continue
+		}
+
+		if current == nil {
+			return fmt.Errorf("%w: line %d: variable outside of a section", ErrConfigSyntax, lineNo)
+		}
+		key, value, hasValue := strings.Cut(line, "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+		if !validConfigKey(key) {
+			return fmt.Errorf("%w: line %d: bad key %q", ErrConfigSyntax, lineNo, key)
+		}
+		if !hasValue {
+			current.vars = append(current.vars, configVar{key: key, value: "true"})
+			continue
+		}
+		v, err := parseConfigValue(value)
+		if err != nil {
+			return fmt.Errorf("%w: line %d", err, lineNo)
+		}
+		current.vars = append(current.vars, configVar{key: key, value: v})
+	}
+	return scanner.Err()
+}
+
+func parseSectionHeader(header string) (string, string, error) {
+	name, rest, found := strings.Cut(header, " ")
+	if !found {
+		// the old "[section.subsection]" syntax
+		name, sub, _ := strings.Cut(header, ".")
+		return strings.ToLower(name), sub, nil
+	}
+	rest = strings.TrimSpace(rest)
+	if len(rest) < 2 || rest[0] != '"' || rest[len(rest)-1] != '"' {
+		return "", "", ErrConfigSyntax
+	}
+	sub := strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(rest[1 : len(rest)-1])
+	return strings.ToLower(name), sub, nil
+}
+
+func parseConfigValue(raw string) (string, error) {
+	var b strings.Builder
+	inQuote := false
+	raw = strings.TrimSpace(raw)
+	for i := 0; i < len(raw); i++ {
+		ch := raw[i]
+		switch {
+		case ch == '"':
+			inQuote = !inQuote
+		case ch == '\\':
+			i++
+			if i == len(raw) {
+				return "", ErrConfigSyntax
+			}
+			switch raw[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case '"', '\\':
+				b.WriteByte(raw[i])
+			default:
+				return "", ErrConfigSyntax
+			}
+		case (ch == '#' || ch == ';') && !inQuote:
+			return strings.TrimRight(b.String(), " \t"), nil
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	if inQuote {
+		return "", ErrConfigSyntax
+	}
+	return b.String(), nil
+}
+
+func validConfigKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for i, ch := range key {
+		alpha := ch >= 'a' && ch <= 'z'
+		if !alpha && (i == 0 || !(ch == '-' || ch >= '0' && ch <= '9')) {
+			return false
+		}
+	}
+	return true
+}
+
+// splitConfigName splits "a.b.c.d" into section "a", subsection "b.c"
+// and key "d".
+func splitConfigName(name string) (string, string, string, error) {
+	first := strings.IndexByte(name, '.')
+	last := strings.LastIndexByte(name, '.')
+	if first == -1 {
+		return "", "", "", fmt.Errorf("%w: key does not contain a section: %s", ErrConfigSyntax, name)
+	}
+	section := strings.ToLower(name[:first])
+	key := strings.ToLower(name[last+1:])
+	sub := ""
+	if first != last {
+		sub = name[first+1 : last]
+	}
+	if section == "" || !validConfigKey(key) {
+		return "", "", "", fmt.Errorf("%w: invalid key: %s", ErrConfigSyntax, name)
+	}
+	return section, sub, key, nil
+}
+
+func (c *Config) section(name, sub string, create bool) *configSection {
+	for _, s := range c.sections {
+		if s.name == name && s.subsection == sub {
+			return s
+		}
+	}
+	if !create {
+		return nil
+	}
+	s := &configSection{name: name, subsection: sub}
+	c.sections = append(c.sections, s)
+	return s
+}
+
+// Get returns the last value set for name.
+func (c *Config) Get(name string) (string, bool) {
+	values := c.GetAll(name)
+	if len(values) == 0 {
+		return "", false
+	}
+	return values[len(values)-1], true
+}
+
+func (c *Config) GetAll(name string) []string {
+	section, sub, key, err := splitConfigName(name)
+	if err != nil {
+		return nil
+	}
+	var values []string
+	for _, s := range c.sections {
+		if s.name != section || s.subsection != sub {
+			continue
+		}
+		for _, v := range s.vars {
+			if v.key == key {
+				values = append(values, v.value)
+			}
+		}
+	}
+	return values
+}
+
+func (c *Config) GetInt(name string, def int) (int, error) {
+	v, ok := c.Get(name)
+	if !ok {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return def, fmt.Errorf("bad numeric config value %q for %s", v, name)
+	}
+	return n, nil
+}
+
+func (c *Config) GetBool(name string, def bool) (bool, error) {
+	v, ok := c.Get(name)
+	if !ok {
+		return def, nil
+	}
+	switch strings.ToLower(v) {
+	case "true", "yes", "on", "1":
+		return true, nil
+	case "false", "no", "off", "0", "":
+		return false, nil
+	}
+	return def, fmt.Errorf("bad boolean config value %q for %s", v, name)
+}
+
+// Set replaces every value of name with value.
+func (c *Config) Set(name, value string) error {
+	section, sub, key, err := splitConfigName(name)
+	if err != nil {
+		return err
+	}
+	c.unset(section, sub, key)
+	s := c.section(section, sub, true)
+	s.vars = append(s.vars, configVar{key: key, value: value})
+	return nil
+}
+
+func (c *Config) Unset(name string) error {
+	section, sub, key, err := splitConfigName(name)
+	if err != nil {
+		return err
+	}
+	c.unset(section, sub, key)
+	return nil
+}
+
+func (c *Config) unset(section, sub, key string) {
+	for _, s := range c.sections {
+		if s.name != section || s.subsection != sub {
+			continue
+		}
+		vars := s.vars[:0]
+		for _, v := range s.vars {
+			if v.key != key {
+				vars = append(vars, v)
+			}
+		}
+		s.vars = vars
+	}
+}
+
+func (c *Config) RemoveSection(section, sub string) bool {
+	section = strings.ToLower(section)
+	removed := false
+	sections := c.sections[:0]
+	for _, s := range c.sections {
+		if s.name == section && s.subsection == sub {
+			removed = true
+			continue
+		}
+		sections = append(sections, s)
+	}
+	c.sections = sections
+	return removed
+}
+
+// Subsections lists the subsections of section, in file order.
+func (c *Config) Subsections(section string) []string {
+	section = strings.ToLower(section)
+	var subs []string
+	for _, s := range c.sections {
+		if s.name == section && s.subsection != "" {
+			subs = append(subs, s.subsection)
+		}
+	}
+	return subs
+}
+
+func (c *Config) Bytes() []byte {
+	var buf bytes.Buffer
+	for _, s := range c.sections {
+		if len(s.vars) == 0 {
+			continue
+		}
+		if s.subsection == "" {
+			fmt.Fprintf(&buf, "[%s]\n", s.name)
+		} else {
+			sub := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s.subsection)
+			fmt.Fprintf(&buf, "[%s \"%s\"]\n", s.name, sub)
+		}
+		for _, v := range s.vars {
+			fmt.Fprintf(&buf, "\t%s = %s\n", v.key, quoteConfigValue(v.value))
+		}
+	}
+	return buf.Bytes()
+}
+
+func quoteConfigValue(v string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`).Replace(v)
+	if v != strings.TrimSpace(v) || strings.ContainsAny(v, "#;") {
+		return `"` + escaped + `"`
+	}
+	return escaped
+}
+
+func (c *Config) Save() error {
+	lock := lockInitialize(c.path)
+	if _, err := lock.holdForUpdate(); err != nil {
+		return err
+	}
+	if err := lock.write(c.Bytes()); err != nil {
+		lock.rollback()
+		return err
+	}
+	return lock.commit()
+}
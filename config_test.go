@@ -0,0 +1,64 @@
+package gitgo
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigParse(t *testing.T) {
+	c := NewConfig("")
+	err := c.parse([]byte(`# comment
+[core]
+	bare = false
+	Editor = "vim -f" ; trailing comment
+	fileMode
+[remote "origin"]
+	url = ../upstream
+	fetch = +refs/heads/*:refs/remotes/origin/*
+	fetch = +refs/tags/*:refs/tags/*
+`))
+	assert.NoError(t, err)
+
+	v, ok := c.Get("core.editor")
+	assert.True(t, ok)
+	assert.Equal(t, "vim -f", v)
+
+	b, err := c.GetBool("core.filemode", false)
+	assert.NoError(t, err)
+	assert.True(t, b)
+
+	v, _ = c.Get("remote.origin.url")
+	assert.Equal(t, "../upstream", v)
+	assert.Len(t, c.GetAll("remote.origin.fetch"), 2)
+	assert.Equal(t, []string{"origin"}, c.Subsections("remote"))
+}
+
+func TestConfigSaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	c, err := LoadConfig(path)
+	assert.NoError(t, err)
+
+	assert.NoError(t, c.Set("index.version", "4"))
+	assert.NoError(t, c.Set("remote.my.remote.url", " spaced # value "))
+	assert.NoError(t, c.Save())
+
+	loaded, err := LoadConfig(path)
+	assert.NoError(t, err)
+	n, err := loaded.GetInt("index.version", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+	v, _ := loaded.Get("remote.my.remote.url")
+	assert.Equal(t, " spaced # value ", v)
+
+	assert.True(t, loaded.RemoveSection("remote", "my.remote"))
+	_, ok := loaded.Get("remote.my.remote.url")
+	assert.False(t, ok)
+}
+
+func TestConfigSyntaxError(t *testing.T) {
+	c := NewConfig("")
+	assert.ErrorIs(t, c.parse([]byte("key = value\n")), ErrConfigSyntax)
+	assert.ErrorIs(t, c.parse([]byte("[core\n")), ErrConfigSyntax)
+}
@@ -2,11 +2,15 @@ package gitgo
 
 import (
 	"bytes"
+	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 )
@@ -19,26 +23,42 @@ const (
 	TypeCommit
 )
 
+var ErrCorruptObject = errors.New("corrupt object")
+
 var G_ignore = map[string]bool{
 	".":      true,
 	"..":     true,
 	".gitgo": true,
+	".git":   true,
 }
 
 type Database struct {
 	BlobData []byte
 	DbPath   string
-	FilePath string
 	Object   map[string]string
+	store    ObjectStore
+	// shallow are the commits read as having no parents, those of a
+	// shallow repository whose parents are not there.
+	shallow map[string]bool
 }
 
 func NewDatabase(dbPath string) *Database {
+	db := NewDatabaseWithStore(NewLooseStore(dbPath))
+	db.DbPath = dbPath
+	return db
+}
+
+// NewDatabaseWithStore lets the caller decide where the objects
+// live, see ObjectStore.
+func NewDatabaseWithStore(store ObjectStore) *Database {
 	return &Database{
-		DbPath: dbPath,
 		Object: make(map[string]string),
+		store:  store,
 	}
 }
 
+func (d *Database) ObjectStore() ObjectStore { return d.store }
+
 func (d *Database) Data(blobType BlobType, data []byte) {
 	var buf bytes.Buffer
 	buf.Write(GetPrefix(blobType, len(data)))
@@ -49,49 +69,110 @@ func (d *Database) Data(blobType BlobType, data []byte) {
 }
 
 func (d *Database) Store() (string, error) {
+	return d.StoreContext(context.Background())
+}
+
+// StoreContext is Store that does nothing once ctx is done.
+func (d *Database) StoreContext(ctx context.Context) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
 	sha := Hash(d.BlobData)
 	oid := hex.EncodeToString(sha)
 	return oid, d.Write(oid)
 }
 
 func (d *Database) Write(oid string) error {
-	compressData := Compress(d.BlobData)
-	d.objectPath(oid)
-	folderPath := filepath.Join(d.DbPath, oid[:2])
+	return d.store.Write(oid, d.BlobData)
+}
 
-	err := StoreObject(compressData, folderPath, d.FilePath)
+func (d *Database) Has(oid string) (bool, error) {
+	return d.store.Has(oid)
+}
+
+func (d *Database) Load(oid string) error {
+	_, data, err := d.ReadObject(oid)
 	if err != nil {
 		return err
 	}
-
+	d.Object[oid] = string(data)
 	return nil
 }
 
-func (d *Database) objectPath(oid string) {
-	d.FilePath = filepath.Join(d.DbPath, oid[:2], oid[2:])
+// ReadObject returns the type and the content of the object, without
+// its header.
+func (d *Database) ReadObject(oid string) (BlobType, []byte, error) {
+	raw, err := d.store.Read(oid)
+	if err != nil {
+		return 0, nil, err
+	}
+	return ParseObject(raw)
 }
 
-func (d *Database) Load(oid string) {
-	d.Object[oid] = d.ReadObject(oid)
+// ParseObject splits a raw object into its type and content, checking
+// the size recorded in the header.
+func ParseObject(raw []byte) (BlobType, []byte, error) {
+	idx := bytes.IndexByte(raw, 0)
+	if idx == -1 {
+		return 0, nil, fmt.Errorf("%w: missing header", ErrCorruptObject)
+	}
+	typ, size, err := parseObjectHeader(string(raw[:idx]))
+	if err != nil {
+		return 0, nil, err
+	}
+	content := raw[idx+1:]
+	if int64(len(content)) != size {
+		return 0, nil, fmt.Errorf(
+			"%w: size mismatch, header says %d got %d",
+			ErrCorruptObject, size, len(content),
+		)
+	}
+	return typ, content, nil
 }
 
-func (d *Database) ReadObject(oid string) string {
-	return ""
+func parseObjectHeader(header string) (BlobType, int64, error) {
+	name, sizeStr, ok := strings.Cut(header, " ")
+	if !ok {
+		return 0, 0, fmt.Errorf("%w: bad header %q", ErrCorruptObject, header)
+	}
+	typ, err := TypeFromName(name)
+	if err != nil {
+		return 0, 0, err
+	}
+	// only plain decimal, so that an object has a single header
+	size, err := strconv.ParseInt(sizeStr, 10, 64)
+	if err != nil || size < 0 || strconv.FormatInt(size, 10) != sizeStr {
+		return 0, 0, fmt.Errorf("%w: bad size %q", ErrCorruptObject, sizeStr)
+	}
+	return typ, size, nil
 }
 
 func AuthorData(name, email string, t time.Time) string {
-	utcOffset := getUTCOffset(t)
-	return fmt.Sprintf("%s <%s> %d %s", name, email, t.Unix(), utcOffset)
+	return fmt.Sprintf("%s <%s> %s", name, email, FormatDate(t))
 }
 
 func CommitData(parent, treeOID, author, message string) []byte {
+	return commitData(parent, treeOID, author, author, message)
+}
+
+func commitData(parent, treeOID, author, committer, message string) []byte {
+	var parents []string
+	if parent != "" {
+		parents = append(parents, parent)
+	}
+	return commitDataParents(parents, treeOID, author, committer, message)
+}
+
+// commitDataParents is commitData for a commit with any number of
+// parents, in order.
+func commitDataParents(parents []string, treeOID, author, committer, message string) []byte {
 	data := bytes.Buffer{}
 	data.WriteString(fmt.Sprintf("tree %s\n", treeOID))
-	if parent != "" {
+	for _, parent := range parents {
 		data.WriteString(fmt.Sprintf("parent %s\n", parent))
 	}
 	data.WriteString(fmt.Sprintf("author %s\n", author))
-	data.WriteString(fmt.Sprintf("comitter %s\n", author))
+	data.WriteString(fmt.Sprintf("committer %s\n", committer))
 	data.WriteString("\n")
 	data.WriteString(message)
 
@@ -103,15 +184,35 @@ func ReadStdinMsg(file *os.File) string {
 	return string(msg)
 }
 
-func GetPrefix(blob BlobType, size int) []byte {
-	res := ""
-	switch blob {
+func (b BlobType) String() string {
+	switch b {
 	case TypeFile:
-		res = fmt.Sprintf(`blob %d`, size)
+		return "blob"
 	case TypeTree:
-		res = fmt.Sprintf(`tree %d`, size)
+		return "tree"
 	case TypeCommit:
-		res = fmt.Sprintf(`commit %d`, size)
+		return "commit"
+	}
+	return "unknown"
+}
+
+func TypeFromName(name string) (BlobType, error) {
+	switch name {
+	case "blob":
+		return TypeFile, nil
+	case "tree":
+		return TypeTree, nil
+	case "commit":
+		return TypeCommit, nil
+	}
+	return 0, fmt.Errorf("%w: unknown object type %q", ErrCorruptObject, name)
+}
+
+func GetPrefix(blob BlobType, size int) []byte {
+	res := ""
+	switch blob {
+	case TypeFile, TypeTree, TypeCommit:
+		res = fmt.Sprintf(`%s %d`, blob, size)
 	default:
 		panic("undefined blob type")
 	}
@@ -121,8 +222,8 @@ func GetPrefix(blob BlobType, size int) []byte {
 
 func BlobData(data []byte) []byte {
 	prefix := GetPrefix(TypeFile, len(data))
-	data = append(data, []byte(prefix)...)
-	return data
+	blob := append(prefix, 0)
+	return append(blob, data...)
 }
 
 func StoreObject(data []byte, folderPath, filePath string) error {
@@ -132,8 +233,7 @@ func StoreObject(data []byte, folderPath, filePath string) error {
 	}
 
 	// if the file exists exit
-	_, err = os.Stat(filePath)
-	if os.IsExist(err) {
+	if _, err = os.Stat(filePath); err == nil {
 		return nil
 	}
 
@@ -157,7 +257,10 @@ func StoreObject(data []byte, folderPath, filePath string) error {
 	}
 
 	// rename the file
-	os.Rename(tempPath, filePath)
+	if err := os.Rename(tempPath, filePath); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("renaming temp file: %s", err)
+	}
 	return nil
 }
 
@@ -0,0 +1,298 @@
+package gitgo
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/Vikuuu/gitgo/internal/diff"
+)
+
+// DiffOptions pick the files Diff compares.
+type DiffOptions struct {
+	// From and To are the revisions compared. Without To, From is
+	// compared to the index when Cached and to the workspace otherwise.
+	// Without either, the index is compared to HEAD when Cached and
+	// the workspace to the index otherwise.
+	From, To string
+	Cached   bool
+	RenameOptions
+}
+
+// Diff returns the files that differ between the two versions opts
+// picks, sorted by path.
+func (r Repository) Diff(ctx context.Context, opts DiffOptions) ([]FileChange, error) {
+	var old, new []TreeEntry
+	var err error
+	switch {
+	case opts.To != "":
+		if old, err = r.commitFiles(opts.From); err == nil {
+			new, err = r.commitFiles(opts.To)
+		}
+	case opts.Cached:
+		if old, err = r.commitFiles(cmp.Or(opts.From, "HEAD")); err == nil {
+			new, err = r.indexFiles()
+		}
+	case opts.From != "":
+		if old, err = r.commitFiles(opts.From); err == nil {
+			new, err = r.workspaceFiles(ctx)
+		}
+	default:
+		if old, err = r.indexFiles(); err == nil {
+			new, err = r.workspaceFiles(ctx)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return detectRenames(diffFiles(old, new), opts.RenameOptions, r.readFileContent)
+}
+
+// commitFiles lists the files of the commit rev, none for HEAD on an
+// unborn branch.
+func (r Repository) commitFiles(rev string) ([]TreeEntry, error) {
+	if rev == "HEAD" && RefInitialize(r.Refs).ReadHead() == "" {
+		return nil, nil
+	}
+	oid, err := r.ResolveRevision(rev)
+	if err != nil {
+		return nil, err
+	}
+	db := r.ObjectDatabase()
+	commit, err := db.ReadCommit(oid)
+	if err != nil {
+		return nil, err
+	}
+	return db.ListTree(commit.Tree)
+}
+
+// indexFiles lists the entries of the index, but those only intended
+// to be added.
+func (r Repository) indexFiles() ([]TreeEntry, error) {
+	index := NewIndex(r.Path, r.GitPath)
+	if err := index.Load(); err != nil {
+		return nil, err
+	}
+	var files []TreeEntry
+	for _, name := range slices.Sorted(maps.Keys(index.IndexEntries())) {
+		entry := index.IndexEntries()[name]
+		if !entry.IntentToAdd() {
+			files = append(files, TreeEntry{Name: name, Mode: entry.Mode, OID: entry.Oid})
+		}
+	}
+	return files, nil
+}
+
+// workspaceFiles lists the tracked files of the workspace, hashing the
+// ones that differ from the index.
+func (r Repository) workspaceFiles(ctx context.Context) ([]TreeEntry, error) {
+	status, err := r.StatusContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	changed := make(map[string]ChangeType)
+	for _, entry := range status.Changed {
+		changed[entry.Path] = entry.Workspace
+	}
+
+	index := NewIndex(r.Path, r.GitPath)
+	if err := index.Load(); err != nil {
+		return nil, err
+	}
+	var files []TreeEntry
+	for _, name := range slices.Sorted(maps.Keys(index.IndexEntries())) {
+		entry := index.IndexEntries()[name]
+		file := TreeEntry{Name: name, Mode: entry.Mode, OID: entry.Oid}
+		switch changed[name] {
+		case Deleted:
+			continue
+		case Modified, Added:
+			path := filepath.Join(r.Path, name)
+			stat, err := os.Lstat(path)
+			if err != nil {
+				return nil, err
+			}
+			if file.OID, err = workspaceOID(ctx, path, entry); err != nil {
+				return nil, err
+			}
+			if entry.Mode != gitlinkMode {
+				file.Mode = modeForStat(stat)
+			}
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// readFileContent returns the content of a file, from the database or
+// else from the workspace, a submodule being the line git shows for
+// it.
+func (r Repository) readFileContent(file TreeEntry) ([]byte, error) {
+	if file.Mode == gitlinkMode {
+		return []byte("Subproject commit " + file.OID + "\n"), nil
+	}
+	db := r.ObjectDatabase()
+	if ok, err := db.Has(file.OID); err != nil {
+		return nil, err
+	} else if ok {
+		return db.readTyped(file.OID, TypeFile)
+	}
+	path := filepath.Join(r.Path, file.Name)
+	if file.Mode == symlinkMode {
+		target, err := os.Readlink(path)
+		return []byte(target), err
+	}
+	return os.ReadFile(path)
+}
+
+// WritePatch writes the changes as git's unified diff, with three
+// lines of context.
+func (r Repository) WritePatch(w io.Writer, changes []FileChange) error {
+	out := bufio.NewWriter(w)
+	for _, c := range changes {
+		if err := r.writeFilePatch(out, c); err != nil {
+			return err
+		}
+	}
+	return out.Flush()
+}
+
+func (r Repository) writeFilePatch(out *bufio.Writer, c FileChange) error {
+	from, to := cmp.Or(c.From.Name, c.To.Name), cmp.Or(c.To.Name, c.From.Name)
+	fmt.Fprintf(out, "diff --git a/%s b/%s\n", from, to)
+	switch {
+	case c.Type == Added:
+		fmt.Fprintf(out, "new file mode %06o\n", c.To.Mode)
+	case c.Type == Deleted:
+		fmt.Fprintf(out, "deleted file mode %06o\n", c.From.Mode)
+	case c.From.Mode != c.To.Mode:
+		fmt.Fprintf(out, "old mode %06o\nnew mode %06o\n", c.From.Mode, c.To.Mode)
+	}
+	switch c.Type {
+	case Renamed:
+		fmt.Fprintf(out, "similarity index %d%%\nrename from %s\nrename to %s\n", c.Similarity, from, to)
+	case Copied:
+		fmt.Fprintf(out, "similarity index %d%%\ncopy from %s\ncopy to %s\n", c.Similarity, from, to)
+	}
+	if c.From.OID == c.To.OID {
+		return nil
+	}
+
+	fromOID, toOID := cmp.Or(c.From.OID, zeroOID), cmp.Or(c.To.OID, zeroOID)
+	fmt.Fprintf(out, "index %s..%s", fromOID[:7], toOID[:7])
+	if c.From.Mode == c.To.Mode {
+		fmt.Fprintf(out, " %06o", c.To.Mode)
+	}
+	fmt.Fprintln(out)
+
+	var old, new []byte
+	var err error
+	if c.From.Name != "" {
+		if old, err = r.readFileContent(c.From); err != nil {
+			return err
+		}
+	}
+	if c.To.Name != "" {
+		if new, err = r.readFileContent(c.To); err != nil {
+			return err
+		}
+	}
+	fromLabel, toLabel := "a/"+from, "b/"+to
+	if c.Type == Added {
+		fromLabel = "/dev/null"
+	}
+	if c.Type == Deleted {
+		toLabel = "/dev/null"
+	}
+	if isBinary(old) || isBinary(new) {
+		fmt.Fprintf(out, "Binary files %s and %s differ\n", fromLabel, toLabel)
+		return nil
+	}
+	if len(old) == 0 && len(new) == 0 {
+		return nil
+	}
+	fmt.Fprintf(out, "--- %s\n+++ %s\n", fromLabel, toLabel)
+	writeHunks(out, string(old), string(new))
+	return nil
+}
+
+// isBinary tells binary content by a NUL byte in its first 8000 bytes,
+// like git does.
+func isBinary(data []byte) bool {
+	return bytes.IndexByte(data[:min(len(data), 8000)], 0) >= 0
+}
+
+// patchLines splits text into lines, a last line without a newline
+// keeping a mark that tells it from the same line with one.
+func patchLines(text string) []string {
+	lines := diff.Split(text)
+	if len(lines) > 0 && !strings.HasSuffix(text, "\n") {
+		lines[len(lines)-1] += noNewlineMark
+	}
+	return lines
+}
+
+const noNewlineMark = "\x00"
+
+func writeHunks(out *bufio.Writer, old, new string) {
+	a, b := patchLines(old), patchLines(new)
+	for _, h := range diff.Hunks(diff.Lines(a, b), 3) {
+		fmt.Fprintf(out, "@@ -%s +%s @@%s\n", hunkRange(h.AStart, h.ALines), hunkRange(h.BStart, h.BLines), hunkHeading(a, h.AStart))
+		for _, e := range h.Edits {
+			switch e.Op {
+			case diff.Equal:
+				writePatchLine(out, ' ', a[e.A])
+			case diff.Delete:
+				writePatchLine(out, '-', a[e.A])
+			case diff.Insert:
+				writePatchLine(out, '+', b[e.B])
+			}
+		}
+	}
+}
+
+func writePatchLine(out *bufio.Writer, prefix byte, line string) {
+	text, noNewline := strings.CutSuffix(line, noNewlineMark)
+	out.WriteByte(prefix)
+	out.WriteString(text)
+	out.WriteString("\n")
+	if noNewline {
+		out.WriteString("\\ No newline at end of file\n")
+	}
+}
+
+// hunkRange writes where a hunk is in a file: its first line and how
+// many it covers, unless one, or the line it follows when empty.
+func hunkRange(start, lines int) string {
+	switch lines {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, lines)
+}
+
+// hunkHeading is the line git shows after the range of a hunk: the
+// last line before it starting with a letter, '_' or '$', as a
+// function definition would, cut to 80 bytes.
+func hunkHeading(lines []string, start int) string {
+	for i := start - 1; i >= 0; i-- {
+		line := strings.TrimSuffix(lines[i], noNewlineMark)
+		if line == "" {
+			continue
+		}
+		if c := line[0]; c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == '$' {
+			return " " + strings.TrimRight(line[:min(len(line), 80)], " \t\r")
+		}
+	}
+	return ""
+}
@@ -11,6 +11,21 @@ const (
 
 	regularMode    uint32 = 0100644
 	executableMode uint32 = 0100755
+	symlinkMode    uint32 = 0120000
+	gitlinkMode    uint32 = 0160000
+
+	flagAssumeValid uint16 = 0x8000
+	flagExtended    uint16 = 0x4000
+	flagStageMask   uint16 = 0x3000
+)
+
+// Extended flags, only written by index versions 3 and up. They are
+// kept in the upper half of IndexEntry.Flags.
+const (
+	FlagSkipWorktree uint32 = 0x4000 << 16
+	FlagIntentToAdd  uint32 = 0x2000 << 16
+
+	extendedFlagsMask uint32 = 0xffff << 16
 )
 
 type Entries struct {
@@ -43,7 +58,13 @@ func modeForStat(s os.FileInfo) uint32 {
 	if s == nil {
 		return uint32(0)
 	}
-	// stat.Mode().IsRegular()
+	if s.Mode()&os.ModeSymlink != 0 {
+		return symlinkMode
+	}
+	// the only directories the index holds are nested repositories
+	if s.IsDir() {
+		return gitlinkMode
+	}
 	if s.Mode()&0111 != 0 {
 		return executableMode
 	} else {
@@ -72,6 +93,14 @@ func NewIndexEntry(name, oid string, stat os.FileInfo) *IndexEntry {
 	}
 }
 
+func (ie IndexEntry) SkipWorktree() bool { return ie.Flags&FlagSkipWorktree != 0 }
+
+func (ie IndexEntry) IntentToAdd() bool { return ie.Flags&FlagIntentToAdd != 0 }
+
+// Stage is 0 for a merged entry, else 1, 2 or 3 for the base, ours or
+// theirs version of a conflict.
+func (ie IndexEntry) Stage() int { return int(uint16(ie.Flags)&flagStageMask) >> 12 }
+
 func (ie IndexEntry) StatMatch(stat os.FileInfo) bool {
 	return ie.Mode == modeForStat(stat) && (ie.Size == 0 || ie.Size == stat.Size())
 }
@@ -92,7 +121,7 @@ func (ie *IndexEntry) updateStat(stat os.FileInfo) {
 	ie.MtimeNsec = s.Mtim.Nsec
 	ie.Dev = s.Dev
 	ie.Ino = s.Ino
-	ie.Mode = s.Mode
+	ie.Mode = modeForStat(stat)
 	ie.Uid = s.Uid
 	ie.Gid = s.Gid
 	ie.Size = s.Size
@@ -0,0 +1,51 @@
+package gitgo
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrCorruptIndex       = errors.New("corrupt index")
+	ErrBadSignature       = errors.New("bad index signature")
+	ErrUnsupportedVersion = errors.New("unsupported index version")
+	ErrChecksumMismatch   = errors.New("index checksum mismatch")
+)
+
+// IndexError reports a problem reading the index file at Path. Bad
+// signatures, checksum mismatches and truncated files all match
+// ErrCorruptIndex with errors.Is, on top of their own sentinel.
+type IndexError struct {
+	Path string
+	Err  error
+}
+
+func (e *IndexError) Error() string {
+	return fmt.Sprintf("index file %s: %v", e.Path, e.Err)
+}
+
+func (e *IndexError) Unwrap() error { return e.Err }
+
+func (e *IndexError) Is(target error) bool {
+	if target != ErrCorruptIndex {
+		return false
+	}
+	return !errors.Is(e.Err, ErrUnsupportedVersion)
+}
+
+// LockError reports a lock file that could not be taken or used.
+// Owner is set when the lock is held by a known gitgo process.
+type LockError struct {
+	Path  string
+	Err   error
+	Owner *LockOwner
+}
+
+func (e *LockError) Error() string {
+	if e.Owner != nil {
+		return fmt.Sprintf("unable to lock '%s': %v (held by %s)", e.Path, e.Err, e.Owner)
+	}
+	return fmt.Sprintf("unable to lock '%s': %v", e.Path, e.Err)
+}
+
+func (e *LockError) Unwrap() error { return e.Err }
@@ -1,6 +1,7 @@
 package gitgo
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/fs"
@@ -12,15 +13,24 @@ var ErrMissingFile = errors.New("no file with the name")
 
 // Returns the flatten directory structure
 func ListFiles(dir string, rootPath string) ([]string, error) {
+	return ListFilesContext(context.Background(), dir, rootPath)
+}
+
+// ListFilesContext is ListFiles that stops walking once ctx is done.
+func ListFilesContext(ctx context.Context, dir string, rootPath string) ([]string, error) {
 	var workfiles []string
 
 	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return fmt.Errorf("from WalkDir %s", err)
 		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 
-		// Check if the given dir string is file or dir?
-		s, err := os.Stat(path)
+		// Check if the given dir string is file or dir? Symbolic
+		// links are added as links, never followed.
+		s, err := os.Lstat(path)
 		// if file is not present
 		if os.IsNotExist(err) {
 			return fmt.Errorf("%w '%s'", ErrMissingFile, dir)
@@ -49,6 +59,17 @@ func ListFiles(dir string, rootPath string) ([]string, error) {
 			return nil
 		}
 
+		// A nested repository is added as a single gitlink entry
+		// instead of its files.
+		if d.IsDir() && filepath.Clean(path) != filepath.Clean(rootPath) && isNestedRepository(path) {
+			relPath, err := filepath.Rel(rootPath, path)
+			if err != nil {
+				return err
+			}
+			workfiles = append(workfiles, relPath)
+			return filepath.SkipDir
+		}
+
 		// Append only files, not directories
 		if !d.IsDir() {
 			relPath, err := filepath.Rel(rootPath, path)
@@ -0,0 +1,208 @@
+package gitgo
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// The seed corpus of these targets is under testdata/fuzz, run them
+// with `go test -fuzz=FuzzIndexLoad` and the like to look for more.
+
+func FuzzIndexLoad(f *testing.F) {
+	f.Add([]byte("DIRC\x00\x00\x00\x02\x00\x00\x00\x00"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		index := NewIndex(t.TempDir(), t.TempDir())
+		if err := index.load(bytes.NewReader(data)); err != nil {
+			return
+		}
+
+		// Whatever was accepted has to be written back in a form
+		// that reads the same and is then stable.
+		first, err := index.encode(index.version)
+		if err != nil {
+			t.Fatalf("encoding loaded index: %v", err)
+		}
+		again := NewIndex(t.TempDir(), t.TempDir())
+		if err := again.load(bytes.NewReader(first)); err != nil {
+			t.Fatalf("loading written index: %v", err)
+		}
+		second, err := again.encode(again.version)
+		if err != nil {
+			t.Fatalf("encoding reloaded index: %v", err)
+		}
+		if !bytes.Equal(first, second) {
+			t.Fatalf("index changed on round trip\n%x\n%x", first, second)
+		}
+
+		// the cached trees it came with must not trip up writing
+		if _, err := again.WriteTree(NewDatabaseWithStore(NewMemoryStore())); err != nil {
+			t.Fatalf("writing tree: %v", err)
+		}
+	})
+}
+
+func FuzzLooseObject(f *testing.F) {
+	for _, raw := range []string{"blob 6\x00hello\n", "tree 0\x00", "commit 3\x00abc", "blob +06\x00hello\n"} {
+		compressed, err := Compress([]byte(raw))
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(compressed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		raw, err := Inflate(data)
+		if err != nil {
+			return
+		}
+		typ, content, err := ParseObject(raw)
+
+		// the streaming reader has to agree with the in-memory one
+		br := bufio.NewReader(bytes.NewReader(raw))
+		header, herr := readObjectHeader(br)
+		var streamed []byte
+		if herr == nil {
+			var styp BlobType
+			var size int64
+			styp, size, herr = parseObjectHeader(header)
+			if herr == nil {
+				streamed, herr = io.ReadAll(&exactReader{r: br, remaining: size})
+				if herr == nil && styp != typ {
+					t.Fatalf("streamed type %s, read %s", styp, typ)
+				}
+			}
+		}
+		if (err == nil) != (herr == nil) {
+			t.Fatalf("ParseObject: %v, streaming: %v", err, herr)
+		}
+		if err != nil {
+			return
+		}
+		if !bytes.Equal(content, streamed) {
+			t.Fatalf("streamed content differs")
+		}
+		rebuilt := append(append(GetPrefix(typ, len(content)), 0), content...)
+		if !bytes.Equal(rebuilt, raw) {
+			t.Fatalf("object %q parsed from %q", rebuilt, raw)
+		}
+	})
+}
+
+func FuzzParseTree(f *testing.F) {
+	oid, _ := hex.DecodeString("ce013625030ba8dba906f756967f9e9ca394464a")
+	f.Add([]byte("100644 a.txt\x00" + string(oid) + "40000 dir\x00" + string(oid)))
+	f.Add([]byte{})
+	f.Add([]byte("100644 ..\x00" + string(oid)))
+	f.Add([]byte("40000 .GIT\x00" + string(oid)))
+	f.Add([]byte("120000 a\x00" + string(oid) + "40000 a\x00" + string(oid)))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		entries, err := ParseTree(data)
+		if err != nil {
+			return
+		}
+		var buf bytes.Buffer
+		for _, e := range entries {
+			if e.Name == "" || e.Name == "." || e.Name == ".." || bytes.ContainsRune([]byte(e.Name), '/') ||
+				strings.EqualFold(e.Name, GitDir) || strings.EqualFold(e.Name, GitgoDir) {
+				t.Fatalf("tree entry named %q", e.Name)
+			}
+			oid, _ := hex.DecodeString(e.OID)
+			fmt.Fprintf(&buf, "%o %s\x00%s", e.Mode, e.Name, oid)
+		}
+		again, err := ParseTree(buf.Bytes())
+		if err != nil {
+			t.Fatalf("parsing written tree: %v", err)
+		}
+		if fmt.Sprint(entries) != fmt.Sprint(again) {
+			t.Fatalf("tree changed on round trip\n%v\n%v", entries, again)
+		}
+	})
+}
+
+func FuzzParseCommit(f *testing.F) {
+	tree := "ce013625030ba8dba906f756967f9e9ca394464a"
+	f.Add([]byte(string(CommitData("", tree, "A U Thor <a@example.com> 1709274600 +0530", "Initial\n"))))
+	f.Add([]byte(string(CommitData(tree, tree, "A <a@example.com> 0 -0100", ""))))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		commit, err := ParseCommit(data)
+		if err != nil {
+			return
+		}
+		if validOID(commit.Tree) != nil {
+			t.Fatalf("commit with tree %q", commit.Tree)
+		}
+		for _, p := range commit.Parents {
+			if validOID(p) != nil {
+				t.Fatalf("commit with parent %q", p)
+			}
+		}
+		if commit.Author == "" {
+			return
+		}
+		if sig, err := ParseSignature(commit.Author); err == nil {
+			again, err := ParseSignature(sig.String())
+			if err != nil || again.Name != sig.Name || again.Email != sig.Email || !again.When.Equal(sig.When) {
+				t.Fatalf("signature %q read back as %q: %v", sig, again, err)
+			}
+		}
+	})
+}
+
+func FuzzApplyDelta(f *testing.F) {
+	f.Add([]byte("hello world\n"), []byte{12, 18, 0x90, 6, 6, 'g', 'i', 't', 'g', 'o', ' ', 0x91, 6, 6})
+	f.Add([]byte("hello world\n"), []byte{12, 0xff, 0xff, 0xff, 0xff, 0x0f, 0x90, 6})
+	f.Fuzz(func(t *testing.T, base, delta []byte) {
+		out, err := applyDelta(base, delta)
+		if err != nil {
+			return
+		}
+		r := bytes.NewReader(delta)
+		binary.ReadUvarint(r)
+		if size, _ := binary.ReadUvarint(r); uint64(len(out)) != size {
+			t.Fatalf("delta for %d bytes made %d", size, len(out))
+		}
+	})
+}
+
+func FuzzUnpackObjects(f *testing.F) {
+	p := &testPack{}
+	at := p.add(packBlob, nil, []byte("hello world\n"))
+	p.ofsDelta(at, []byte{12, 18, 0x90, 6, 6, 'g', 'i', 't', 'g', 'o', ' ', 0x91, 6, 6})
+	p.refDelta(blobOID("hello world\n"), []byte{12, 1, 0x01, '!'})
+	pack := p.bytes()
+	f.Add(pack[:len(pack)-20])
+	f.Add([]byte("PACK\x00\x00\x00\x02\xff\xff\xff\xff"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// the checksum is added here, or hardly any input would get
+		// past it
+		sum := sha1.Sum(data)
+		pack := append(bytes.Clone(data), sum[:]...)
+		db := NewDatabaseWithStore(NewMemoryStore())
+		n, err := unpackObjects(context.Background(), bytes.NewReader(pack), db, nil)
+		if err != nil {
+			return
+		}
+		// no more objects are stored than the pack holds, and each of
+		// them reads back
+		var oids []string
+		db.ObjectStore().Iterate(func(oid string) error {
+			oids = append(oids, oid)
+			return nil
+		})
+		if len(oids) > n {
+			t.Fatalf("pack of %d objects stored %d", n, len(oids))
+		}
+		for _, oid := range oids {
+			if _, _, err := db.ReadObject(oid); err != nil {
+				t.Fatalf("reading unpacked %s: %v", oid, err)
+			}
+		}
+	})
+}
@@ -2,10 +2,12 @@ module github.com/Vikuuu/gitgo
 
 go 1.23.5
 
-require github.com/stretchr/testify v1.10.0
+require (
+	github.com/brianvoe/gofakeit/v7 v7.3.0
+	github.com/stretchr/testify v1.10.0
+)
 
 require (
-	github.com/brianvoe/gofakeit/v7 v7.3.0 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
@@ -1,9 +1,16 @@
 package gitgo
 
-import "crypto/sha1"
+import (
+	"crypto/sha1"
+	"encoding/hex"
+)
 
 func Hash(data []byte) []byte {
 	h := sha1.New()
 	h.Write(data)
 	return h.Sum(nil)
 }
+
+func HashHex(data []byte) string {
+	return hex.EncodeToString(Hash(data))
+}
@@ -1,18 +1,24 @@
 package gitgo
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/sha1"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
-	"log"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
 
 	"github.com/Vikuuu/gitgo/internal/datastr"
+	"github.com/Vikuuu/gitgo/internal/workpool"
 )
 
 const (
@@ -21,9 +27,16 @@ const (
 	SIGNATURE    = "DIRC"
 	VERSION      = 2
 
+	MinIndexVersion = 2
+	MaxIndexVersion = 4
+
 	ENTRYFORMAT  = "N10H40Z*"
 	ENTRYBLOCK   = 8
 	ENTRYMINSIZE = 64
+
+	// size of an entry up to its flags, the path comes right after
+	// (or after the extended flags when present).
+	entryFixedSize = 62
 )
 
 type Index struct {
@@ -34,6 +47,15 @@ type Index struct {
 	lockfile *lockFile
 	changed  bool
 	parents  map[string]*datastr.Set
+	// version the index was read with, 0 when not read from disk.
+	version uint32
+	// version asked for with SetVersion, wins over everything else.
+	forceVersion uint32
+	tree         *cacheTree
+	// unmerged holds the base, ours and theirs entries, nil when
+	// missing, of the paths a merge left conflicted, in place of their
+	// entry in entries.
+	unmerged map[string][3]*IndexEntry
 }
 
 func NewIndex(repoPath, gitPath string) *Index {
@@ -45,6 +67,7 @@ func NewIndex(repoPath, gitPath string) *Index {
 		lockfile: lockInitialize(filepath.Join(gitPath, "index")),
 		changed:  false,
 		parents:  make(map[string]*datastr.Set),
+		unmerged: make(map[string][3]*IndexEntry),
 	}
 }
 
@@ -55,17 +78,36 @@ func (i *Index) Entries() []Entries {
 	for it.Next() {
 		path := it.Key()
 		entry := i.entries[path]
+		// intent-to-add entries have no content to commit yet
+		if entry.IntentToAdd() {
+			continue
+		}
 		e = append(e, Entries{
 			Path: path,
 			OID:  entry.Oid,
-			Stat: strconv.Itoa(int(entry.Mode)),
+			Stat: strconv.FormatUint(uint64(entry.Mode), 8),
 		})
 	}
 	return e
 }
 
+// SetLockOptions changes how the index lock is taken, it has to be
+// called before the lock is held.
+func (i *Index) SetLockOptions(opts LockOptions) {
+	i.lockfile.Options = opts
+}
+
+// IndexHoldForUpdate locks the index of the repository at repoPath,
+// with the default lock options, and loads it.
 func IndexHoldForUpdate(repoPath, gitPath string) (bool, *Index, error) {
+	return IndexHoldForUpdateWithOptions(repoPath, gitPath, defaultLockOptions)
+}
+
+// IndexHoldForUpdateWithOptions is IndexHoldForUpdate taking the lock
+// as opts say.
+func IndexHoldForUpdateWithOptions(repoPath, gitPath string, opts LockOptions) (bool, *Index, error) {
 	index := NewIndex(repoPath, gitPath)
+	index.SetLockOptions(opts)
 	b, err := index.lockfile.holdForUpdate()
 	if err != nil {
 		return false, index, err
@@ -75,44 +117,113 @@ func IndexHoldForUpdate(repoPath, gitPath string) (bool, *Index, error) {
 	}
 
 	// load the index file
-	err = index.Load()
+	if err := index.Load(); err != nil {
+		index.Release()
+		return false, index, err
+	}
 
 	return true, index, nil
 }
 
+// Load reads the index file, a missing file is an empty index. Any
+// problem with its content is reported as an *IndexError.
 func (i *Index) Load() error {
 	fileReader, err := os.Open(i.path)
 	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
 		return err
 	}
 	defer fileReader.Close()
-	hash := new(bytes.Buffer)
-	count := i.readHeader(fileReader, hash)
-	i.readEntries(fileReader, count, hash)
-	verifyChecksum(fileReader, hash)
 
+	if err := i.load(fileReader); err != nil {
+		return &IndexError{Path: i.path, Err: err}
+	}
+	return nil
+}
+
+func (i *Index) load(f io.Reader) error {
+	r := bufio.NewReader(f)
+	hash := sha1.New()
+	count, err := i.readHeader(r, hash)
+	if err != nil {
+		return err
+	}
+	if err := i.readEntries(r, count, hash); err != nil {
+		return err
+	}
+
+	// What follows the entries is the extensions and the checksum
+	rest, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if len(rest) < 20 {
+		return errors.New("index file truncated")
+	}
+	extensions, checksum := rest[:len(rest)-20], rest[len(rest)-20:]
+	hash.Write(extensions)
+	if err := verifyChecksum(bytes.NewReader(checksum), hash); err != nil {
+		return err
+	}
+	return i.readExtensions(extensions)
+}
+
+func (i *Index) readExtensions(data []byte) error {
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return errors.New("index extension header truncated")
+		}
+		signature := string(data[:4])
+		size := binary.BigEndian.Uint32(data[4:8])
+		if uint64(size) > uint64(len(data)-8) {
+			return fmt.Errorf("index extension %s truncated", signature)
+		}
+		body := data[8 : 8+size]
+		data = data[8+size:]
+
+		switch {
+		case signature == treeExtension:
+			tree, err := parseCacheTree(body)
+			if err != nil {
+				return err
+			}
+			i.tree = tree
+		case signature[0] >= 'A' && signature[0] <= 'Z':
+			// optional extension we don't know about, it gets
+			// dropped on the next write
+		default:
+			return fmt.Errorf("index uses %q extension, which we do not understand", signature)
+		}
+	}
 	return nil
 }
 
-func (i *Index) readHeader(f *os.File, h *bytes.Buffer) int {
+func (i *Index) readHeader(f io.Reader, h hash.Hash) (int, error) {
 	data, err := read(f, HEADERSIZE)
 	if err != nil {
-		log.Fatalf("%s\n", err)
+		return 0, fmt.Errorf("reading header: %w", err)
 	}
 	signature, version, count := data[:4], data[4:8], data[8:12]
 	if string(signature) != SIGNATURE {
-		log.Fatalf("signature: expected %s got %s\n", SIGNATURE, signature)
+		return 0, fmt.Errorf("%w: expected %s got %q", ErrBadSignature, SIGNATURE, signature)
 	}
-	if binary.BigEndian.Uint32(version) != VERSION {
-		log.Fatalf("version: expected %d got %d\n", binary.BigEndian.Uint32(version), VERSION)
+	v := binary.BigEndian.Uint32(version)
+	if v < MinIndexVersion || v > MaxIndexVersion {
+		return 0, fmt.Errorf(
+			"%w: expected %d-%d got %d",
+			ErrUnsupportedVersion, MinIndexVersion, MaxIndexVersion, v,
+		)
 	}
+	i.version = v
 	h.Write(data)
-	return int(binary.BigEndian.Uint32(count))
+	return int(binary.BigEndian.Uint32(count)), nil
 }
 
 func read(f io.Reader, size int) ([]byte, error) {
 	data := make([]byte, size)
-	_, err := f.Read(data)
+	_, err := io.ReadFull(f, data)
 	if err != nil {
 		return nil, err
 	}
@@ -120,36 +231,121 @@ func read(f io.Reader, size int) ([]byte, error) {
 	return data, nil
 }
 
-func (i *Index) readEntries(r io.Reader, count int, h *bytes.Buffer) {
-	for range count {
-		entry, err := read(r, ENTRYMINSIZE)
+func (i *Index) readEntries(r *bufio.Reader, count int, h hash.Hash) error {
+	prevPath := ""
+	for n := range count {
+		entry, err := read(r, entryFixedSize)
 		if err != nil {
-			log.Fatalln(err)
+			return fmt.Errorf("reading entry %d: %w", n, err)
 		}
 
-		for entry[len(entry)-1] != byte(0) {
-			e, err := read(r, ENTRYBLOCK)
+		flags := binary.BigEndian.Uint16(entry[60:62])
+		if flags&flagExtended != 0 {
+			if i.version < 3 {
+				return fmt.Errorf("entry %d: extended flags in index version %d", n, i.version)
+			}
+			ext, err := read(r, 2)
 			if err != nil {
-				log.Fatalln(err)
+				return fmt.Errorf("reading entry %d: %w", n, err)
 			}
+			entry = append(entry, ext...)
+		}
+		h.Write(entry)
 
-			entry = append(entry, e...)
+		var path string
+		if i.version >= 4 {
+			path, err = readCompressedPath(r, prevPath, h)
+		} else {
+			path, err = readPaddedPath(r, len(entry), h)
 		}
+		if err != nil {
+			return fmt.Errorf("reading entry %d path: %w", n, err)
+		}
+
+		i.storeEntryByte(entry, path)
+		prevPath = path
+	}
+	return nil
+}
+
+// readPaddedPath reads the NUL terminated path of a version 2 or 3
+// entry along with the NULs padding the entry to a multiple of 8.
+func readPaddedPath(r *bufio.Reader, fixedLen int, h hash.Hash) (string, error) {
+	name, err := r.ReadBytes(0)
+	if err != nil {
+		return "", err
+	}
+	h.Write(name)
 
-		i.storeEntryByte(entry)
-		_, err = h.Write(entry)
+	size := fixedLen + len(name)
+	padding := (ENTRYBLOCK - size%ENTRYBLOCK) % ENTRYBLOCK
+	pad, err := read(r, padding)
+	if err != nil {
+		return "", err
+	}
+	h.Write(pad)
+	return string(name[:len(name)-1]), nil
+}
+
+// readCompressedPath reads a version 4 path, stored as the number of
+// bytes to drop from the end of the previous path followed by the NUL
+// terminated suffix to append.
+func readCompressedPath(r *bufio.Reader, prev string, h hash.Hash) (string, error) {
+	var raw []byte
+	strip, err := readOffsetVarint(r, &raw)
+	if err != nil {
+		return "", err
+	}
+	h.Write(raw)
+	if strip > uint64(len(prev)) {
+		return "", fmt.Errorf("path prefix strip %d longer than previous path", strip)
+	}
+
+	suffix, err := r.ReadBytes(0)
+	if err != nil {
+		return "", err
+	}
+	h.Write(suffix)
+	return prev[:len(prev)-int(strip)] + string(suffix[:len(suffix)-1]), nil
+}
+
+// readOffsetVarint decodes git's offset varint, where every
+// continuation adds one before shifting so that no value has two
+// encodings.
+func readOffsetVarint(r io.ByteReader, raw *[]byte) (uint64, error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	*raw = append(*raw, c)
+	val := uint64(c & 0x7f)
+	for c&0x80 != 0 {
+		if val >= 1<<56 {
+			return 0, errors.New("varint overflow")
+		}
+		c, err = r.ReadByte()
 		if err != nil {
-			log.Fatalf("err writing entry to hash: %s\n", err)
+			return 0, err
 		}
+		*raw = append(*raw, c)
+		val = ((val + 1) << 7) | uint64(c&0x7f)
 	}
+	return val, nil
 }
 
-func (i *Index) storeEntryByte(entry []byte) {
-	const headerLen = 62
-	if len(entry) < headerLen {
-		return
+func appendOffsetVarint(b []byte, val uint64) []byte {
+	var buf [16]byte
+	pos := len(buf) - 1
+	buf[pos] = byte(val & 0x7f)
+	for val >>= 7; val != 0; val >>= 7 {
+		val--
+		pos--
+		buf[pos] = 0x80 | byte(val&0x7f)
 	}
+	return append(b, buf[pos:]...)
+}
 
+func (i *Index) storeEntryByte(entry []byte, path string) {
 	ctime := entry[0:4]
 	ctimeN := entry[4:8]
 	mtime := entry[8:12]
@@ -162,11 +358,6 @@ func (i *Index) storeEntryByte(entry []byte) {
 	size := entry[36:40]
 	oidInEntry := entry[40:60]
 	flag := entry[60:62]
-	fNameInEntry := entry[62:]
-
-	if idx := bytes.IndexByte(fNameInEntry, 0); idx != -1 {
-		fNameInEntry = fNameInEntry[:idx]
-	}
 
 	mtimeVal := int64(binary.BigEndian.Uint32(mtime))
 	mtimeNVal := int64(binary.BigEndian.Uint32(mtimeN))
@@ -179,14 +370,13 @@ func (i *Index) storeEntryByte(entry []byte) {
 	uidVal := uint32(binary.BigEndian.Uint32(uid))
 	gidVal := uint32(binary.BigEndian.Uint32(gid))
 	sizeVal := int64(binary.BigEndian.Uint32(size))
-	flagVal := uint32(binary.BigEndian.Uint16(flag))
-
-	if len(oidInEntry) != 20 {
-		panic("storeEntryByte: oid len != 20 bytes")
+	flagVal := uint32(binary.BigEndian.Uint16(flag) &^ flagExtended)
+	if len(entry) > entryFixedSize {
+		flagVal |= uint32(binary.BigEndian.Uint16(entry[62:64])) << 16
 	}
 
-	i.add(&IndexEntry{
-		Path:      string(fNameInEntry),
+	e := &IndexEntry{
+		Path:      path,
 		Oid:       hex.EncodeToString(oidInEntry),
 		Mtime:     mtimeVal,
 		MtimeNsec: mtimeNVal,
@@ -199,36 +389,57 @@ func (i *Index) storeEntryByte(entry []byte) {
 		Gid:       gidVal,
 		Size:      sizeVal,
 		Flags:     flagVal,
-	})
+	}
+	if stage := e.Stage(); stage > 0 {
+		stages := i.unmerged[path]
+		stages[stage-1] = e
+		i.unmerged[path] = stages
+		return
+	}
+	i.add(e)
 }
 
-func verifyChecksum(f io.Reader, h *bytes.Buffer) {
-	checksum := make([]byte, 20)
-	c := bytes.NewBuffer(checksum)
-	_, err := f.Read(c.AvailableBuffer())
+// verifyChecksum checks the trailing SHA-1 of the index against the
+// hash of everything read before it.
+func verifyChecksum(f io.Reader, h hash.Hash) error {
+	checksum, err := read(f, sha1.Size)
 	if err != nil {
-		log.Fatalln(err)
+		return fmt.Errorf("reading checksum: %w", err)
 	}
 
-	currChecksum := sha1.Sum(h.Bytes())
-	currC := bytes.NewBuffer(currChecksum[:])
-	if bytes.Equal(c.Bytes(), currC.Bytes()) {
-		log.Fatalln("checksums not equal")
+	if !bytes.Equal(checksum, h.Sum(nil)) {
+		return ErrChecksumMismatch
 	}
+	return nil
 }
 
 func (i *Index) add(entry *IndexEntry) {
+	delete(i.unmerged, entry.Path)
 	i.discardConflict(entry)
 	i.storeEntry(entry)
+	i.invalidateTree(entry.Path)
+	i.changed = true
+}
+
+// clear drops every entry, and the cached trees with them.
+func (i *Index) clear() {
+	i.entries = make(map[string]IndexEntry)
+	i.keys = datastr.NewSortedSet()
+	i.parents = make(map[string]*datastr.Set)
+	i.unmerged = make(map[string][3]*IndexEntry)
+	i.tree = nil
 	i.changed = true
 }
 
-// This function is being used in the tests.
+// Add stores the file path with content oid and its stat data. An
+// entry it replaces keeps its extended flags, but intent-to-add, which
+// adding the content resolves.
 func (i *Index) Add(path, oid string, stat os.FileInfo) {
 	entry := NewIndexEntry(path, oid, stat)
-	i.discardConflict(entry)
-	i.storeEntry(entry)
-	i.changed = true
+	if old, ok := i.entries[path]; ok {
+		entry.Flags |= old.Flags & extendedFlagsMask &^ FlagIntentToAdd
+	}
+	i.add(entry)
 }
 
 func (i *Index) discardConflict(e *IndexEntry) {
@@ -250,6 +461,39 @@ func (i *Index) discardConflict(e *IndexEntry) {
 	i.removeChildren(e.Path)
 }
 
+// remove drops the entry of the file path, or its unmerged ones.
+func (i *Index) remove(path string) {
+	if _, ok := i.entries[path]; ok {
+		i.removeEntry(path)
+		i.changed = true
+	}
+	if _, ok := i.unmerged[path]; ok {
+		delete(i.unmerged, path)
+		i.changed = true
+	}
+}
+
+// addUnmerged replaces the entry of path with the base, ours and
+// theirs versions of a conflict, nil for the missing ones.
+func (i *Index) addUnmerged(path string, stages [3]*TreeEntry) {
+	i.remove(path)
+	var entries [3]*IndexEntry
+	for n, file := range stages {
+		if file != nil {
+			flags := uint32(n+1)<<12 | uint32(min(len(path), maxPathSize))
+			entries[n] = &IndexEntry{Path: path, Oid: file.OID, Mode: file.Mode, Flags: flags}
+		}
+	}
+	i.unmerged[path] = entries
+	i.invalidateTree(path)
+	i.changed = true
+}
+
+// Unmerged returns the paths with conflicts left by a merge, sorted.
+func (i *Index) Unmerged() []string {
+	return slices.Sorted(maps.Keys(i.unmerged))
+}
+
 func (i *Index) removeEntry(path string) {
 	entry, ok := i.entries[path]
 	if !ok {
@@ -257,6 +501,7 @@ func (i *Index) removeEntry(path string) {
 	}
 	i.keys.Remove(entry.Path)
 	delete(i.entries, entry.Path)
+	i.invalidateTree(entry.Path)
 
 	var dirPaths []string
 	d := filepath.Dir(path)
@@ -310,6 +555,50 @@ func (i *Index) storeEntry(e *IndexEntry) {
 	}
 }
 
+// SetVersion picks the index format used by the next write, taking
+// precedence over the `index.version` config.
+func (i *Index) SetVersion(version uint32) error {
+	if version < MinIndexVersion || version > MaxIndexVersion {
+		return fmt.Errorf("index version %d not in range %d-%d", version, MinIndexVersion, MaxIndexVersion)
+	}
+	i.forceVersion = version
+	return nil
+}
+
+// writeVersion decides the format of the index being written, it is
+// the one asked for with SetVersion, else the `index.version` config,
+// else the one the index was read with. Version 2 cannot hold extended
+// flags, so it gets bumped to 3 when any entry has them.
+func (i *Index) writeVersion() (uint32, error) {
+	version := i.forceVersion
+	if version == 0 {
+		cfg, err := LoadConfig(filepath.Join(filepath.Dir(i.path), "config"))
+		if err != nil {
+			return 0, err
+		}
+		v, err := cfg.GetInt("index.version", 0)
+		if err != nil {
+			return 0, err
+		}
+		if v != 0 && (v < MinIndexVersion || v > MaxIndexVersion) {
+			return 0, fmt.Errorf("index.version %d not in range %d-%d", v, MinIndexVersion, MaxIndexVersion)
+		}
+		version = uint32(v)
+	}
+	if version == 0 {
+		version = max(i.version, VERSION)
+	}
+
+	if version == 2 {
+		for _, entry := range i.entries {
+			if entry.Flags&extendedFlagsMask != 0 {
+				return 3, nil
+			}
+		}
+	}
+	return version, nil
+}
+
 func (i *Index) WriteUpdate() (bool, error) {
 	if !i.changed {
 		return false, i.lockfile.rollback()
@@ -323,17 +612,69 @@ func (i *Index) WriteUpdate() (bool, error) {
 		return false, nil
 	}
 
+	version, err := i.writeVersion()
+	if err != nil {
+		i.lockfile.rollback()
+		return true, err
+	}
+	data, err := i.encode(version)
+	if err != nil {
+		i.lockfile.rollback()
+		return true, err
+	}
+
+	if err := i.lockfile.write(data); err != nil {
+		i.lockfile.rollback()
+		return true, err
+	}
+	if err := i.lockfile.commit(); err != nil {
+		return true, err
+	}
+	i.changed = false
+	i.version = version
+	return true, nil
+}
+
+func (i *Index) encode(version uint32) ([]byte, error) {
 	buf := new(bytes.Buffer) // Makes a new buffer and returns its pointer
-	writeHeader(buf, len(i.entries))
+	var entries []IndexEntry
 	it := i.keys.Iterator()
 	for it.Next() {
-		path := it.Key()
-		entry := i.entries[path]
-		data, err := writeIndexEntry(entry)
+		entries = append(entries, i.entries[it.Key()])
+	}
+	// the stages of unmerged paths go where their entry would
+	for _, path := range i.Unmerged() {
+		for _, entry := range i.unmerged[path] {
+			if entry != nil {
+				entries = append(entries, *entry)
+			}
+		}
+	}
+	slices.SortStableFunc(entries, func(a, b IndexEntry) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+
+	if err := writeHeader(buf, version, len(entries)); err != nil {
+		return nil, err
+	}
+	prevPath := ""
+	for _, entry := range entries {
+		data, err := writeIndexEntry(entry, version, prevPath)
 		if err != nil {
-			return true, err
+			return nil, err
 		}
 		buf.Write(data)
+		prevPath = entry.Path
+	}
+
+	if i.tree != nil {
+		ext := new(bytes.Buffer)
+		if err := i.tree.encode(ext); err != nil {
+			return nil, err
+		}
+		buf.WriteString(treeExtension)
+		binary.Write(buf, binary.BigEndian, uint32(ext.Len()))
+		buf.Write(ext.Bytes())
 	}
 
 	// Getting the hash of the whole content in the
@@ -341,20 +682,16 @@ func (i *Index) WriteUpdate() (bool, error) {
 	content := buf.Bytes()
 	bufHash := sha1.Sum(content)
 	buf.Write(bufHash[:])
-
-	i.lockfile.write(buf.Bytes())
-	i.lockfile.commit()
-	i.changed = false
-	return true, nil
+	return buf.Bytes(), nil
 }
 
-func writeHeader(buf *bytes.Buffer, entryLen int) error {
+func writeHeader(buf *bytes.Buffer, version uint32, entryLen int) error {
 	_, err := buf.Write([]byte("DIRC"))
 	if err != nil {
 		return fmt.Errorf("writing index header: %s", err)
 	}
 	b := new(bytes.Buffer)
-	versionNum := uint32(2)
+	versionNum := version
 	entriesNum := uint32(entryLen)
 	binary.Write(b, binary.BigEndian, versionNum)
 	binary.Write(b, binary.BigEndian, entriesNum)
@@ -366,7 +703,7 @@ func writeHeader(buf *bytes.Buffer, entryLen int) error {
 	return nil
 }
 
-func writeIndexEntry(entry IndexEntry) ([]byte, error) {
+func writeIndexEntry(entry IndexEntry, version uint32, prevPath string) ([]byte, error) {
 	b := new(bytes.Buffer)
 
 	writeU32 := func(v uint32, what string) error {
@@ -425,19 +762,45 @@ func writeIndexEntry(entry IndexEntry) ([]byte, error) {
 		return nil, fmt.Errorf("writing oid: %w", err)
 	}
 
+	extended := uint16(entry.Flags >> 16)
+	if extended != 0 && version < 3 {
+		return nil, fmt.Errorf("extended flags on %s need index version 3", entry.Path)
+	}
 	nameLen := min(len(entry.Path), 0xFFF)
-	flagVal := (uint16(entry.Flags) & 0xF000) | uint16(nameLen&0x0FFF)
+	flagVal := (uint16(entry.Flags) & (flagAssumeValid | flagStageMask)) | uint16(nameLen&0x0FFF)
+	if extended != 0 {
+		flagVal |= flagExtended
+	}
 	if err := writeU16(flagVal, "flag"); err != nil {
 		return nil, err
 	}
+	if extended != 0 {
+		if err := writeU16(extended, "extended flag"); err != nil {
+			return nil, err
+		}
+	}
 
-	if _, err := b.Write([]byte(entry.Path)); err != nil {
+	path := entry.Path
+	if version >= 4 {
+		// Only write what differs from the previous path
+		common := 0
+		for common < len(path) && common < len(prevPath) && path[common] == prevPath[common] {
+			common++
+		}
+		b.Write(appendOffsetVarint(nil, uint64(len(prevPath)-common)))
+		path = path[common:]
+	}
+
+	if _, err := b.Write([]byte(path)); err != nil {
 		return nil, fmt.Errorf("writing entry path: %s", err)
 	}
 	if err = b.WriteByte(0); err != nil {
 		return nil, fmt.Errorf("writing null byte after entry: %s", err)
 	}
 
+	if version >= 4 {
+		return b.Bytes(), nil
+	}
 	missing := (8 - (b.Len() % 8)) % 8
 	for range missing {
 		if err := b.WriteByte(0); err != nil {
@@ -453,6 +816,11 @@ func (i *Index) IsTracked(path string) bool {
 	cleanPath := filepath.Clean(path)
 	_, pres := i.entries[cleanPath]
 	_, pPres := i.parents[cleanPath]
+	for path := range i.unmerged {
+		if path == cleanPath || strings.HasPrefix(path, cleanPath+"/") {
+			return true
+		}
+	}
 	return pres || pPres
 }
 
@@ -462,5 +830,63 @@ func (i *Index) IndexEntries() map[string]IndexEntry {
 
 func (i *Index) UpdateEntryStat(entry *IndexEntry, stat os.FileInfo) {
 	entry.updateStat(stat)
+	i.entries[entry.Path] = *entry
 	i.changed = true
 }
+
+// RebuildIndex replaces the index, which may be unreadable, with the
+// content of the HEAD commit. Files in the workspace that still match
+// HEAD get their stat data recorded, the others are left without it so
+// that status compares their content.
+func RebuildIndex(repoPath, gitPath string, db *Database, workers int) (*Index, error) {
+	index := NewIndex(repoPath, gitPath)
+	if _, err := index.lockfile.holdForUpdate(); err != nil {
+		return nil, err
+	}
+
+	var files []TreeEntry
+	if head := RefInitialize(gitPath).ReadHead(); head != "" {
+		commit, err := db.ReadCommit(head)
+		if err != nil {
+			index.Release()
+			return nil, err
+		}
+		files, err = db.ListTree(commit.Tree)
+		if err != nil {
+			index.Release()
+			return nil, err
+		}
+	}
+
+	entries := make([]*IndexEntry, len(files))
+	err := workpool.Run(workers, len(files), func(n int) error {
+		file := files[n]
+		entry := &IndexEntry{Path: file.Name, Oid: file.OID, Mode: file.Mode}
+		entries[n] = entry
+
+		r, size, stat, err := openBlob(filepath.Join(repoPath, file.Name))
+		if err != nil {
+			return nil
+		}
+		defer r.Close()
+		oid, err := HashStream(TypeFile, size, r)
+		if err == nil && oid == file.OID && modeForStat(stat) == file.Mode {
+			entries[n] = NewIndexEntry(file.Name, file.OID, stat)
+		}
+		return nil
+	})
+	if err != nil {
+		index.Release()
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		entry.Flags |= uint32(min(len(entry.Path), maxPathSize))
+		index.add(entry)
+	}
+	index.changed = true
+	if _, err := index.WriteUpdate(); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
@@ -3,6 +3,7 @@ package gitgo
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"fmt"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -59,6 +60,9 @@ func TestAddSingleFile(t *testing.T) {
 
 	expected := []string{"alice.txt"}
 	assert.Equal(t, expected, got)
+
+	// the mode is written in octal, as trees store it
+	assert.Equal(t, "100644", entries[0].Stat)
 }
 
 func TestReplaceFileWithDir(t *testing.T) {
@@ -113,3 +117,181 @@ func TestReplaceNestedDirWithFile(t *testing.T) {
 
 	assert.Equal(t, expected, got)
 }
+
+func TestIndexVersionsRoundTrip(t *testing.T) {
+	paths := []string{
+		"README.md",
+		"cmd/gitgo/main.go",
+		"cmd/gitgo/main_test.go",
+		"internal/datastr/set.go",
+		"internal/datastr/skiplist.go",
+	}
+
+	for _, version := range []uint32{2, 3, 4} {
+		t.Run(fmt.Sprintf("version %d", version), func(t *testing.T) {
+			tmpDir := t.TempDir()
+			gitPath := filepath.Join(tmpDir, ".gitgo")
+			assert.NoError(t, os.MkdirAll(gitPath, 0755))
+
+			index := NewIndex(tmpDir, gitPath)
+			oids := make(map[string]string)
+			for _, p := range paths {
+				oids[p] = randomOID()
+				index.Add(p, oids[p], thisFileStat(t))
+			}
+			if version >= 3 {
+				entry := index.entries["README.md"]
+				entry.Flags |= FlagSkipWorktree
+				index.entries["README.md"] = entry
+			}
+			assert.NoError(t, index.SetVersion(version))
+			_, err := index.WriteUpdate()
+			assert.NoError(t, err)
+
+			loaded := NewIndex(tmpDir, gitPath)
+			assert.NoError(t, loaded.Load())
+			assert.Equal(t, version, loaded.version)
+
+			var got []string
+			for _, e := range loaded.Entries() {
+				got = append(got, e.Path)
+				assert.Equal(t, oids[e.Path], e.OID)
+			}
+			assert.Equal(t, paths, got)
+			assert.Equal(t, version >= 3, loaded.entries["README.md"].SkipWorktree())
+		})
+	}
+}
+
+func TestIndexVersionFromConfig(t *testing.T) {
+	tmpDir := t.TempDir()
+	gitPath := filepath.Join(tmpDir, ".gitgo")
+	assert.NoError(t, os.MkdirAll(gitPath, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(gitPath, "config"), []byte("[index]\n\tversion = 4\n"), 0644))
+
+	index := NewIndex(tmpDir, gitPath)
+	index.Add("alice.txt", randomOID(), thisFileStat(t))
+	_, err := index.WriteUpdate()
+	assert.NoError(t, err)
+
+	loaded := NewIndex(tmpDir, gitPath)
+	assert.NoError(t, loaded.Load())
+	assert.Equal(t, uint32(4), loaded.version)
+}
+
+func TestIntentToAddBumpsVersion(t *testing.T) {
+	tmpDir := t.TempDir()
+	gitPath := filepath.Join(tmpDir, ".gitgo")
+	assert.NoError(t, os.MkdirAll(gitPath, 0755))
+
+	index := NewIndex(tmpDir, gitPath)
+	index.Add("alice.txt", randomOID(), thisFileStat(t))
+	entry := index.entries["alice.txt"]
+	entry.Flags |= FlagIntentToAdd
+	index.entries["alice.txt"] = entry
+
+	version, err := index.writeVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(3), version)
+	assert.Empty(t, index.Entries())
+}
+
+func TestAddKeepsExtendedFlags(t *testing.T) {
+	index := NewIndex(t.TempDir(), "")
+	index.Add("alice.txt", randomOID(), thisFileStat(t))
+	entry := index.entries["alice.txt"]
+	entry.Flags |= FlagSkipWorktree | FlagIntentToAdd
+	index.entries["alice.txt"] = entry
+
+	oid := randomOID()
+	index.Add("alice.txt", oid, thisFileStat(t))
+	entry = index.entries["alice.txt"]
+	assert.Equal(t, oid, entry.Oid)
+	assert.True(t, entry.SkipWorktree())
+	assert.False(t, entry.IntentToAdd())
+}
+
+func writeTestIndex(t *testing.T, gitPath string, data []byte) *Index {
+	assert.NoError(t, os.MkdirAll(gitPath, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(gitPath, "index"), data, 0644))
+	return NewIndex(filepath.Dir(gitPath), gitPath)
+}
+
+func TestLoadReportsTypedErrors(t *testing.T) {
+	gitPath := filepath.Join(t.TempDir(), ".gitgo")
+
+	index := writeTestIndex(t, gitPath, []byte("DIRX\x00\x00\x00\x02\x00\x00\x00\x00"))
+	err := index.Load()
+	assert.ErrorIs(t, err, ErrBadSignature)
+	assert.ErrorIs(t, err, ErrCorruptIndex)
+	var indexErr *IndexError
+	assert.ErrorAs(t, err, &indexErr)
+	assert.Equal(t, filepath.Join(gitPath, "index"), indexErr.Path)
+
+	index = writeTestIndex(t, gitPath, []byte("DIRC\x00\x00\x00\x09\x00\x00\x00\x00"))
+	err = index.Load()
+	assert.ErrorIs(t, err, ErrUnsupportedVersion)
+	assert.NotErrorIs(t, err, ErrCorruptIndex)
+
+	// claims one entry but has none
+	index = writeTestIndex(t, gitPath, []byte("DIRC\x00\x00\x00\x02\x00\x00\x00\x01"))
+	assert.ErrorIs(t, index.Load(), ErrCorruptIndex)
+}
+
+func TestLoadMissingIndexIsEmpty(t *testing.T) {
+	tmpDir := t.TempDir()
+	index := NewIndex(tmpDir, filepath.Join(tmpDir, ".gitgo"))
+	assert.NoError(t, index.Load())
+	assert.Empty(t, index.Entries())
+}
+
+func TestLockDenied(t *testing.T) {
+	tmpDir := t.TempDir()
+	gitPath := filepath.Join(tmpDir, ".gitgo")
+	assert.NoError(t, os.MkdirAll(gitPath, 0755))
+
+	_, first, err := IndexHoldForUpdate(tmpDir, gitPath)
+	assert.NoError(t, err)
+
+	_, _, err = IndexHoldForUpdate(tmpDir, gitPath)
+	assert.ErrorIs(t, err, ErrLockDenied)
+	var lockErr *LockError
+	assert.ErrorAs(t, err, &lockErr)
+
+	// releasing an index that never got the lock keeps the other
+	// holder's lock in place
+	other := NewIndex(tmpDir, gitPath)
+	assert.NoError(t, other.Release())
+	_, err = os.Stat(filepath.Join(gitPath, "index.lock"))
+	assert.NoError(t, err)
+
+	assert.NoError(t, first.Release())
+	_, err = os.Stat(filepath.Join(gitPath, "index.lock"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestLoadDetectsChecksumMismatch(t *testing.T) {
+	tmpDir := t.TempDir()
+	gitPath := filepath.Join(tmpDir, ".gitgo")
+	assert.NoError(t, os.MkdirAll(gitPath, 0755))
+
+	index := NewIndex(tmpDir, gitPath)
+	index.Add("alice.txt", randomOID(), thisFileStat(t))
+	_, err := index.WriteUpdate()
+	assert.NoError(t, err)
+
+	loaded := NewIndex(tmpDir, gitPath)
+	assert.NoError(t, loaded.Load())
+
+	data, err := os.ReadFile(filepath.Join(gitPath, "index"))
+	assert.NoError(t, err)
+	// flip a byte of the path
+	data[HEADERSIZE+entryFixedSize] ^= 0xff
+	index = writeTestIndex(t, gitPath, data)
+	err = index.Load()
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+	assert.ErrorIs(t, err, ErrCorruptIndex)
+
+	index = writeTestIndex(t, gitPath, data[:len(data)-5])
+	assert.ErrorIs(t, index.Load(), ErrCorruptIndex)
+}
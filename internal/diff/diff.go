@@ -0,0 +1,247 @@
+// Package diff finds the shortest edit script turning one list of lines
+// into another with Myers' algorithm, keeping the lines common to both
+// and deleting or inserting the others. It also merges the changes two
+// lists made to a third one.
+package diff
+
+import (
+	"slices"
+	"strings"
+)
+
+// Op is what an edit does with a line.
+type Op int
+
+const (
+	Equal Op = iota
+	Delete
+	Insert
+)
+
+// Edit is one step of an edit script. A and B are the indexes of its
+// line in the old and the new list, -1 on the side it is not in.
+type Edit struct {
+	Op   Op
+	A, B int
+}
+
+// Split cuts text into lines without their "\n", a last line ending
+// without one included.
+func Split(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// Lines returns the edits turning a into b, in order, a deletion coming
+// before the insertion at the same place.
+func Lines(a, b []string) []Edit {
+	// the common ends are left out of the search
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	edits := make([]Edit, 0, len(a)+len(b))
+	for i := range prefix {
+		edits = append(edits, Edit{Op: Equal, A: i, B: i})
+	}
+	edits = append(edits, myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix], prefix)...)
+	for i := range suffix {
+		edits = append(edits, Edit{Op: Equal, A: len(a) - suffix + i, B: len(b) - suffix + i})
+	}
+	return edits
+}
+
+// myers finds the edits of a into b in linear space: it looks for a
+// point in the middle of a shortest edit path, searching forward from
+// the start and backward from the end at once, then finds the edits on
+// both sides of it the same way. The indexes of the edits are shifted
+// by offset.
+func myers(a, b []string, offset int) []Edit {
+	var edits []Edit
+	var walk func(a0, a1, b0, b1 int)
+	walk = func(a0, a1, b0, b1 int) {
+		for a0 < a1 && b0 < b1 && a[a0] == b[b0] {
+			edits = append(edits, Edit{Op: Equal, A: a0 + offset, B: b0 + offset})
+			a0, b0 = a0+1, b0+1
+		}
+		suffix := 0
+		for a1-suffix > a0 && b1-suffix > b0 && a[a1-1-suffix] == b[b1-1-suffix] {
+			suffix++
+		}
+		a1, b1 = a1-suffix, b1-suffix
+
+		x, y := -1, -1
+		if a0 < a1 && b0 < b1 {
+			x, y = middle(a[a0:a1], b[b0:b1])
+		}
+		if x > 0 || y > 0 {
+			walk(a0, a0+x, b0, b0+y)
+			walk(a0+x, a1, b0+y, b1)
+		} else {
+			// one side is empty, or the two have nothing in common
+			for i := a0; i < a1; i++ {
+				edits = append(edits, Edit{Op: Delete, A: i + offset, B: -1})
+			}
+			for j := b0; j < b1; j++ {
+				edits = append(edits, Edit{Op: Insert, A: -1, B: j + offset})
+			}
+		}
+		for i := range suffix {
+			edits = append(edits, Edit{Op: Equal, A: a1 + i + offset, B: b1 + i + offset})
+		}
+	}
+	walk(0, len(a), 0, len(b))
+
+	// the halves may leave an insertion before a deletion next to it
+	for i := 0; i < len(edits); {
+		if edits[i].Op == Equal {
+			i++
+			continue
+		}
+		j := i
+		for j < len(edits) && edits[j].Op != Equal {
+			j++
+		}
+		slices.SortStableFunc(edits[i:j], func(x, y Edit) int { return int(x.Op) - int(y.Op) })
+		i = j
+	}
+	return edits
+}
+
+// middle returns where a shortest edit path of a into b, which differ
+// in their first and last lines, goes through the middle, or -1, -1
+// when they have no line in common. The forward search keeps in vf the
+// furthest x reached on every diagonal k = x-y, the backward one in vb
+// the same counted from the ends; once they overlap, the path is found.
+func middle(a, b []string) (int, int) {
+	n, m := len(a), len(b)
+	maxD := (n + m + 1) / 2
+	vf := make([]int, 2*maxD+2)
+	vb := make([]int, 2*maxD+2)
+	for i := range vf {
+		vf[i], vb[i] = -1, -1
+	}
+	vf[maxD+1], vb[maxD+1] = 0, 0
+	delta := n - m
+	// with an odd delta, the forward search reaches the overlap first
+	odd := delta%2 != 0
+	// the diagonals that ran off the end of a or b are not searched
+	// again
+	fStart, fEnd, bStart, bEnd := 0, 0, 0, 0
+	for d := 0; d < maxD; d++ {
+		for k := -d + fStart; k <= d-fEnd; k += 2 {
+			i := maxD + k
+			var x int
+			if k == -d || k != d && vf[i-1] < vf[i+1] {
+				x = vf[i+1]
+			} else {
+				x = vf[i-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			vf[i] = x
+			switch {
+			case x > n:
+				fEnd += 2
+			case y > m:
+				fStart += 2
+			case odd:
+				if j := maxD + delta - k; j >= 0 && j < len(vb) && vb[j] != -1 && x >= n-vb[j] {
+					return x, y
+				}
+			}
+		}
+		for k := -d + bStart; k <= d-bEnd; k += 2 {
+			i := maxD + k
+			var x int
+			if k == -d || k != d && vb[i-1] < vb[i+1] {
+				x = vb[i+1]
+			} else {
+				x = vb[i-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[n-x-1] == b[m-y-1] {
+				x, y = x+1, y+1
+			}
+			vb[i] = x
+			switch {
+			case x > n:
+				bEnd += 2
+			case y > m:
+				bStart += 2
+			case !odd:
+				if j := maxD + delta - k; j >= 0 && j < len(vf) && vf[j] != -1 && vf[j] >= n-x {
+					return vf[j], vf[j] - (j - maxD)
+				}
+			}
+		}
+	}
+	return -1, -1
+}
+
+// Hunk is a run of edits with changes, along with the equal lines
+// around them. AStart and BStart are the indexes in a and b where it
+// starts, ALines and BLines how many of their lines it covers.
+type Hunk struct {
+	AStart, ALines int
+	BStart, BLines int
+	Edits          []Edit
+}
+
+// Hunks cuts the changes of edits into hunks keeping up to context
+// equal lines on every side of them, changes closer than twice that
+// going in the same hunk.
+func Hunks(edits []Edit, context int) []Hunk {
+	var hunks []Hunk
+	for i := 0; i < len(edits); {
+		if edits[i].Op == Equal {
+			i++
+			continue
+		}
+		start, end := max(0, i-context), i
+		for {
+			for end < len(edits) && edits[end].Op != Equal {
+				end++
+			}
+			next := end
+			for next < len(edits) && edits[next].Op == Equal {
+				next++
+			}
+			if next == len(edits) || next-end > 2*context {
+				break
+			}
+			end = next
+		}
+		end = min(len(edits), end+context)
+
+		h := Hunk{Edits: edits[start:end]}
+		for _, e := range edits[:start] {
+			if e.Op != Insert {
+				h.AStart++
+			}
+			if e.Op != Delete {
+				h.BStart++
+			}
+		}
+		for _, e := range h.Edits {
+			if e.Op != Insert {
+				h.ALines++
+			}
+			if e.Op != Delete {
+				h.BLines++
+			}
+		}
+		hunks = append(hunks, h)
+		i = end
+	}
+	return hunks
+}
@@ -0,0 +1,161 @@
+package diff
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// script writes edits as one letter per line: =, - or +.
+func script(edits []Edit) string {
+	var b strings.Builder
+	for _, e := range edits {
+		b.WriteByte("=-+"[e.Op])
+	}
+	return b.String()
+}
+
+// lcs is the length of the longest common subsequence of a and b.
+func lcs(a, b []string) int {
+	prev := make([]int, len(b)+1)
+	for i := range a {
+		cur := make([]int, len(b)+1)
+		for j := range b {
+			if a[i] == b[j] {
+				cur[j+1] = prev[j] + 1
+			} else {
+				cur[j+1] = max(prev[j+1], cur[j])
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func TestLines(t *testing.T) {
+	for _, tc := range []struct {
+		a, b, script string
+	}{
+		{"", "", ""},
+		{"a b c", "a b c", "==="},
+		{"", "a b", "++"},
+		{"a b", "", "--"},
+		{"a b c", "a c", "=-="},
+		{"a c", "a b c", "=+="},
+		{"a b c", "a x c", "=-+="},
+		{"a b c a b b a", "c b a b a c", "-+=-==-=+"},
+	} {
+		edits := Lines(strings.Fields(tc.a), strings.Fields(tc.b))
+		assert.Equal(t, tc.script, script(edits), "%q -> %q", tc.a, tc.b)
+	}
+}
+
+func TestLinesShortest(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	random := func() []string {
+		lines := make([]string, rnd.Intn(12))
+		for i := range lines {
+			lines[i] = string(rune('a' + rnd.Intn(3)))
+		}
+		return lines
+	}
+	for range 500 {
+		a, b := random(), random()
+		edits := Lines(a, b)
+
+		// the edits go through both lists in order
+		i, j, equal := 0, 0, 0
+		for _, e := range edits {
+			switch e.Op {
+			case Equal:
+				assert.Equal(t, [2]int{i, j}, [2]int{e.A, e.B})
+				assert.Equal(t, a[i], b[j])
+				i, j, equal = i+1, j+1, equal+1
+			case Delete:
+				assert.Equal(t, [2]int{i, -1}, [2]int{e.A, e.B})
+				i++
+			case Insert:
+				assert.Equal(t, [2]int{-1, j}, [2]int{e.A, e.B})
+				j++
+			}
+		}
+		assert.Equal(t, [2]int{len(a), len(b)}, [2]int{i, j})
+		assert.Equal(t, lcs(a, b), equal, "%v -> %v", a, b)
+	}
+}
+
+func TestLinesLarge(t *testing.T) {
+	// keeping every round of the search, as many rounds as lines, would
+	// take hundreds of megabytes here
+	a, b := make([]string, 5000), make([]string, 5000)
+	for i := range a {
+		a[i], b[i] = fmt.Sprint("a", i), fmt.Sprint("b", i)
+	}
+	b[2500] = a[2500]
+	edits := Lines(a, b)
+	assert.Len(t, edits, 9999)
+	assert.Equal(t, Edit{Op: Equal, A: 2500, B: 2500}, edits[5000])
+}
+
+func TestSplit(t *testing.T) {
+	assert.Nil(t, Split(""))
+	assert.Equal(t, []string{""}, Split("\n"))
+	assert.Equal(t, []string{"a", "b"}, Split("a\nb\n"))
+	assert.Equal(t, []string{"a", "b"}, Split("a\nb"))
+}
+
+func TestHunks(t *testing.T) {
+	a := strings.Fields("1 2 3 4 5 6 7 8 9 10 11 12 13 14 15 16 17 18 19 20")
+	b := strings.Fields("1 x 3 4 5 6 7 8 9 10 11 12 13 14 15 16 y 18 19 20")
+	hunks := Hunks(Lines(a, b), 3)
+	assert.Len(t, hunks, 2)
+	assert.Equal(t, [4]int{0, 5, 0, 5}, [4]int{hunks[0].AStart, hunks[0].ALines, hunks[0].BStart, hunks[0].BLines})
+	assert.Equal(t, [4]int{13, 7, 13, 7}, [4]int{hunks[1].AStart, hunks[1].ALines, hunks[1].BStart, hunks[1].BLines})
+
+	// six equal lines between two changes keep them together
+	b = strings.Fields("1 x 3 4 5 6 7 8 y 10 11 12 13 14 15 16 17 18 19 20")
+	hunks = Hunks(Lines(a, b), 3)
+	assert.Len(t, hunks, 1)
+	assert.Equal(t, [4]int{0, 12, 0, 12}, [4]int{hunks[0].AStart, hunks[0].ALines, hunks[0].BStart, hunks[0].BLines})
+
+	hunks = Hunks(Lines(nil, []string{"a"}), 3)
+	assert.Equal(t, [4]int{0, 0, 0, 1}, [4]int{hunks[0].AStart, hunks[0].ALines, hunks[0].BStart, hunks[0].BLines})
+	assert.Empty(t, Hunks(Lines(a, a), 3))
+}
+
+// merged writes the chunks of a merge back as lines, conflicts between
+// brackets.
+func merged(chunks []Chunk) string {
+	var lines []string
+	for _, c := range chunks {
+		if c.Conflict {
+			lines = append(lines, "<"+strings.Join(c.Ours, " ")+"|"+strings.Join(c.Theirs, " ")+">")
+		} else {
+			lines = append(lines, c.Lines...)
+		}
+	}
+	return strings.Join(lines, " ")
+}
+
+func TestMerge(t *testing.T) {
+	for _, tc := range []struct {
+		base, ours, theirs, merged string
+	}{
+		{"a b c", "a b c", "a b c", "a b c"},
+		{"a b c", "a x c", "a b c", "a x c"},
+		{"a b c", "a b c", "a b y", "a b y"},
+		{"a b c d e", "x b c d e", "a b c d y", "x b c d y"},
+		{"a b c", "a x c", "a x c", "a x c"},
+		{"a b c", "a c", "a b c z", "a c z"},
+		{"a b c", "a x c", "a y c", "a <x|y> c"},
+		{"a b c", "a x b c", "a y b c", "a <x|y> b c"},
+		{"", "a", "b", "<a|b>"},
+		{"a b c d", "a x y z d", "a x q z d", "a x <y|q> z d"},
+	} {
+		f := strings.Fields
+		assert.Equal(t, tc.merged, merged(Merge(f(tc.base), f(tc.ours), f(tc.theirs))), "%q %q %q", tc.base, tc.ours, tc.theirs)
+	}
+}
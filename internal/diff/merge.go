@@ -0,0 +1,101 @@
+package diff
+
+import "slices"
+
+// Chunk is a part of a three-way merge. A clean chunk has the merged
+// Lines, a conflicting one the Base, Ours and Theirs versions of the
+// lines both sides changed.
+type Chunk struct {
+	Conflict           bool
+	Lines              []string
+	Base, Ours, Theirs []string
+}
+
+// Merge combines the changes ours and theirs made to base, with diff3:
+// the lines of base both sides kept split the files into chunks, the
+// ones between them changed by one side take its version, and by both
+// sides differently are a conflict.
+func Merge(base, ours, theirs []string) []Chunk {
+	matchOurs, matchTheirs := matches(base, ours), matches(base, theirs)
+	var chunks []Chunk
+	lo, la, lb := 0, 0, 0
+	// emit adds the chunk from where the last one ended to o, a and b
+	emit := func(o, a, b int) {
+		chunks = append(chunks, mergeChunk(base[lo:o], ours[la:a], theirs[lb:b])...)
+		lo, la, lb = o, a, b
+	}
+	for {
+		// the lines all three keep from where the last chunk ended
+		i := 0
+		for lo+i < len(base) && la+i < len(ours) && lb+i < len(theirs) &&
+			matchOurs[lo+i] == la+i && matchTheirs[lo+i] == lb+i {
+			i++
+		}
+		if i > 0 {
+			emit(lo+i, la+i, lb+i)
+			continue
+		}
+
+		// then the changes up to the next base line both kept
+		o := lo
+		for o < len(base) && (matchOurs[o] < 0 || matchTheirs[o] < 0) {
+			o++
+		}
+		if o == len(base) {
+			if lo < len(base) || la < len(ours) || lb < len(theirs) {
+				emit(len(base), len(ours), len(theirs))
+			}
+			return chunks
+		}
+		emit(o, matchOurs[o], matchTheirs[o])
+	}
+}
+
+// matches maps every line of a kept in b to its index there, -1 for
+// the lines deleted.
+func matches(a, b []string) []int {
+	m := make([]int, len(a))
+	for i := range m {
+		m[i] = -1
+	}
+	for _, e := range Lines(a, b) {
+		if e.Op == Equal {
+			m[e.A] = e.B
+		}
+	}
+	return m
+}
+
+// mergeChunk resolves the lines between two that all three versions
+// kept. The lines both sides of a conflict start or end with are left
+// out of it, as they would merge cleanly.
+func mergeChunk(base, ours, theirs []string) []Chunk {
+	switch {
+	case slices.Equal(ours, base), slices.Equal(ours, theirs):
+		return []Chunk{{Lines: theirs}}
+	case slices.Equal(theirs, base):
+		return []Chunk{{Lines: ours}}
+	}
+	prefix := 0
+	for prefix < len(ours) && prefix < len(theirs) && ours[prefix] == theirs[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(ours)-prefix && suffix < len(theirs)-prefix && ours[len(ours)-1-suffix] == theirs[len(theirs)-1-suffix] {
+		suffix++
+	}
+	var chunks []Chunk
+	if prefix > 0 {
+		chunks = append(chunks, Chunk{Lines: ours[:prefix]})
+	}
+	chunks = append(chunks, Chunk{
+		Conflict: true,
+		Base:     base,
+		Ours:     ours[prefix : len(ours)-suffix],
+		Theirs:   theirs[prefix : len(theirs)-suffix],
+	})
+	if suffix > 0 {
+		chunks = append(chunks, Chunk{Lines: ours[len(ours)-suffix:]})
+	}
+	return chunks
+}
@@ -0,0 +1,118 @@
+// Package pktline reads and writes the pkt-line framing of git's wire
+// protocol, every packet being prefixed by its length in four hex
+// digits, length included. Lengths 0000, 0001 and 0002 are the flush,
+// delimiter and response end packets of protocol v2.
+package pktline
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Kind tells data packets from the special ones.
+type Kind int
+
+const (
+	Data Kind = iota
+	Flush
+	Delim
+	ResponseEnd
+)
+
+// MaxPayload is the most data a single packet carries.
+const MaxPayload = 65516
+
+var ErrTooLong = errors.New("pkt-line too long")
+
+// Write writes data as a single packet.
+func Write(w io.Writer, data []byte) error {
+	if len(data) > MaxPayload {
+		return fmt.Errorf("%w: %d bytes", ErrTooLong, len(data))
+	}
+	if _, err := fmt.Fprintf(w, "%04x", len(data)+4); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// WriteString writes a packet of the text s, which usually ends with a
+// newline.
+func WriteString(w io.Writer, s string) error {
+	return Write(w, []byte(s))
+}
+
+// WriteSpecial writes a flush, delimiter or response end packet.
+func WriteSpecial(w io.Writer, kind Kind) error {
+	var s string
+	switch kind {
+	case Flush:
+		s = "0000"
+	case Delim:
+		s = "0001"
+	case ResponseEnd:
+		s = "0002"
+	default:
+		return fmt.Errorf("pkt-line kind %d is not special", kind)
+	}
+	_, err := io.WriteString(w, s)
+	return err
+}
+
+type Reader struct {
+	r io.Reader
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r}
+}
+
+// Read returns the next packet, its data is only set for Data packets.
+// The end of the input is io.EOF between packets and
+// io.ErrUnexpectedEOF inside one.
+func (r *Reader) Read() (Kind, []byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r.r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	size, err := strconv.ParseUint(string(header[:]), 16, 16)
+	if err != nil {
+		return 0, nil, fmt.Errorf("bad pkt-line length %q", header[:])
+	}
+	switch size {
+	case 0:
+		return Flush, nil, nil
+	case 1:
+		return Delim, nil, nil
+	case 2:
+		return ResponseEnd, nil, nil
+	case 3:
+		return 0, nil, fmt.Errorf("bad pkt-line length %q", header[:])
+	}
+	if size-4 > MaxPayload {
+		return 0, nil, fmt.Errorf("%w: %d bytes", ErrTooLong, size-4)
+	}
+	data := make([]byte, size-4)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+	return Data, data, nil
+}
+
+// ReadLine is Read for text packets, dropping the trailing newline.
+// Special packets give an empty line.
+func (r *Reader) ReadLine() (Kind, string, error) {
+	kind, data, err := r.Read()
+	if err != nil {
+		return kind, "", err
+	}
+	if n := len(data); n > 0 && data[n-1] == '\n' {
+		data = data[:n-1]
+	}
+	return kind, string(data), nil
+}
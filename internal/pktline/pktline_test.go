@@ -0,0 +1,44 @@
+package pktline
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, WriteString(&buf, "command=ls-refs\n"))
+	assert.NoError(t, WriteSpecial(&buf, Delim))
+	assert.NoError(t, Write(&buf, []byte{1, 'P', 'A', 'C', 'K'}))
+	assert.NoError(t, WriteSpecial(&buf, Flush))
+	assert.Equal(t, "0014command=ls-refs\n00010009\x01PACK0000", buf.String())
+
+	r := NewReader(&buf)
+	kind, line, err := r.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, Data, kind)
+	assert.Equal(t, "command=ls-refs", line)
+	kind, _, err = r.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, Delim, kind)
+	_, data, err := r.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{1, 'P', 'A', 'C', 'K'}, data)
+	kind, _, err = r.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, Flush, kind)
+	_, _, err = r.Read()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestBadPackets(t *testing.T) {
+	for _, in := range []string{"zzzz", "0003", "000ashort", "fff0"} {
+		_, _, err := NewReader(strings.NewReader(in)).Read()
+		assert.Error(t, err, in)
+	}
+	assert.ErrorIs(t, Write(io.Discard, make([]byte, MaxPayload+1)), ErrTooLong)
+}
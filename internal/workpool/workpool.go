@@ -0,0 +1,74 @@
+package workpool
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// Size returns n when it is positive, otherwise the number of
+// goroutines that can run in parallel (GOMAXPROCS).
+func Size(n int) int {
+	if n > 0 {
+		return n
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// Run calls fn for every index in [0, count) using at most `workers`
+// goroutines.
+//
+// When fn fails, no index above the failing one gets started and the
+// error of the lowest failing index is returned, which is the same
+// error a sequential loop would have stopped at.
+func Run(workers, count int, fn func(i int) error) error {
+	return RunContext(context.Background(), workers, count, fn)
+}
+
+// RunContext is Run that stops starting new indexes once ctx is done,
+// the index that would have been next fails with ctx.Err().
+func RunContext(ctx context.Context, workers, count int, fn func(i int) error) error {
+	if count == 0 {
+		return nil
+	}
+	workers = min(Size(workers), count)
+
+	errs := make([]error, count)
+	var firstFailed atomic.Int64
+	firstFailed.Store(int64(count))
+
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(next.Add(1) - 1)
+				if i >= count || int64(i) > firstFailed.Load() {
+					return
+				}
+				err := ctx.Err()
+				if err == nil {
+					err = fn(i)
+				}
+				if err != nil {
+					errs[i] = err
+					for {
+						curr := firstFailed.Load()
+						if int64(i) >= curr || firstFailed.CompareAndSwap(curr, int64(i)) {
+							break
+						}
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if f := int(firstFailed.Load()); f < count {
+		return errs[f]
+	}
+	return nil
+}
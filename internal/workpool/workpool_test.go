@@ -0,0 +1,65 @@
+package workpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunVisitsEveryIndex(t *testing.T) {
+	const count = 1000
+	var visited [count]atomic.Int32
+
+	err := Run(8, count, func(i int) error {
+		visited[i].Add(1)
+		return nil
+	})
+	assert.NoError(t, err)
+	for i := range visited {
+		assert.Equal(t, int32(1), visited[i].Load())
+	}
+}
+
+func TestRunReturnsLowestError(t *testing.T) {
+	err := Run(8, 100, func(i int) error {
+		if i%10 == 7 {
+			return fmt.Errorf("failed %d", i)
+		}
+		return nil
+	})
+	assert.EqualError(t, err, "failed 7")
+}
+
+func TestRunStopsAfterError(t *testing.T) {
+	boom := errors.New("boom")
+	var ran atomic.Int32
+
+	err := Run(1, 100, func(i int) error {
+		ran.Add(1)
+		if i == 3 {
+			return boom
+		}
+		return nil
+	})
+	assert.ErrorIs(t, err, boom)
+	assert.Equal(t, int32(4), ran.Load())
+}
+
+func TestRunContextStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var started atomic.Int32
+
+	err := RunContext(ctx, 1, 100, func(i int) error {
+		started.Add(1)
+		if i == 9 {
+			cancel()
+		}
+		return nil
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, int32(10), started.Load())
+}
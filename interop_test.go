@@ -0,0 +1,111 @@
+package gitgo
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// runGit runs git in dir and returns its trimmed output, the test is
+// skipped when git is not installed.
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_CONFIG_NOSYSTEM=1",
+		"HOME="+dir,
+		"GIT_AUTHOR_NAME=A U Thor",
+		"GIT_AUTHOR_EMAIL=author@example.com",
+		"GIT_COMMITTER_NAME=A U Thor",
+		"GIT_COMMITTER_EMAIL=author@example.com",
+	)
+	out, err := cmd.CombinedOutput()
+	assert.NoError(t, err, "git %s: %s", strings.Join(args, " "), out)
+	return strings.TrimSpace(string(out))
+}
+
+func TestInteropWithGit(t *testing.T) {
+	dir := t.TempDir()
+	runGit(t, dir, "--version")
+
+	repo, err := InitWithGitDir(dir, GitDir)
+	assert.NoError(t, err)
+
+	files := map[string]string{
+		"a.txt":   "a\n",
+		"a/b.txt": "b\n",
+		"a-b":     "c\n",
+		"a/c/d":   "d\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "run.sh"), []byte("echo\n"), 0755))
+	assert.NoError(t, os.Symlink("a.txt", filepath.Join(dir, "link")))
+
+	_, err = repo.Add(".")
+	assert.NoError(t, err)
+	res, err := repo.Commit(CommitOptions{
+		Name:    "A U Thor",
+		Email:   "author@example.com",
+		Message: "first\n",
+		When:    time.Unix(1700000000, 0).In(time.FixedZone("", 3600)),
+	})
+	assert.NoError(t, err)
+
+	runGit(t, dir, "fsck", "--strict", "--no-dangling")
+	assert.Equal(t, res.OID, runGit(t, dir, "rev-parse", "refs/heads/master"))
+	assert.Equal(t, res.Tree, runGit(t, dir, "write-tree"))
+	assert.Equal(t, "", runGit(t, dir, "status", "--porcelain"))
+	assert.Equal(t,
+		"tree "+res.Tree+"\n"+
+			"author A U Thor <author@example.com> 1700000000 +0100\n"+
+			"committer A U Thor <author@example.com> 1700000000 +0100\n\n"+
+			"first",
+		runGit(t, dir, "cat-file", "-p", "HEAD"))
+
+	// and gitgo reads what git writes
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("changed\n"), 0644))
+	runGit(t, dir, "add", "a.txt")
+	runGit(t, dir, "commit", "-q", "-m", "second")
+	opened, err := Open(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, runGit(t, dir, "rev-parse", "HEAD"), RefInitialize(opened.Refs).ReadHead())
+	status, err := opened.Status()
+	assert.NoError(t, err)
+	assert.Empty(t, status.Changed)
+	assert.Empty(t, status.Untracked)
+}
+
+func TestCloneGitRepository(t *testing.T) {
+	tmp := t.TempDir()
+	src := filepath.Join(tmp, "src")
+	assert.NoError(t, os.MkdirAll(filepath.Join(src, "dir"), 0755))
+	runGit(t, src, "init", "-q", "-b", "main")
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "dir", "a.txt"), []byte("a\n"), 0644))
+	runGit(t, src, "add", ".")
+	runGit(t, src, "commit", "-q", "-m", "first")
+	head := runGit(t, src, "rev-parse", "HEAD")
+
+	clone := NewRepositoryWithGitDir(filepath.Join(tmp, "clone"), GitDir)
+	_, err := clone.Clone(context.Background(), src, CloneOptions{})
+	assert.NoError(t, err)
+
+	assert.Equal(t, head, runGit(t, clone.Path, "rev-parse", "HEAD"))
+	assert.Equal(t, "main", runGit(t, clone.Path, "rev-parse", "--abbrev-ref", "HEAD"))
+	assert.Equal(t, head, runGit(t, clone.Path, "rev-parse", "origin/main"))
+	assert.Equal(t, "", runGit(t, clone.Path, "status", "--porcelain"))
+	assert.Equal(t, "", runGit(t, clone.Path, "fsck", "--strict", "--no-dangling"))
+}
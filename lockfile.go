@@ -2,10 +2,13 @@ package gitgo
 
 import (
 	"errors"
-	"log"
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 )
 
 var (
@@ -14,10 +17,61 @@ var (
 	ErrStaleLock     = errors.New("Stale Lock")
 )
 
+// LockOptions control what happens when a lock file is already taken.
+type LockOptions struct {
+	// Timeout is how long to keep retrying a lock held by someone
+	// else, zero gives up right away.
+	Timeout time.Duration
+	// RetryInterval is the wait between two attempts.
+	RetryInterval time.Duration
+	// StaleAge makes any lock older than it stale, zero only counts
+	// locks whose owner died as stale.
+	StaleAge time.Duration
+	// Takeover removes stale locks instead of failing on them.
+	Takeover bool
+	// Warn, when set, is told about every lock taken over.
+	Warn func(msg string)
+}
+
+// defaultLockOptions are used by every lock not given its own
+// options. Locks of crashed gitgo processes on this host are taken
+// over, others are never waited for.
+var defaultLockOptions = LockOptions{
+	RetryInterval: 50 * time.Millisecond,
+	Takeover:      true,
+}
+
+// DefaultLockOptions returns the options of the locks not given their
+// own, to start other options from.
+func DefaultLockOptions() LockOptions { return defaultLockOptions }
+
+// LockOwner is who holds a lock, as recorded next to the lock file.
+type LockOwner struct {
+	PID      int
+	Hostname string
+	Since    time.Time
+}
+
+func (o *LockOwner) String() string {
+	return fmt.Sprintf("pid %d on %s since %s", o.PID, o.Hostname, o.Since.Format(time.RFC3339))
+}
+
+// alive reports whether the owner may still be running. Processes on
+// other hosts cannot be checked so they are always assumed alive.
+func (o *LockOwner) alive() bool {
+	host, _ := os.Hostname()
+	if o.Hostname != host || o.PID <= 0 {
+		return true
+	}
+	err := syscall.Kill(o.PID, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
 type lockFile struct {
 	FilePath string
 	LockPath string
 	Lock     *os.File
+	Options  LockOptions
 	mu       sync.Mutex
 }
 
@@ -26,9 +80,13 @@ func lockInitialize(path string) *lockFile {
 	return &lockFile{
 		FilePath: path,
 		LockPath: lockPath,
+		Options:  defaultLockOptions,
 	}
 }
 
+func (l *lockFile) ownerPath() string    { return l.LockPath + ".owner" }
+func (l *lockFile) takeoverPath() string { return l.LockPath + ".takeover" }
+
 func (l *lockFile) holdForUpdate() (bool, error) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
@@ -37,70 +95,211 @@ func (l *lockFile) holdForUpdate() (bool, error) {
 		return true, nil // lock already aquired
 	}
 
+	deadline := time.Now().Add(l.Options.Timeout)
+	for {
+		err := l.tryLock()
+		if !errors.Is(err, ErrLockDenied) {
+			return err == nil, err
+		}
+
+		owner, _ := readLockOwner(l.ownerPath())
+		if l.Options.Takeover && l.isStale(owner) {
+			if l.takeover(owner) {
+				continue
+			}
+		}
+
+		if !time.Now().Before(deadline) {
+			var lockErr *LockError
+			if errors.As(err, &lockErr) {
+				lockErr.Owner = owner
+			}
+			return false, err
+		}
+		time.Sleep(max(l.Options.RetryInterval, time.Millisecond))
+	}
+}
+
+func (l *lockFile) tryLock() error {
 	file, err := os.OpenFile(l.LockPath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
 	if err != nil {
 		var pathErr *os.PathError
 		if errors.As(err, &pathErr) {
 			switch pathErr.Err {
 			case syscall.EEXIST:
-				return false, ErrLockDenied
+				return &LockError{Path: l.LockPath, Err: ErrLockDenied}
 			case syscall.ENOENT:
-				return false, ErrMissingParent
+				return &LockError{Path: l.LockPath, Err: ErrMissingParent}
 			case syscall.EACCES:
-				return false, ErrNoPermission
+				return &LockError{Path: l.LockPath, Err: ErrNoPermission}
 			}
 		}
-		return false, err
+		return err
 	}
 
 	l.Lock = file
-	return true, nil
+	l.writeOwner()
+	return nil
 }
 
-func (l *lockFile) write(data []byte) {
+// writeOwner records who holds the lock, it is only a hint for
+// others so failing to write it is not an error.
+func (l *lockFile) writeOwner() {
+	host, _ := os.Hostname()
+	content := fmt.Sprintf("%d %s %d\n", os.Getpid(), host, time.Now().Unix())
+	os.WriteFile(l.ownerPath(), []byte(content), 0644)
+}
+
+func readLockOwner(path string) (*LockOwner, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("bad lock owner %q", data)
+	}
+	pid, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return nil, err
+	}
+	since, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &LockOwner{PID: pid, Hostname: fields[1], Since: time.Unix(since, 0)}, nil
+}
+
+// isStale tells if the lock currently on disk was left behind, either
+// because its owner is gone or because it is older than StaleAge.
+func (l *lockFile) isStale(owner *LockOwner) bool {
+	if owner != nil && !owner.alive() {
+		return true
+	}
+	if l.Options.StaleAge <= 0 {
+		return false
+	}
+	stat, err := os.Stat(l.LockPath)
+	if err != nil {
+		return false
+	}
+	return time.Since(stat.ModTime()) > l.Options.StaleAge
+}
+
+// takeover removes a stale lock. Takeovers are serialized through
+// another lock file, and staleness is checked again once holding it,
+// so that two processes cannot both remove the lock and one of them
+// end up removing the fresh lock of the other.
+func (l *lockFile) takeover(owner *LockOwner) bool {
+	tf, err := os.OpenFile(l.takeoverPath(), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		// a takeover that crashed midway should not block forever
+		if stat, err := os.Stat(l.takeoverPath()); err == nil && time.Since(stat.ModTime()) > 10*time.Second {
+			os.Remove(l.takeoverPath())
+		}
+		return false
+	}
+	defer os.Remove(l.takeoverPath())
+	defer tf.Close()
+
+	current, _ := readLockOwner(l.ownerPath())
+	if !sameOwner(owner, current) || !l.isStale(current) {
+		return false
+	}
+	if err := os.Remove(l.LockPath); err != nil && !os.IsNotExist(err) {
+		return false
+	}
+	os.Remove(l.ownerPath())
+
+	if l.Options.Warn != nil {
+		if owner != nil {
+			l.Options.Warn(fmt.Sprintf("removed stale lock '%s' held by %s", l.LockPath, owner))
+		} else {
+			l.Options.Warn(fmt.Sprintf("removed stale lock '%s'", l.LockPath))
+		}
+	}
+	return true
+}
+
+func sameOwner(a, b *LockOwner) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// ForceUnlock removes the lock on path whoever holds it.
+func ForceUnlock(path string) error {
+	l := lockInitialize(path)
+	os.Remove(l.ownerPath())
+	err := os.Remove(l.LockPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (l *lockFile) write(data []byte) error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	l.errOnStaleLock()
+	if err := l.errOnStaleLock(); err != nil {
+		return err
+	}
 	_, err := l.Lock.Write(data)
 	if err != nil {
-		log.Fatalf("Write error: %s\n", err)
+		return fmt.Errorf("writing %s: %w", l.LockPath, err)
 	}
+	return nil
 }
 
-func (l *lockFile) commit() {
+func (l *lockFile) commit() error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	l.errOnStaleLock()
+	if err := l.errOnStaleLock(); err != nil {
+		return err
+	}
 
 	err := l.Lock.Close()
+	l.Lock = nil
 	if err != nil {
-		log.Fatalf("Err closing file: %s\n", err)
+		l.remove()
+		return fmt.Errorf("closing %s: %w", l.LockPath, err)
 	}
 
+	os.Remove(l.ownerPath())
 	err = os.Rename(l.LockPath, l.FilePath)
 	if err != nil {
-		log.Fatalf("Err renaming file: %s\n", err)
+		os.Remove(l.LockPath)
+		return fmt.Errorf("renaming %s: %w", l.LockPath, err)
 	}
-
-	l.Lock = nil
+	return nil
 }
 
+// rollback gives the lock up without touching the file, it does
+// nothing when the lock is not held so that it never removes a lock
+// taken by someone else.
 func (l *lockFile) rollback() error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	err := os.Remove(l.LockPath)
-	if err != nil {
-		return err
+	if l.Lock == nil {
+		return nil
 	}
+	l.Lock.Close()
 	l.Lock = nil
-	return nil
+	return l.remove()
+}
+
+func (l *lockFile) remove() error {
+	os.Remove(l.ownerPath())
+	return os.Remove(l.LockPath)
 }
 
-func (l *lockFile) errOnStaleLock() {
+func (l *lockFile) errOnStaleLock() error {
 	if l.Lock == nil {
-		log.Fatalf("Err: %s\nNot holding lock on file: %s", ErrStaleLock, l.LockPath)
+		return &LockError{Path: l.LockPath, Err: ErrStaleLock}
 	}
+	return nil
 }
@@ -0,0 +1,124 @@
+package gitgo
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// deadPID returns the pid of a process that already exited.
+func deadPID(t *testing.T) int {
+	c := exec.Command("true")
+	assert.NoError(t, c.Run())
+	return c.Process.Pid
+}
+
+func writeLockOwner(t *testing.T, lockPath string, pid int, since time.Time) {
+	host, _ := os.Hostname()
+	content := fmt.Sprintf("%d %s %d\n", pid, host, since.Unix())
+	assert.NoError(t, os.WriteFile(lockPath, nil, 0644))
+	assert.NoError(t, os.WriteFile(lockPath+".owner", []byte(content), 0644))
+}
+
+func TestLockTakesOverDeadOwner(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	writeLockOwner(t, path+".lock", deadPID(t), time.Now())
+
+	var warnings []string
+	l := lockInitialize(path)
+	l.Options.Warn = func(msg string) { warnings = append(warnings, msg) }
+
+	ok, err := l.holdForUpdate()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Len(t, warnings, 1)
+	assert.Contains(t, warnings[0], "removed stale lock")
+
+	owner, err := readLockOwner(l.ownerPath())
+	assert.NoError(t, err)
+	assert.Equal(t, os.Getpid(), owner.PID)
+
+	assert.NoError(t, l.rollback())
+	_, err = os.Stat(l.ownerPath())
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestLockKeepsLiveOwner(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	writeLockOwner(t, path+".lock", os.Getpid(), time.Now())
+
+	l := lockInitialize(path)
+	_, err := l.holdForUpdate()
+	assert.ErrorIs(t, err, ErrLockDenied)
+	var lockErr *LockError
+	assert.ErrorAs(t, err, &lockErr)
+	assert.NotNil(t, lockErr.Owner)
+	assert.Equal(t, os.Getpid(), lockErr.Owner.PID)
+
+	// without takeover even a dead owner keeps its lock
+	writeLockOwner(t, path+".lock", deadPID(t), time.Now())
+	l.Options.Takeover = false
+	_, err = l.holdForUpdate()
+	assert.ErrorIs(t, err, ErrLockDenied)
+}
+
+func TestLockTakesOverOldLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	assert.NoError(t, os.WriteFile(path+".lock", nil, 0644))
+	old := time.Now().Add(-time.Hour)
+	assert.NoError(t, os.Chtimes(path+".lock", old, old))
+
+	l := lockInitialize(path)
+	_, err := l.holdForUpdate()
+	assert.ErrorIs(t, err, ErrLockDenied)
+
+	l.Options.StaleAge = time.Minute
+	ok, err := l.holdForUpdate()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.NoError(t, l.rollback())
+}
+
+func TestLockWaitsForRelease(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	first := lockInitialize(path)
+	_, err := first.holdForUpdate()
+	assert.NoError(t, err)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		first.rollback()
+	}()
+
+	second := lockInitialize(path)
+	second.Options.Timeout = 5 * time.Second
+	second.Options.RetryInterval = 10 * time.Millisecond
+	ok, err := second.holdForUpdate()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.NoError(t, second.write([]byte("data")))
+	assert.NoError(t, second.commit())
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "data", string(data))
+}
+
+func TestForceUnlock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	writeLockOwner(t, path+".lock", os.Getpid(), time.Now())
+
+	assert.NoError(t, ForceUnlock(path))
+	assert.NoError(t, ForceUnlock(path))
+
+	l := lockInitialize(path)
+	ok, err := l.holdForUpdate()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.NoError(t, l.rollback())
+}
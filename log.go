@@ -0,0 +1,110 @@
+package gitgo
+
+import "errors"
+
+// ErrStopWalk makes Log stop without failing.
+var ErrStopWalk = errors.New("stop walk")
+
+// Log calls fn for HEAD and its first parents, newest first, until fn
+// returns an error or the root commit is reached. ErrStopWalk ends the
+// walk without an error.
+func (r Repository) Log(fn func(oid string, commit *Commit) error) error {
+	database := r.ObjectDatabase()
+	oid := RefInitialize(r.Refs).ReadHead()
+	for oid != "" {
+		commit, err := database.ReadCommit(oid)
+		if err != nil {
+			return err
+		}
+		if err := fn(oid, commit); err != nil {
+			if errors.Is(err, ErrStopWalk) {
+				return nil
+			}
+			return err
+		}
+		oid = commit.Parent()
+	}
+	return nil
+}
+
+// LogFile calls fn like Log for the commits that changed the file
+// path, compared to their first parent. With renames detected by opts,
+// the file is followed under the name it had before a commit renamed
+// or copied it.
+func (r Repository) LogFile(path string, opts RenameOptions, fn func(oid string, commit *Commit) error) error {
+	path, err := r.relPath(path)
+	if err != nil {
+		return err
+	}
+	database := r.ObjectDatabase()
+	oid := RefInitialize(r.Refs).ReadHead()
+	for oid != "" {
+		commit, err := database.ReadCommit(oid)
+		if err != nil {
+			return err
+		}
+		changed, from, err := fileChange(database, commit, path, opts)
+		if err != nil {
+			return err
+		}
+		if changed {
+			if err := fn(oid, commit); err != nil {
+				if errors.Is(err, ErrStopWalk) {
+					return nil
+				}
+				return err
+			}
+		}
+		oid, path = commit.Parent(), from
+	}
+	return nil
+}
+
+// fileChange tells if the commit changed the file path from its first
+// parent, and the name of the file in the parent.
+func fileChange(db *Database, commit *Commit, path string, opts RenameOptions) (changed bool, from string, err error) {
+	entry, err := treeEntryAt(db, commit.Tree, path)
+	if err != nil {
+		return false, "", err
+	}
+	if commit.Parent() == "" {
+		return entry != nil, "", nil
+	}
+	parent, err := db.ReadCommit(commit.Parent())
+	if err != nil {
+		return false, "", err
+	}
+	old, err := treeEntryAt(db, parent.Tree, path)
+	if err != nil {
+		return false, "", err
+	}
+	switch {
+	case old != nil:
+		return entry == nil || *old != *entry, path, nil
+	case entry == nil:
+		return false, path, nil
+	case !opts.Renames && !opts.Copies:
+		return true, path, nil
+	}
+
+	oldFiles, err := db.ListTree(parent.Tree)
+	if err != nil {
+		return false, "", err
+	}
+	files, err := db.ListTree(commit.Tree)
+	if err != nil {
+		return false, "", err
+	}
+	changes, err := detectRenames(diffFiles(oldFiles, files), opts, func(e TreeEntry) ([]byte, error) {
+		return db.readTyped(e.OID, TypeFile)
+	})
+	if err != nil {
+		return false, "", err
+	}
+	for _, c := range changes {
+		if c.To.Name == path && (c.Type == Renamed || c.Type == Copied) {
+			return true, c.From.Name, nil
+		}
+	}
+	return true, path, nil
+}
@@ -0,0 +1,368 @@
+package gitgo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/Vikuuu/gitgo/internal/diff"
+)
+
+// ErrLocalChanges is returned when a merge would overwrite changes
+// that are not committed.
+var ErrLocalChanges = errors.New("your local changes would be overwritten")
+
+// mergeConflict is a file both sides of a merge changed in ways that
+// could not be combined. Base, Ours and Theirs are its versions, nil
+// where it is missing. The workspace gets content with mode: the file
+// with conflict markers, or the version of the side that kept it.
+type mergeConflict struct {
+	Path               string
+	Base, Ours, Theirs *TreeEntry
+	content            []byte
+	mode               uint32
+}
+
+// mergeLabels name the sides of a merge in conflict markers.
+type mergeLabels struct {
+	ours, theirs string
+}
+
+// mergeFiles merges the changes ours and theirs made to base, lists of
+// files sorted by name. It returns the files merged cleanly, sorted,
+// and the conflicts, which are left out of them.
+func mergeFiles(db *Database, base, ours, theirs []TreeEntry, labels mergeLabels) ([]TreeEntry, []mergeConflict, error) {
+	versions := make(map[string]*[3]*TreeEntry)
+	for n, files := range [][]TreeEntry{base, ours, theirs} {
+		for i := range files {
+			v := versions[files[i].Name]
+			if v == nil {
+				v = new([3]*TreeEntry)
+				versions[files[i].Name] = v
+			}
+			v[n] = &files[i]
+		}
+	}
+
+	var merged []TreeEntry
+	var conflicts []mergeConflict
+	for _, path := range slices.Sorted(maps.Keys(versions)) {
+		b, o, t := versions[path][0], versions[path][1], versions[path][2]
+		switch {
+		case sameFile(o, t), sameFile(b, t):
+			if o != nil {
+				merged = append(merged, *o)
+			}
+			continue
+		case sameFile(b, o):
+			if t != nil {
+				merged = append(merged, *t)
+			}
+			continue
+		}
+		file, conflict, err := mergeFile(db, path, b, o, t, labels)
+		if err != nil {
+			return nil, nil, err
+		}
+		if conflict != nil {
+			conflicts = append(conflicts, *conflict)
+		} else {
+			merged = append(merged, *file)
+		}
+	}
+	return merged, conflicts, nil
+}
+
+func sameFile(a, b *TreeEntry) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.OID == b.OID && a.Mode == b.Mode
+}
+
+// mergeFile merges a file both sides changed, line by line when both
+// kept it as a text file, taking the mode either side changed.
+func mergeFile(db *Database, path string, b, o, t *TreeEntry, labels mergeLabels) (*TreeEntry, *mergeConflict, error) {
+	conflict := &mergeConflict{Path: path, Base: b, Ours: o, Theirs: t}
+	kept := o
+	if kept == nil {
+		kept = t
+	}
+	if o == nil || t == nil || !mergeable(o.Mode) || !mergeable(t.Mode) {
+		conflict.mode = kept.Mode
+		if kept.Mode != gitlinkMode {
+			data, err := db.readTyped(kept.OID, TypeFile)
+			if err != nil {
+				return nil, nil, err
+			}
+			conflict.content = data
+		}
+		return nil, conflict, nil
+	}
+
+	var contents [3][]byte
+	for n, file := range [3]*TreeEntry{b, o, t} {
+		// a base that was no file merges as an empty one
+		if file == nil || !mergeable(file.Mode) {
+			continue
+		}
+		data, err := db.readTyped(file.OID, TypeFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		contents[n] = data
+	}
+	mode, modeClean := o.Mode, o.Mode == t.Mode
+	if b != nil && !modeClean {
+		if b.Mode == o.Mode {
+			mode, modeClean = t.Mode, true
+		} else if b.Mode == t.Mode {
+			modeClean = true
+		}
+	}
+	if isBinary(contents[1]) || isBinary(contents[2]) {
+		conflict.content, conflict.mode = contents[1], mode
+		return nil, conflict, nil
+	}
+
+	data, clean := mergeText(contents[0], contents[1], contents[2], labels)
+	if !clean || !modeClean {
+		conflict.content, conflict.mode = data, mode
+		return nil, conflict, nil
+	}
+	db.Data(TypeFile, data)
+	oid, err := db.Store()
+	if err != nil {
+		return nil, nil, err
+	}
+	return &TreeEntry{Name: path, Mode: mode, OID: oid}, nil, nil
+}
+
+// mergeable tells the files whose content merges line by line.
+func mergeable(mode uint32) bool {
+	return mode == regularMode || mode == executableMode
+}
+
+// mergeText merges the lines of three versions of a file, conflicts
+// between git's markers. It reports if there were none.
+func mergeText(base, ours, theirs []byte, labels mergeLabels) ([]byte, bool) {
+	var out bytes.Buffer
+	clean := true
+	writeLines := func(lines []string) {
+		for _, line := range lines {
+			out.WriteString(line)
+			if !strings.HasSuffix(line, "\n") {
+				out.WriteByte('\n')
+			}
+		}
+	}
+	for _, c := range diff.Merge(mergeLines(base), mergeLines(ours), mergeLines(theirs)) {
+		if !c.Conflict {
+			out.WriteString(strings.Join(c.Lines, ""))
+			continue
+		}
+		clean = false
+		out.WriteString("<<<<<<< " + labels.ours + "\n")
+		writeLines(c.Ours)
+		out.WriteString("=======\n")
+		writeLines(c.Theirs)
+		out.WriteString(">>>>>>> " + labels.theirs + "\n")
+	}
+	return out.Bytes(), clean
+}
+
+// mergeLines splits data into lines that keep their "\n", so that a
+// last line without one stays so.
+func mergeLines(data []byte) []string {
+	lines := strings.SplitAfter(string(data), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// applyMerge takes the index and the workspace from the files ours, as
+// HEAD has them, to the result of a merge, the conflicts becoming
+// unmerged entries. It fails with ErrLocalChanges, before changing
+// anything, when the index differs from HEAD or a file the merge
+// changes differs from the index or is untracked.
+func (r Repository) applyMerge(ctx context.Context, ours, merged []TreeEntry, conflicts []mergeConflict) error {
+	conflicted := make(map[string]bool)
+	for _, c := range conflicts {
+		conflicted[c.Path] = true
+	}
+	var changes []FileChange
+	touched := maps.Clone(conflicted)
+	for _, c := range diffFiles(ours, merged) {
+		if !conflicted[c.Path()] {
+			changes = append(changes, c)
+			touched[c.Path()] = true
+		}
+	}
+
+	status, err := r.StatusContext(ctx)
+	if err != nil {
+		return err
+	}
+	tracked := trackedPaths(ours)
+	var dirty []string
+	for _, entry := range status.Changed {
+		if entry.Index != Unmodified || touched[entry.Path] && entry.Workspace != Unmodified {
+			dirty = append(dirty, entry.Path)
+		}
+	}
+	for _, path := range slices.Sorted(maps.Keys(touched)) {
+		if _, err := os.Lstat(filepath.Join(r.Path, path)); err == nil && !tracked[path] {
+			dirty = append(dirty, path)
+		}
+	}
+	if len(dirty) > 0 {
+		return fmt.Errorf("%w: %s", ErrLocalChanges, strings.Join(dirty, ", "))
+	}
+
+	db := r.ObjectDatabase()
+	_, index, err := r.holdIndex()
+	if err != nil {
+		return err
+	}
+	// deletions go first, to clear the way for a file that replaces a
+	// directory or a directory that replaces a file
+	for _, c := range changes {
+		if c.Type == Deleted {
+			removeWorkspaceFile(r.Path, c.Path())
+			index.remove(c.Path())
+		}
+	}
+	for _, c := range changes {
+		if c.Type == Deleted {
+			continue
+		}
+		stat, err := checkoutFile(db, r.Path, c.To.Name, c.To.OID, c.To.Mode)
+		if err != nil {
+			index.Release()
+			return fmt.Errorf("checkout %s: %w", c.To.Name, err)
+		}
+		entry := NewIndexEntry(c.To.Name, c.To.OID, stat)
+		entry.Mode = c.To.Mode
+		index.add(entry)
+	}
+	for _, c := range conflicts {
+		if err := writeWorkspaceFile(filepath.Join(r.Path, c.Path), c.content, c.mode); err != nil {
+			index.Release()
+			return fmt.Errorf("write %s: %w", c.Path, err)
+		}
+		index.addUnmerged(c.Path, [3]*TreeEntry{c.Base, c.Ours, c.Theirs})
+	}
+	_, err = index.WriteUpdate()
+	return err
+}
+
+// trackedPaths gives the names of files and of the directories that
+// hold them, so that a directory becoming a file is not taken for an
+// untracked file in the way.
+func trackedPaths(files []TreeEntry) map[string]bool {
+	tracked := make(map[string]bool)
+	for _, file := range files {
+		for name := file.Name; name != "."; name = filepath.Dir(name) {
+			tracked[name] = true
+		}
+	}
+	return tracked
+}
+
+// writeWorkspaceFile replaces the file at path with data, as a
+// symbolic link, an executable or a regular file depending on mode.
+func writeWorkspaceFile(path string, data []byte, mode uint32) error {
+	if mode == gitlinkMode {
+		return os.MkdirAll(path, 0755)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if mode == symlinkMode {
+		return os.Symlink(string(data), path)
+	}
+	perm := os.FileMode(0644)
+	if mode == executableMode {
+		perm = 0755
+	}
+	return os.WriteFile(path, data, perm)
+}
+
+// resetMerge takes the index and the workspace to the files of commit
+// oid, like `git reset --merge`: the files that differ between HEAD
+// and oid, and those the index changed or has conflicts on, are
+// checked out. Other changes in the workspace are kept.
+func (r Repository) resetMerge(ctx context.Context, oid string) error {
+	head, err := r.commitFiles("HEAD")
+	if err != nil {
+		return err
+	}
+	target, err := r.commitFiles(oid)
+	if err != nil {
+		return err
+	}
+	_, index, err := r.holdIndex()
+	if err != nil {
+		return err
+	}
+
+	paths := make(map[string]bool)
+	for _, c := range diffFiles(head, target) {
+		paths[c.Path()] = true
+	}
+	headFiles := make(map[string]TreeEntry)
+	for _, file := range head {
+		headFiles[file.Name] = file
+	}
+	for name, entry := range index.IndexEntries() {
+		if file, ok := headFiles[name]; !ok || file.OID != entry.Oid || file.Mode != entry.Mode {
+			paths[name] = true
+		}
+	}
+	for name := range headFiles {
+		if _, ok := index.IndexEntries()[name]; !ok {
+			paths[name] = true
+		}
+	}
+	for _, name := range index.Unmerged() {
+		paths[name] = true
+	}
+
+	db := r.ObjectDatabase()
+	targetFiles := make(map[string]TreeEntry)
+	for _, file := range target {
+		targetFiles[file.Name] = file
+	}
+	for _, name := range slices.Sorted(maps.Keys(paths)) {
+		if err := ctx.Err(); err != nil {
+			index.Release()
+			return err
+		}
+		file, ok := targetFiles[name]
+		if !ok {
+			removeWorkspaceFile(r.Path, name)
+			index.remove(name)
+			continue
+		}
+		stat, err := checkoutFile(db, r.Path, name, file.OID, file.Mode)
+		if err != nil {
+			index.Release()
+			return fmt.Errorf("checkout %s: %w", name, err)
+		}
+		entry := NewIndexEntry(name, file.OID, stat)
+		entry.Mode = file.Mode
+		index.add(entry)
+	}
+	_, err = index.WriteUpdate()
+	return err
+}
@@ -0,0 +1,51 @@
+package gitgo
+
+import (
+	"cmp"
+	"slices"
+)
+
+// MergeBases returns the best common ancestors of the commits a and b,
+// those no other common ancestor descends from, newest first. A commit
+// is its own ancestor. In a shallow repository the history ends at the
+// shallow commits, so bases below them are not found.
+func (r Repository) MergeBases(a, b string) ([]string, error) {
+	db := r.ObjectDatabase()
+	fromA := make(map[string]bool)
+	if err := walkCommits(db, []string{a}, fromA, nil); err != nil {
+		return nil, err
+	}
+	var common []string
+	fromB := make(map[string]bool)
+	err := walkCommits(db, []string{b}, fromB, func(oid string, _ *Commit) {
+		if fromA[oid] {
+			common = append(common, oid)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// a common ancestor in the history of another is not a best one
+	var parents []string
+	times := make(map[string]int64, len(common))
+	for _, oid := range common {
+		commit, err := db.ReadCommit(oid)
+		if err != nil {
+			return nil, err
+		}
+		parents = append(parents, commit.Parents...)
+		if sig, err := ParseSignature(commit.Committer); err == nil {
+			times[oid] = sig.When.Unix()
+		}
+	}
+	below := make(map[string]bool)
+	if err := walkCommits(db, parents, below, nil); err != nil {
+		return nil, err
+	}
+	bases := slices.DeleteFunc(common, func(oid string) bool { return below[oid] })
+	slices.SortFunc(bases, func(x, y string) int {
+		return cmp.Or(cmp.Compare(times[y], times[x]), cmp.Compare(x, y))
+	})
+	return bases, nil
+}
@@ -0,0 +1,192 @@
+package gitgo
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const treeEntryMode uint32 = 040000
+
+type TreeEntry struct {
+	Name string
+	Mode uint32
+	OID  string
+}
+
+func (e TreeEntry) IsTree() bool { return e.Mode == treeEntryMode }
+
+// ParseTree decodes the content of a tree object, a list of
+// "<octal mode> <name>\0<20 byte oid>" records.
+func ParseTree(data []byte) ([]TreeEntry, error) {
+	var entries []TreeEntry
+	seen := make(map[string]bool)
+	for len(data) > 0 {
+		sp := bytes.IndexByte(data, ' ')
+		if sp <= 0 {
+			return nil, fmt.Errorf("%w: tree entry without mode", ErrCorruptObject)
+		}
+		mode, err := strconv.ParseUint(string(data[:sp]), 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad tree entry mode %q", ErrCorruptObject, data[:sp])
+		}
+		data = data[sp+1:]
+
+		nul := bytes.IndexByte(data, 0)
+		if nul <= 0 {
+			return nil, fmt.Errorf("%w: tree entry without name", ErrCorruptObject)
+		}
+		name := string(data[:nul])
+		data = data[nul+1:]
+		if !validTreeName(name) {
+			return nil, fmt.Errorf("%w: bad tree entry name %q", ErrCorruptObject, name)
+		}
+		// a second entry could check out through the first, say a
+		// directory under a symbolic link of the same name
+		if seen[name] {
+			return nil, fmt.Errorf("%w: duplicate tree entry %q", ErrCorruptObject, name)
+		}
+		seen[name] = true
+
+		if len(data) < 20 {
+			return nil, fmt.Errorf("%w: tree entry %q truncated", ErrCorruptObject, name)
+		}
+		entries = append(entries, TreeEntry{
+			Name: name,
+			Mode: uint32(mode),
+			OID:  hex.EncodeToString(data[:20]),
+		})
+		data = data[20:]
+	}
+	return entries, nil
+}
+
+// validTreeName tells if name can be a tree entry. The name ends up in
+// a workspace path, it must be one component that neither leads out of
+// the tree's directory nor into the metadata directory of a repository.
+func validTreeName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.Contains(name, "/") &&
+		!strings.EqualFold(name, GitDir) && !strings.EqualFold(name, GitgoDir)
+}
+
+type Commit struct {
+	Tree      string
+	Parents   []string
+	Author    string
+	Committer string
+	Message   string
+}
+
+// Parent returns the first parent, empty for a root commit.
+func (c *Commit) Parent() string {
+	if len(c.Parents) == 0 {
+		return ""
+	}
+	return c.Parents[0]
+}
+
+func ParseCommit(data []byte) (*Commit, error) {
+	headers, message, found := strings.Cut(string(data), "\n\n")
+	if !found {
+		// a commit with no message at all
+		headers = strings.TrimSuffix(headers, "\n")
+	}
+
+	c := &Commit{Message: message}
+	for _, line := range strings.Split(headers, "\n") {
+		if strings.HasPrefix(line, " ") {
+			// continuation of a multi-line header, like gpgsig
+			continue
+		}
+		key, value, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("%w: bad commit header %q", ErrCorruptObject, line)
+		}
+		switch key {
+		case "tree":
+			c.Tree = value
+		case "parent":
+			c.Parents = append(c.Parents, value)
+		case "author":
+			c.Author = value
+		// older gitgo versions misspelled the committer header
+		case "committer", "comitter":
+			c.Committer = value
+		}
+	}
+	if validOID(c.Tree) != nil {
+		return nil, fmt.Errorf("%w: commit without a valid tree", ErrCorruptObject)
+	}
+	for _, p := range c.Parents {
+		if validOID(p) != nil {
+			return nil, fmt.Errorf("%w: bad parent %q", ErrCorruptObject, p)
+		}
+	}
+	return c, nil
+}
+
+func (d *Database) readTyped(oid string, want BlobType) ([]byte, error) {
+	typ, data, err := d.ReadObject(oid)
+	if err != nil {
+		return nil, err
+	}
+	if typ != want {
+		return nil, fmt.Errorf("object %s is a %s, not a %s", oid, typ, want)
+	}
+	return data, nil
+}
+
+func (d *Database) ReadCommit(oid string) (*Commit, error) {
+	data, err := d.readTyped(oid, TypeCommit)
+	if err != nil {
+		return nil, err
+	}
+	commit, err := ParseCommit(data)
+	if err == nil && d.shallow[oid] {
+		commit.Parents = nil
+	}
+	return commit, err
+}
+
+func (d *Database) ReadTree(oid string) ([]TreeEntry, error) {
+	data, err := d.readTyped(oid, TypeTree)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTree(data)
+}
+
+// ListTree returns every entry that is not a tree below the tree oid,
+// named by their full path and sorted by it.
+func (d *Database) ListTree(oid string) ([]TreeEntry, error) {
+	var files []TreeEntry
+	if err := d.listTree(oid, "", &files); err != nil {
+		return nil, err
+	}
+	slices.SortFunc(files, func(a, b TreeEntry) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return files, nil
+}
+
+func (d *Database) listTree(oid, prefix string, files *[]TreeEntry) error {
+	entries, err := d.ReadTree(oid)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		e.Name = path.Join(prefix, e.Name)
+		if e.IsTree() {
+			if err := d.listTree(e.OID, e.Name, files); err != nil {
+				return err
+			}
+			continue
+		}
+		*files = append(*files, e)
+	}
+	return nil
+}
@@ -0,0 +1,407 @@
+package gitgo
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+var ErrCorruptPack = errors.New("corrupt pack")
+
+// Object types as numbered in pack files.
+const (
+	packCommit   = 1
+	packTree     = 2
+	packBlob     = 3
+	packTag      = 4
+	packOfsDelta = 6
+	packRefDelta = 7
+)
+
+var packObjectTypes = map[int]BlobType{
+	packCommit: TypeCommit,
+	packTree:   TypeTree,
+	packBlob:   TypeFile,
+}
+
+var packTypeOf = map[BlobType]int{
+	TypeCommit: packCommit,
+	TypeTree:   packTree,
+	TypeFile:   packBlob,
+}
+
+// packReader counts and hashes the bytes of a pack as they are read.
+// It reads them one at a time from the buffer below when asked to,
+// so that zlib never takes more than the object it inflates.
+type packReader struct {
+	r      *bufio.Reader
+	h      hash.Hash
+	offset int64
+}
+
+func (p *packReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.h.Write(b[:n])
+	p.offset += int64(n)
+	return n, err
+}
+
+func (p *packReader) ReadByte() (byte, error) {
+	c, err := p.r.ReadByte()
+	if err == nil {
+		p.h.Write([]byte{c})
+		p.offset++
+	}
+	return c, err
+}
+
+// pendingDelta is a delta whose base was not stored yet.
+type pendingDelta struct {
+	offset  int64
+	base    string
+	baseOff int64
+	data    []byte
+}
+
+// unpackLimits caps what unpackObjects takes from a pack whose sender
+// is not trusted, a zero field setting no limit.
+type unpackLimits struct {
+	// objects is the most objects the pack may hold.
+	objects int64
+	// objectSize is the most bytes any of them may have once inflated
+	// or rebuilt from its delta.
+	objectSize int64
+}
+
+// unpackObjects stores in db every object of the pack read from r,
+// resolving deltas against the objects of the pack or, for thin packs,
+// those db already has. It returns the number of objects in the pack.
+func unpackObjects(ctx context.Context, r io.Reader, db *Database, progress Progress) (int, error) {
+	return unpackObjectsLimited(ctx, r, db, progress, unpackLimits{})
+}
+
+// unpackObjectsLimited is unpackObjects refusing a pack that goes over
+// limits.
+func unpackObjectsLimited(ctx context.Context, r io.Reader, db *Database, progress Progress, limits unpackLimits) (int, error) {
+	p := &packReader{r: bufio.NewReader(r), h: sha1.New()}
+	var header [12]byte
+	if _, err := io.ReadFull(p, header[:]); err != nil {
+		return 0, fmt.Errorf("%w: reading header: %w", ErrCorruptPack, err)
+	}
+	if string(header[:4]) != "PACK" {
+		return 0, fmt.Errorf("%w: bad signature %q", ErrCorruptPack, header[:4])
+	}
+	if v := binary.BigEndian.Uint32(header[4:8]); v != 2 && v != 3 {
+		return 0, fmt.Errorf("%w: unsupported version %d", ErrCorruptPack, v)
+	}
+	count := int(binary.BigEndian.Uint32(header[8:12]))
+	if limits.objects > 0 && int64(count) > limits.objects {
+		return 0, fmt.Errorf("%w: %d objects, more than the limit of %d", ErrCorruptPack, count, limits.objects)
+	}
+
+	received := newProgressCounter(progress, "Receiving objects", count)
+	// the count is the sender's word, it only hints at the size
+	oids := make(map[int64]string, min(count, 1024))
+	var deltas []pendingDelta
+	for range count {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		offset := p.offset
+		typ, size, err := readPackObjectHeader(p)
+		if err != nil {
+			return 0, err
+		}
+		if limits.objectSize > 0 && size > limits.objectSize {
+			return 0, fmt.Errorf("%w: object of %d bytes, more than the limit of %d", ErrCorruptPack, size, limits.objectSize)
+		}
+
+		delta := pendingDelta{offset: offset}
+		switch typ {
+		case packOfsDelta:
+			back, err := readOfsDeltaOffset(p)
+			if err != nil {
+				return 0, err
+			}
+			if back <= 0 || back > offset {
+				return 0, fmt.Errorf("%w: delta base offset out of the pack", ErrCorruptPack)
+			}
+			delta.baseOff = offset - back
+		case packRefDelta:
+			var base [20]byte
+			if _, err := io.ReadFull(p, base[:]); err != nil {
+				return 0, fmt.Errorf("%w: %w", ErrCorruptPack, err)
+			}
+			delta.base = hex.EncodeToString(base[:])
+		case packTag:
+			return 0, fmt.Errorf("%w: tag objects are not supported", ErrCorruptPack)
+		case packCommit, packTree, packBlob:
+		default:
+			return 0, fmt.Errorf("%w: unknown object type %d", ErrCorruptPack, typ)
+		}
+
+		data, err := inflatePackObject(p, size)
+		if err != nil {
+			return 0, err
+		}
+		if typ == packOfsDelta || typ == packRefDelta {
+			delta.data = data
+			deltas = append(deltas, delta)
+		} else {
+			db.Data(packObjectTypes[typ], data)
+			oid, err := db.StoreContext(ctx)
+			if err != nil {
+				return 0, err
+			}
+			oids[offset] = oid
+		}
+		received.add()
+	}
+
+	var checksum [20]byte
+	sum := p.h.Sum(nil)
+	if _, err := io.ReadFull(p.r, checksum[:]); err != nil {
+		return 0, fmt.Errorf("%w: reading checksum: %w", ErrCorruptPack, err)
+	}
+	if !bytes.Equal(sum, checksum[:]) {
+		return 0, fmt.Errorf("%w: checksum mismatch", ErrCorruptPack)
+	}
+
+	if err := resolveDeltas(ctx, db, deltas, oids, limits.objectSize, progress); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// resolveDeltas applies the deltas whose base is known, over and over
+// as every round may store the base of others. No delta may make an
+// object of more than maxSize bytes, unless it is zero.
+func resolveDeltas(ctx context.Context, db *Database, deltas []pendingDelta, oids map[int64]string, maxSize int64, progress Progress) error {
+	resolving := newProgressCounter(progress, "Resolving deltas", len(deltas))
+	for len(deltas) > 0 {
+		var left []pendingDelta
+		for _, d := range deltas {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			base := d.base
+			if base == "" {
+				base = oids[d.baseOff]
+			}
+			if size := deltaSize(d.data); maxSize > 0 && size > uint64(maxSize) {
+				return fmt.Errorf("%w: delta of %d bytes, more than the limit of %d", ErrCorruptPack, size, maxSize)
+			}
+			ok := false
+			if base != "" {
+				var err error
+				if ok, err = db.Has(base); err != nil {
+					return err
+				}
+			}
+			if !ok {
+				left = append(left, d)
+				continue
+			}
+
+			typ, baseData, err := db.ReadObject(base)
+			if err != nil {
+				return err
+			}
+			data, err := applyDelta(baseData, d.data)
+			if err != nil {
+				return err
+			}
+			db.Data(typ, data)
+			oid, err := db.StoreContext(ctx)
+			if err != nil {
+				return err
+			}
+			oids[d.offset] = oid
+			resolving.add()
+		}
+		if len(left) == len(deltas) {
+			return fmt.Errorf("%w: %d deltas without a base", ErrCorruptPack, len(left))
+		}
+		deltas = left
+	}
+	return nil
+}
+
+// readPackObjectHeader reads the type and inflated size that start
+// every object, 3 bits of type and a size in little endian groups of
+// 7 bits.
+func readPackObjectHeader(r io.ByteReader) (int, int64, error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w: %w", ErrCorruptPack, err)
+	}
+	typ := int(c>>4) & 7
+	size := int64(c & 0x0f)
+	for shift := 4; c&0x80 != 0; shift += 7 {
+		if shift > 56 {
+			return 0, 0, fmt.Errorf("%w: object size overflow", ErrCorruptPack)
+		}
+		if c, err = r.ReadByte(); err != nil {
+			return 0, 0, fmt.Errorf("%w: %w", ErrCorruptPack, err)
+		}
+		size |= int64(c&0x7f) << shift
+	}
+	return typ, size, nil
+}
+
+// readOfsDeltaOffset reads how far back the base of an offset delta
+// starts, in the same encoding as the index v4 path varint.
+func readOfsDeltaOffset(r io.ByteReader) (int64, error) {
+	var raw []byte
+	off, err := readOffsetVarint(r, &raw)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrCorruptPack, err)
+	}
+	return int64(off), nil
+}
+
+func inflatePackObject(p *packReader, size int64) ([]byte, error) {
+	zr, err := zlib.NewReader(p)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCorruptPack, err)
+	}
+	data := bytes.NewBuffer(make([]byte, 0, min(size, 1<<20)))
+	if _, err := io.Copy(data, io.LimitReader(zr, size+1)); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCorruptPack, err)
+	}
+	if int64(data.Len()) != size {
+		return nil, fmt.Errorf("%w: object of %d bytes inflated to %d", ErrCorruptPack, size, data.Len())
+	}
+	// reading up to the end checks the zlib checksum
+	if _, err := zr.Read(make([]byte, 1)); err != io.EOF {
+		return nil, fmt.Errorf("%w: bad zlib stream", ErrCorruptPack)
+	}
+	return data.Bytes(), zr.Close()
+}
+
+// deltaSize returns the size of the object delta makes, which follows
+// that of its base.
+func deltaSize(delta []byte) uint64 {
+	r := bytes.NewReader(delta)
+	binary.ReadUvarint(r)
+	size, _ := binary.ReadUvarint(r)
+	return size
+}
+
+// applyDelta rebuilds an object from its base and a delta, which is
+// the two sizes followed by instructions either copying a range of the
+// base or inserting the bytes that follow them.
+func applyDelta(base, delta []byte) ([]byte, error) {
+	r := bytes.NewReader(delta)
+	baseSize, err := binary.ReadUvarint(r)
+	if err != nil || baseSize != uint64(len(base)) {
+		return nil, fmt.Errorf("%w: delta does not fit its base", ErrCorruptPack)
+	}
+	size, err := binary.ReadUvarint(r)
+	if err != nil || size > 1<<32 {
+		return nil, fmt.Errorf("%w: bad delta size", ErrCorruptPack)
+	}
+
+	out := make([]byte, 0, min(size, 1<<20))
+	for r.Len() > 0 {
+		if uint64(len(out)) > size {
+			return nil, fmt.Errorf("%w: delta made more than %d bytes", ErrCorruptPack, size)
+		}
+		op, _ := r.ReadByte()
+		if op&0x80 == 0 {
+			if op == 0 {
+				return nil, fmt.Errorf("%w: reserved delta instruction", ErrCorruptPack)
+			}
+			n := int(op)
+			if n > r.Len() {
+				return nil, fmt.Errorf("%w: delta truncated", ErrCorruptPack)
+			}
+			start := len(delta) - r.Len()
+			out = append(out, delta[start:start+n]...)
+			r.Seek(int64(n), io.SeekCurrent)
+			continue
+		}
+
+		// the low 4 bits tell which offset bytes follow, the next 3
+		// which size bytes do
+		var offset, length uint64
+		for i := range 7 {
+			if op&(1<<i) == 0 {
+				continue
+			}
+			b, err := r.ReadByte()
+			if err != nil {
+				return nil, fmt.Errorf("%w: delta truncated", ErrCorruptPack)
+			}
+			if i < 4 {
+				offset |= uint64(b) << (8 * i)
+			} else {
+				length |= uint64(b) << (8 * (i - 4))
+			}
+		}
+		if length == 0 {
+			length = 0x10000
+		}
+		if offset+length > uint64(len(base)) {
+			return nil, fmt.Errorf("%w: delta copies past its base", ErrCorruptPack)
+		}
+		out = append(out, base[offset:offset+length]...)
+	}
+	if uint64(len(out)) != size {
+		return nil, fmt.Errorf("%w: delta made %d bytes instead of %d", ErrCorruptPack, len(out), size)
+	}
+	return out, nil
+}
+
+// writePack writes to w a pack of the objects oids of db, each stored
+// whole rather than as a delta.
+func writePack(ctx context.Context, w io.Writer, db *Database, oids []string, progress Progress) error {
+	h := sha1.New()
+	out := io.MultiWriter(w, h)
+	var header [12]byte
+	copy(header[:], "PACK")
+	binary.BigEndian.PutUint32(header[4:], 2)
+	binary.BigEndian.PutUint32(header[8:], uint32(len(oids)))
+	if _, err := out.Write(header[:]); err != nil {
+		return err
+	}
+
+	writing := newProgressCounter(progress, "Writing objects", len(oids))
+	var buf bytes.Buffer
+	for _, oid := range oids {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		typ, data, err := db.ReadObject(oid)
+		if err != nil {
+			return err
+		}
+		buf.Reset()
+		size := len(data)
+		c := byte(packTypeOf[typ]<<4) | byte(size&0x0f)
+		for size >>= 4; size > 0; size >>= 7 {
+			buf.WriteByte(c | 0x80)
+			c = byte(size & 0x7f)
+		}
+		buf.WriteByte(c)
+		zw := zlib.NewWriter(&buf)
+		zw.Write(data)
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		if _, err := out.Write(buf.Bytes()); err != nil {
+			return err
+		}
+		writing.add()
+	}
+	_, err := w.Write(h.Sum(nil))
+	return err
+}
@@ -0,0 +1,145 @@
+package gitgo
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testPack builds a pack one object at a time.
+type testPack struct {
+	buf   bytes.Buffer
+	count int
+}
+
+func (p *testPack) add(typ int, prefix, data []byte) int64 {
+	offset := int64(p.buf.Len()) + 12
+	size := len(data)
+	c := byte(typ<<4) | byte(size&0x0f)
+	for size >>= 4; size > 0; size >>= 7 {
+		p.buf.WriteByte(c | 0x80)
+		c = byte(size & 0x7f)
+	}
+	p.buf.WriteByte(c)
+	p.buf.Write(prefix)
+	zw := zlib.NewWriter(&p.buf)
+	zw.Write(data)
+	zw.Close()
+	p.count++
+	return offset
+}
+
+// ofsDelta adds a delta against the object at base.
+func (p *testPack) ofsDelta(base int64, delta []byte) int64 {
+	back := uint64(int64(p.buf.Len())+12) - uint64(base)
+	enc := []byte{byte(back & 0x7f)}
+	for back >>= 7; back > 0; back >>= 7 {
+		back--
+		enc = append([]byte{byte(0x80 | back&0x7f)}, enc...)
+	}
+	return p.add(packOfsDelta, enc, delta)
+}
+
+func (p *testPack) refDelta(base string, delta []byte) int64 {
+	raw, _ := hex.DecodeString(base)
+	return p.add(packRefDelta, raw, delta)
+}
+
+func (p *testPack) bytes() []byte {
+	var out bytes.Buffer
+	out.WriteString("PACK")
+	binary.Write(&out, binary.BigEndian, uint32(2))
+	binary.Write(&out, binary.BigEndian, uint32(p.count))
+	out.Write(p.buf.Bytes())
+	sum := sha1.Sum(out.Bytes())
+	out.Write(sum[:])
+	return out.Bytes()
+}
+
+func blobOID(data string) string {
+	db := NewDatabaseWithStore(NewMemoryStore())
+	db.Data(TypeFile, []byte(data))
+	oid, _ := db.Store()
+	return oid
+}
+
+func TestApplyDelta(t *testing.T) {
+	base := []byte("hello world\n")
+	// copy "hello ", insert "gitgo ", copy "world\n"
+	delta := []byte{12, 18, 0x90, 6, 6, 'g', 'i', 't', 'g', 'o', ' ', 0x91, 6, 6}
+	out, err := applyDelta(base, delta)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello gitgo world\n", string(out))
+
+	for name, bad := range map[string][]byte{
+		"base size":     {11, 6, 0x90, 6},
+		"copy past end": {12, 6, 0x91, 8, 6},
+		"truncated":     {12, 6, 0x04, 'a'},
+		"reserved":      {12, 1, 0x00},
+		"size":          {12, 5, 0x90, 6},
+	} {
+		_, err := applyDelta(base, bad)
+		assert.ErrorIs(t, err, ErrCorruptPack, name)
+	}
+}
+
+func TestUnpackObjects(t *testing.T) {
+	base := "hello world\n"
+	first := "hello gitgo world\n"
+	second := first + "!"
+
+	p := &testPack{}
+	at := p.add(packBlob, nil, []byte(base))
+	// the ref delta comes first, its base is only known once the ofs
+	// delta after it is resolved
+	p.refDelta(blobOID(first), []byte{18, 19, 0x90, 18, 1, '!'})
+	p.ofsDelta(at, []byte{12, 18, 0x90, 6, 6, 'g', 'i', 't', 'g', 'o', ' ', 0x91, 6, 6})
+
+	db := NewDatabaseWithStore(NewMemoryStore())
+	n, err := unpackObjects(context.Background(), bytes.NewReader(p.bytes()), db, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	for _, data := range []string{base, first, second} {
+		typ, got, err := db.ReadObject(blobOID(data))
+		assert.NoError(t, err)
+		assert.Equal(t, TypeFile, typ)
+		assert.Equal(t, data, string(got))
+	}
+
+	// a thin pack's base is taken from the database
+	thin := &testPack{}
+	thin.refDelta(blobOID(second), []byte{19, 20, 0x90, 19, 1, '?'})
+	_, err = unpackObjects(context.Background(), bytes.NewReader(thin.bytes()), db, nil)
+	assert.NoError(t, err)
+	has, err := db.Has(blobOID(second + "?"))
+	assert.NoError(t, err)
+	assert.True(t, has)
+}
+
+func TestUnpackObjectsCorrupt(t *testing.T) {
+	p := &testPack{}
+	p.add(packBlob, nil, []byte("data"))
+	good := p.bytes()
+
+	badSum := bytes.Clone(good)
+	badSum[len(badSum)-1] ^= 0xff
+	missing := &testPack{}
+	missing.refDelta(blobOID("nowhere"), []byte{7, 1, 0x01, 'x'})
+
+	for name, pack := range map[string][]byte{
+		"signature": append([]byte("KCAP"), good[4:]...),
+		"checksum":  badSum,
+		"truncated": good[:len(good)-25],
+		"no base":   missing.bytes(),
+	} {
+		db := NewDatabaseWithStore(NewMemoryStore())
+		_, err := unpackObjects(context.Background(), bytes.NewReader(pack), db, nil)
+		assert.ErrorIs(t, err, ErrCorruptPack, name)
+	}
+}
@@ -0,0 +1,43 @@
+package gitgo
+
+import "sync"
+
+// Progress is told how far a long running operation got. total is 0
+// when it is not known up front. Calls for one operation are never
+// made concurrently, even when the work is done in parallel.
+type Progress interface {
+	Progress(op string, done, total int)
+}
+
+// ProgressFunc lets a plain function be used as a Progress.
+type ProgressFunc func(op string, done, total int)
+
+func (f ProgressFunc) Progress(op string, done, total int) { f(op, done, total) }
+
+// progressCounter reports the progress of op as workers finish their
+// items. A nil Progress makes it a no-op.
+type progressCounter struct {
+	mu    sync.Mutex
+	p     Progress
+	op    string
+	done  int
+	total int
+}
+
+func newProgressCounter(p Progress, op string, total int) *progressCounter {
+	c := &progressCounter{p: p, op: op, total: total}
+	if p != nil {
+		p.Progress(op, 0, total)
+	}
+	return c
+}
+
+func (c *progressCounter) add() {
+	if c.p == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.done++
+	c.p.Progress(c.op, c.done, c.total)
+}
@@ -0,0 +1,879 @@
+package gitgo
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	ErrRebaseRunning = errors.New("a rebase is already in progress")
+	ErrNoRebase      = errors.New("no rebase in progress")
+)
+
+// RebaseOptions are the settings of a rebase.
+type RebaseOptions struct {
+	// Name, Email and When are the committer of the new commits and
+	// the identity of the reflog entries, When being the current time
+	// when zero.
+	Name, Email string
+	When        time.Time
+	// Interactive lets EditTodo change the list of commits to replay
+	// before any is.
+	Interactive bool
+	// Autosquash moves the commits whose subject is "fixup! " or
+	// "squash! " followed by the subject of another right after it, as
+	// a fixup or a squash of it.
+	Autosquash bool
+	// EditTodo changes the todo list in the file at path, EditMessage
+	// the message of a reworded or squashed commit. The files are kept
+	// as they are when nil.
+	EditTodo    func(path string) error
+	EditMessage func(path string) error
+	// Exec runs the command of an exec line in the workspace.
+	Exec func(command string) error
+}
+
+// RebaseResult is where a rebase ended: done with HEAD at Head, back
+// on the branch Branch unless HEAD was detached, or Stopped at the
+// commit an edit line asked to amend. UpToDate tells that there was
+// nothing to replay.
+type RebaseResult struct {
+	Head     string
+	Branch   string
+	UpToDate bool
+	Stopped  string
+}
+
+// RebaseExecError stops a rebase at an exec line whose Command failed.
+// ContinueRebase goes on with the line after it.
+type RebaseExecError struct {
+	Command string
+	Err     error
+}
+
+func (e *RebaseExecError) Error() string {
+	return fmt.Sprintf("execution failed: %s: %v", e.Command, e.Err)
+}
+
+func (e *RebaseExecError) Unwrap() error { return e.Err }
+
+// rebaseStep is a line of the todo list of a rebase, an action on the
+// commit oid or, for exec, a command to run.
+type rebaseStep struct {
+	action  string
+	oid     string
+	command string
+}
+
+// rebaseActions maps the actions of a todo list, in full or short, to
+// their name.
+var rebaseActions = map[string]string{
+	"p": "pick", "pick": "pick",
+	"r": "reword", "reword": "reword",
+	"e": "edit", "edit": "edit",
+	"s": "squash", "squash": "squash",
+	"f": "fixup", "fixup": "fixup",
+	"d": "drop", "drop": "drop",
+	"x": "exec", "exec": "exec",
+}
+
+const rebaseTodoHelp = `
+# Commands:
+# p, pick <commit> = use commit
+# r, reword <commit> = use commit, but edit the commit message
+# e, edit <commit> = use commit, but stop for amending
+# s, squash <commit> = use commit, but meld into previous commit
+# f, fixup <commit> = like "squash", but discard this commit's log message
+# x, exec <command> = run command (the rest of the line) using shell
+# d, drop <commit> = remove commit
+#
+# These lines can be re-ordered; they are executed from top to bottom.
+# If you remove everything, the rebase will be aborted.
+`
+
+// Rebase replays the commits of HEAD that upstream does not have on
+// top of it, oldest first and leaving merges out, then moves the
+// branch HEAD is on to the last one. A conflict stops it with a
+// *PickError until ContinueRebase, SkipRebase or AbortRebase. Every
+// commit HEAD moves to goes in its reflog. The state is kept in
+// rebase-merge in the git directory, like git keeps it.
+func (r Repository) Rebase(ctx context.Context, upstream string, opts RebaseOptions) (*RebaseResult, error) {
+	if _, err := os.Stat(r.rebaseDir()); err == nil {
+		return nil, ErrRebaseRunning
+	}
+	refs := RefInitialize(r.Refs)
+	head := refs.ReadHead()
+	if head == "" {
+		return nil, errors.New("cannot rebase an unborn branch")
+	}
+	branch, err := refs.HeadRef()
+	if err != nil {
+		return nil, err
+	}
+	onto, err := r.ResolveRevision(upstream)
+	if err != nil {
+		return nil, err
+	}
+	status, err := r.StatusContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(status.Changed) > 0 {
+		var paths []string
+		for _, entry := range status.Changed {
+			paths = append(paths, entry.Path)
+		}
+		return nil, fmt.Errorf("%w: %s", ErrLocalChanges, strings.Join(paths, ", "))
+	}
+
+	commits, err := r.rebaseCommits(head, onto)
+	if err != nil {
+		return nil, err
+	}
+	todo := make([]rebaseStep, len(commits))
+	for i, oid := range commits {
+		todo[i] = rebaseStep{action: "pick", oid: oid}
+	}
+	if opts.Autosquash {
+		if todo, err = r.autosquash(todo); err != nil {
+			return nil, err
+		}
+	}
+	if !opts.Interactive {
+		upToDate, err := r.replaysNothing(onto, head, todo)
+		if err != nil || upToDate {
+			return &RebaseResult{Head: head, Branch: branch, UpToDate: true}, err
+		}
+	}
+
+	if err := os.MkdirAll(r.rebaseDir(), 0755); err != nil {
+		return nil, err
+	}
+	// nothing is left behind by a rebase that could not start
+	started := false
+	defer func() {
+		if !started {
+			os.RemoveAll(r.rebaseDir())
+		}
+	}()
+	if opts.Interactive {
+		list, err := r.formatRebaseTodo(todo, true)
+		if err != nil {
+			return nil, err
+		}
+		list += fmt.Sprintf("\n# Rebase %s..%s onto %s (%d commands)\n#", onto[:7], head[:7], onto[:7], len(todo))
+		if err := os.WriteFile(r.rebasePath("git-rebase-todo"), []byte(list+rebaseTodoHelp), 0644); err != nil {
+			return nil, err
+		}
+		if opts.EditTodo != nil {
+			if err := opts.EditTodo(r.rebasePath("git-rebase-todo")); err != nil {
+				return nil, err
+			}
+		}
+		if todo, err = r.readRebaseTodo("git-rebase-todo"); err != nil {
+			return nil, err
+		}
+		if len(todo) == 0 {
+			return nil, errors.New("nothing to do")
+		}
+	}
+	for _, step := range todo {
+		if step.action == "squash" || step.action == "fixup" {
+			return nil, fmt.Errorf("cannot '%s' without a previous commit", step.action)
+		}
+		if step.action != "exec" && step.action != "drop" {
+			break
+		}
+	}
+
+	// the picks on top of onto already are kept as they are
+	start := onto
+	for len(todo) > 0 && todo[0].action == "pick" {
+		commit, err := r.ObjectDatabase().ReadCommit(todo[0].oid)
+		if err != nil {
+			return nil, err
+		}
+		if commit.Parent() != start {
+			break
+		}
+		start, todo = todo[0].oid, todo[1:]
+	}
+
+	headName := cmp.Or(branch, "detached HEAD")
+	for name, value := range map[string]string{"head-name": headName, "onto": onto, "orig-head": head} {
+		if err := os.WriteFile(r.rebasePath(name), []byte(value+"\n"), 0644); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Interactive {
+		if err := os.WriteFile(r.rebasePath("interactive"), nil, 0644); err != nil {
+			return nil, err
+		}
+	}
+	if err := r.writeRebaseTodo(todo); err != nil {
+		return nil, err
+	}
+	if err := r.resetMerge(ctx, start); err != nil {
+		return nil, err
+	}
+	if err := refs.DetachHead(start); err != nil {
+		return nil, err
+	}
+	started = true
+	if err := refs.AppendReflog("HEAD", head, start, rebaseIdent(opts), "rebase (start): checkout "+upstream); err != nil {
+		return nil, err
+	}
+	return r.runRebase(ctx, opts)
+}
+
+// ContinueRebase commits the resolution of the conflicts the rebase
+// stopped at or, after an edit line, amends the commit with the
+// changes in the index, then replays the commits left.
+func (r Repository) ContinueRebase(ctx context.Context, opts RebaseOptions) (*RebaseResult, error) {
+	if _, err := os.Stat(r.rebaseDir()); err != nil {
+		return nil, ErrNoRebase
+	}
+	status, err := r.StatusContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	staged := false
+	for _, entry := range status.Changed {
+		if entry.Index == Unmerged {
+			return nil, ErrUnmergedFiles
+		}
+		if entry.Index != Unmodified {
+			staged = true
+		}
+	}
+
+	db := r.ObjectDatabase()
+	refs := RefInitialize(r.Refs)
+	stopped, err := r.readRebaseState(r.rebaseHeadPath())
+	if err != nil {
+		return nil, err
+	}
+	if stopped != "" {
+		done, err := r.readRebaseTodo("done")
+		if err != nil {
+			return nil, err
+		}
+		if len(done) == 0 {
+			return nil, fmt.Errorf("%w: the rebase stopped at no commit", ErrCorruptObject)
+		}
+		step := done[len(done)-1]
+		commit, err := db.ReadCommit(stopped)
+		if err != nil {
+			return nil, err
+		}
+		_, author, err := r.rebaseMessage(step, commit)
+		if err != nil {
+			return nil, err
+		}
+		message, err := os.ReadFile(r.rebasePath("message"))
+		if err != nil {
+			return nil, err
+		}
+		// a resolution committed already leaves nothing staged
+		if staged || step.action == "squash" || step.action == "fixup" {
+			if err := r.commitRebaseStep(ctx, step, string(message), author, "continue", opts); err != nil {
+				return nil, err
+			}
+		}
+		if err := r.removeRebaseHead(); err != nil {
+			return nil, err
+		}
+	}
+
+	amend, err := r.readRebaseState(r.rebasePath("amend"))
+	if err != nil {
+		return nil, err
+	}
+	if head := refs.ReadHead(); amend != "" && amend == head && staged {
+		commit, err := db.ReadCommit(head)
+		if err != nil {
+			return nil, err
+		}
+		author, err := ParseSignature(commit.Author)
+		if err != nil {
+			return nil, err
+		}
+		res, err := r.CommitContext(ctx, CommitOptions{
+			Name:    opts.Name,
+			Email:   opts.Email,
+			When:    opts.When,
+			Message: commit.Message,
+			Author:  &author,
+			Amend:   true,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := refs.AppendReflog("HEAD", head, res.OID, rebaseIdent(opts), "rebase (continue): "+FirstLine(commit.Message)); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.Remove(r.rebasePath("amend")); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return r.runRebase(ctx, opts)
+}
+
+// SkipRebase drops the commit the rebase stopped at, along with the
+// changes it made to the index and the workspace, and replays the
+// commits left.
+func (r Repository) SkipRebase(ctx context.Context, opts RebaseOptions) (*RebaseResult, error) {
+	if _, err := os.Stat(r.rebaseDir()); err != nil {
+		return nil, ErrNoRebase
+	}
+	if _, err := os.Stat(r.rebaseHeadPath()); err == nil {
+		if err := r.resetMerge(ctx, "HEAD"); err != nil {
+			return nil, err
+		}
+		if err := r.removeRebaseHead(); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.Remove(r.rebasePath("amend")); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return r.runRebase(ctx, opts)
+}
+
+// AbortRebase gives up the rebase in progress, taking HEAD, the index
+// and the workspace back to where it started.
+func (r Repository) AbortRebase(ctx context.Context, opts RebaseOptions) error {
+	if _, err := os.Stat(r.rebaseDir()); err != nil {
+		return ErrNoRebase
+	}
+	origHead, err := r.readRebaseState(r.rebasePath("orig-head"))
+	if err != nil {
+		return err
+	}
+	headName, err := r.readRebaseState(r.rebasePath("head-name"))
+	if err != nil {
+		return err
+	}
+	if err := r.resetMerge(ctx, origHead); err != nil {
+		return err
+	}
+	refs := RefInitialize(r.Refs)
+	head := refs.ReadHead()
+	if strings.HasPrefix(headName, "refs/") {
+		err = refs.SetHeadRef(headName)
+	} else {
+		headName = origHead
+		err = refs.DetachHead(origHead)
+	}
+	if err != nil {
+		return err
+	}
+	if err := refs.AppendReflog("HEAD", head, origHead, rebaseIdent(opts), "rebase (abort): returning to "+headName); err != nil {
+		return err
+	}
+	if err := r.removeRebaseHead(); err != nil {
+		return err
+	}
+	return os.RemoveAll(r.rebaseDir())
+}
+
+func (r Repository) rebaseDir() string {
+	return filepath.Join(r.GitPath, "rebase-merge")
+}
+
+func (r Repository) rebasePath(name string) string {
+	return filepath.Join(r.rebaseDir(), name)
+}
+
+// rebaseHeadPath is the file naming the commit a conflict stopped the
+// rebase at.
+func (r Repository) rebaseHeadPath() string {
+	return filepath.Join(r.GitPath, "REBASE_HEAD")
+}
+
+// readRebaseState returns the line held by the file at path, empty
+// when there is none.
+func (r Repository) readRebaseState(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	return strings.TrimSpace(string(data)), err
+}
+
+// rebaseIdent is who the reflog entries of a rebase are by.
+func rebaseIdent(opts RebaseOptions) string {
+	when := opts.When
+	if when.IsZero() {
+		when = time.Now()
+	}
+	return AuthorData(opts.Name, opts.Email, when)
+}
+
+// rebaseCommits lists the commits of head that upstream does not have,
+// parents before children, leaving merges out.
+func (r Repository) rebaseCommits(head, upstream string) ([]string, error) {
+	db := r.ObjectDatabase()
+	seen := make(map[string]bool)
+	if err := walkCommits(db, []string{upstream}, seen, nil); err != nil {
+		return nil, err
+	}
+	var commits []string
+	var visit func(oid string) error
+	visit = func(oid string) error {
+		if seen[oid] {
+			return nil
+		}
+		seen[oid] = true
+		commit, err := db.ReadCommit(oid)
+		if err != nil {
+			return err
+		}
+		for _, parent := range commit.Parents {
+			if err := visit(parent); err != nil {
+				return err
+			}
+		}
+		if len(commit.Parents) <= 1 {
+			commits = append(commits, oid)
+		}
+		return nil
+	}
+	return commits, visit(head)
+}
+
+// replaysNothing tells if picking todo onto upstream gives head back,
+// every commit already being on top of the one before.
+func (r Repository) replaysNothing(upstream, head string, todo []rebaseStep) (bool, error) {
+	db := r.ObjectDatabase()
+	prev := upstream
+	for _, step := range todo {
+		commit, err := db.ReadCommit(step.oid)
+		if err != nil {
+			return false, err
+		}
+		if step.action != "pick" || commit.Parent() != prev {
+			return false, nil
+		}
+		prev = step.oid
+	}
+	return prev == head, nil
+}
+
+// autosquash moves the commits of todo whose subject is "fixup! " or
+// "squash! " followed by the subject of an earlier one, or the start of
+// its oid, right after it as a fixup or a squash.
+func (r Repository) autosquash(todo []rebaseStep) ([]rebaseStep, error) {
+	db := r.ObjectDatabase()
+	subjects := make([]string, len(todo))
+	for i, step := range todo {
+		commit, err := db.ReadCommit(step.oid)
+		if err != nil {
+			return nil, err
+		}
+		subjects[i] = FirstLine(commit.Message)
+	}
+
+	moved := make(map[int]bool)
+	fixups := make(map[int][]rebaseStep)
+	for i, subject := range subjects {
+		action := ""
+		for {
+			if rest, ok := strings.CutPrefix(subject, "fixup! "); ok {
+				action, subject = cmp.Or(action, "fixup"), rest
+			} else if rest, ok := strings.CutPrefix(subject, "squash! "); ok {
+				action, subject = cmp.Or(action, "squash"), rest
+			} else {
+				break
+			}
+		}
+		if action == "" {
+			continue
+		}
+		for j := range i {
+			if !moved[j] && (subjects[j] == subject || len(subject) >= 4 && strings.HasPrefix(todo[j].oid, subject)) {
+				moved[i] = true
+				fixups[j] = append(fixups[j], rebaseStep{action: action, oid: todo[i].oid})
+				break
+			}
+		}
+	}
+
+	var sorted []rebaseStep
+	for i, step := range todo {
+		if !moved[i] {
+			sorted = append(sorted, step)
+			sorted = append(sorted, fixups[i]...)
+		}
+	}
+	return sorted, nil
+}
+
+// runRebase carries out the todo list line by line, until it is empty
+// and the rebase finished, or a line stops it.
+func (r Repository) runRebase(ctx context.Context, opts RebaseOptions) (*RebaseResult, error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		todo, err := r.readRebaseTodo("git-rebase-todo")
+		if err != nil {
+			return nil, err
+		}
+		if len(todo) == 0 {
+			return r.finishRebase(opts)
+		}
+
+		// a line is done once it starts, so a stop leaves it last there
+		step := todo[0]
+		if err := r.writeRebaseTodo(todo[1:]); err != nil {
+			return nil, err
+		}
+		line, err := r.formatRebaseTodo(todo[:1], false)
+		if err != nil {
+			return nil, err
+		}
+		done, err := os.OpenFile(r.rebasePath("done"), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		_, err = done.WriteString(line)
+		if cerr := done.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch step.action {
+		case "drop":
+		case "exec":
+			if opts.Exec == nil {
+				return nil, &RebaseExecError{Command: step.command, Err: errors.New("commands cannot be run")}
+			}
+			if err := opts.Exec(step.command); err != nil {
+				return nil, &RebaseExecError{Command: step.command, Err: err}
+			}
+		default:
+			if err := r.rebasePick(ctx, step, opts); err != nil {
+				return nil, err
+			}
+			if step.action == "edit" {
+				head := RefInitialize(r.Refs).ReadHead()
+				if err := os.WriteFile(r.rebasePath("amend"), []byte(head+"\n"), 0644); err != nil {
+					return nil, err
+				}
+				return &RebaseResult{Head: head, Stopped: step.oid}, nil
+			}
+		}
+	}
+}
+
+// rebasePick replays the commit of step on HEAD. A commit already on
+// top of HEAD is kept as it is, a squash or a fixup amends HEAD. A
+// conflict leaves the commit in REBASE_HEAD and the message of the
+// commit to make in the message file of the rebase, and returns a
+// *PickError.
+func (r Repository) rebasePick(ctx context.Context, step rebaseStep, opts RebaseOptions) error {
+	db := r.ObjectDatabase()
+	refs := RefInitialize(r.Refs)
+	commit, err := db.ReadCommit(step.oid)
+	if err != nil {
+		return err
+	}
+	if len(commit.Parents) > 1 {
+		return fmt.Errorf("commit %s is a merge, which cannot be picked", step.oid)
+	}
+	subject := FirstLine(commit.Message)
+	head := refs.ReadHead()
+	if commit.Parent() == head && (step.action == "pick" || step.action == "edit") {
+		if err := r.resetMerge(ctx, step.oid); err != nil {
+			return err
+		}
+		if err := refs.DetachHead(step.oid); err != nil {
+			return err
+		}
+		return refs.AppendReflog("HEAD", head, step.oid, rebaseIdent(opts), "rebase ("+step.action+"): "+subject)
+	}
+
+	files, err := db.ListTree(commit.Tree)
+	if err != nil {
+		return err
+	}
+	var parent []TreeEntry
+	if commit.Parent() != "" {
+		if parent, err = r.commitFiles(commit.Parent()); err != nil {
+			return err
+		}
+	}
+	ours, err := r.commitFiles("HEAD")
+	if err != nil {
+		return err
+	}
+	labels := mergeLabels{ours: "HEAD", theirs: step.oid[:7] + " (" + subject + ")"}
+	merged, conflicts, err := mergeFiles(db, parent, ours, files, labels)
+	if err != nil {
+		return err
+	}
+	if err := r.applyMerge(ctx, ours, merged, conflicts); err != nil {
+		return err
+	}
+	message, author, err := r.rebaseMessage(step, commit)
+	if err != nil {
+		return err
+	}
+
+	if len(conflicts) > 0 {
+		if err := os.WriteFile(r.rebaseHeadPath(), []byte(step.oid+"\n"), 0644); err != nil {
+			return err
+		}
+		if err := os.WriteFile(r.rebasePath("message"), []byte(message), 0644); err != nil {
+			return err
+		}
+		perr := &PickError{Commit: step.oid, Subject: subject}
+		for _, c := range conflicts {
+			perr.Paths = append(perr.Paths, c.Path)
+		}
+		return perr
+	}
+	// the changes of a commit upstream has already are dropped with it
+	if len(diffFiles(ours, merged)) == 0 && step.action != "squash" && step.action != "fixup" {
+		return nil
+	}
+	return r.commitRebaseStep(ctx, step, message, author, step.action, opts)
+}
+
+// rebaseMessage is the message and the author of the commit made by a
+// step: those of the commit it picks, or for a squash or a fixup the
+// author of HEAD with the two messages combined, or the one of HEAD.
+func (r Repository) rebaseMessage(step rebaseStep, commit *Commit) (string, *Signature, error) {
+	if step.action != "squash" && step.action != "fixup" {
+		author, err := ParseSignature(commit.Author)
+		return commit.Message, &author, err
+	}
+	head, err := r.ObjectDatabase().ReadCommit(RefInitialize(r.Refs).ReadHead())
+	if err != nil {
+		return "", nil, err
+	}
+	author, err := ParseSignature(head.Author)
+	if err != nil {
+		return "", nil, err
+	}
+	if step.action == "fixup" {
+		return head.Message, &author, nil
+	}
+	message := "# This is a combination of 2 commits.\n# This is the 1st commit message:\n\n" +
+		strings.TrimRight(head.Message, "\n") + "\n\n# This is the commit message #2:\n\n" + commit.Message
+	return message, &author, nil
+}
+
+// commitRebaseStep commits the index for step with message and author,
+// amending HEAD for a squash or a fixup, and logs it in the reflog of
+// HEAD as done by action. A squash message, and then that of a reword,
+// goes through EditMessage.
+func (r Repository) commitRebaseStep(ctx context.Context, step rebaseStep, message string, author *Signature, action string, opts RebaseOptions) error {
+	refs := RefInitialize(r.Refs)
+	var err error
+	if step.action == "squash" {
+		if message, err = r.editRebaseMessage(message, opts); err != nil {
+			return err
+		}
+	}
+	amend := step.action == "squash" || step.action == "fixup"
+	for {
+		head := refs.ReadHead()
+		res, err := r.CommitContext(ctx, CommitOptions{
+			Name:    opts.Name,
+			Email:   opts.Email,
+			When:    opts.When,
+			Message: message,
+			Author:  author,
+			Amend:   amend,
+		})
+		if err != nil {
+			return err
+		}
+		if err := refs.AppendReflog("HEAD", head, res.OID, rebaseIdent(opts), "rebase ("+action+"): "+FirstLine(message)); err != nil {
+			return err
+		}
+		if step.action != "reword" || amend {
+			return nil
+		}
+
+		// the commit is amended again once its message is reworded
+		reworded, err := r.editRebaseMessage(message, opts)
+		if err != nil || reworded == message {
+			return err
+		}
+		message, amend, action = reworded, true, "reword"
+	}
+}
+
+// editRebaseMessage lets EditMessage change message in COMMIT_EDITMSG,
+// then drops its comment lines and needless blank lines, like git
+// cleans up messages.
+func (r Repository) editRebaseMessage(message string, opts RebaseOptions) (string, error) {
+	if opts.EditMessage != nil {
+		path := filepath.Join(r.GitPath, "COMMIT_EDITMSG")
+		if err := os.WriteFile(path, []byte(message), 0644); err != nil {
+			return "", err
+		}
+		if err := opts.EditMessage(path); err != nil {
+			return "", err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		message = string(data)
+	}
+	message = cleanupMessage(message)
+	if message == "" {
+		return "", errors.New("aborting commit due to empty commit message")
+	}
+	return message, nil
+}
+
+// cleanupMessage removes the lines of message starting with '#', the
+// spaces ending lines, and the blank lines at its start and end or
+// following another.
+func cleanupMessage(message string) string {
+	var b strings.Builder
+	blank := false
+	for _, line := range strings.Split(message, "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" {
+			blank = b.Len() > 0
+			continue
+		}
+		if blank {
+			b.WriteString("\n")
+			blank = false
+		}
+		b.WriteString(line + "\n")
+	}
+	return b.String()
+}
+
+// finishRebase moves the branch the rebase started on to HEAD and puts
+// HEAD back on it, then drops the state of the rebase.
+func (r Repository) finishRebase(opts RebaseOptions) (*RebaseResult, error) {
+	refs := RefInitialize(r.Refs)
+	head := refs.ReadHead()
+	headName, err := r.readRebaseState(r.rebasePath("head-name"))
+	if err != nil {
+		return nil, err
+	}
+	onto, err := r.readRebaseState(r.rebasePath("onto"))
+	if err != nil {
+		return nil, err
+	}
+	res := &RebaseResult{Head: head}
+	if strings.HasPrefix(headName, "refs/") {
+		old, err := refs.ReadRef(headName)
+		if err != nil {
+			return nil, err
+		}
+		if err := refs.UpdateRef(headName, head); err != nil {
+			return nil, err
+		}
+		who := rebaseIdent(opts)
+		if err := refs.AppendReflog(headName, old, head, who, fmt.Sprintf("rebase (finish): %s onto %s", headName, onto)); err != nil {
+			return nil, err
+		}
+		if err := refs.SetHeadRef(headName); err != nil {
+			return nil, err
+		}
+		if err := refs.AppendReflog("HEAD", head, head, who, "rebase (finish): returning to "+headName); err != nil {
+			return nil, err
+		}
+		res.Branch = headName
+	}
+	return res, os.RemoveAll(r.rebaseDir())
+}
+
+// readRebaseTodo reads the todo list name of the rebase state, the
+// lines left or those done, skipping blank lines and comments.
+func (r Repository) readRebaseTodo(name string) ([]rebaseStep, error) {
+	data, err := os.ReadFile(r.rebasePath(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var todo []rebaseStep
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		word, rest, _ := strings.Cut(line, " ")
+		rest = strings.TrimSpace(rest)
+		action, ok := rebaseActions[word]
+		if !ok || rest == "" {
+			return nil, fmt.Errorf("invalid line %d of the todo list: %s", n+1, line)
+		}
+		if action == "exec" {
+			todo = append(todo, rebaseStep{action: action, command: rest})
+			continue
+		}
+		rev, _, _ := strings.Cut(rest, " ")
+		oid, err := r.ResolveRevision(rev)
+		if err != nil {
+			return nil, fmt.Errorf("invalid line %d of the todo list: %s: %w", n+1, line, err)
+		}
+		todo = append(todo, rebaseStep{action: action, oid: oid})
+	}
+	return todo, nil
+}
+
+func (r Repository) writeRebaseTodo(todo []rebaseStep) error {
+	list, err := r.formatRebaseTodo(todo, false)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(r.rebasePath("git-rebase-todo"), []byte(list), 0644)
+}
+
+// formatRebaseTodo writes the lines of todo, the commits followed by
+// their subject and their oid abbreviated when it is to be edited.
+func (r Repository) formatRebaseTodo(todo []rebaseStep, abbrev bool) (string, error) {
+	db := r.ObjectDatabase()
+	var b strings.Builder
+	for _, step := range todo {
+		if step.action == "exec" {
+			fmt.Fprintf(&b, "exec %s\n", step.command)
+			continue
+		}
+		commit, err := db.ReadCommit(step.oid)
+		if err != nil {
+			return "", err
+		}
+		oid := step.oid
+		if abbrev {
+			oid = oid[:7]
+		}
+		fmt.Fprintf(&b, "%s %s %s\n", step.action, oid, FirstLine(commit.Message))
+	}
+	return b.String(), nil
+}
+
+// removeRebaseHead drops the files of a rebase stopped by a conflict.
+func (r Repository) removeRebaseHead() error {
+	for _, path := range []string{r.rebaseHeadPath(), r.rebasePath("message")} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,325 @@
+package gitgo
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// commitMessage commits the file name with content and message.
+func commitMessage(t *testing.T, repo *Repository, name, content, message string) string {
+	assert.NoError(t, os.WriteFile(filepath.Join(repo.Path, name), []byte(content), 0644))
+	_, err := repo.Add(name)
+	assert.NoError(t, err)
+	res, err := repo.Commit(CommitOptions{Message: message + "\n"})
+	assert.NoError(t, err)
+	return res.OID
+}
+
+// switchBranch makes the branch name, at oid, the one HEAD is on.
+func switchBranch(t *testing.T, repo *Repository, name, oid string) {
+	refs := RefInitialize(repo.Refs)
+	assert.NoError(t, refs.UpdateRef("refs/heads/"+name, oid))
+	assert.NoError(t, repo.CheckoutCommit(context.Background(), oid))
+	assert.NoError(t, refs.SetHeadRef("refs/heads/"+name))
+}
+
+// subjects lists the subjects of the commits leading to HEAD.
+func subjects(t *testing.T, repo *Repository) []string {
+	var subjects []string
+	assert.NoError(t, repo.Log(func(oid string, commit *Commit) error {
+		subjects = append(subjects, FirstLine(commit.Message))
+		return nil
+	}))
+	return subjects
+}
+
+func TestRebase(t *testing.T) {
+	ctx := context.Background()
+	repo, err := Init(t.TempDir())
+	assert.NoError(t, err)
+	refs := RefInitialize(repo.Refs)
+	base := commitMessage(t, repo, "a.txt", "one\ntwo\nthree\n", "base")
+	upstream := commitMessage(t, repo, "a.txt", "ONE\ntwo\nthree\n", "upstream")
+	switchBranch(t, repo, "side", base)
+	commitMessage(t, repo, "a.txt", "one\ntwo\nTHREE\n", "three")
+	side := commitMessage(t, repo, "b.txt", "b\n", "add b")
+
+	res, err := repo.Rebase(ctx, "master", RebaseOptions{Name: "C", Email: "c@example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, "refs/heads/side", res.Branch)
+	head, _ := refs.HeadRef()
+	assert.Equal(t, "refs/heads/side", head)
+	tip, _ := refs.ReadRef("refs/heads/side")
+	assert.Equal(t, res.Head, tip)
+	assert.Equal(t, []string{"add b", "three", "upstream", "base"}, subjects(t, repo))
+	assert.Equal(t, "ONE\ntwo\nTHREE\n", readFile(t, repo, "a.txt"))
+	assert.NoDirExists(t, repo.rebaseDir())
+
+	// the original author is kept
+	commit, err := repo.ObjectDatabase().ReadCommit(tip)
+	assert.NoError(t, err)
+	assert.NotContains(t, commit.Author, "c@example.com")
+	assert.Contains(t, commit.Committer, "C <c@example.com>")
+
+	entries, err := refs.ReadReflog("HEAD")
+	assert.NoError(t, err)
+	var messages []string
+	for _, e := range entries {
+		messages = append(messages, e.Message)
+	}
+	assert.Equal(t, []string{
+		"rebase (start): checkout master",
+		"rebase (pick): three",
+		"rebase (pick): add b",
+		"rebase (finish): returning to refs/heads/side",
+	}, messages)
+	assert.Equal(t, side, entries[0].Old)
+	assert.Equal(t, upstream, entries[0].New)
+	entries, err = refs.ReadReflog("refs/heads/side")
+	assert.NoError(t, err)
+	assert.Equal(t, []ReflogEntry{{
+		Old:     side,
+		New:     tip,
+		Who:     entries[0].Who,
+		Message: "rebase (finish): refs/heads/side onto " + upstream,
+	}}, entries)
+
+	// nothing left to replay
+	res, err = repo.Rebase(ctx, "master", RebaseOptions{})
+	assert.NoError(t, err)
+	assert.True(t, res.UpToDate)
+	assert.Equal(t, tip, refs.ReadHead())
+
+	// changes not committed are in the way
+	assert.NoError(t, os.WriteFile(filepath.Join(repo.Path, "b.txt"), []byte("local\n"), 0644))
+	_, err = repo.Rebase(ctx, base, RebaseOptions{})
+	assert.ErrorIs(t, err, ErrLocalChanges)
+	assert.NoDirExists(t, repo.rebaseDir())
+}
+
+func TestRebaseConflicts(t *testing.T) {
+	ctx := context.Background()
+	repo, err := Init(t.TempDir())
+	assert.NoError(t, err)
+	refs := RefInitialize(repo.Refs)
+	base := commitMessage(t, repo, "a.txt", "one\ntwo\nthree\n", "base")
+	commitMessage(t, repo, "a.txt", "one\n2\nthree\n", "upstream")
+	switchBranch(t, repo, "side", base)
+	conflicting := commitMessage(t, repo, "a.txt", "one\ntwo!\nthree\n", "conflicting")
+	side := commitMessage(t, repo, "b.txt", "b\n", "add b")
+
+	_, err = repo.Rebase(ctx, "master", RebaseOptions{})
+	var perr *PickError
+	assert.ErrorAs(t, err, &perr)
+	assert.Equal(t, conflicting, perr.Commit)
+	assert.Equal(t, []string{"a.txt"}, perr.Paths)
+	assert.Equal(t, "one\n<<<<<<< HEAD\n2\n=======\ntwo!\n>>>>>>> "+conflicting[:7]+" (conflicting)\nthree\n", readFile(t, repo, "a.txt"))
+	assert.FileExists(t, repo.rebaseHeadPath())
+
+	// a new rebase waits, and so does the commit
+	_, err = repo.Rebase(ctx, "master", RebaseOptions{})
+	assert.ErrorIs(t, err, ErrRebaseRunning)
+	_, err = repo.ContinueRebase(ctx, RebaseOptions{})
+	assert.ErrorIs(t, err, ErrUnmergedFiles)
+
+	// aborting goes back to the start
+	assert.NoError(t, repo.AbortRebase(ctx, RebaseOptions{}))
+	assert.Equal(t, side, refs.ReadHead())
+	head, _ := refs.HeadRef()
+	assert.Equal(t, "refs/heads/side", head)
+	assert.Equal(t, "one\ntwo!\nthree\n", readFile(t, repo, "a.txt"))
+	assert.NoDirExists(t, repo.rebaseDir())
+	assert.NoFileExists(t, repo.rebaseHeadPath())
+	assert.ErrorIs(t, repo.AbortRebase(ctx, RebaseOptions{}), ErrNoRebase)
+
+	// resolving and going on
+	_, err = repo.Rebase(ctx, "master", RebaseOptions{})
+	assert.ErrorAs(t, err, &perr)
+	assert.NoError(t, os.WriteFile(filepath.Join(repo.Path, "a.txt"), []byte("one\n2!\nthree\n"), 0644))
+	_, err = repo.Add("a.txt")
+	assert.NoError(t, err)
+	res, err := repo.ContinueRebase(ctx, RebaseOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "refs/heads/side", res.Branch)
+	assert.Equal(t, []string{"add b", "conflicting", "upstream", "base"}, subjects(t, repo))
+	assert.Equal(t, "one\n2!\nthree\n", readFile(t, repo, "a.txt"))
+	assert.NoFileExists(t, repo.rebaseHeadPath())
+
+	// skipping the conflicting commit
+	switchBranch(t, repo, "other", side)
+	_, err = repo.Rebase(ctx, "master", RebaseOptions{})
+	assert.ErrorAs(t, err, &perr)
+	res, err = repo.SkipRebase(ctx, RebaseOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "refs/heads/other", res.Branch)
+	assert.Equal(t, []string{"add b", "upstream", "base"}, subjects(t, repo))
+	assert.Equal(t, "one\n2\nthree\n", readFile(t, repo, "a.txt"))
+	_, err = repo.SkipRebase(ctx, RebaseOptions{})
+	assert.ErrorIs(t, err, ErrNoRebase)
+}
+
+func TestRebaseInteractive(t *testing.T) {
+	ctx := context.Background()
+	repo, err := Init(t.TempDir())
+	assert.NoError(t, err)
+	refs := RefInitialize(repo.Refs)
+	base := commitMessage(t, repo, "a.txt", "a\n", "base")
+	first := commitMessage(t, repo, "b.txt", "b\n", "add b")
+	commitMessage(t, repo, "c.txt", "c\n", "add c")
+	commitMessage(t, repo, "d.txt", "d\n", "add d")
+	commitMessage(t, repo, "e.txt", "e\n", "add e")
+	commitMessage(t, repo, "f.txt", "f\n", "add f")
+	commitMessage(t, repo, "g.txt", "g\n", "add g")
+
+	var ran []string
+	opts := RebaseOptions{
+		Interactive: true,
+		EditTodo: func(path string) error {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			assert.Contains(t, string(data), "pick "+first[:7]+" add b\n")
+			list := strings.NewReplacer(
+				"pick "+first[:7], "reword "+first[:7],
+				"pick", "p",
+			).Replace(string(data))
+			lines := strings.SplitAfter(list, "\n")
+			lines[1] = "s" + strings.TrimPrefix(lines[1], "p")
+			lines[2] = "exec touch ran\nf" + strings.TrimPrefix(lines[2], "p")
+			lines[3] = "edit" + strings.TrimPrefix(lines[3], "p")
+			lines[4] = "drop" + strings.TrimPrefix(lines[4], "p")
+			return os.WriteFile(path, []byte(strings.Join(lines, "")), 0644)
+		},
+		EditMessage: func(path string) error {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			return os.WriteFile(path, []byte(strings.Replace(string(data), "add b\n", "add b and c\n", 1)), 0644)
+		},
+		Exec: func(command string) error {
+			ran = append(ran, command)
+			return nil
+		},
+	}
+	res, err := repo.Rebase(ctx, base, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"touch ran"}, ran)
+	assert.NotEmpty(t, res.Stopped)
+	assert.Equal(t, []string{"add e", "add b and c", "base"}, subjects(t, repo))
+	head, err := repo.ObjectDatabase().ReadCommit(refs.ReadHead())
+	assert.NoError(t, err)
+	parent, err := repo.ObjectDatabase().ReadCommit(head.Parent())
+	assert.NoError(t, err)
+	assert.Equal(t, "add b and c\n\nadd c\n", parent.Message)
+	files, err := repo.commitFiles(head.Parent())
+	assert.NoError(t, err)
+	assert.Len(t, files, 4)
+
+	// the commit stopped at is amended with the changes staged
+	assert.NoError(t, os.WriteFile(filepath.Join(repo.Path, "e.txt"), []byte("e!\n"), 0644))
+	_, err = repo.Add("e.txt")
+	assert.NoError(t, err)
+	res, err = repo.ContinueRebase(ctx, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, "refs/heads/master", res.Branch)
+	assert.Equal(t, []string{"add g", "add e", "add b and c", "base"}, subjects(t, repo))
+	assert.Equal(t, "e!\n", readFile(t, repo, "e.txt"))
+	assert.NoFileExists(t, filepath.Join(repo.Path, "f.txt"))
+
+	entries, err := refs.ReadReflog("HEAD")
+	assert.NoError(t, err)
+	var messages []string
+	for _, e := range entries {
+		messages = append(messages, e.Message)
+	}
+	assert.Equal(t, []string{
+		"rebase (start): checkout " + base,
+		"rebase (reword): add b",
+		"rebase (reword): add b and c",
+		"rebase (squash): add b and c",
+		"rebase (fixup): add b and c",
+		"rebase (edit): add e",
+		"rebase (continue): add e",
+		"rebase (pick): add g",
+		"rebase (finish): returning to refs/heads/master",
+	}, messages)
+
+	// a failing command stops the rebase until it goes on
+	opts = RebaseOptions{
+		Interactive: true,
+		EditTodo: func(path string) error {
+			return os.WriteFile(path, []byte("exec false\nexec true\n"), 0644)
+		},
+		Exec: func(command string) error {
+			if command == "false" {
+				return errors.New("exit status 1")
+			}
+			ran = append(ran, command)
+			return nil
+		},
+	}
+	_, err = repo.Rebase(ctx, "HEAD", opts)
+	var eerr *RebaseExecError
+	assert.ErrorAs(t, err, &eerr)
+	assert.Equal(t, "false", eerr.Command)
+	_, err = repo.ContinueRebase(ctx, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"touch ran", "true"}, ran)
+
+	// an empty todo list does nothing
+	opts.EditTodo = func(path string) error { return os.WriteFile(path, []byte("# nothing\n"), 0644) }
+	_, err = repo.Rebase(ctx, base, opts)
+	assert.EqualError(t, err, "nothing to do")
+	assert.NoDirExists(t, repo.rebaseDir())
+
+	opts.EditTodo = func(path string) error { return os.WriteFile(path, []byte("squash "+first+"\n"), 0644) }
+	_, err = repo.Rebase(ctx, base, opts)
+	assert.EqualError(t, err, "cannot 'squash' without a previous commit")
+
+	opts.EditTodo = func(path string) error { return os.WriteFile(path, []byte("frobnicate "+first+"\n"), 0644) }
+	_, err = repo.Rebase(ctx, base, opts)
+	assert.ErrorContains(t, err, "invalid line 1 of the todo list")
+	assert.NoDirExists(t, repo.rebaseDir())
+}
+
+func TestAutosquash(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	assert.NoError(t, err)
+	a := commitMessage(t, repo, "a.txt", "a\n", "add a")
+	b := commitMessage(t, repo, "b.txt", "b\n", "add b")
+	fixA := commitMessage(t, repo, "a.txt", "a!\n", "fixup! add a")
+	squashB := commitMessage(t, repo, "b.txt", "b!\n", "squash! "+b[:7])
+	fixFixA := commitMessage(t, repo, "a.txt", "a!!\n", "fixup! fixup! add a")
+	unknown := commitMessage(t, repo, "c.txt", "c\n", "fixup! nothing")
+
+	todo, err := repo.autosquash([]rebaseStep{
+		{action: "pick", oid: a},
+		{action: "pick", oid: b},
+		{action: "pick", oid: fixA},
+		{action: "pick", oid: squashB},
+		{action: "pick", oid: fixFixA},
+		{action: "pick", oid: unknown},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []rebaseStep{
+		{action: "pick", oid: a},
+		{action: "fixup", oid: fixA},
+		{action: "fixup", oid: fixFixA},
+		{action: "pick", oid: b},
+		{action: "squash", oid: squashB},
+		{action: "pick", oid: unknown},
+	}, todo)
+}
+
+func TestCleanupMessage(t *testing.T) {
+	assert.Equal(t, "Subject\n\nBody\n", cleanupMessage("\n# comment\nSubject  \n\n\n# more\nBody\n\n"))
+	assert.Equal(t, "", cleanupMessage("# only comments\n\n"))
+}
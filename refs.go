@@ -2,11 +2,23 @@ package gitgo
 
 import (
 	"errors"
+	"fmt"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
+	"strings"
 )
 
-var ErrLockDenied = errors.New("Lock Denied")
+var (
+	ErrLockDenied = errors.New("Lock Denied")
+	ErrInvalidRef = errors.New("invalid ref name")
+	ErrStaleRef   = errors.New("ref changed meanwhile")
+)
+
+// DefaultBranch is the branch HEAD points to in a new repository.
+const DefaultBranch = "master"
 
 type ref struct {
 	pathname string
@@ -19,30 +31,405 @@ func RefInitialize(pathname string) ref {
 	return r
 }
 
+// UpdateHead moves the branch HEAD points to to oid, or HEAD itself
+// when it is detached. A repository without HEAD gets one pointing at
+// DefaultBranch first.
 func (r ref) UpdateHead(oid []byte) error {
-	lockfile := lockInitialize(r.headPath)
+	name, err := r.HeadRef()
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		if _, err := os.Stat(r.headPath); !os.IsNotExist(err) {
+			return r.DetachHead(string(oid))
+		}
+		name = "refs/heads/" + DefaultBranch
+		if err := r.SetHeadRef(name); err != nil {
+			return err
+		}
+	}
+	return r.UpdateRef(name, string(oid))
+}
+
+// DetachHead points HEAD directly at oid.
+func (r ref) DetachHead(oid string) error {
+	return writeLocked(r.headPath, oid+"\n")
+}
+
+// SetHeadRef makes HEAD a symbolic ref to name, like
+// "refs/heads/master", which does not have to exist yet.
+func (r ref) SetHeadRef(name string) error {
+	if err := validRefName(name); err != nil {
+		return err
+	}
+	return writeLocked(r.headPath, "ref: "+name+"\n")
+}
+
+// HeadRef returns the ref HEAD points to, empty when HEAD is detached
+// or missing.
+func (r ref) HeadRef() (string, error) {
+	data, err := os.ReadFile(r.headPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	name, symbolic := strings.CutPrefix(strings.TrimSpace(string(data)), "ref: ")
+	if !symbolic {
+		return "", nil
+	}
+	return name, nil
+}
+
+// UpdateRef points the ref name, like "refs/heads/master", at oid.
+func (r ref) UpdateRef(name, oid string) error {
+	if err := validRefName(name); err != nil {
+		return err
+	}
+	path := filepath.Join(r.pathname, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return writeLocked(path, oid+"\n")
+}
 
+// ReadRef returns the oid of the ref name, looked up in loose then
+// packed refs. It is empty when the ref does not exist.
+func (r ref) ReadRef(name string) (string, error) {
+	return readRef(r.pathname, name)
+}
+
+// ListRefs returns the refs whose name starts with prefix, like
+// "refs/heads/", and the oids they point to, from loose and packed
+// refs.
+func (r ref) ListRefs(prefix string) (map[string]string, error) {
+	refs := make(map[string]string)
+	packed, err := os.ReadFile(filepath.Join(r.pathname, "packed-refs"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(packed), "\n") {
+		oid, name, ok := strings.Cut(line, " ")
+		if ok && strings.HasPrefix(name, prefix) && validOID(oid) == nil {
+			refs[name] = oid
+		}
+	}
+
+	// loose refs win over packed ones, which can be out of date
+	dir, _ := path.Split(prefix)
+	err = filepath.WalkDir(filepath.Join(r.pathname, filepath.FromSlash(dir)), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(p, ".lock") || strings.HasSuffix(p, ".owner") {
+			return nil
+		}
+		rel, err := filepath.Rel(r.pathname, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		if oid := strings.TrimSpace(string(data)); validOID(oid) == nil {
+			refs[name] = oid
+		}
+		return nil
+	})
+	return refs, err
+}
+
+// DeleteRef removes the ref name, loose and packed. Deleting a ref
+// that does not exist is not an error.
+func (r ref) DeleteRef(name string) error {
+	if err := validRefName(name); err != nil {
+		return err
+	}
+	path := filepath.Join(r.pathname, filepath.FromSlash(name))
+	lockfile := lockInitialize(path)
 	if _, err := lockfile.holdForUpdate(); err != nil {
 		return err
 	}
+	defer lockfile.rollback()
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return r.deletePackedRef(name)
+}
+
+// RefChange moves the ref Name from Old to New. An empty Old means the
+// ref must not exist yet and an empty New deletes it.
+type RefChange struct {
+	Name string
+	Old  string
+	New  string
+}
+
+// Transaction applies changes all or nothing: every ref is locked and
+// checked to still be at its Old oid before any of them is written.
+func (r ref) Transaction(changes []RefChange) error {
+	sorted := slices.SortedFunc(slices.Values(changes), func(a, b RefChange) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	locks := make([]*lockFile, 0, len(sorted))
+	defer func() {
+		for _, l := range locks {
+			l.rollback()
+		}
+	}()
+	for i, c := range sorted {
+		if err := validRefName(c.Name); err != nil {
+			return err
+		}
+		if i > 0 && sorted[i-1].Name == c.Name {
+			return fmt.Errorf("%w: '%s' changed twice", ErrInvalidRef, c.Name)
+		}
+		path := filepath.Join(r.pathname, filepath.FromSlash(c.Name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		lockfile := lockInitialize(path)
+		if _, err := lockfile.holdForUpdate(); err != nil {
+			return err
+		}
+		locks = append(locks, lockfile)
+		current, err := r.ReadRef(c.Name)
+		if err != nil {
+			return err
+		}
+		if current != c.Old {
+			return fmt.Errorf("%w: '%s'", ErrStaleRef, c.Name)
+		}
+		if c.New != "" {
+			if err := lockfile.write([]byte(c.New + "\n")); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i, c := range sorted {
+		if c.New != "" {
+			if err := locks[i].commit(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.Remove(locks[i].FilePath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := r.deletePackedRef(c.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r ref) deletePackedRef(name string) error {
+	packedPath := filepath.Join(r.pathname, "packed-refs")
+	packed, err := os.ReadFile(packedPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var kept []string
+	removed, peeled := false, false
+	for _, line := range strings.Split(strings.TrimSuffix(string(packed), "\n"), "\n") {
+		// a "^<oid>" line is the peeled tag of the ref before it
+		if peeled && strings.HasPrefix(line, "^") {
+			continue
+		}
+		_, ref, _ := strings.Cut(line, " ")
+		peeled = ref == name
+		if peeled {
+			removed = true
+			continue
+		}
+		kept = append(kept, line)
+	}
+	if !removed {
+		return nil
+	}
+	return writeLocked(packedPath, strings.Join(kept, "\n")+"\n")
+}
 
-	oid = append(oid, '\n')
-	lockfile.write(oid)
-	lockfile.commit()
+// ShortRefName strips refs/heads/, refs/tags/ or refs/remotes/ off
+// name, the way git shows refs.
+func ShortRefName(name string) string {
+	for _, prefix := range []string{"refs/heads/", "refs/tags/", "refs/remotes/"} {
+		if short, ok := strings.CutPrefix(name, prefix); ok {
+			return short
+		}
+	}
+	return name
+}
 
+func validRefName(name string) error {
+	if !strings.HasPrefix(name, "refs/") ||
+		strings.Contains(name, "..") ||
+		strings.ContainsAny(name, " ~^:?*[\\") ||
+		strings.HasSuffix(name, "/") ||
+		strings.HasSuffix(name, ".lock") {
+		return fmt.Errorf("%w: '%s'", ErrInvalidRef, name)
+	}
 	return nil
 }
 
+func writeLocked(path, content string) error {
+	lockfile := lockInitialize(path)
+	if _, err := lockfile.holdForUpdate(); err != nil {
+		return err
+	}
+	if err := lockfile.write([]byte(content)); err != nil {
+		lockfile.rollback()
+		return err
+	}
+	return lockfile.commit()
+}
+
 func (r ref) HeadPath() string {
 	return r.headPath
 }
 
+// ReadHead returns the commit HEAD resolves to, empty when there is
+// none yet.
 func (r ref) ReadHead() string {
-	_, err := os.Stat(r.headPath)
-	if os.IsNotExist(err) {
-		return ""
+	oid, _ := resolveHead(r.pathname)
+	return oid
+}
+
+// resolveHead returns the commit the HEAD of the repository at gitDir
+// points to, following a symbolic "ref: <name>" HEAD through loose and
+// packed refs. It is empty when nothing was committed yet.
+func resolveHead(gitDir string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(gitDir, "HEAD"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	head := strings.TrimSpace(string(data))
+	name, symbolic := strings.CutPrefix(head, "ref: ")
+	if !symbolic {
+		return head, nil
+	}
+	return readRef(gitDir, name)
+}
+
+func readRef(gitDir, name string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(gitDir, filepath.FromSlash(name)))
+	if err == nil {
+		return strings.TrimSpace(string(data)), nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	packed, err := os.ReadFile(filepath.Join(gitDir, "packed-refs"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	for _, line := range strings.Split(string(packed), "\n") {
+		oid, ref, ok := strings.Cut(line, " ")
+		if ok && ref == name {
+			return oid, nil
+		}
+	}
+	return "", nil
+}
+
+// ReflogEntry is a line of the reflog of a ref: it moved from Old to
+// New, by Who, for Message.
+type ReflogEntry struct {
+	Old     string
+	New     string
+	Who     string
+	Message string
+}
+
+// AppendReflog records that name, "HEAD" or a ref like
+// "refs/heads/master", moved from old to new, by who, an identity and
+// date like a committer line, for message. An empty old is a ref that
+// did not exist.
+func (r ref) AppendReflog(name, old, new, who, message string) error {
+	if name != "HEAD" {
+		if err := validRefName(name); err != nil {
+			return err
+		}
+	}
+	if old == "" {
+		old = zeroOID
+	}
+	path := filepath.Join(r.pathname, "logs", filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	message = strings.ReplaceAll(strings.TrimRight(message, "\n"), "\n", " ")
+	if _, err := fmt.Fprintf(f, "%s %s %s\t%s\n", old, new, who, message); err != nil {
+		f.Close()
+		return err
 	}
+	return f.Close()
+}
 
-	content, _ := os.ReadFile(r.headPath)
-	return string(content)
+// ReadReflog returns the reflog of name, oldest entry first, empty
+// when it has none.
+func (r ref) ReadReflog(name string) ([]ReflogEntry, error) {
+	data, err := os.ReadFile(filepath.Join(r.pathname, "logs", filepath.FromSlash(name)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var entries []ReflogEntry
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		head, message, _ := strings.Cut(line, "\t")
+		fields := strings.SplitN(head, " ", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%w: bad reflog line %q", ErrCorruptObject, line)
+		}
+		entries = append(entries, ReflogEntry{Old: fields[0], New: fields[1], Who: fields[2], Message: message})
+	}
+	return entries, nil
+}
+
+// WriteReflog replaces the reflog of name with entries, oldest first,
+// removing it when there are none.
+func (r ref) WriteReflog(name string, entries []ReflogEntry) error {
+	if name != "HEAD" {
+		if err := validRefName(name); err != nil {
+			return err
+		}
+	}
+	path := filepath.Join(r.pathname, "logs", filepath.FromSlash(name))
+	if len(entries) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	var b strings.Builder
+	for _, e := range entries {
+		fmt.Fprintf(&b, "%s %s %s\t%s\n", e.Old, e.New, e.Who, e.Message)
+	}
+	return writeLocked(path, b.String())
 }
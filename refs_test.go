@@ -0,0 +1,49 @@
+package gitgo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRefTransaction(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	assert.NoError(t, err)
+	refs := RefInitialize(repo.Refs)
+	one := "ce013625030ba8dba906f756967f9e9ca394464a"
+	two := "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391"
+	assert.NoError(t, refs.UpdateRef("refs/heads/master", one))
+	assert.NoError(t, refs.UpdateRef("refs/heads/old", one))
+
+	assert.NoError(t, refs.Transaction([]RefChange{
+		{Name: "refs/heads/master", Old: one, New: two},
+		{Name: "refs/heads/topic/new", New: one},
+		{Name: "refs/heads/old", Old: one},
+	}))
+	all, err := refs.ListRefs("refs/")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"refs/heads/master":    two,
+		"refs/heads/topic/new": one,
+	}, all)
+
+	// one stale ref and nothing changes
+	err = refs.Transaction([]RefChange{
+		{Name: "refs/heads/master", Old: two, New: one},
+		{Name: "refs/heads/topic/new", Old: two, New: two},
+	})
+	assert.ErrorIs(t, err, ErrStaleRef)
+	err = refs.Transaction([]RefChange{{Name: "refs/heads/master", New: one}})
+	assert.ErrorIs(t, err, ErrStaleRef)
+	again, err := refs.ListRefs("refs/")
+	assert.NoError(t, err)
+	assert.Equal(t, all, again)
+
+	err = refs.Transaction([]RefChange{{Name: "refs/heads/a b", New: one}})
+	assert.ErrorIs(t, err, ErrInvalidRef)
+	err = refs.Transaction([]RefChange{
+		{Name: "refs/heads/master", Old: two, New: one},
+		{Name: "refs/heads/master", Old: two},
+	})
+	assert.ErrorIs(t, err, ErrInvalidRef)
+}
@@ -0,0 +1,201 @@
+package gitgo
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrNoSuchRemote = errors.New("no such remote")
+	ErrRemoteExists = errors.New("remote already exists")
+)
+
+// DefaultRemote is the name clone gives to the repository it copies.
+const DefaultRemote = "origin"
+
+// Remote is a repository to fetch from and push to, kept in the
+// config as remote.<name>.url and remote.<name>.fetch.
+type Remote struct {
+	Name string
+	URL  string
+	// Fetch are the refspecs mapping its refs to remote-tracking
+	// refs, like "+refs/heads/*:refs/remotes/origin/*".
+	Fetch []string
+}
+
+// Remotes lists the remotes in the order they appear in the config.
+func (r Repository) Remotes() ([]Remote, error) {
+	config, err := r.Config()
+	if err != nil {
+		return nil, err
+	}
+	var remotes []Remote
+	for _, name := range config.Subsections("remote") {
+		if slices.ContainsFunc(remotes, func(rm Remote) bool { return rm.Name == name }) {
+			continue
+		}
+		url, _ := config.Get("remote." + name + ".url")
+		remotes = append(remotes, Remote{
+			Name:  name,
+			URL:   url,
+			Fetch: config.GetAll("remote." + name + ".fetch"),
+		})
+	}
+	return remotes, nil
+}
+
+func (r Repository) Remote(name string) (*Remote, error) {
+	remotes, err := r.Remotes()
+	if err != nil {
+		return nil, err
+	}
+	for _, remote := range remotes {
+		if remote.Name == name && remote.URL != "" {
+			return &remote, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: '%s'", ErrNoSuchRemote, name)
+}
+
+// AddRemote registers url as the remote name, whose branches are then
+// fetched to refs/remotes/<name>/.
+func (r Repository) AddRemote(name, url string) (*Remote, error) {
+	if name == "" || validRefName("refs/remotes/"+name) != nil {
+		return nil, fmt.Errorf("'%s' is not a valid remote name", name)
+	}
+	config, err := r.Config()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := config.Get("remote." + name + ".url"); ok {
+		return nil, fmt.Errorf("%w: '%s'", ErrRemoteExists, name)
+	}
+	remote := &Remote{
+		Name:  name,
+		URL:   url,
+		Fetch: []string{"+refs/heads/*:refs/remotes/" + name + "/*"},
+	}
+	if err := config.Set("remote."+name+".url", url); err != nil {
+		return nil, err
+	}
+	if err := config.Set("remote."+name+".fetch", remote.Fetch[0]); err != nil {
+		return nil, err
+	}
+	return remote, config.Save()
+}
+
+// RemoveRemote forgets the remote name along with its remote-tracking
+// refs and the branches set to track it.
+func (r Repository) RemoveRemote(name string) error {
+	remote, err := r.Remote(name)
+	if err != nil {
+		return err
+	}
+	config, err := r.Config()
+	if err != nil {
+		return err
+	}
+	for _, branch := range config.Subsections("branch") {
+		if v, _ := config.Get("branch." + branch + ".remote"); v == name {
+			config.Unset("branch." + branch + ".remote")
+			config.Unset("branch." + branch + ".merge")
+		}
+	}
+	config.RemoveSection("remote", name)
+	if err := config.Save(); err != nil {
+		return err
+	}
+
+	refs := RefInitialize(r.Refs)
+	local, err := refs.ListRefs("refs/")
+	if err != nil {
+		return err
+	}
+	for ref := range local {
+		for _, spec := range remote.Fetch {
+			if _, ok := parseRefspec(spec).reverse(ref); ok {
+				if err := refs.DeleteRef(ref); err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// refspec maps the refs matching src to dst, either a single ref or,
+// with a '*' in both, every ref under a prefix. A forced refspec
+// updates dst even when that is not a fast-forward.
+type refspec struct {
+	force bool
+	src   string
+	dst   string
+}
+
+func parseRefspec(s string) refspec {
+	spec := refspec{}
+	s, spec.force = strings.CutPrefix(s, "+")
+	spec.src, spec.dst, _ = strings.Cut(s, ":")
+	return spec
+}
+
+// match returns the ref name maps to, false when it is not matched.
+func (s refspec) match(name string) (string, bool) {
+	return mapRef(s.src, s.dst, name)
+}
+
+// reverse returns the ref that maps to name.
+func (s refspec) reverse(name string) (string, bool) {
+	return mapRef(s.dst, s.src, name)
+}
+
+func mapRef(from, to, name string) (string, bool) {
+	prefix, suffix, glob := strings.Cut(from, "*")
+	if !glob {
+		return to, name == from && to != ""
+	}
+	if len(name) < len(prefix)+len(suffix) || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+		return "", false
+	}
+	middle := name[len(prefix) : len(name)-len(suffix)]
+	return strings.Replace(to, "*", middle, 1), true
+}
+
+// openRemote opens the repository at url, a path or a file:// URL,
+// relative ones being taken from the workspace. It can be bare.
+func (r Repository) openRemote(url string) (*Repository, error) {
+	path := r.resolveURL(url)
+	if repo, err := Open(path); err == nil {
+		return repo, nil
+	}
+	if isBareRepository(path) {
+		repo := Repository{
+			Path:     path,
+			GitPath:  path,
+			Database: filepath.Join(path, "objects"),
+			Index:    filepath.Join(path, "index"),
+			Refs:     path,
+		}
+		return &repo, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNotARepository, url)
+}
+
+func isBareRepository(path string) bool {
+	for _, dir := range []string{"objects", "refs"} {
+		if stat, err := os.Stat(filepath.Join(path, dir)); err != nil || !stat.IsDir() {
+			return false
+		}
+	}
+	return true
+}
+
+// bare tells if the repository has no workspace.
+func (r Repository) bare() bool {
+	return r.GitPath == r.Path
+}
@@ -0,0 +1,238 @@
+package gitgo
+
+import (
+	"bytes"
+	"cmp"
+	"path"
+	"slices"
+	"strings"
+)
+
+// DefaultRenameThreshold is how alike, in percent, two files need to be
+// for one to be taken as a rename or copy of the other.
+const DefaultRenameThreshold = 50
+
+// DefaultRenameLimit is how many files, added or source, are compared
+// by content to find renames and copies.
+const DefaultRenameLimit = 1000
+
+// RenameOptions pick how the files added by a change are matched to
+// the ones it removed or modified.
+type RenameOptions struct {
+	// Renames pairs the added files with the removed ones they come
+	// from, and Copies also with the modified ones.
+	Renames bool
+	Copies  bool
+	// Threshold is the least similarity of a pair, in percent,
+	// DefaultRenameThreshold when nil. At 0, any file can pair with
+	// any other.
+	Threshold *int
+	// Limit caps the files compared by content, like diff.renameLimit:
+	// over it on both sides, only files with the same content are
+	// paired. It is DefaultRenameLimit when 0 and none when negative.
+	Limit int
+}
+
+// FileChange is a file that differs between two versions of the files
+// of a repository, named by their full path: From before and To after,
+// the one missing for an addition or a deletion having no Name.
+// Similarity is how alike, in percent, the two files of a rename or a
+// copy are.
+type FileChange struct {
+	Type       ChangeType
+	From, To   TreeEntry
+	Similarity int
+}
+
+// Path is the name of the file after the change, before it for a
+// deletion.
+func (c FileChange) Path() string {
+	return cmp.Or(c.To.Name, c.From.Name)
+}
+
+// diffFiles compares two lists of files sorted by name.
+func diffFiles(old, new []TreeEntry) []FileChange {
+	var changes []FileChange
+	i, j := 0, 0
+	for i < len(old) || j < len(new) {
+		switch {
+		case j == len(new) || i < len(old) && old[i].Name < new[j].Name:
+			changes = append(changes, FileChange{Type: Deleted, From: old[i]})
+			i++
+		case i == len(old) || new[j].Name < old[i].Name:
+			changes = append(changes, FileChange{Type: Added, To: new[j]})
+			j++
+		default:
+			if old[i].OID != new[j].OID || old[i].Mode != new[j].Mode {
+				changes = append(changes, FileChange{Type: Modified, From: old[i], To: new[j]})
+			}
+			i, j = i+1, j+1
+		}
+	}
+	return changes
+}
+
+// detectRenames turns the additions of changes that come from a file
+// removed, or for copies modified, into renames and copies. Files
+// with the same content are paired first, then the most alike ones,
+// read giving the content of a file. A removed file is renamed to the
+// last file made from it, the others are copies.
+func detectRenames(changes []FileChange, opts RenameOptions, read func(TreeEntry) ([]byte, error)) ([]FileChange, error) {
+	if !opts.Renames && !opts.Copies {
+		return changes, nil
+	}
+	threshold := DefaultRenameThreshold
+	if opts.Threshold != nil {
+		threshold = *opts.Threshold
+	}
+	changes = slices.Clone(changes)
+
+	var sources, added []int
+	for i, c := range changes {
+		switch {
+		case c.Type == Added && c.To.Mode != gitlinkMode:
+			added = append(added, i)
+		case c.Type == Deleted && c.From.Mode != gitlinkMode,
+			c.Type == Modified && opts.Copies && c.From.Mode != gitlinkMode:
+			sources = append(sources, i)
+		}
+	}
+	if len(sources) == 0 || len(added) == 0 {
+		return changes, nil
+	}
+
+	used := make(map[int]bool)
+	pair := func(src, dst, score int) {
+		used[src] = true
+		changes[dst] = FileChange{Type: Copied, From: changes[src].From, To: changes[dst].To, Similarity: score * 100 / maxScore}
+	}
+
+	// the same content, a source under the same base name first
+	var left []int
+	for _, dst := range added {
+		to := changes[dst].To
+		best := -1
+		for _, src := range sources {
+			from := changes[src].From
+			if from.OID != to.OID || from.OID == emptyBlobOID || !opts.Copies && used[src] {
+				continue
+			}
+			if best < 0 || path.Base(from.Name) == path.Base(to.Name) && path.Base(changes[best].From.Name) != path.Base(to.Name) {
+				best = src
+			}
+		}
+		if best < 0 {
+			left = append(left, dst)
+			continue
+		}
+		pair(best, dst, maxScore)
+	}
+
+	// then the most alike pairs, best first, unless there are too many
+	// to compare
+	if limit := cmp.Or(opts.Limit, DefaultRenameLimit); limit > 0 && len(left)*len(sources) > limit*limit {
+		left = nil
+	}
+	type candidate struct{ src, dst, score int }
+	var candidates []candidate
+	contents := make(map[string]map[string]int)
+	chunks := func(e TreeEntry) (map[string]int, error) {
+		if c, ok := contents[e.OID]; ok {
+			return c, nil
+		}
+		data, err := read(e)
+		if err != nil {
+			return nil, err
+		}
+		contents[e.OID] = contentChunks(data)
+		return contents[e.OID], nil
+	}
+	for _, dst := range left {
+		to, err := chunks(changes[dst].To)
+		if err != nil {
+			return nil, err
+		}
+		for _, src := range sources {
+			if !opts.Copies && used[src] {
+				continue
+			}
+			from, err := chunks(changes[src].From)
+			if err != nil {
+				return nil, err
+			}
+			if score := similarity(from, to); score >= threshold*maxScore/100 {
+				candidates = append(candidates, candidate{src, dst, score})
+			}
+		}
+	}
+	slices.SortStableFunc(candidates, func(a, b candidate) int { return b.score - a.score })
+	done := make(map[int]bool)
+	for _, c := range candidates {
+		if done[c.dst] || !opts.Copies && used[c.src] {
+			continue
+		}
+		done[c.dst] = true
+		pair(c.src, c.dst, c.score)
+	}
+
+	// the last file made from a removed one, by path, is its rename
+	renamed := make(map[string]bool)
+	for i := len(changes) - 1; i >= 0; i-- {
+		c := changes[i]
+		if c.Type == Copied && !renamed[c.From.Name] && slices.ContainsFunc(sources, func(src int) bool {
+			return changes[src].Type == Deleted && changes[src].From.Name == c.From.Name
+		}) {
+			renamed[c.From.Name] = true
+			changes[i].Type = Renamed
+		}
+	}
+	var result []FileChange
+	for _, c := range changes {
+		if c.Type != Deleted || !renamed[c.From.Name] {
+			result = append(result, c)
+		}
+	}
+	slices.SortStableFunc(result, func(a, b FileChange) int {
+		return strings.Compare(a.Path(), b.Path())
+	})
+	return result, nil
+}
+
+// emptyBlobOID is the oid of an empty file, which is never taken as a
+// rename of another.
+const emptyBlobOID = "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391"
+
+// maxScore is the similarity of identical files.
+const maxScore = 60000
+
+// contentChunks cuts data into its lines, or pieces of 64 bytes for
+// longer ones, and counts the bytes of every distinct one.
+func contentChunks(data []byte) map[string]int {
+	chunks := make(map[string]int)
+	for len(data) > 0 {
+		n := min(len(data), 64)
+		if i := bytes.IndexByte(data[:n], '\n'); i >= 0 {
+			n = i + 1
+		}
+		chunks[string(data[:n])] += n
+		data = data[n:]
+	}
+	return chunks
+}
+
+// similarity is how much of the larger of two files, out of maxScore,
+// is made of the chunks they have in common, like git scores renames.
+func similarity(a, b map[string]int) int {
+	sizeA, sizeB, common := 0, 0, 0
+	for chunk, n := range a {
+		sizeA += n
+		common += min(n, b[chunk])
+	}
+	for _, n := range b {
+		sizeB += n
+	}
+	if largest := max(sizeA, sizeB); largest > 0 {
+		return common * maxScore / largest
+	}
+	return 0
+}
@@ -0,0 +1,134 @@
+package gitgo
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// changeNames writes the changes as git's --name-status does.
+func changeNames(changes []FileChange) []string {
+	var names []string
+	for _, c := range changes {
+		switch c.Type {
+		case Renamed, Copied:
+			names = append(names, string(c.Type.Code())+" "+c.From.Name+" "+c.To.Name)
+		default:
+			names = append(names, string(c.Type.Code())+" "+c.Path())
+		}
+	}
+	return names
+}
+
+func TestDetectRenames(t *testing.T) {
+	contents := map[string]string{
+		"1": "one\ntwo\nthree\nfour\n",
+		"2": "one\ntwo\nthree\n4\n",
+		"3": "something\nelse\n",
+	}
+	read := func(e TreeEntry) ([]byte, error) { return []byte(contents[e.OID]), nil }
+	file := func(name, oid string) TreeEntry { return TreeEntry{Name: name, Mode: 0100644, OID: oid} }
+
+	old := []TreeEntry{file("a.txt", "1"), file("b.txt", "3"), file("dir/c.txt", "1")}
+	new := []TreeEntry{file("b.txt", "2"), file("c.txt", "1"), file("d.txt", "2"), file("e.txt", "3")}
+	changes := diffFiles(old, new)
+	assert.Equal(t, []string{"D a.txt", "M b.txt", "A c.txt", "A d.txt", "D dir/c.txt", "A e.txt"}, changeNames(changes))
+
+	// the same content goes first, under the same base name
+	renamed, err := detectRenames(changes, RenameOptions{Renames: true}, read)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"M b.txt", "R dir/c.txt c.txt", "R a.txt d.txt", "A e.txt"}, changeNames(renamed))
+	assert.Equal(t, 100, renamed[1].Similarity)
+	assert.Equal(t, 73, renamed[2].Similarity)
+
+	// too different for the threshold
+	threshold := 80
+	renamed, err = detectRenames(changes, RenameOptions{Renames: true, Threshold: &threshold}, read)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"D a.txt", "M b.txt", "R dir/c.txt c.txt", "A d.txt", "A e.txt"}, changeNames(renamed))
+
+	// too many files to compare, only the same content is paired
+	renamed, err = detectRenames(changes, RenameOptions{Renames: true, Limit: 1}, read)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"D a.txt", "M b.txt", "R dir/c.txt c.txt", "A d.txt", "A e.txt"}, changeNames(renamed))
+	renamed, err = detectRenames(changes, RenameOptions{Renames: true, Limit: 2}, read)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"M b.txt", "R dir/c.txt c.txt", "R a.txt d.txt", "A e.txt"}, changeNames(renamed))
+
+	// at 0%, unlike left unset, anything goes
+	unrelated := diffFiles([]TreeEntry{file("x.txt", "3")}, []TreeEntry{file("y.txt", "1")})
+	renamed, err = detectRenames(unrelated, RenameOptions{Renames: true}, read)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"D x.txt", "A y.txt"}, changeNames(renamed))
+	threshold = 0
+	renamed, err = detectRenames(unrelated, RenameOptions{Renames: true, Threshold: &threshold}, read)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"R x.txt y.txt"}, changeNames(renamed))
+
+	// copies come from the modified files too
+	renamed, err = detectRenames(changes, RenameOptions{Renames: true, Copies: true}, read)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"M b.txt", "R dir/c.txt c.txt", "R a.txt d.txt", "C b.txt e.txt"}, changeNames(renamed))
+
+	renamed, err = detectRenames(changes, RenameOptions{}, read)
+	assert.NoError(t, err)
+	assert.Equal(t, changes, renamed)
+}
+
+func TestLogFileFollowsRenames(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	assert.NoError(t, err)
+	lines := "one\ntwo\nthree\nfour\nfive\n"
+	first := commitFile(t, repo, "a.txt", lines)
+	commitFile(t, repo, "other.txt", "other\n")
+
+	assert.NoError(t, os.Rename(filepath.Join(repo.Path, "a.txt"), filepath.Join(repo.Path, "b.txt")))
+	_, err = repo.AddAll("a.txt")
+	assert.NoError(t, err)
+	third := commitFile(t, repo, "b.txt", strings.Replace(lines, "three", "3", 1))
+
+	logFile := func(opts RenameOptions) []string {
+		var oids []string
+		assert.NoError(t, repo.LogFile("b.txt", opts, func(oid string, commit *Commit) error {
+			oids = append(oids, oid)
+			return nil
+		}))
+		return oids
+	}
+	assert.Equal(t, []string{third}, logFile(RenameOptions{}))
+	assert.Equal(t, []string{third, first}, logFile(RenameOptions{Renames: true}))
+
+	status, err := repo.StatusContext(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, status.Changed)
+	changes, err := repo.Diff(context.Background(), DiffOptions{From: first, To: third, RenameOptions: RenameOptions{Renames: true}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"R a.txt b.txt", "A other.txt"}, changeNames(changes))
+}
+
+func TestRenameConfig(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	assert.NoError(t, err)
+	assert.Equal(t, RenameOptions{Renames: true, Limit: DefaultRenameLimit}, repo.RenameConfig("diff.renames"))
+
+	config, err := repo.Config()
+	assert.NoError(t, err)
+	for value, want := range map[string]RenameOptions{
+		"copies": {Renames: true, Copies: true, Limit: 10},
+		"false":  {Limit: 10},
+	} {
+		assert.NoError(t, config.Set("diff.renames", value))
+		assert.NoError(t, config.Set("diff.renameLimit", "10"))
+		assert.NoError(t, config.Save())
+		assert.Equal(t, want, repo.RenameConfig("diff.renames"), value)
+	}
+
+	// as in git, a limit of 0 is none
+	assert.NoError(t, config.Set("diff.renameLimit", "0"))
+	assert.NoError(t, config.Save())
+	assert.Equal(t, -1, repo.RenameConfig().Limit)
+}
@@ -1,6 +1,34 @@
 package gitgo
 
-import "path/filepath"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Vikuuu/gitgo/internal/workpool"
+)
+
+var ErrNotARepository = errors.New("not a gitgo repository")
+
+// Metadata directory names. gitgo keeps its own by default, GitDir
+// lets it work on repositories shared with git.
+const (
+	GitgoDir = ".gitgo"
+	GitDir   = ".git"
+)
+
+// RepositoryDirs are the directories Init creates inside .gitgo.
+var RepositoryDirs = []string{"objects", "refs"}
+
+// gitRepositoryDirs are the ones git itself expects in .git.
+var gitRepositoryDirs = []string{"objects/info", "objects/pack", "refs/heads", "refs/tags"}
 
 type Repository struct {
 	Path     string
@@ -8,14 +36,358 @@ type Repository struct {
 	Database string
 	Index    string
 	Refs     string
+
+	// Store overrides where objects are kept, when nil the loose
+	// objects under Database are used.
+	Store ObjectStore
+	// Workers is the number of files hashed in parallel, zero uses
+	// GOMAXPROCS.
+	Workers int
+	// Progress, when set, is told how long operations are going.
+	Progress Progress
+	// RemoteProgress, when set, gets the progress messages servers
+	// send while fetching.
+	RemoteProgress io.Writer
+	// Locks, when set, is how the index lock is taken instead of
+	// DefaultLockOptions.
+	Locks *LockOptions
+}
+
+// holdIndex locks and loads the index of the repository, as Locks
+// says.
+func (r Repository) holdIndex() (bool, *Index, error) {
+	opts := defaultLockOptions
+	if r.Locks != nil {
+		opts = *r.Locks
+	}
+	return IndexHoldForUpdateWithOptions(r.Path, r.GitPath, opts)
 }
 
 func NewRepository(path string) Repository {
+	return NewRepositoryWithGitDir(path, GitgoDir)
+}
+
+// NewRepositoryWithGitDir returns the repository at path keeping its
+// metadata in the directory gitDir, GitgoDir or GitDir.
+func NewRepositoryWithGitDir(path, gitDir string) Repository {
 	return Repository{
 		Path:     path,
-		GitPath:  filepath.Join(path, ".gitgo"),
-		Database: filepath.Join(path, ".gitgo", "objects"),
-		Index:    filepath.Join(path, ".gitgo", "index"),
-		Refs:     filepath.Join(path, ".gitgo"),
+		GitPath:  filepath.Join(path, gitDir),
+		Database: filepath.Join(path, gitDir, "objects"),
+		Index:    filepath.Join(path, gitDir, "index"),
+		Refs:     filepath.Join(path, gitDir),
+	}
+}
+
+// Init creates an empty repository in path, running it again on an
+// existing repository is harmless.
+func Init(path string) (*Repository, error) {
+	return InitWithGitDir(path, GitgoDir)
+}
+
+// InitWithGitDir is Init keeping the metadata in gitDir. A GitDir
+// repository gets the layout, HEAD and config git creates, so that git
+// can use it too.
+func InitWithGitDir(path, gitDir string) (*Repository, error) {
+	repo := NewRepositoryWithGitDir(path, gitDir)
+	dirs := RepositoryDirs
+	if gitDir == GitDir {
+		dirs = gitRepositoryDirs
+	}
+	for _, dir := range dirs {
+		if err := os.MkdirAll(filepath.Join(repo.GitPath, filepath.FromSlash(dir)), 0755); err != nil {
+			return nil, err
+		}
+	}
+	if gitDir != GitDir {
+		return &repo, nil
+	}
+
+	refs := RefInitialize(repo.Refs)
+	if _, err := os.Stat(refs.HeadPath()); os.IsNotExist(err) {
+		if err := refs.SetHeadRef("refs/heads/" + DefaultBranch); err != nil {
+			return nil, err
+		}
+	}
+	config, err := repo.Config()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := config.Get("core.repositoryformatversion"); !ok {
+		config.Set("core.repositoryformatversion", "0")
+		config.Set("core.filemode", "true")
+		config.Set("core.bare", "false")
+		config.Set("core.logallrefupdates", "true")
+		if err := config.Save(); err != nil {
+			return nil, err
+		}
+	}
+	return &repo, nil
+}
+
+// Open returns the repository whose workspace is path, using its
+// .gitgo directory or, when there is none, its .git directory.
+func Open(path string) (*Repository, error) {
+	for _, gitDir := range []string{GitgoDir, GitDir} {
+		if repo, err := OpenWithGitDir(path, gitDir); err == nil {
+			return repo, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNotARepository, path)
+}
+
+func OpenWithGitDir(path, gitDir string) (*Repository, error) {
+	repo := NewRepositoryWithGitDir(path, gitDir)
+	stat, err := os.Stat(repo.GitPath)
+	if err != nil || !stat.IsDir() {
+		return nil, fmt.Errorf("%w: %s", ErrNotARepository, path)
+	}
+	return &repo, nil
+}
+
+// ObjectDatabase returns a Database backed by the repository's store.
+// In a shallow repository, the commits whose parents are missing are
+// read as having none.
+func (r Repository) ObjectDatabase() *Database {
+	var db *Database
+	if r.Store != nil {
+		db = NewDatabaseWithStore(r.Store)
+	} else {
+		db = NewDatabase(r.Database)
+	}
+	if shallow, err := r.Shallow(); err == nil && len(shallow) > 0 {
+		db = db.withShallow(shallow)
+	}
+	return db
+}
+
+func (r Repository) ConfigPath() string {
+	return filepath.Join(r.GitPath, "config")
+}
+
+func (r Repository) Config() (*Config, error) {
+	return LoadConfig(r.ConfigPath())
+}
+
+// AddError reports the file that could not be added.
+type AddError struct {
+	Path string
+	Err  error
+}
+
+func (e *AddError) Error() string { return fmt.Sprintf("%s: %v", e.Path, e.Err) }
+
+func (e *AddError) Unwrap() error { return e.Err }
+
+// Add stores the files found under paths, relative to the workspace
+// unless absolute, and records them in the index. It returns the
+// workspace relative paths that were added.
+func (r Repository) Add(paths ...string) ([]string, error) {
+	return r.AddContext(context.Background(), paths...)
+}
+
+// AddContext is Add that stops once ctx is done, giving the index lock
+// up without changing the index. Blobs already stored are kept.
+func (r Repository) AddContext(ctx context.Context, paths ...string) ([]string, error) {
+	return r.add(ctx, false, paths)
+}
+
+// AddAll is Add that also removes from the index the tracked files
+// under paths that are gone from the workspace, like `git add -A`.
+func (r Repository) AddAll(paths ...string) ([]string, error) {
+	return r.AddAllContext(context.Background(), paths...)
+}
+
+// AddAllContext is AddAll that stops once ctx is done, like
+// AddContext.
+func (r Repository) AddAllContext(ctx context.Context, paths ...string) ([]string, error) {
+	return r.add(ctx, true, paths)
+}
+
+// add records paths in the index and, with removals, the removal of
+// the files under them that are gone.
+func (r Repository) add(ctx context.Context, removals bool, paths []string) ([]string, error) {
+	database := r.ObjectDatabase()
+	_, index, err := r.holdIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	var filePaths, removed []string
+	for _, path := range paths {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(r.Path, path)
+		}
+		if removals {
+			gone, err := r.removedFiles(index, path)
+			if err != nil {
+				index.Release()
+				return nil, err
+			}
+			removed = append(removed, gone...)
+			if _, err := os.Lstat(path); os.IsNotExist(err) && len(gone) > 0 {
+				continue
+			}
+		}
+		expandPaths, err := ListFilesContext(ctx, path, r.Path)
+		if err != nil {
+			index.Release()
+			return nil, err
+		}
+		filePaths = append(filePaths, expandPaths...)
+	}
+
+	// Hash and store the blobs in parallel, the index is then
+	// updated in the order the paths were given.
+	type added struct {
+		oid  string
+		stat os.FileInfo
+	}
+	results := make([]added, len(filePaths))
+	progress := newProgressCounter(r.Progress, "Adding files", len(filePaths))
+	err = workpool.RunContext(ctx, workpool.Size(r.Workers), len(filePaths), func(i int) error {
+		oid, stat, err := storeFile(ctx, database, filepath.Join(r.Path, filePaths[i]))
+		if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			return &AddError{Path: filePaths[i], Err: err}
+		}
+		results[i] = added{oid: oid, stat: stat}
+		progress.add()
+		return nil
+	})
+	if err != nil {
+		index.Release()
+		return nil, err
+	}
+
+	for _, p := range removed {
+		index.remove(p)
+	}
+	for i, p := range filePaths {
+		index.Add(p, results[i].oid, results[i].stat)
+	}
+	if _, err := index.WriteUpdate(); err != nil {
+		return nil, err
+	}
+	return filePaths, nil
+}
+
+// removedFiles returns the files of the index at or below path that
+// are gone from the workspace, whose removal AddAll stages.
+func (r Repository) removedFiles(index *Index, path string) ([]string, error) {
+	rel, err := r.relPath(path)
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for name := range index.IndexEntries() {
+		if rel != "." && name != rel && !strings.HasPrefix(name, rel+"/") {
+			continue
+		}
+		if _, err := os.Lstat(filepath.Join(r.Path, name)); os.IsNotExist(err) {
+			removed = append(removed, name)
+		} else if err != nil && !errors.Is(err, syscall.ENOTDIR) {
+			return nil, err
+		}
+	}
+	slices.Sort(removed)
+	return removed, nil
+}
+
+func storeFile(ctx context.Context, database *Database, path string) (string, os.FileInfo, error) {
+	// a nested repository is recorded by its commit, which is not
+	// stored here
+	if oid, stat, ok, err := gitlinkOID(path); ok || err != nil {
+		return oid, stat, err
+	}
+
+	r, size, stat, err := openBlob(path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer r.Close()
+
+	oid, err := database.StoreStreamContext(ctx, TypeFile, size, r)
+	return oid, stat, err
+}
+
+type CommitOptions struct {
+	Name    string
+	Email   string
+	Message string
+	// When is the commit time, the current time when zero.
+	When time.Time
+	// Author is the author when not the committer, like the one of a
+	// cherry-picked commit.
+	Author *Signature
+	// Amend replaces HEAD with the new commit, made on the parent of
+	// HEAD instead of HEAD itself.
+	Amend bool
+}
+
+type CommitResult struct {
+	OID  string
+	Tree string
+	// Parent is empty for the root commit.
+	Parent string
+}
+
+// Commit writes the index as a tree, reusing the subtrees cached in
+// it, and moves HEAD to a new commit of that tree. It concludes the
+// commit a cherry-pick or revert stopped at, if any.
+func (r Repository) Commit(opts CommitOptions) (*CommitResult, error) {
+	return r.CommitContext(context.Background(), opts)
+}
+
+// CommitContext is Commit that stops once ctx is done, before HEAD is
+// moved and with the index lock given up.
+func (r Repository) CommitContext(ctx context.Context, opts CommitOptions) (*CommitResult, error) {
+	database := r.ObjectDatabase()
+	_, index, err := r.holdIndex()
+	if err != nil {
+		return nil, err
+	}
+	treeHash, err := index.WriteTreeContext(ctx, database)
+	if err != nil {
+		index.Release()
+		return nil, err
+	}
+	if _, err := index.WriteUpdate(); err != nil {
+		return nil, err
+	}
+
+	when := opts.When
+	if when.IsZero() {
+		when = time.Now()
+	}
+	committer := AuthorData(opts.Name, opts.Email, when)
+	author := committer
+	if opts.Author != nil {
+		author = opts.Author.String()
+	}
+	refs := RefInitialize(r.Refs)
+	parent := refs.ReadHead()
+	if opts.Amend && parent != "" {
+		head, err := database.ReadCommit(parent)
+		if err != nil {
+			return nil, err
+		}
+		parent = head.Parent()
+	}
+
+	database.Data(TypeCommit, commitData(parent, treeHash, author, committer, opts.Message))
+	cHash, err := database.StoreContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := refs.UpdateHead([]byte(cHash)); err != nil {
+		return nil, err
+	}
+	// the commit concludes a cherry-pick or revert stopped by conflicts
+	if err := r.removePickHead(); err != nil {
+		return nil, err
 	}
+	return &CommitResult{OID: cHash, Tree: treeHash, Parent: parent}, nil
 }
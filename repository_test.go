@@ -0,0 +1,249 @@
+package gitgo
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenRequiresRepository(t *testing.T) {
+	_, err := Open(t.TempDir())
+	assert.ErrorIs(t, err, ErrNotARepository)
+}
+
+func TestRepositoryAddCommitStatus(t *testing.T) {
+	dir := t.TempDir()
+	_, err := Init(dir)
+	assert.NoError(t, err)
+	repo, err := Open(dir)
+	assert.NoError(t, err)
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "a"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "1.txt"), []byte("one"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "a", "2.txt"), []byte("two"), 0644))
+
+	added, err := repo.Add(".")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"1.txt", filepath.Join("a", "2.txt")}, added)
+
+	first, err := repo.Commit(CommitOptions{
+		Name:    "A U Thor",
+		Email:   "author@example.com",
+		Message: "first\n",
+		When:    time.Unix(1700000000, 0),
+	})
+	assert.NoError(t, err)
+	assert.Empty(t, first.Parent)
+
+	commit, err := repo.ObjectDatabase().ReadCommit(first.OID)
+	assert.NoError(t, err)
+	assert.Equal(t, first.Tree, commit.Tree)
+	assert.Equal(t, "first\n", commit.Message)
+
+	status, err := repo.Status()
+	assert.NoError(t, err)
+	assert.Empty(t, status.Changed)
+	assert.Empty(t, status.Untracked)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "1.txt"), []byte("changed"), 0644))
+	assert.NoError(t, os.Remove(filepath.Join(dir, "a", "2.txt")))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "new.txt"), nil, 0644))
+
+	status, err = repo.Status()
+	assert.NoError(t, err)
+	assert.Equal(t, []StatusEntry{
+		{Path: "1.txt", Workspace: Modified},
+		{Path: filepath.Join("a", "2.txt"), Workspace: Deleted},
+	}, status.Changed)
+	assert.Equal(t, []string{"new.txt"}, status.Untracked)
+
+	_, err = repo.Add("1.txt")
+	assert.NoError(t, err)
+	second, err := repo.Commit(CommitOptions{Message: "second\n"})
+	assert.NoError(t, err)
+	assert.Equal(t, first.OID, second.Parent)
+}
+
+func TestAddContextCancelled(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := Init(dir)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "1.txt"), []byte("one"), 0644))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = repo.AddContext(ctx, ".")
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// the lock is given up and no index got written
+	_, err = os.Stat(filepath.Join(repo.GitPath, "index.lock"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(repo.GitPath, "index"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestAddReportsProgress(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := Init(dir)
+	assert.NoError(t, err)
+	for i := range 5 {
+		name := filepath.Join(dir, fmt.Sprintf("%d.txt", i))
+		assert.NoError(t, os.WriteFile(name, []byte(name), 0644))
+	}
+
+	var done []int
+	repo.Progress = ProgressFunc(func(op string, n, total int) {
+		assert.Equal(t, "Adding files", op)
+		assert.Equal(t, 5, total)
+		done = append(done, n)
+	})
+	_, err = repo.Add(".")
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5}, done)
+}
+
+func TestSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := Init(dir)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "target.txt"), []byte("target"), 0644))
+	assert.NoError(t, os.Symlink("target.txt", filepath.Join(dir, "link")))
+
+	_, err = repo.Add(".")
+	assert.NoError(t, err)
+
+	index := NewIndex(dir, repo.GitPath)
+	assert.NoError(t, index.Load())
+	entry := index.IndexEntries()["link"]
+	assert.Equal(t, symlinkMode, entry.Mode)
+	_, data, err := repo.ObjectDatabase().ReadObject(entry.Oid)
+	assert.NoError(t, err)
+	assert.Equal(t, "target.txt", string(data))
+
+	res, err := repo.Commit(CommitOptions{Message: "links\n"})
+	assert.NoError(t, err)
+	tree, err := repo.ObjectDatabase().ReadTree(res.Tree)
+	assert.NoError(t, err)
+	assert.Contains(t, tree, TreeEntry{Name: "link", Mode: symlinkMode, OID: entry.Oid})
+
+	// pointing the link somewhere else is a change, even though the
+	// new target has the same length
+	assert.NoError(t, os.Remove(filepath.Join(dir, "link")))
+	assert.NoError(t, os.Symlink("target.md!", filepath.Join(dir, "link")))
+	status, err := repo.Status()
+	assert.NoError(t, err)
+	assert.Equal(t, []StatusEntry{{Path: "link", Workspace: Modified}}, status.Changed)
+
+	restored, err := repo.Checkout("link")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"link"}, restored)
+	target, err := os.Readlink(filepath.Join(dir, "link"))
+	assert.NoError(t, err)
+	assert.Equal(t, "target.txt", target)
+
+	status, err = repo.Status()
+	assert.NoError(t, err)
+	assert.Empty(t, status.Changed)
+}
+
+func TestAddAllStagesRemovals(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	assert.NoError(t, err)
+	writeFile(t, repo, "dir/b.txt", "b\n")
+	_, err = repo.Add("dir")
+	assert.NoError(t, err)
+	commitFile(t, repo, "a.txt", "a\n")
+	assert.NoError(t, os.Remove(filepath.Join(repo.Path, "a.txt")))
+	assert.NoError(t, os.RemoveAll(filepath.Join(repo.Path, "dir")))
+
+	// only asked for, the removal of a file is staged
+	_, err = repo.Add("a.txt")
+	assert.Error(t, err)
+	assert.Equal(t, []string{" D a.txt", " D dir/b.txt"}, shortStatus(t, repo))
+	_, err = repo.AddAll("a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"D  a.txt", " D dir/b.txt"}, shortStatus(t, repo))
+	_, err = repo.AddAll(".")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"D  a.txt", "D  dir/b.txt"}, shortStatus(t, repo))
+}
+
+func TestCheckoutUnknownPath(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	assert.NoError(t, err)
+	_, err = repo.Checkout("missing.txt")
+	assert.ErrorIs(t, err, ErrPathspecMismatch)
+}
+
+func TestCheckoutRejectsMetadataEntries(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	assert.NoError(t, err)
+	head := commitFile(t, repo, "a.txt", "a\n")
+	db := repo.ObjectDatabase()
+	commit, err := db.ReadCommit(head)
+	assert.NoError(t, err)
+	blob, err := hex.DecodeString(randomOID())
+	assert.NoError(t, err)
+
+	for _, name := range []string{".git", ".GIT", ".gitgo", ".GitGo"} {
+		tree, err := hex.DecodeString(commit.Tree)
+		assert.NoError(t, err)
+		db.Data(TypeTree, []byte("40000 "+name+"\x00"+string(tree)+"100644 a.txt\x00"+string(blob)))
+		oid, err := db.Store()
+		assert.NoError(t, err)
+		db.Data(TypeCommit, []byte("tree "+oid+"\n\nbad\n"))
+		bad, err := db.Store()
+		assert.NoError(t, err)
+
+		err = repo.CheckoutCommit(context.Background(), bad)
+		assert.ErrorIs(t, err, ErrCorruptObject, name)
+		assert.Equal(t, "a\n", readFile(t, repo, "a.txt"))
+	}
+}
+
+func TestCheckoutRejectsSymlinkThenTree(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	assert.NoError(t, err)
+	outside := t.TempDir()
+	db := repo.ObjectDatabase()
+	store := func(typ BlobType, data string) []byte {
+		db.Data(typ, []byte(data))
+		oid, err := db.Store()
+		assert.NoError(t, err)
+		raw, err := hex.DecodeString(oid)
+		assert.NoError(t, err)
+		return raw
+	}
+
+	link := store(TypeFile, outside)
+	sub := store(TypeTree, "100644 x\x00"+string(store(TypeFile, "x\n")))
+	tree := store(TypeTree, "120000 a\x00"+string(link)+"40000 a\x00"+string(sub))
+	db.Data(TypeCommit, []byte("tree "+hex.EncodeToString(tree)+"\n\nbad\n"))
+	bad, err := db.Store()
+	assert.NoError(t, err)
+
+	err = repo.CheckoutCommit(context.Background(), bad)
+	assert.ErrorIs(t, err, ErrCorruptObject)
+	assert.NoFileExists(t, filepath.Join(outside, "x"))
+}
+
+func TestCheckoutRefusesSymlinkedDirectory(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	assert.NoError(t, err)
+	outside := t.TempDir()
+	assert.NoError(t, os.Mkdir(filepath.Join(repo.Path, "a"), 0755))
+	head := commitFile(t, repo, "a/x", "x\n")
+
+	assert.NoError(t, os.RemoveAll(filepath.Join(repo.Path, "a")))
+	assert.NoError(t, os.Symlink(outside, filepath.Join(repo.Path, "a")))
+	assert.Error(t, repo.CheckoutCommit(context.Background(), head))
+	_, err = repo.Checkout("a/x")
+	assert.Error(t, err)
+	assert.NoFileExists(t, filepath.Join(outside, "x"))
+}
@@ -0,0 +1,145 @@
+package gitgo
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrUnknownRevision   = errors.New("unknown revision")
+	ErrAmbiguousRevision = errors.New("ambiguous revision")
+)
+
+// ResolveRevision returns the commit rev names: HEAD, a ref, a branch,
+// tag or remote-tracking branch name, or a full or abbreviated oid,
+// followed by any number of ~<n> for the n-th first parent and ^<n>
+// for the n-th parent.
+func (r Repository) ResolveRevision(rev string) (string, error) {
+	base, suffix := rev, ""
+	if i := strings.IndexAny(rev, "~^"); i >= 0 {
+		base, suffix = rev[:i], rev[i:]
+	}
+	oid, err := r.resolveName(base)
+	if err != nil {
+		return "", err
+	}
+	db := r.ObjectDatabase()
+	commit, err := db.ReadCommit(oid)
+	if err != nil {
+		return "", fmt.Errorf("%w: '%s' is not a commit", ErrUnknownRevision, rev)
+	}
+
+	for suffix != "" {
+		op := suffix[0]
+		end := 1
+		for end < len(suffix) && suffix[end] >= '0' && suffix[end] <= '9' {
+			end++
+		}
+		n := 1
+		if end > 1 {
+			if n, err = strconv.Atoi(suffix[1:end]); err != nil {
+				return "", fmt.Errorf("%w: '%s'", ErrUnknownRevision, rev)
+			}
+		}
+		suffix = suffix[end:]
+
+		steps := []int{n}
+		if op == '~' {
+			// n times the first parent
+			steps = make([]int, n)
+			for i := range steps {
+				steps[i] = 1
+			}
+		}
+		for _, parent := range steps {
+			if parent == 0 {
+				continue
+			}
+			if parent > len(commit.Parents) {
+				return "", fmt.Errorf("%w: '%s'", ErrUnknownRevision, rev)
+			}
+			oid = commit.Parents[parent-1]
+			if commit, err = db.ReadCommit(oid); err != nil {
+				return "", err
+			}
+		}
+	}
+	return oid, nil
+}
+
+// resolveName returns the object name stands for, without suffixes.
+func (r Repository) resolveName(name string) (string, error) {
+	refs := RefInitialize(r.Refs)
+	if name == "HEAD" || name == "@" {
+		if oid := refs.ReadHead(); oid != "" {
+			return oid, nil
+		}
+		return "", fmt.Errorf("%w: HEAD", ErrUnknownRevision)
+	}
+	if ref, err := r.expandRef(name); err != nil {
+		return "", err
+	} else if ref != "" {
+		return refs.ReadRef(ref)
+	}
+	if validOID(name) == nil {
+		return name, nil
+	}
+	return r.expandOID(name)
+}
+
+// expandRef returns the full name of the ref name stands for, trying
+// it as is and under refs/, refs/tags/, refs/heads/ and refs/remotes/
+// like git does. It is empty when there is no such ref.
+func (r Repository) expandRef(name string) (string, error) {
+	if name == "" {
+		return "", nil
+	}
+	refs := RefInitialize(r.Refs)
+	for _, ref := range []string{
+		name,
+		"refs/" + name,
+		"refs/tags/" + name,
+		"refs/heads/" + name,
+		"refs/remotes/" + name,
+		"refs/remotes/" + name + "/HEAD",
+	} {
+		if validRefName(ref) != nil {
+			continue
+		}
+		if oid, err := refs.ReadRef(ref); err != nil {
+			return "", err
+		} else if oid != "" {
+			return ref, nil
+		}
+	}
+	return "", nil
+}
+
+// expandOID returns the only object whose oid starts with prefix, of at
+// least 4 hex digits.
+func (r Repository) expandOID(prefix string) (string, error) {
+	if len(prefix) < 4 || len(prefix) > 40 || strings.Trim(strings.ToLower(prefix), "0123456789abcdef") != "" {
+		return "", fmt.Errorf("%w: '%s'", ErrUnknownRevision, prefix)
+	}
+	prefix = strings.ToLower(prefix)
+	var found string
+	err := r.ObjectDatabase().ObjectStore().Iterate(func(oid string) error {
+		if !strings.HasPrefix(oid, prefix) {
+			return nil
+		}
+		if found != "" {
+			return fmt.Errorf("%w: '%s'", ErrAmbiguousRevision, prefix)
+		}
+		found = oid
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if found == "" {
+		return "", fmt.Errorf("%w: '%s'", ErrUnknownRevision, prefix)
+	}
+	return found, nil
+}
@@ -0,0 +1,51 @@
+package gitgo
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveRevision(t *testing.T) {
+	repo, err := Init(filepath.Join(t.TempDir(), "repo"))
+	assert.NoError(t, err)
+	_, err = repo.ResolveRevision("HEAD")
+	assert.ErrorIs(t, err, ErrUnknownRevision)
+
+	first := commitFile(t, repo, "a.txt", "one")
+	second := commitFile(t, repo, "a.txt", "two")
+	third := commitFile(t, repo, "a.txt", "three")
+	refs := RefInitialize(repo.Refs)
+	assert.NoError(t, refs.UpdateRef("refs/heads/topic", second))
+	assert.NoError(t, refs.UpdateRef("refs/remotes/origin/master", first))
+
+	for rev, want := range map[string]string{
+		"HEAD":                       third,
+		"@":                          third,
+		"master":                     third,
+		"refs/heads/topic":           second,
+		"heads/topic":                second,
+		"topic":                      second,
+		"origin/master":              first,
+		"HEAD~":                      second,
+		"HEAD~2":                     first,
+		"master^":                    second,
+		"HEAD^^":                     first,
+		"HEAD~1^1":                   first,
+		"topic^0":                    second,
+		third:                        third,
+		third[:7]:                    third,
+		second[:4] + "~1":            first,
+		"refs/remotes/origin/master": first,
+	} {
+		got, err := repo.ResolveRevision(rev)
+		assert.NoError(t, err, rev)
+		assert.Equal(t, want, got, rev)
+	}
+
+	for _, rev := range []string{"nope", "HEAD~3", "HEAD^2", "abc", "zzzz", "a.txt"} {
+		_, err := repo.ResolveRevision(rev)
+		assert.ErrorIs(t, err, ErrUnknownRevision, rev)
+	}
+}
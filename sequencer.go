@@ -0,0 +1,429 @@
+package gitgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	ErrSequencerRunning = errors.New("a cherry-pick or revert is already in progress")
+	ErrNoSequencer      = errors.New("no cherry-pick or revert in progress")
+)
+
+// PickOptions are the settings of a cherry-pick or a revert.
+type PickOptions struct {
+	// Name, Email and When are the committer of the new commits, When
+	// being the current time when zero.
+	Name, Email string
+	When        time.Time
+	// RecordOrigin adds the commit a cherry-pick copies to its message,
+	// like git's -x. It is kept for the commits picked after a stop.
+	RecordOrigin bool
+}
+
+// PickError stops a cherry-pick, revert or rebase at Commit, which
+// conflicts with HEAD on Paths or, without any, leaves nothing to
+// commit. The sequencer waits for ContinuePick, SkipPick or AbortPick,
+// a rebase for ContinueRebase, SkipRebase or AbortRebase.
+type PickError struct {
+	Revert  bool
+	Commit  string
+	Subject string
+	Paths   []string
+}
+
+func (e *PickError) Error() string {
+	if len(e.Paths) == 0 {
+		return fmt.Sprintf("%s... %s leaves nothing to commit", e.Commit[:7], e.Subject)
+	}
+	if e.Revert {
+		return fmt.Sprintf("could not revert %s... %s", e.Commit[:7], e.Subject)
+	}
+	return fmt.Sprintf("could not apply %s... %s", e.Commit[:7], e.Subject)
+}
+
+// pickStep is a line of the sequencer todo list, "pick <oid>" or
+// "revert <oid>" followed by the subject of the commit.
+type pickStep struct {
+	revert bool
+	oid    string
+}
+
+// CherryPick commits the changes the commits revs made on top of HEAD,
+// in order, with their author and message. It returns the commits it
+// made, those before a *PickError stopping it included.
+func (r Repository) CherryPick(ctx context.Context, revs []string, opts PickOptions) ([]string, error) {
+	return r.startSequence(ctx, false, revs, opts)
+}
+
+// Revert commits the changes undoing the commits revs on top of HEAD,
+// in order, like CherryPick.
+func (r Repository) Revert(ctx context.Context, revs []string, opts PickOptions) ([]string, error) {
+	return r.startSequence(ctx, true, revs, opts)
+}
+
+func (r Repository) sequencerDir() string {
+	return filepath.Join(r.GitPath, "sequencer")
+}
+
+// pickHeadPath is the file naming the commit the sequencer stopped at.
+func (r Repository) pickHeadPath(revert bool) string {
+	if revert {
+		return filepath.Join(r.GitPath, "REVERT_HEAD")
+	}
+	return filepath.Join(r.GitPath, "CHERRY_PICK_HEAD")
+}
+
+func (r Repository) startSequence(ctx context.Context, revert bool, revs []string, opts PickOptions) ([]string, error) {
+	if _, err := os.Stat(r.sequencerDir()); err == nil {
+		return nil, ErrSequencerRunning
+	}
+	head := RefInitialize(r.Refs).ReadHead()
+	if head == "" {
+		return nil, fmt.Errorf("cannot pick commits onto an unborn branch")
+	}
+	db := r.ObjectDatabase()
+	var todo []pickStep
+	for _, rev := range revs {
+		oid, err := r.ResolveRevision(rev)
+		if err != nil {
+			return nil, err
+		}
+		commit, err := db.ReadCommit(oid)
+		if err != nil {
+			return nil, err
+		}
+		if len(commit.Parents) > 1 {
+			return nil, fmt.Errorf("commit %s is a merge, which cannot be picked", oid)
+		}
+		todo = append(todo, pickStep{revert: revert, oid: oid})
+	}
+
+	if err := os.MkdirAll(r.sequencerDir(), 0755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(r.sequencerDir(), "head"), []byte(head+"\n"), 0644); err != nil {
+		return nil, err
+	}
+	config := NewConfig(filepath.Join(r.sequencerDir(), "opts"))
+	if opts.RecordOrigin {
+		config.Set("options.record-origin", "true")
+	}
+	if err := config.Save(); err != nil {
+		return nil, err
+	}
+	if err := r.writeTodo(todo); err != nil {
+		return nil, err
+	}
+
+	commits, err := r.runSequence(ctx, opts)
+	// nothing happened when the first commit could not even start
+	var perr *PickError
+	if err != nil && len(commits) == 0 && !errors.As(err, &perr) {
+		os.RemoveAll(r.sequencerDir())
+	}
+	return commits, err
+}
+
+// ContinuePick commits the resolution of the conflicts the sequencer
+// stopped at, with the message of the commit picked, and picks the
+// commits left.
+func (r Repository) ContinuePick(ctx context.Context, opts PickOptions) ([]string, error) {
+	if _, err := os.Stat(r.sequencerDir()); err != nil {
+		return nil, ErrNoSequencer
+	}
+	config, err := LoadConfig(filepath.Join(r.sequencerDir(), "opts"))
+	if err != nil {
+		return nil, err
+	}
+	if opts.RecordOrigin, err = config.GetBool("options.record-origin", opts.RecordOrigin); err != nil {
+		return nil, err
+	}
+
+	var commits []string
+	for _, revert := range []bool{false, true} {
+		data, err := os.ReadFile(r.pickHeadPath(revert))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		oid := strings.TrimSpace(string(data))
+		commit, err := r.ObjectDatabase().ReadCommit(oid)
+		if err != nil {
+			return nil, err
+		}
+		message, author, err := r.pickMessage(pickStep{revert: revert, oid: oid}, commit, opts)
+		if err != nil {
+			return nil, err
+		}
+		if data, err := os.ReadFile(filepath.Join(r.GitPath, "MERGE_MSG")); err == nil {
+			message = string(data)
+		}
+		res, err := r.CommitContext(ctx, CommitOptions{
+			Name:    opts.Name,
+			Email:   opts.Email,
+			When:    opts.When,
+			Message: message,
+			Author:  author,
+		})
+		if err != nil {
+			return nil, err
+		}
+		commits = append(commits, res.OID)
+	}
+
+	more, err := r.runSequence(ctx, opts)
+	return append(commits, more...), err
+}
+
+// SkipPick drops the commit the sequencer stopped at, along with the
+// changes it made to the index and the workspace, and picks the
+// commits left.
+func (r Repository) SkipPick(ctx context.Context, opts PickOptions) ([]string, error) {
+	if _, err := os.Stat(r.sequencerDir()); err != nil {
+		return nil, ErrNoSequencer
+	}
+	stopped := false
+	for _, revert := range []bool{false, true} {
+		if _, err := os.Stat(r.pickHeadPath(revert)); err == nil {
+			stopped = true
+		}
+	}
+	if stopped {
+		if err := r.resetMerge(ctx, "HEAD"); err != nil {
+			return nil, err
+		}
+		if err := r.removePickHead(); err != nil {
+			return nil, err
+		}
+	} else {
+		// the first commit left could not start
+		todo, err := r.readTodo()
+		if err != nil {
+			return nil, err
+		}
+		if len(todo) > 0 {
+			if err := r.writeTodo(todo[1:]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return r.ContinuePick(ctx, opts)
+}
+
+// AbortPick gives up the cherry-pick or revert in progress, taking
+// HEAD, the index and the workspace back to where it started.
+func (r Repository) AbortPick(ctx context.Context) error {
+	data, err := os.ReadFile(filepath.Join(r.sequencerDir(), "head"))
+	if os.IsNotExist(err) {
+		return ErrNoSequencer
+	} else if err != nil {
+		return err
+	}
+	head := strings.TrimSpace(string(data))
+	if err := r.resetMerge(ctx, head); err != nil {
+		return err
+	}
+	if err := RefInitialize(r.Refs).UpdateHead([]byte(head)); err != nil {
+		return err
+	}
+	if err := r.removePickHead(); err != nil {
+		return err
+	}
+	return os.RemoveAll(r.sequencerDir())
+}
+
+// runSequence picks the commits of the todo list until it is empty,
+// then removes the sequencer state, or until one stops.
+func (r Repository) runSequence(ctx context.Context, opts PickOptions) ([]string, error) {
+	var commits []string
+	for {
+		todo, err := r.readTodo()
+		if err != nil {
+			return commits, err
+		}
+		if len(todo) == 0 {
+			return commits, os.RemoveAll(r.sequencerDir())
+		}
+		oid, err := r.pick(ctx, todo, opts)
+		if oid != "" {
+			commits = append(commits, oid)
+		}
+		if err != nil {
+			return commits, err
+		}
+	}
+}
+
+// pick merges the changes of the first commit of todo, or their
+// reverse, into HEAD and commits them. The commit leaves the todo list
+// once merged, a conflict leaving it in CHERRY_PICK_HEAD or
+// REVERT_HEAD with its message in MERGE_MSG.
+func (r Repository) pick(ctx context.Context, todo []pickStep, opts PickOptions) (string, error) {
+	step := todo[0]
+	db := r.ObjectDatabase()
+	commit, err := db.ReadCommit(step.oid)
+	if err != nil {
+		return "", err
+	}
+	files, err := db.ListTree(commit.Tree)
+	if err != nil {
+		return "", err
+	}
+	var parent []TreeEntry
+	if commit.Parent() != "" {
+		if parent, err = r.commitFiles(commit.Parent()); err != nil {
+			return "", err
+		}
+	}
+	ours, err := r.commitFiles("HEAD")
+	if err != nil {
+		return "", err
+	}
+
+	subject := FirstLine(commit.Message)
+	base, theirs := parent, files
+	labels := mergeLabels{ours: "HEAD", theirs: step.oid[:7] + " (" + subject + ")"}
+	if step.revert {
+		base, theirs = files, parent
+		labels.theirs = "parent of " + labels.theirs
+	}
+	message, author, err := r.pickMessage(step, commit, opts)
+	if err != nil {
+		return "", err
+	}
+
+	merged, conflicts, err := mergeFiles(db, base, ours, theirs, labels)
+	if err != nil {
+		return "", err
+	}
+	if err := r.applyMerge(ctx, ours, merged, conflicts); err != nil {
+		return "", err
+	}
+	if err := r.writeTodo(todo[1:]); err != nil {
+		return "", err
+	}
+
+	if len(conflicts) > 0 || len(diffFiles(ours, merged)) == 0 {
+		if err := os.WriteFile(r.pickHeadPath(step.revert), []byte(step.oid+"\n"), 0644); err != nil {
+			return "", err
+		}
+		if err := os.WriteFile(filepath.Join(r.GitPath, "MERGE_MSG"), []byte(message), 0644); err != nil {
+			return "", err
+		}
+		perr := &PickError{Revert: step.revert, Commit: step.oid, Subject: subject}
+		for _, c := range conflicts {
+			perr.Paths = append(perr.Paths, c.Path)
+		}
+		return "", perr
+	}
+
+	res, err := r.CommitContext(ctx, CommitOptions{
+		Name:    opts.Name,
+		Email:   opts.Email,
+		When:    opts.When,
+		Message: message,
+		Author:  author,
+	})
+	if err != nil {
+		return "", err
+	}
+	return res.OID, nil
+}
+
+// pickMessage is the message and the author of the commit made by a
+// step: those of the commit for a cherry-pick, with its origin when
+// recorded, and for a revert a message naming it and the committer as
+// author.
+func (r Repository) pickMessage(step pickStep, commit *Commit, opts PickOptions) (string, *Signature, error) {
+	if step.revert {
+		subject := FirstLine(commit.Message)
+		return fmt.Sprintf("Revert \"%s\"\n\nThis reverts commit %s.\n", subject, step.oid), nil, nil
+	}
+	author, err := ParseSignature(commit.Author)
+	if err != nil {
+		return "", nil, err
+	}
+	message := commit.Message
+	if opts.RecordOrigin {
+		message = recordOrigin(message, step.oid)
+	}
+	return message, &author, nil
+}
+
+// recordOrigin appends the line naming the commit a cherry-pick copies
+// to its message, in the last paragraph when it is made of trailers
+// like "Signed-off-by: ...".
+func recordOrigin(message, oid string) string {
+	message = strings.TrimRight(message, "\n") + "\n"
+	paragraphs := strings.Split(strings.TrimSuffix(message, "\n"), "\n\n")
+	last := paragraphs[len(paragraphs)-1]
+	trailers := len(paragraphs) > 1
+	for _, line := range strings.Split(last, "\n") {
+		key, _, ok := strings.Cut(line, ": ")
+		if !strings.HasPrefix(line, "(cherry picked from commit ") && (!ok || strings.ContainsAny(key, " \t")) {
+			trailers = false
+		}
+	}
+	if !trailers {
+		message += "\n"
+	}
+	return message + "(cherry picked from commit " + oid + ")\n"
+}
+
+func (r Repository) readTodo() ([]pickStep, error) {
+	data, err := os.ReadFile(filepath.Join(r.sequencerDir(), "todo"))
+	if err != nil {
+		return nil, err
+	}
+	var todo []pickStep
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if fields[0] != "pick" && fields[0] != "revert" {
+			return nil, fmt.Errorf("bad sequencer todo line %q", line)
+		}
+		oid, err := r.ResolveRevision(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		todo = append(todo, pickStep{revert: fields[0] == "revert", oid: oid})
+	}
+	return todo, nil
+}
+
+func (r Repository) writeTodo(todo []pickStep) error {
+	db := r.ObjectDatabase()
+	var b strings.Builder
+	for _, step := range todo {
+		commit, err := db.ReadCommit(step.oid)
+		if err != nil {
+			return err
+		}
+		action := "pick"
+		if step.revert {
+			action = "revert"
+		}
+		fmt.Fprintf(&b, "%s %s %s\n", action, step.oid, FirstLine(commit.Message))
+	}
+	return os.WriteFile(filepath.Join(r.sequencerDir(), "todo"), []byte(b.String()), 0644)
+}
+
+// removePickHead drops the files of a cherry-pick or revert stopped at
+// a commit.
+func (r Repository) removePickHead() error {
+	for _, path := range []string{r.pickHeadPath(false), r.pickHeadPath(true), filepath.Join(r.GitPath, "MERGE_MSG")} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package gitgo
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readFile(t *testing.T, repo *Repository, name string) string {
+	data, err := os.ReadFile(filepath.Join(repo.Path, name))
+	assert.NoError(t, err)
+	return string(data)
+}
+
+func TestMergeText(t *testing.T) {
+	labels := mergeLabels{ours: "HEAD", theirs: "theirs"}
+	data, clean := mergeText([]byte("a\nb\nc\n"), []byte("A\nb\nc\n"), []byte("a\nb\nC\n"), labels)
+	assert.True(t, clean)
+	assert.Equal(t, "A\nb\nC\n", string(data))
+
+	data, clean = mergeText([]byte("a\nb\nc"), []byte("a\nB\nc"), []byte("a\nb2\nc"), labels)
+	assert.False(t, clean)
+	assert.Equal(t, "a\n<<<<<<< HEAD\nB\n=======\nb2\n>>>>>>> theirs\nc", string(data))
+
+	// both adding different files
+	data, clean = mergeText(nil, []byte("x"), []byte("y\n"), labels)
+	assert.False(t, clean)
+	assert.Equal(t, "<<<<<<< HEAD\nx\n=======\ny\n>>>>>>> theirs\n", string(data))
+}
+
+func TestUnmergedIndexEntries(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	assert.NoError(t, err)
+	commitFile(t, repo, "a.txt", "one\n")
+
+	_, index, err := IndexHoldForUpdate(repo.Path, repo.GitPath)
+	assert.NoError(t, err)
+	file := func(oid string) *TreeEntry { return &TreeEntry{Name: "a.txt", Mode: regularMode, OID: oid} }
+	index.addUnmerged("a.txt", [3]*TreeEntry{file(emptyBlobOID), file(zeroOID[:39] + "1"), nil})
+	index.addUnmerged("dir/b.txt", [3]*TreeEntry{nil, file(emptyBlobOID), file(emptyBlobOID)})
+	_, err = index.WriteUpdate()
+	assert.NoError(t, err)
+
+	index = NewIndex(repo.Path, repo.GitPath)
+	assert.NoError(t, index.Load())
+	assert.Equal(t, []string{"a.txt", "dir/b.txt"}, index.Unmerged())
+	assert.Empty(t, index.IndexEntries())
+	assert.Equal(t, 2, index.unmerged["a.txt"][1].Stage())
+	assert.Nil(t, index.unmerged["a.txt"][2])
+	assert.True(t, index.IsTracked("dir"))
+	_, err = index.WriteTree(repo.ObjectDatabase())
+	assert.ErrorIs(t, err, ErrUnmergedFiles)
+
+	status, err := repo.Status()
+	assert.NoError(t, err)
+	assert.Equal(t, []StatusEntry{
+		{Path: "a.txt", Index: Unmerged, Workspace: Deleted},
+		{Path: "dir/b.txt", Index: Added, Workspace: Added},
+	}, status.Changed)
+
+	// adding a file resolves it
+	_, err = repo.Add("a.txt")
+	assert.NoError(t, err)
+	index = NewIndex(repo.Path, repo.GitPath)
+	assert.NoError(t, index.Load())
+	assert.Equal(t, []string{"dir/b.txt"}, index.Unmerged())
+}
+
+func TestCherryPickAndRevert(t *testing.T) {
+	ctx := context.Background()
+	repo, err := Init(t.TempDir())
+	assert.NoError(t, err)
+	first := commitFile(t, repo, "a.txt", "one\ntwo\nthree\n")
+	commitFile(t, repo, "b.txt", "b\n")
+	third := commitFile(t, repo, "a.txt", "one\ntwo\nTHREE\n")
+	fourth := commitFile(t, repo, "a.txt", "ONE\ntwo\nTHREE\n")
+
+	assert.NoError(t, repo.CheckoutCommit(ctx, first))
+	opts := PickOptions{Name: "C O Mitter", Email: "c@example.com", When: time.Unix(1700000000, 0)}
+	commits, err := repo.CherryPick(ctx, []string{fourth, third}, opts)
+	assert.NoError(t, err)
+	assert.Len(t, commits, 2)
+	assert.Equal(t, "ONE\ntwo\nTHREE\n", readFile(t, repo, "a.txt"))
+	assert.NoFileExists(t, filepath.Join(repo.Path, "b.txt"))
+	assert.NoDirExists(t, repo.sequencerDir())
+
+	db := repo.ObjectDatabase()
+	pickedOID := commits[1]
+	picked, err := db.ReadCommit(pickedOID)
+	assert.NoError(t, err)
+	original, err := db.ReadCommit(third)
+	assert.NoError(t, err)
+	assert.Equal(t, original.Author, picked.Author)
+	assert.Equal(t, "C O Mitter <c@example.com> 1700000000 +0000", picked.Committer)
+	assert.Equal(t, original.Message, picked.Message)
+	assert.Equal(t, []string{commits[0]}, picked.Parents)
+
+	// reverting the older change keeps the newer one
+	commits, err = repo.Revert(ctx, []string{"HEAD"}, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, "ONE\ntwo\nthree\n", readFile(t, repo, "a.txt"))
+	reverted, err := db.ReadCommit(commits[0])
+	assert.NoError(t, err)
+	assert.Equal(t, "Revert \"one\"\n\nThis reverts commit "+pickedOID+".\n", reverted.Message)
+	assert.Equal(t, reverted.Author, reverted.Committer)
+
+	// with the origin
+	commits, err = repo.CherryPick(ctx, []string{third}, PickOptions{RecordOrigin: true})
+	assert.NoError(t, err)
+	picked, err = db.ReadCommit(commits[0])
+	assert.NoError(t, err)
+	assert.Equal(t, "one\ntwo\nTHREE\n\n(cherry picked from commit "+third+")\n", picked.Message)
+}
+
+func TestCherryPickConflicts(t *testing.T) {
+	ctx := context.Background()
+	repo, err := Init(t.TempDir())
+	assert.NoError(t, err)
+	first := commitFile(t, repo, "a.txt", "one\ntwo\nthree\n")
+	second := commitFile(t, repo, "a.txt", "one\ntwo\n3\n")
+	third := commitFile(t, repo, "b.txt", "b\n")
+	assert.NoError(t, repo.CheckoutCommit(ctx, first))
+	ours := commitFile(t, repo, "a.txt", "one\ntwo\nthree!\n")
+
+	commits, err := repo.CherryPick(ctx, []string{second, third}, PickOptions{})
+	var perr *PickError
+	assert.ErrorAs(t, err, &perr)
+	assert.Empty(t, commits)
+	assert.Equal(t, []string{"a.txt"}, perr.Paths)
+	assert.Equal(t, second, perr.Commit)
+	want := "one\ntwo\n<<<<<<< HEAD\nthree!\n=======\n3\n>>>>>>> " + second[:7] + " (one)\n"
+	assert.Equal(t, want, readFile(t, repo, "a.txt"))
+	assert.FileExists(t, filepath.Join(repo.GitPath, "CHERRY_PICK_HEAD"))
+
+	// a new pick waits, and so does the commit
+	_, err = repo.CherryPick(ctx, []string{third}, PickOptions{})
+	assert.ErrorIs(t, err, ErrSequencerRunning)
+	_, err = repo.ContinuePick(ctx, PickOptions{})
+	assert.ErrorIs(t, err, ErrUnmergedFiles)
+
+	// aborting goes back to the start
+	assert.NoError(t, repo.AbortPick(ctx))
+	assert.Equal(t, ours, RefInitialize(repo.Refs).ReadHead())
+	assert.Equal(t, "one\ntwo\nthree!\n", readFile(t, repo, "a.txt"))
+	status, err := repo.Status()
+	assert.NoError(t, err)
+	assert.Empty(t, status.Changed)
+	assert.NoDirExists(t, repo.sequencerDir())
+	assert.ErrorIs(t, repo.AbortPick(ctx), ErrNoSequencer)
+
+	// resolving and going on
+	_, err = repo.CherryPick(ctx, []string{second, third}, PickOptions{})
+	assert.ErrorAs(t, err, &perr)
+	assert.NoError(t, os.WriteFile(filepath.Join(repo.Path, "a.txt"), []byte("one\ntwo\n3!\n"), 0644))
+	_, err = repo.Add("a.txt")
+	assert.NoError(t, err)
+	commits, err = repo.ContinuePick(ctx, PickOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, commits, 2)
+	resolved, err := repo.ObjectDatabase().ReadCommit(commits[0])
+	assert.NoError(t, err)
+	assert.Equal(t, "one\ntwo\n3\n\n", resolved.Message)
+	assert.Equal(t, "b\n", readFile(t, repo, "b.txt"))
+	assert.NoDirExists(t, repo.sequencerDir())
+	assert.NoFileExists(t, filepath.Join(repo.GitPath, "CHERRY_PICK_HEAD"))
+
+	// skipping the conflicting commit
+	assert.NoError(t, repo.CheckoutCommit(ctx, ours))
+	commits, err = repo.CherryPick(ctx, []string{second, third}, PickOptions{})
+	assert.ErrorAs(t, err, &perr)
+	commits, err = repo.SkipPick(ctx, PickOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, commits, 1)
+	assert.Equal(t, "one\ntwo\nthree!\n", readFile(t, repo, "a.txt"))
+	assert.Equal(t, "b\n", readFile(t, repo, "b.txt"))
+
+	// local changes in the way
+	assert.NoError(t, os.WriteFile(filepath.Join(repo.Path, "a.txt"), []byte("local\n"), 0644))
+	_, err = repo.CherryPick(ctx, []string{second}, PickOptions{})
+	assert.ErrorIs(t, err, ErrLocalChanges)
+	assert.Equal(t, "local\n", readFile(t, repo, "a.txt"))
+	assert.NoDirExists(t, repo.sequencerDir())
+}
+
+func TestCherryPickSwapsFileAndDirectory(t *testing.T) {
+	ctx := context.Background()
+	repo, err := Init(t.TempDir())
+	assert.NoError(t, err)
+	commit := func(message string) string {
+		_, err := repo.AddAll(".")
+		assert.NoError(t, err)
+		res, err := repo.Commit(CommitOptions{Message: message + "\n"})
+		assert.NoError(t, err)
+		return res.OID
+	}
+	writeFile(t, repo, "d/x", "x\n")
+	dir := commit("dir")
+	assert.NoError(t, os.RemoveAll(filepath.Join(repo.Path, "d")))
+	writeFile(t, repo, "d", "d\n")
+	toFile := commit("to file")
+	assert.NoError(t, os.Remove(filepath.Join(repo.Path, "d")))
+	writeFile(t, repo, "d/y", "y\n")
+	toDir := commit("to dir")
+
+	// a directory becomes a file
+	assert.NoError(t, repo.CheckoutCommit(ctx, dir))
+	_, err = repo.CherryPick(ctx, []string{toFile}, PickOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "d\n", readFile(t, repo, "d"))
+	assert.Empty(t, shortStatus(t, repo))
+
+	// and back into a directory
+	_, err = repo.CherryPick(ctx, []string{toDir}, PickOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "y\n", readFile(t, repo, "d/y"))
+	assert.NoFileExists(t, filepath.Join(repo.Path, "d/x"))
+	assert.Empty(t, shortStatus(t, repo))
+}
+
+func TestRecordOrigin(t *testing.T) {
+	oid := "1234567890123456789012345678901234567890"
+	assert.Equal(t, "Subject\n\n(cherry picked from commit "+oid+")\n", recordOrigin("Subject", oid))
+	assert.Equal(t, "Subject\n\nBody\n\n(cherry picked from commit "+oid+")\n", recordOrigin("Subject\n\nBody\n", oid))
+	assert.Equal(t, "Subject\n\nSigned-off-by: A <a@example.com>\n(cherry picked from commit "+oid+")\n",
+		recordOrigin("Subject\n\nSigned-off-by: A <a@example.com>\n", oid))
+}
@@ -0,0 +1,663 @@
+package gitgo
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/Vikuuu/gitgo/internal/pktline"
+)
+
+// zeroOID stands for a ref that does not exist, on either side of a
+// pushed update.
+const zeroOID = "0000000000000000000000000000000000000000"
+
+// Server serves the repositories under Root over the smart HTTP
+// protocol, the one at Root/<path> being at http://<host>/<path>.
+// Clones and fetches speak protocol v2, pushes the receive-pack
+// protocol, the only one there is for them.
+type Server struct {
+	Root string
+	// ReadOnly refuses every push.
+	ReadOnly bool
+
+	// MaxRequestSize caps the bytes of a request body, before and
+	// after gzip, DefaultMaxRequestSize when zero.
+	MaxRequestSize int64
+	// MaxPackObjects caps the objects of a pushed pack,
+	// DefaultMaxPackObjects when zero.
+	MaxPackObjects int64
+	// MaxObjectSize caps the bytes of every pushed object once
+	// inflated, DefaultMaxObjectSize when zero.
+	MaxObjectSize int64
+}
+
+// The limits of a Server leaving them unset. A negative one on a
+// Server sets no limit.
+const (
+	DefaultMaxRequestSize = 1 << 30
+	DefaultMaxPackObjects = 1 << 20
+	DefaultMaxObjectSize  = 256 << 20
+)
+
+// limit is the limit set to value, or else to def. It is zero for no
+// limit.
+func limit(value, def int64) int64 {
+	switch {
+	case value == 0:
+		return def
+	case value < 0:
+		return 0
+	}
+	return value
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	dir, endpoint := splitEndpoint(req.URL.Path)
+	var service string
+	switch {
+	case endpoint == "info/refs" && req.Method == http.MethodGet:
+		service = req.URL.Query().Get("service")
+	case endpoint != "" && endpoint != "info/refs" && req.Method == http.MethodPost:
+		service = endpoint
+	default:
+		http.NotFound(w, req)
+		return
+	}
+	switch {
+	case service != "git-upload-pack" && service != "git-receive-pack":
+		http.Error(w, "only smart HTTP clients are served", http.StatusForbidden)
+		return
+	case service == "git-receive-pack" && s.ReadOnly:
+		http.Error(w, "pushing is not allowed", http.StatusForbidden)
+		return
+	case service == "git-upload-pack" && !strings.Contains(req.Header.Get("Git-Protocol"), "version=2"):
+		http.Error(w, "fetching needs protocol v2", http.StatusBadRequest)
+		return
+	}
+	repo, err := s.open(dir)
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	if endpoint == "info/refs" {
+		w.Header().Set("Content-Type", "application/x-"+service+"-advertisement")
+		if service == "git-upload-pack" {
+			writeCapabilities(w)
+		} else {
+			advertiseReceivePack(w, repo)
+		}
+		return
+	}
+
+	maxSize := limit(s.MaxRequestSize, DefaultMaxRequestSize)
+	body := io.ReadCloser(req.Body)
+	if maxSize > 0 {
+		body = http.MaxBytesReader(w, body, maxSize)
+	}
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		if body, err = gzip.NewReader(body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// a small body can inflate to a large one
+		if maxSize > 0 {
+			body = http.MaxBytesReader(w, body, maxSize)
+		}
+	}
+	w.Header().Set("Content-Type", "application/x-"+service+"-result")
+	if service == "git-upload-pack" {
+		err = uploadPack(req.Context(), w, repo, body)
+	} else {
+		err = receivePack(req.Context(), w, repo, body, unpackLimits{
+			objects:    limit(s.MaxPackObjects, DefaultMaxPackObjects),
+			objectSize: limit(s.MaxObjectSize, DefaultMaxObjectSize),
+		})
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// splitEndpoint cuts the path of a request into the repository and
+// the endpoint asked for, empty when it is none of the protocol's.
+func splitEndpoint(p string) (string, string) {
+	for _, endpoint := range []string{"info/refs", "git-upload-pack", "git-receive-pack"} {
+		if dir, ok := strings.CutSuffix(p, "/"+endpoint); ok {
+			return dir, endpoint
+		}
+	}
+	return p, ""
+}
+
+// open returns the repository at dir, cleaned so that it never leaves
+// the root. The metadata directory of a repository with a workspace is
+// not served on its own: taken for a bare repository, it would let
+// pushes skip the checks of the workspace's branch.
+func (s *Server) open(dir string) (*Repository, error) {
+	root, err := filepath.Abs(s.Root)
+	if err != nil {
+		return nil, err
+	}
+	dir = path.Clean("/" + dir)
+	parent := root
+	for _, name := range strings.Split(dir, "/")[1:] {
+		if strings.EqualFold(name, GitDir) || strings.EqualFold(name, GitgoDir) {
+			if _, err := Open(parent); err == nil {
+				return nil, fmt.Errorf("%w: %s", ErrNotARepository, dir)
+			}
+		}
+		parent = filepath.Join(parent, name)
+	}
+	return Repository{Path: root}.openRemote(filepath.Join(root, filepath.FromSlash(dir)))
+}
+
+// writeCapabilities tells a client what the protocol v2 upload-pack
+// can do.
+func writeCapabilities(w io.Writer) {
+	for _, line := range []string{"version 2", "agent=" + userAgent, "ls-refs=unborn", "fetch=shallow", "object-format=sha1"} {
+		pktline.WriteString(w, line+"\n")
+	}
+	pktline.WriteSpecial(w, pktline.Flush)
+}
+
+// uploadPack runs a protocol v2 command, failing before it writes
+// anything when the request cannot be read. Errors of a well formed
+// request are sent to the client as an ERR packet.
+func uploadPack(ctx context.Context, w io.Writer, repo *Repository, body io.Reader) error {
+	r := pktline.NewReader(body)
+	var command string
+	for {
+		kind, line, err := r.ReadLine()
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrProtocol, err)
+		}
+		if kind != pktline.Data {
+			if command == "" {
+				return fmt.Errorf("%w: no command", ErrProtocol)
+			}
+			if kind == pktline.Flush {
+				return runUploadPackCommand(ctx, w, repo, command, nil)
+			}
+			break
+		}
+		if c, ok := strings.CutPrefix(line, "command="); ok {
+			command = c
+		}
+		// the agent and object format tell nothing we need
+	}
+	var args []string
+	for {
+		kind, line, err := r.ReadLine()
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrProtocol, err)
+		}
+		if kind == pktline.Flush {
+			break
+		}
+		args = append(args, line)
+	}
+	return runUploadPackCommand(ctx, w, repo, command, args)
+}
+
+func runUploadPackCommand(ctx context.Context, w io.Writer, repo *Repository, command string, args []string) error {
+	var err error
+	switch command {
+	case "ls-refs":
+		err = lsRefs(w, repo, args)
+	case "fetch":
+		err = fetchPack(ctx, w, repo, args)
+	default:
+		err = fmt.Errorf("unknown command '%s'", command)
+	}
+	if err != nil {
+		pktline.WriteString(w, "ERR "+err.Error()+"\n")
+	}
+	return nil
+}
+
+// lsRefs lists HEAD and the refs under the prefixes asked for, all of
+// them when there are none.
+func lsRefs(w io.Writer, repo *Repository, args []string) error {
+	var prefixes []string
+	symrefs, unborn := false, false
+	for _, arg := range args {
+		switch {
+		case arg == "symrefs":
+			symrefs = true
+		case arg == "unborn":
+			unborn = true
+		case strings.HasPrefix(arg, "ref-prefix "):
+			prefixes = append(prefixes, strings.TrimPrefix(arg, "ref-prefix "))
+		}
+	}
+	matches := func(name string) bool {
+		return len(prefixes) == 0 || slices.ContainsFunc(prefixes, func(p string) bool {
+			return strings.HasPrefix(name, p)
+		})
+	}
+
+	refs := RefInitialize(repo.Refs)
+	all, err := refs.ListRefs("refs/")
+	if err != nil {
+		return err
+	}
+	head, err := refs.HeadRef()
+	if err != nil {
+		return err
+	}
+	if matches("HEAD") {
+		switch oid := refs.ReadHead(); {
+		case oid != "" && symrefs && head != "":
+			pktline.WriteString(w, oid+" HEAD symref-target:"+head+"\n")
+		case oid != "":
+			pktline.WriteString(w, oid+" HEAD\n")
+		case unborn && head != "":
+			pktline.WriteString(w, "unborn HEAD symref-target:"+head+"\n")
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(all)) {
+		if matches(name) {
+			pktline.WriteString(w, all[name]+" "+name+"\n")
+		}
+	}
+	return pktline.WriteSpecial(w, pktline.Flush)
+}
+
+// fetchPack answers a fetch. Until the client says it is done, its
+// haves are acknowledged and the pack only follows once one of them
+// is common.
+func fetchPack(ctx context.Context, w io.Writer, repo *Repository, args []string) error {
+	var req fetchRequest
+	done, progress := false, true
+	for _, arg := range args {
+		verb, value, _ := strings.Cut(arg, " ")
+		switch {
+		case verb == "want":
+			req.wants = append(req.wants, value)
+		case verb == "have":
+			req.haves = append(req.haves, value)
+		case verb == "shallow":
+			req.shallow = append(req.shallow, value)
+		case verb == "deepen":
+			depth, err := strconv.Atoi(value)
+			if err != nil || depth <= 0 {
+				return fmt.Errorf("invalid deepen: %s", value)
+			}
+			req.depth = depth
+		case verb == "deepen-relative":
+			req.relative = true
+		case verb == "deepen-since" || verb == "deepen-not":
+			return fmt.Errorf("%s is not supported", verb)
+		case arg == "done":
+			done = true
+		case arg == "no-progress":
+			progress = false
+		}
+		// thin-pack, ofs-delta and include-tag change nothing to packs
+		// made of whole objects
+	}
+	if len(req.wants) == 0 {
+		return errors.New("fetch without wants")
+	}
+
+	refs := RefInitialize(repo.Refs)
+	all, err := refs.ListRefs("refs/")
+	if err != nil {
+		return err
+	}
+	tips := slices.Collect(maps.Values(all))
+	tips = append(tips, refs.ReadHead())
+	for _, oid := range req.wants {
+		if !slices.Contains(tips, oid) {
+			return fmt.Errorf("upload-pack: not our ref %s", oid)
+		}
+	}
+
+	db := repo.ObjectDatabase()
+	if !done {
+		pktline.WriteString(w, "acknowledgments\n")
+		var common []string
+		for _, oid := range req.haves {
+			if ok, err := db.Has(oid); err != nil {
+				return err
+			} else if ok {
+				common = append(common, oid)
+				pktline.WriteString(w, "ACK "+oid+"\n")
+			}
+		}
+		if len(common) == 0 {
+			pktline.WriteString(w, "NAK\n")
+			return pktline.WriteSpecial(w, pktline.Flush)
+		}
+		pktline.WriteString(w, "ready\n")
+		pktline.WriteSpecial(w, pktline.Delim)
+	}
+
+	plan, err := planFetch(db, req)
+	if err != nil {
+		return err
+	}
+	oids, _, err := packCommits(plan.db, plan.commits)
+	if err != nil {
+		return err
+	}
+	if req.depth > 0 || len(req.shallow) > 0 || len(plan.shallow) > 0 {
+		pktline.WriteString(w, "shallow-info\n")
+		for _, oid := range plan.shallow {
+			pktline.WriteString(w, "shallow "+oid+"\n")
+		}
+		for _, oid := range plan.unshallow {
+			pktline.WriteString(w, "unshallow "+oid+"\n")
+		}
+		pktline.WriteSpecial(w, pktline.Delim)
+	}
+	pktline.WriteString(w, "packfile\n")
+	remote := io.Discard
+	if progress {
+		remote = sidebandWriter{w: w, band: 2}
+	}
+	fmt.Fprintf(remote, "Enumerating objects: %d, done.\n", len(oids))
+	if err := writePack(ctx, sidebandWriter{w: w, band: 1}, db, oids, nil); err != nil {
+		sidebandWriter{w: w, band: 3}.Write([]byte(err.Error() + "\n"))
+		return pktline.WriteSpecial(w, pktline.Flush)
+	}
+	fmt.Fprintf(remote, "Total %d (delta 0), reused 0 (delta 0), pack-reused 0\n", len(oids))
+	return pktline.WriteSpecial(w, pktline.Flush)
+}
+
+// packObjects lists what a client having haves needs to get wants: the
+// commits it misses and what they hold that the commits it has right
+// below them do not. Those boundary commits are returned too.
+func packObjects(db *Database, wants, haves []string) ([]string, []string, error) {
+	commits, err := negotiate(db, wants, haves)
+	if err != nil {
+		return nil, nil, err
+	}
+	return packCommits(db, commits)
+}
+
+// packCommits lists commits and what they hold that their parents
+// not among them, the boundary commits also returned, do not.
+func packCommits(db *Database, commits []string) ([]string, []string, error) {
+	sending := make(map[string]bool, len(commits))
+	for _, oid := range commits {
+		sending[oid] = true
+	}
+	has := make(map[string]bool)
+	var boundary []string
+	for _, oid := range commits {
+		commit, err := db.ReadCommit(oid)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, parent := range commit.Parents {
+			if sending[parent] || has[parent] {
+				continue
+			}
+			has[parent] = true
+			boundary = append(boundary, parent)
+			below, err := db.ReadCommit(parent)
+			if err != nil {
+				return nil, nil, err
+			}
+			if err := markTree(db, below.Tree, has); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	oids, err := objectsToSend(db, commits, func(oid string) (bool, error) {
+		return has[oid], nil
+	})
+	return oids, boundary, err
+}
+
+// markTree adds the tree oid and everything in it to seen.
+func markTree(db *Database, oid string, seen map[string]bool) error {
+	if seen[oid] {
+		return nil
+	}
+	seen[oid] = true
+	entries, err := db.ReadTree(oid)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		switch {
+		case e.Mode == gitlinkMode:
+		case e.IsTree():
+			if err := markTree(db, e.OID, seen); err != nil {
+				return err
+			}
+		default:
+			seen[e.OID] = true
+		}
+	}
+	return nil
+}
+
+// advertiseReceivePack lists the refs a push can update, the first
+// line carrying the capabilities.
+func advertiseReceivePack(w io.Writer, repo *Repository) {
+	pktline.WriteString(w, "# service=git-receive-pack\n")
+	pktline.WriteSpecial(w, pktline.Flush)
+	caps := "report-status delete-refs atomic ofs-delta object-format=sha1 agent=" + userAgent
+	all, _ := RefInitialize(repo.Refs).ListRefs("refs/")
+	if len(all) == 0 {
+		pktline.WriteString(w, zeroOID+" capabilities^{}\x00"+caps+"\n")
+	}
+	for i, name := range slices.Sorted(maps.Keys(all)) {
+		line := all[name] + " " + name
+		if i == 0 {
+			line += "\x00" + caps
+		}
+		pktline.WriteString(w, line+"\n")
+	}
+	pktline.WriteSpecial(w, pktline.Flush)
+}
+
+// pushCommand is a ref update asked for by a push, refused when
+// reason is set.
+type pushCommand struct {
+	old, new, ref string
+	reason        string
+}
+
+// receivePack reads the ref updates of a push and the pack that
+// follows them, within limits, then applies the updates that pass the
+// checks of checkPush. An atomic push applies all of them or none.
+func receivePack(ctx context.Context, w io.Writer, repo *Repository, body io.Reader, limits unpackLimits) error {
+	r := pktline.NewReader(body)
+	var commands []pushCommand
+	var caps []string
+	for {
+		kind, line, err := r.ReadLine()
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrProtocol, err)
+		}
+		if kind == pktline.Flush {
+			break
+		}
+		if len(commands) == 0 {
+			var list string
+			line, list, _ = strings.Cut(line, "\x00")
+			caps = strings.Fields(list)
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 || validOID(fields[0]) != nil || validOID(fields[1]) != nil {
+			return fmt.Errorf("%w: bad command %q", ErrProtocol, line)
+		}
+		commands = append(commands, pushCommand{old: fields[0], new: fields[1], ref: fields[2]})
+	}
+	if len(commands) == 0 {
+		return nil
+	}
+
+	var unpackErr error
+	if slices.ContainsFunc(commands, func(c pushCommand) bool { return c.new != zeroOID }) {
+		_, unpackErr = unpackObjectsLimited(ctx, body, repo.ObjectDatabase(), nil, limits)
+	}
+	if unpackErr != nil {
+		for i := range commands {
+			commands[i].reason = "unpacker error"
+		}
+	} else if err := updatePushedRefs(repo, commands, slices.Contains(caps, "atomic")); err != nil {
+		return err
+	}
+
+	if !slices.Contains(caps, "report-status") {
+		return nil
+	}
+	if unpackErr != nil {
+		pktline.WriteString(w, "unpack "+unpackErr.Error()+"\n")
+	} else {
+		pktline.WriteString(w, "unpack ok\n")
+	}
+	for _, c := range commands {
+		if c.reason != "" {
+			pktline.WriteString(w, "ng "+c.ref+" "+c.reason+"\n")
+		} else {
+			pktline.WriteString(w, "ok "+c.ref+"\n")
+		}
+	}
+	return pktline.WriteSpecial(w, pktline.Flush)
+}
+
+// updatePushedRefs applies the commands passing checkPush, each in its
+// own ref transaction or, for an atomic push, all in one.
+func updatePushedRefs(repo *Repository, commands []pushCommand, atomic bool) error {
+	config, err := repo.Config()
+	if err != nil {
+		return err
+	}
+	for i := range commands {
+		if commands[i].reason, err = checkPush(repo, config, commands[i]); err != nil {
+			return err
+		}
+	}
+
+	refs := RefInitialize(repo.Refs)
+	if atomic {
+		failed := slices.ContainsFunc(commands, func(c pushCommand) bool { return c.reason != "" })
+		var changes []RefChange
+		for _, c := range commands {
+			changes = append(changes, c.change())
+		}
+		var reason string
+		if failed {
+			reason = "atomic push failure"
+		} else if err := refs.Transaction(changes); err != nil {
+			reason = transactionReason(err)
+		}
+		for i := range commands {
+			if commands[i].reason == "" {
+				commands[i].reason = reason
+			}
+		}
+		return nil
+	}
+	for i, c := range commands {
+		if c.reason != "" {
+			continue
+		}
+		if err := refs.Transaction([]RefChange{c.change()}); err != nil {
+			commands[i].reason = transactionReason(err)
+		}
+	}
+	return nil
+}
+
+func (c pushCommand) change() RefChange {
+	change := RefChange{Name: c.ref, Old: c.old, New: c.new}
+	if change.Old == zeroOID {
+		change.Old = ""
+	}
+	if change.New == zeroOID {
+		change.New = ""
+	}
+	return change
+}
+
+func transactionReason(err error) string {
+	if errors.Is(err, ErrStaleRef) {
+		return "stale info"
+	}
+	return "failed to update ref"
+}
+
+// checkPush returns why the command cannot be applied, empty when it
+// can. Pushes are refused when they delete a ref while
+// receive.denyDeletes is set, rewrite history while
+// receive.denyNonFastForwards is, or move the branch checked out in a
+// repository with a workspace, and when the objects they need are
+// missing.
+func checkPush(repo *Repository, config *Config, c pushCommand) (string, error) {
+	if validRefName(c.ref) != nil {
+		return "funny refname", nil
+	}
+	if !repo.bare() {
+		if err := denyCurrentBranch(repo, c.ref); errors.Is(err, ErrCurrentBranch) {
+			return "branch is currently checked out", nil
+		} else if err != nil {
+			return "", err
+		}
+	}
+	if c.new == zeroOID {
+		if deny, _ := config.GetBool("receive.denyDeletes", false); deny {
+			return "deletion prohibited", nil
+		}
+		return "", nil
+	}
+
+	db := repo.ObjectDatabase()
+	if typ, _, err := db.ReadObject(c.new); err != nil || typ != TypeCommit {
+		return "missing necessary objects", nil
+	}
+	all, err := RefInitialize(repo.Refs).ListRefs("refs/")
+	if err != nil {
+		return "", err
+	}
+	if !connected(db, c.new, slices.Collect(maps.Values(all))) {
+		return "missing necessary objects", nil
+	}
+	if c.old != zeroOID {
+		if deny, _ := config.GetBool("receive.denyNonFastForwards", false); deny {
+			if ff, err := isAncestor(db, c.old, c.new); err != nil {
+				return "", err
+			} else if !ff {
+				return "non-fast-forward", nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// connected tells if db has everything reachable from oid that is not
+// reachable from the tips, which it has with their history.
+func connected(db *Database, oid string, tips []string) bool {
+	commits, err := negotiate(db, []string{oid}, tips)
+	if err != nil {
+		return false
+	}
+	oids, err := objectsToSend(db, commits, func(string) (bool, error) { return false, nil })
+	if err != nil {
+		return false
+	}
+	for _, oid := range oids {
+		if ok, err := db.Has(oid); err != nil || !ok {
+			return false
+		}
+	}
+	return true
+}
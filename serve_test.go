@@ -0,0 +1,187 @@
+package gitgo
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// gitPushFails runs a git push in dir that is expected to fail and
+// returns its output.
+func gitPushFails(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"push"}, args...)...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_CONFIG_NOSYSTEM=1", "HOME="+dir)
+	out, err := cmd.CombinedOutput()
+	assert.Error(t, err, "git push %s: %s", strings.Join(args, " "), out)
+	return string(out)
+}
+
+func commitWithGit(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	runGit(t, dir, "add", name)
+	runGit(t, dir, "commit", "-q", "-m", name+": "+content)
+	return runGit(t, dir, "rev-parse", "HEAD")
+}
+
+func TestServeGitClient(t *testing.T) {
+	tmp := t.TempDir()
+	runGit(t, tmp, "--version")
+	root := filepath.Join(tmp, "root")
+	assert.NoError(t, os.MkdirAll(root, 0755))
+	runGit(t, root, "init", "-q", "--bare", "-b", "main", "repo.git")
+	srv := httptest.NewServer(&Server{Root: root})
+	defer srv.Close()
+
+	// a clone of an empty repository, then pushes to it
+	runGit(t, tmp, "clone", "-q", srv.URL+"/repo.git", "work")
+	work := filepath.Join(tmp, "work")
+	first := commitWithGit(t, work, "a.txt", "one\n")
+	runGit(t, work, "push", "-q", "origin", "main")
+	second := commitWithGit(t, work, "a.txt", "two\n")
+	runGit(t, work, "push", "-q", "origin", "main", "main:refs/heads/topic")
+	bare := filepath.Join(root, "repo.git")
+	assert.Equal(t, second, runGit(t, bare, "rev-parse", "main"))
+	assert.Equal(t, second, runGit(t, bare, "rev-parse", "topic"))
+	assert.Equal(t, "", runGit(t, bare, "fsck", "--strict", "--no-dangling"))
+
+	// git clones and fetches what was pushed
+	runGit(t, tmp, "clone", "-q", srv.URL+"/repo.git", "other")
+	other := filepath.Join(tmp, "other")
+	assert.Equal(t, second, runGit(t, other, "rev-parse", "HEAD"))
+	third := commitWithGit(t, work, "b.txt", "three\n")
+	runGit(t, work, "push", "-q")
+	runGit(t, other, "pull", "-q", "--ff-only")
+	assert.Equal(t, third, runGit(t, other, "rev-parse", "HEAD"))
+	assert.Equal(t, "", runGit(t, other, "fsck", "--strict", "--no-dangling"))
+
+	// so does gitgo
+	clone := NewRepositoryWithGitDir(filepath.Join(tmp, "clone"), GitDir)
+	_, err := clone.Clone(context.Background(), srv.URL+"/repo.git", CloneOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, third, runGit(t, clone.Path, "rev-parse", "HEAD"))
+
+	// deleting a branch, and a forced push
+	runGit(t, work, "push", "-q", "origin", ":topic")
+	assert.Equal(t, "", runGit(t, bare, "branch", "--list", "topic"))
+	runGit(t, work, "reset", "-q", "--hard", first)
+	runGit(t, work, "push", "-q", "--force")
+	assert.Equal(t, first, runGit(t, bare, "rev-parse", "main"))
+}
+
+func TestServeRefusedPushes(t *testing.T) {
+	tmp := t.TempDir()
+	runGit(t, tmp, "--version")
+	srv := httptest.NewServer(&Server{Root: tmp})
+	defer srv.Close()
+
+	// a repository with a workspace keeps its current branch
+	upstream := filepath.Join(tmp, "upstream")
+	assert.NoError(t, os.MkdirAll(upstream, 0755))
+	runGit(t, upstream, "init", "-q", "-b", "main")
+	base := commitWithGit(t, upstream, "a.txt", "one\n")
+	runGit(t, upstream, "config", "receive.denyNonFastForwards", "true")
+	runGit(t, tmp, "clone", "-q", srv.URL+"/upstream", "work")
+	work := filepath.Join(tmp, "work")
+	next := commitWithGit(t, work, "a.txt", "two\n")
+	out := gitPushFails(t, work, "origin", "main")
+	assert.Contains(t, out, "branch is currently checked out")
+	assert.Equal(t, base, runGit(t, upstream, "rev-parse", "main"))
+
+	// other branches can move, forward only
+	runGit(t, work, "push", "-q", "origin", "main:side")
+	runGit(t, work, "reset", "-q", "--hard", base)
+	out = gitPushFails(t, work, "--force", "origin", "main:side")
+	assert.Contains(t, out, "non-fast-forward")
+	assert.Equal(t, next, runGit(t, upstream, "rev-parse", "side"))
+
+	// an atomic push changes nothing when one of its refs is refused
+	out = gitPushFails(t, work, "--atomic", "--force", "origin", "main:side", "main:fresh")
+	assert.Contains(t, out, "atomic push failure")
+	assert.Equal(t, "", runGit(t, upstream, "branch", "--list", "fresh"))
+	runGit(t, work, "push", "-q", "--atomic", "origin", "main:fresh", "main:fresh2")
+	assert.Equal(t, base, runGit(t, upstream, "rev-parse", "fresh2"))
+
+	// and a read-only server none of them
+	readOnly := httptest.NewServer(&Server{Root: tmp, ReadOnly: true})
+	defer readOnly.Close()
+	runGit(t, work, "remote", "add", "ro", readOnly.URL+"/upstream")
+	gitPushFails(t, work, "ro", "main:elsewhere")
+	assert.Equal(t, "", runGit(t, upstream, "branch", "--list", "elsewhere"))
+
+	// the metadata directory is not a bare repository of its own
+	for _, dir := range []string{".git", ".GIT", ".git/../.git"} {
+		gitPushFails(t, work, srv.URL+"/upstream/"+dir, next+":refs/heads/main")
+	}
+	assert.Equal(t, base, runGit(t, upstream, "rev-parse", "main"))
+
+	// a pack over the limits is not unpacked
+	limited := httptest.NewServer(&Server{Root: tmp, MaxPackObjects: 2})
+	defer limited.Close()
+	commitWithGit(t, work, "b.txt", "three\n")
+	out = gitPushFails(t, work, limited.URL+"/upstream", "main:big")
+	assert.Contains(t, out, "more than the limit of 2")
+	assert.Equal(t, "", runGit(t, upstream, "branch", "--list", "big"))
+}
+
+func TestServeRequests(t *testing.T) {
+	tmp := t.TempDir()
+	repo, err := Init(filepath.Join(tmp, "repo"))
+	assert.NoError(t, err)
+	srv := httptest.NewServer(&Server{Root: tmp})
+	defer srv.Close()
+
+	for name, tc := range map[string]struct {
+		method, path, protocol string
+		status                 int
+	}{
+		"v2":           {http.MethodGet, "/repo/info/refs?service=git-upload-pack", "version=2", http.StatusOK},
+		"v0":           {http.MethodGet, "/repo/info/refs?service=git-upload-pack", "", http.StatusBadRequest},
+		"receive-pack": {http.MethodGet, "/repo/info/refs?service=git-receive-pack", "", http.StatusOK},
+		"dumb":         {http.MethodGet, "/repo/info/refs", "", http.StatusForbidden},
+		"missing":      {http.MethodGet, "/nowhere/info/refs?service=git-upload-pack", "version=2", http.StatusNotFound},
+		"outside root": {http.MethodGet, "/../" + filepath.Base(tmp) + "/repo/info/refs?service=git-upload-pack", "version=2", http.StatusNotFound},
+		"other file":   {http.MethodGet, "/repo/HEAD", "", http.StatusNotFound},
+		"get a post":   {http.MethodGet, "/repo/git-upload-pack", "version=2", http.StatusNotFound},
+	} {
+		req, err := http.NewRequest(tc.method, srv.URL+tc.path, nil)
+		assert.NoError(t, err)
+		if tc.protocol != "" {
+			req.Header.Set("Git-Protocol", tc.protocol)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, tc.status, resp.StatusCode, name)
+	}
+
+	// gitgo talks to itself
+	commitFile(t, repo, "README", "hello")
+	clone := NewRepository(filepath.Join(t.TempDir(), "clone"))
+	res, err := clone.Clone(context.Background(), srv.URL+"/repo", CloneOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, res.Objects)
+	data, err := os.ReadFile(filepath.Join(clone.Path, "README"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	// a body over the limit is cut short
+	small := httptest.NewServer(&Server{Root: tmp, MaxRequestSize: 16})
+	defer small.Close()
+	req, err := http.NewRequest(http.MethodPost, small.URL+"/repo/git-upload-pack", strings.NewReader("0014command=ls-refs\n0000"))
+	assert.NoError(t, err)
+	req.Header.Set("Git-Protocol", "version=2")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
@@ -0,0 +1,202 @@
+package gitgo
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// infiniteDepth is the depth fetching the whole history, as asked for
+// by --unshallow.
+const infiniteDepth = 1<<31 - 1
+
+func (r Repository) shallowPath() string {
+	return filepath.Join(r.GitPath, "shallow")
+}
+
+// Shallow returns, sorted, the commits of a shallow repository whose
+// parents it does not have, as kept in its shallow file. It is empty
+// for a repository with its whole history.
+func (r Repository) Shallow() ([]string, error) {
+	data, err := os.ReadFile(r.shallowPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var oids []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if validOID(line) == nil {
+			oids = append(oids, line)
+		}
+	}
+	slices.Sort(oids)
+	return oids, nil
+}
+
+// updateShallow adds and removes commits from the shallow file, which
+// goes away along with the last of them.
+func (r Repository) updateShallow(add, remove []string) error {
+	if len(add) == 0 && len(remove) == 0 {
+		return nil
+	}
+	current, err := r.Shallow()
+	if err != nil {
+		return err
+	}
+	set := make(map[string]bool)
+	for _, oid := range append(current, add...) {
+		set[oid] = true
+	}
+	for _, oid := range remove {
+		delete(set, oid)
+	}
+	if len(set) == 0 {
+		lockfile := lockInitialize(r.shallowPath())
+		if _, err := lockfile.holdForUpdate(); err != nil {
+			return err
+		}
+		defer lockfile.rollback()
+		if err := os.Remove(r.shallowPath()); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return writeLocked(r.shallowPath(), strings.Join(slices.Sorted(maps.Keys(set)), "\n")+"\n")
+}
+
+// withShallow returns a view of d in which the commits oids, on top of
+// those already shallow in d, have no parents.
+func (d *Database) withShallow(oids []string) *Database {
+	view := *d
+	view.shallow = maps.Clone(d.shallow)
+	if view.shallow == nil {
+		view.shallow = make(map[string]bool, len(oids))
+	}
+	for _, oid := range oids {
+		view.shallow[oid] = true
+	}
+	return &view
+}
+
+// fetchRequest is what a fetch asks of a source.
+type fetchRequest struct {
+	wants []string
+	haves []string
+	// shallow are the commits the fetching repository has without
+	// their parents.
+	shallow []string
+	// depth, when set, limits the history fetched to that many commits
+	// from the wants or, when relative, that many more below shallow.
+	depth    int
+	relative bool
+}
+
+// fetchResponse is what a source sent for a fetchRequest.
+type fetchResponse struct {
+	objects int
+	// shallow are the commits the fetching repository now has without
+	// their parents and unshallow those whose parents it now has.
+	shallow   []string
+	unshallow []string
+}
+
+// fetchPlan is the history a source sends for a fetchRequest.
+type fetchPlan struct {
+	// db is the database seen from the fetching side, the new shallow
+	// commits having no parents in it.
+	db        *Database
+	commits   []string
+	shallow   []string
+	unshallow []string
+}
+
+// planFetch works out the commits db sends for req: those reachable
+// from the wants that the other side does not have and, for a limited
+// depth, that are not too deep.
+func planFetch(db *Database, req fetchRequest) (*fetchPlan, error) {
+	plan := &fetchPlan{db: db}
+	var known []string
+	for _, oid := range req.haves {
+		if ok, err := db.Has(oid); err != nil {
+			return nil, err
+		} else if ok {
+			known = append(known, oid)
+		}
+	}
+	// what the other side has stops at its shallow commits
+	common := make(map[string]bool)
+	if err := walkCommits(db.withShallow(req.shallow), known, common, nil); err != nil {
+		return nil, err
+	}
+
+	starts := slices.Clone(req.wants)
+	if from, depth := req.wants, req.depth; depth > 0 && !(req.relative && len(req.shallow) == 0) {
+		if req.relative {
+			from, depth = req.shallow, depth+1
+		}
+		keep, boundary, err := shallowCut(db, from, depth)
+		if err != nil {
+			return nil, err
+		}
+		plan.db = db.withShallow(boundary)
+		plan.shallow = boundary
+		for _, oid := range req.shallow {
+			if !keep[oid] || slices.Contains(boundary, oid) {
+				continue
+			}
+			plan.unshallow = append(plan.unshallow, oid)
+			commit, err := db.ReadCommit(oid)
+			if err != nil {
+				return nil, err
+			}
+			starts = append(starts, commit.Parents...)
+		}
+	}
+
+	err := walkCommits(plan.db, starts, maps.Clone(common), func(oid string, _ *Commit) {
+		plan.commits = append(plan.commits, oid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// the commits sent that are shallow here already stay so there
+	for _, oid := range plan.commits {
+		if db.shallow[oid] && !slices.Contains(plan.shallow, oid) {
+			plan.shallow = append(plan.shallow, oid)
+		}
+	}
+	return plan, nil
+}
+
+// shallowCut walks at most depth commits down from starts, returning
+// the commits it reaches and, among them, those whose parents it does
+// not take.
+func shallowCut(db *Database, starts []string, depth int) (map[string]bool, []string, error) {
+	keep := make(map[string]bool)
+	var boundary []string
+	level := starts
+	for d := 1; len(level) > 0; d++ {
+		var next []string
+		for _, oid := range level {
+			if keep[oid] {
+				continue
+			}
+			keep[oid] = true
+			commit, err := db.ReadCommit(oid)
+			if err != nil {
+				return nil, nil, err
+			}
+			if d < depth {
+				next = append(next, commit.Parents...)
+			} else if len(commit.Parents) > 0 {
+				boundary = append(boundary, oid)
+			}
+		}
+		level = next
+	}
+	return keep, boundary, nil
+}
@@ -0,0 +1,181 @@
+package gitgo
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// logOIDs returns the commits Log walks in repo.
+func logOIDs(t *testing.T, repo Repository) []string {
+	t.Helper()
+	var oids []string
+	assert.NoError(t, repo.Log(func(oid string, _ *Commit) error {
+		oids = append(oids, oid)
+		return nil
+	}))
+	return oids
+}
+
+func TestShallowFile(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	assert.NoError(t, err)
+	a := "ce013625030ba8dba906f756967f9e9ca394464a"
+	b := "3b18e512dba79e4c8300dd08aeb37f8e728b8dad"
+
+	shallow, err := repo.Shallow()
+	assert.NoError(t, err)
+	assert.Empty(t, shallow)
+	assert.NoError(t, repo.updateShallow([]string{b, a}, nil))
+	shallow, err = repo.Shallow()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{b, a}, shallow)
+	data, err := os.ReadFile(filepath.Join(repo.GitPath, "shallow"))
+	assert.NoError(t, err)
+	assert.Equal(t, b+"\n"+a+"\n", string(data))
+
+	// the file goes away with its last commit
+	assert.NoError(t, repo.updateShallow(nil, []string{a, b}))
+	assert.NoFileExists(t, filepath.Join(repo.GitPath, "shallow"))
+}
+
+func TestShallowCloneAndDeepen(t *testing.T) {
+	tmp := t.TempDir()
+	ctx := context.Background()
+	upstream, err := Init(filepath.Join(tmp, "upstream"))
+	assert.NoError(t, err)
+	var history []string
+	for _, content := range []string{"one", "two", "three", "four"} {
+		history = append([]string{commitFile(t, upstream, "a.txt", content)}, history...)
+	}
+
+	clone := NewRepository(filepath.Join(tmp, "clone"))
+	res, err := clone.Clone(ctx, upstream.Path, CloneOptions{Depth: 1})
+	assert.NoError(t, err)
+	// the tip, its tree and blob
+	assert.Equal(t, 3, res.Objects)
+	shallow, err := clone.Shallow()
+	assert.NoError(t, err)
+	assert.Equal(t, history[:1], shallow)
+	assert.Equal(t, history[:1], logOIDs(t, clone))
+
+	res, err = clone.Fetch(ctx, DefaultRemote, FetchOptions{Deepen: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, 6, res.Objects)
+	shallow, err = clone.Shallow()
+	assert.NoError(t, err)
+	assert.Equal(t, history[2:3], shallow)
+	assert.Equal(t, history[:3], logOIDs(t, clone))
+
+	// a new commit upstream comes along with the depth asked for
+	history = append([]string{commitFile(t, upstream, "a.txt", "five")}, history...)
+	_, err = clone.Fetch(ctx, DefaultRemote, FetchOptions{Depth: 2})
+	assert.NoError(t, err)
+	shallow, err = clone.Shallow()
+	assert.NoError(t, err)
+	assert.Contains(t, shallow, history[1])
+
+	_, err = clone.Fetch(ctx, DefaultRemote, FetchOptions{Unshallow: true})
+	assert.NoError(t, err)
+	shallow, err = clone.Shallow()
+	assert.NoError(t, err)
+	assert.Empty(t, shallow)
+	tracking, err := RefInitialize(clone.Refs).ReadRef("refs/remotes/origin/master")
+	assert.NoError(t, err)
+	assert.Equal(t, history[0], tracking)
+	_, err = clone.Fetch(ctx, DefaultRemote, FetchOptions{Unshallow: true})
+	assert.ErrorContains(t, err, "does not make sense")
+
+	// a clone of a shallow repository is as shallow
+	shallowClone := NewRepository(filepath.Join(tmp, "shallow"))
+	_, err = shallowClone.Clone(ctx, upstream.Path, CloneOptions{Depth: 2})
+	assert.NoError(t, err)
+	again := NewRepository(filepath.Join(tmp, "again"))
+	_, err = again.Clone(ctx, shallowClone.Path, CloneOptions{})
+	assert.NoError(t, err)
+	shallow, err = again.Shallow()
+	assert.NoError(t, err)
+	assert.Equal(t, history[1:2], shallow)
+	assert.Equal(t, history[:2], logOIDs(t, again))
+}
+
+func TestMergeBasesStopAtShallow(t *testing.T) {
+	tmp := t.TempDir()
+	ctx := context.Background()
+	upstream, err := Init(filepath.Join(tmp, "upstream"))
+	assert.NoError(t, err)
+	refs := RefInitialize(upstream.Refs)
+	base := commitFile(t, upstream, "a.txt", "base")
+	side := commitFile(t, upstream, "a.txt", "side")
+	assert.NoError(t, refs.UpdateRef("refs/heads/side", side))
+	assert.NoError(t, refs.UpdateRef("refs/heads/master", base))
+	main := commitFile(t, upstream, "a.txt", "main")
+
+	bases, err := upstream.MergeBases(main, side)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{base}, bases)
+	bases, err = upstream.MergeBases(main, base)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{base}, bases)
+
+	// the base is below the shallow commits of the clone
+	clone := NewRepository(filepath.Join(tmp, "clone"))
+	_, err = clone.Clone(ctx, upstream.Path, CloneOptions{Depth: 1})
+	assert.NoError(t, err)
+	bases, err = clone.MergeBases(main, side)
+	assert.NoError(t, err)
+	assert.Empty(t, bases)
+
+	_, err = clone.Fetch(ctx, DefaultRemote, FetchOptions{Deepen: 1})
+	assert.NoError(t, err)
+	bases, err = clone.MergeBases(main, side)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{base}, bases)
+}
+
+func TestShallowInteropWithGit(t *testing.T) {
+	tmp := t.TempDir()
+	root := filepath.Join(tmp, "srv")
+	upstream := filepath.Join(root, "upstream")
+	assert.NoError(t, os.MkdirAll(upstream, 0755))
+	runGit(t, upstream, "init", "-q", "-b", "main")
+	var history []string
+	for _, content := range []string{"one\n", "two\n", "three\n", "four\n"} {
+		history = append([]string{commitWithGit(t, upstream, "a.txt", content)}, history...)
+	}
+
+	// gitgo against git http-backend, read by git
+	gitSrv := gitHTTPServer(t, root)
+	clone := NewRepositoryWithGitDir(filepath.Join(tmp, "clone"), GitDir)
+	_, err := clone.Clone(context.Background(), gitSrv.URL+"/upstream", CloneOptions{Depth: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, "true", runGit(t, clone.Path, "rev-parse", "--is-shallow-repository"))
+	assert.Equal(t, history[0], runGit(t, clone.Path, "log", "--format=%H"))
+	_, err = clone.Fetch(context.Background(), DefaultRemote, FetchOptions{Deepen: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, history[0]+"\n"+history[1], runGit(t, clone.Path, "rev-list", "HEAD"))
+	assert.Equal(t, "", runGit(t, clone.Path, "fsck", "--no-dangling"))
+	_, err = clone.Fetch(context.Background(), DefaultRemote, FetchOptions{Unshallow: true})
+	assert.NoError(t, err)
+	assert.Equal(t, "false", runGit(t, clone.Path, "rev-parse", "--is-shallow-repository"))
+
+	// git against the gitgo server
+	srv := httptest.NewServer(&Server{Root: root})
+	defer srv.Close()
+	runGit(t, tmp, "clone", "-q", "--depth", "1", srv.URL+"/upstream", "work")
+	work := filepath.Join(tmp, "work")
+	assert.Equal(t, history[0], runGit(t, work, "log", "--format=%H"))
+	runGit(t, work, "fetch", "-q", "--deepen", "2")
+	data, err := os.ReadFile(filepath.Join(work, ".git", "shallow"))
+	assert.NoError(t, err)
+	assert.Equal(t, history[2]+"\n", string(data))
+	assert.Equal(t, "", runGit(t, work, "fsck", "--no-dangling"))
+	runGit(t, work, "fetch", "-q", "--unshallow")
+	assert.Equal(t, "false", runGit(t, work, "rev-parse", "--is-shallow-repository"))
+	assert.Equal(t, history, strings.Fields(runGit(t, work, "rev-list", "HEAD")))
+}
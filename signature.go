@@ -0,0 +1,74 @@
+package gitgo
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Signature is the identity and time of an author or committer line,
+// "Name <email> 1700000000 +0100" in commit objects.
+type Signature struct {
+	Name  string
+	Email string
+	When  time.Time
+}
+
+func ParseSignature(s string) (Signature, error) {
+	open := strings.LastIndexByte(s, '<')
+	end := strings.LastIndexByte(s, '>')
+	if open == -1 || end < open {
+		return Signature{}, fmt.Errorf("%w: bad signature %q", ErrCorruptObject, s)
+	}
+	sig := Signature{
+		Name:  strings.TrimSpace(s[:open]),
+		Email: s[open+1 : end],
+	}
+	when, err := ParseDate(strings.TrimSpace(s[end+1:]))
+	if err != nil {
+		return Signature{}, fmt.Errorf("%w: bad signature %q", ErrCorruptObject, s)
+	}
+	sig.When = when
+	return sig, nil
+}
+
+func (s Signature) String() string {
+	return AuthorData(s.Name, s.Email, s.When)
+}
+
+// FormatDate writes t in git's internal date format, which ParseDate
+// reads back.
+func FormatDate(t time.Time) string {
+	return fmt.Sprintf("%d %s", t.Unix(), getUTCOffset(t))
+}
+
+// ParseDate reads git's internal date format, "<unix seconds>
+// <+hhmm>", with an optional leading '@'. An RFC 3339 date is accepted
+// too.
+func ParseDate(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	secs, zone, _ := strings.Cut(strings.TrimPrefix(s, "@"), " ")
+	unix, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad date %q", s)
+	}
+	if zone == "" {
+		return time.Unix(unix, 0).UTC(), nil
+	}
+	if len(zone) != 5 || (zone[0] != '+' && zone[0] != '-') {
+		return time.Time{}, fmt.Errorf("bad date %q", s)
+	}
+	hours, err1 := strconv.Atoi(zone[1:3])
+	mins, err2 := strconv.Atoi(zone[3:5])
+	if err1 != nil || err2 != nil {
+		return time.Time{}, fmt.Errorf("bad date %q", s)
+	}
+	offset := hours*3600 + mins*60
+	if zone[0] == '-' {
+		offset = -offset
+	}
+	return time.Unix(unix, 0).In(time.FixedZone("", offset)), nil
+}
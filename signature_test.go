@@ -0,0 +1,39 @@
+package gitgo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSignature(t *testing.T) {
+	sig, err := ParseSignature("A U Thor <author@example.com> 1709274600 -0330")
+	assert.NoError(t, err)
+	assert.Equal(t, "A U Thor", sig.Name)
+	assert.Equal(t, "author@example.com", sig.Email)
+	assert.Equal(t, int64(1709274600), sig.When.Unix())
+	_, offset := sig.When.Zone()
+	assert.Equal(t, -(3*3600 + 30*60), offset)
+	assert.Equal(t, "A U Thor <author@example.com> 1709274600 -0330", sig.String())
+
+	_, err = ParseSignature("A U Thor author@example.com 1709274600 +0000")
+	assert.ErrorIs(t, err, ErrCorruptObject)
+	_, err = ParseSignature("A U Thor <author@example.com> yesterday")
+	assert.ErrorIs(t, err, ErrCorruptObject)
+}
+
+func TestParseDate(t *testing.T) {
+	for _, s := range []string{"1709274600 +0100", "@1709274600 +0100", "2024-03-01T07:30:00+01:00"} {
+		when, err := ParseDate(s)
+		assert.NoError(t, err, s)
+		assert.Equal(t, int64(1709274600), when.Unix(), s)
+		assert.Equal(t, "1709274600 +0100", FormatDate(when), s)
+	}
+	_, err := ParseDate("1709274600 0100")
+	assert.Error(t, err)
+
+	when, err := ParseDate("1709274600")
+	assert.NoError(t, err)
+	assert.Equal(t, time.UTC, when.Location())
+}
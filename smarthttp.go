@@ -0,0 +1,325 @@
+package gitgo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/Vikuuu/gitgo/internal/pktline"
+)
+
+var ErrProtocol = errors.New("protocol error")
+
+// userAgent is sent to servers, which log it.
+const userAgent = "gitgo/1.0"
+
+// httpSource fetches from a git server speaking the smart HTTP
+// protocol, version 2.
+type httpSource struct {
+	url    string
+	client *http.Client
+	// caps are the capabilities the server advertised, by name, with
+	// their value if they have one.
+	caps map[string]string
+	// progress gets what the server reports on the sideband.
+	progress io.Writer
+}
+
+func isHTTPURL(url string) bool {
+	return strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://")
+}
+
+// newHTTPSource asks the server at url for its protocol v2
+// capabilities.
+func newHTTPSource(ctx context.Context, url string, progress io.Writer) (*httpSource, error) {
+	s := &httpSource{
+		url:      strings.TrimSuffix(url, "/"),
+		client:   http.DefaultClient,
+		caps:     make(map[string]string),
+		progress: progress,
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url+"/info/refs?service=git-upload-pack", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Git-Protocol", "version=2")
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", ErrNotARepository, httpError(url, resp))
+	}
+
+	r := pktline.NewReader(resp.Body)
+	kind, line, err := r.ReadLine()
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading capabilities: %v", ErrProtocol, err)
+	}
+	// servers may still start with the v0 service announcement
+	if strings.HasPrefix(line, "# service=") {
+		if kind, _, err = r.ReadLine(); err != nil || kind != pktline.Flush {
+			return nil, fmt.Errorf("%w: bad service announcement", ErrProtocol)
+		}
+		if kind, line, err = r.ReadLine(); err != nil {
+			return nil, fmt.Errorf("%w: reading capabilities: %v", ErrProtocol, err)
+		}
+	}
+	if kind != pktline.Data || line != "version 2" {
+		return nil, fmt.Errorf("%w: %s does not speak protocol v2", ErrProtocol, url)
+	}
+	for {
+		kind, line, err := r.ReadLine()
+		if err != nil {
+			return nil, fmt.Errorf("%w: reading capabilities: %v", ErrProtocol, err)
+		}
+		if kind == pktline.Flush {
+			break
+		}
+		name, value, _ := strings.Cut(line, "=")
+		s.caps[name] = value
+	}
+	for _, command := range []string{"ls-refs", "fetch"} {
+		if _, ok := s.caps[command]; !ok {
+			return nil, fmt.Errorf("%w: server does not support %s", ErrProtocol, command)
+		}
+	}
+	return s, nil
+}
+
+// supports tells if the server lists feature for capability command,
+// like "unborn" in "ls-refs=unborn".
+func (s *httpSource) supports(command, feature string) bool {
+	return slices.Contains(strings.Fields(s.caps[command]), feature)
+}
+
+// command runs a protocol v2 command, the response body is the
+// caller's to close.
+func (s *httpSource) command(ctx context.Context, name string, args []string) (io.ReadCloser, error) {
+	var body bytes.Buffer
+	pktline.WriteString(&body, "command="+name+"\n")
+	pktline.WriteString(&body, "agent="+userAgent+"\n")
+	if format, ok := s.caps["object-format"]; ok {
+		pktline.WriteString(&body, "object-format="+format+"\n")
+	}
+	pktline.WriteSpecial(&body, pktline.Delim)
+	for _, arg := range args {
+		if err := pktline.WriteString(&body, arg+"\n"); err != nil {
+			return nil, err
+		}
+	}
+	pktline.WriteSpecial(&body, pktline.Flush)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url+"/git-upload-pack", &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-git-upload-pack-request")
+	req.Header.Set("Accept", "application/x-git-upload-pack-result")
+	req.Header.Set("Git-Protocol", "version=2")
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %s", ErrProtocol, httpError(s.url, resp))
+	}
+	return resp.Body, nil
+}
+
+func httpError(url string, resp *http.Response) string {
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Sprintf("%s: %s %s", url, resp.Status, bytes.TrimSpace(msg))
+}
+
+// advertise runs ls-refs, each line of the answer being
+// "<oid> <ref>[ symref-target:<ref>]", or "unborn HEAD ..." for the
+// HEAD of an empty repository.
+func (s *httpSource) advertise(ctx context.Context) (*advertisement, error) {
+	args := []string{"symrefs", "ref-prefix HEAD", "ref-prefix refs/heads/", "ref-prefix refs/tags/"}
+	if s.supports("ls-refs", "unborn") {
+		args = append(args, "unborn")
+	}
+	body, err := s.command(ctx, "ls-refs", args)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	adv := &advertisement{refs: make(map[string]string)}
+	r := pktline.NewReader(body)
+	for {
+		kind, line, err := r.ReadLine()
+		if err != nil {
+			return nil, fmt.Errorf("%w: reading refs: %v", ErrProtocol, err)
+		}
+		if kind == pktline.Flush {
+			return adv, nil
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%w: bad ref line %q", ErrProtocol, line)
+		}
+		oid, name := fields[0], fields[1]
+		var target string
+		for _, attr := range fields[2:] {
+			if t, ok := strings.CutPrefix(attr, "symref-target:"); ok {
+				target = t
+			}
+		}
+		switch {
+		case name == "HEAD":
+			adv.head = target
+			if oid != "unborn" {
+				adv.headOID = oid
+			}
+		case validOID(oid) != nil:
+			return nil, fmt.Errorf("%w: bad ref line %q", ErrProtocol, line)
+		default:
+			adv.refs[name] = oid
+		}
+	}
+}
+
+// fetch asks for a pack of the objects req wants. Sending "done" right
+// away skips the acknowledgments, and what the server has to send
+// reaches us in the shallow-info and packfile sections.
+func (s *httpSource) fetch(ctx context.Context, db *Database, req fetchRequest, progress Progress) (*fetchResponse, error) {
+	if (req.depth > 0 || len(req.shallow) > 0) && !s.supports("fetch", "shallow") {
+		return nil, fmt.Errorf("%w: server does not support shallow clients", ErrProtocol)
+	}
+	args := []string{"ofs-delta"}
+	if s.progress == nil {
+		args = append(args, "no-progress")
+	}
+	for _, oid := range req.wants {
+		args = append(args, "want "+oid)
+	}
+	for _, oid := range req.haves {
+		args = append(args, "have "+oid)
+	}
+	for _, oid := range req.shallow {
+		args = append(args, "shallow "+oid)
+	}
+	if req.depth > 0 {
+		args = append(args, fmt.Sprintf("deepen %d", req.depth))
+		if req.relative {
+			args = append(args, "deepen-relative")
+		}
+	}
+	args = append(args, "done")
+	body, err := s.command(ctx, "fetch", args)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	resp := &fetchResponse{}
+	r := pktline.NewReader(body)
+	for {
+		kind, line, err := r.ReadLine()
+		if err != nil {
+			return nil, fmt.Errorf("%w: reading fetch response: %v", ErrProtocol, err)
+		}
+		if kind != pktline.Data {
+			continue
+		}
+		if line == "packfile" {
+			break
+		}
+		if strings.HasPrefix(line, "ERR ") {
+			return nil, fmt.Errorf("%w: remote error: %s", ErrProtocol, strings.TrimPrefix(line, "ERR "))
+		}
+		switch verb, oid, _ := strings.Cut(line, " "); verb {
+		case "shallow":
+			resp.shallow = append(resp.shallow, oid)
+		case "unshallow":
+			resp.unshallow = append(resp.unshallow, oid)
+		}
+		// acknowledgments carry nothing we use
+	}
+
+	pack := &sidebandReader{r: r, progress: s.progress}
+	if resp.objects, err = unpackObjects(ctx, pack, db, progress); err != nil {
+		return nil, err
+	}
+	// drain to the flush, which can follow the last progress message
+	if _, err := io.Copy(io.Discard, pack); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// sidebandReader reads the pack data sent on band 1 of the packfile
+// section, copying the progress messages of band 2 to progress and
+// failing on the error of band 3.
+type sidebandReader struct {
+	r        *pktline.Reader
+	progress io.Writer
+	buf      []byte
+	done     bool
+}
+
+func (s *sidebandReader) Read(p []byte) (int, error) {
+	for len(s.buf) == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+		kind, data, err := s.r.Read()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		if kind != pktline.Data {
+			s.done = true
+			continue
+		}
+		if len(data) == 0 {
+			return 0, fmt.Errorf("%w: empty sideband packet", ErrProtocol)
+		}
+		switch data[0] {
+		case 1:
+			s.buf = data[1:]
+		case 2:
+			if s.progress != nil {
+				s.progress.Write(data[1:])
+			}
+		case 3:
+			return 0, fmt.Errorf("%w: remote error: %s", ErrProtocol, bytes.TrimSpace(data[1:]))
+		default:
+			return 0, fmt.Errorf("%w: bad sideband %d", ErrProtocol, data[0])
+		}
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+// sidebandWriter sends what is written to it on a band of the
+// sideband, in as many packets as it takes.
+type sidebandWriter struct {
+	w    io.Writer
+	band byte
+}
+
+func (s sidebandWriter) Write(p []byte) (int, error) {
+	for n := 0; n < len(p); {
+		chunk := p[n:min(len(p), n+pktline.MaxPayload-1)]
+		if err := pktline.Write(s.w, append([]byte{s.band}, chunk...)); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+	}
+	return len(p), nil
+}
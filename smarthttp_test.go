@@ -0,0 +1,170 @@
+package gitgo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/Vikuuu/gitgo/internal/pktline"
+	"github.com/stretchr/testify/assert"
+)
+
+// gitHTTPServer serves the repositories under root with git
+// http-backend, as a git server would.
+func gitHTTPServer(t *testing.T, root string) *httptest.Server {
+	t.Helper()
+	git, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git is not installed")
+	}
+	srv := httptest.NewServer(&cgi.Handler{
+		Path: git,
+		Args: []string{"http-backend"},
+		Env: []string{
+			"GIT_PROJECT_ROOT=" + root,
+			"GIT_HTTP_EXPORT_ALL=1",
+			"GIT_CONFIG_NOSYSTEM=1",
+			"HOME=" + root,
+		},
+	})
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestCloneFetchOverHTTP(t *testing.T) {
+	tmp := t.TempDir()
+	src := filepath.Join(tmp, "src")
+	assert.NoError(t, os.MkdirAll(filepath.Join(src, "dir"), 0755))
+	runGit(t, src, "init", "-q", "-b", "main")
+	for i, content := range []string{"one\n", "one\ntwo\n"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(src, "dir", "a.txt"), []byte(content), 0644))
+		assert.NoError(t, os.WriteFile(filepath.Join(src, "README"), []byte("readme\n"), 0644))
+		runGit(t, src, "add", ".")
+		runGit(t, src, "commit", "-q", "-m", string(rune('1'+i)))
+	}
+	runGit(t, src, "branch", "topic", "HEAD~1")
+	// deltas against objects already in the pack
+	runGit(t, src, "repack", "-adq")
+	srv := gitHTTPServer(t, tmp)
+
+	var remote bytes.Buffer
+	clone := NewRepositoryWithGitDir(filepath.Join(tmp, "clone"), GitDir)
+	clone.RemoteProgress = &remote
+	res, err := clone.Clone(context.Background(), srv.URL+"/src", CloneOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 9, res.Objects)
+	assert.Len(t, res.Updates, 2)
+	assert.Contains(t, remote.String(), "objects")
+
+	head := runGit(t, src, "rev-parse", "HEAD")
+	assert.Equal(t, head, runGit(t, clone.Path, "rev-parse", "HEAD"))
+	assert.Equal(t, "main", runGit(t, clone.Path, "rev-parse", "--abbrev-ref", "HEAD"))
+	assert.Equal(t, runGit(t, src, "rev-parse", "topic"), runGit(t, clone.Path, "rev-parse", "origin/topic"))
+	assert.Equal(t, "", runGit(t, clone.Path, "status", "--porcelain"))
+	assert.Equal(t, "", runGit(t, clone.Path, "fsck", "--strict", "--no-dangling"))
+
+	// a fetch only gets what is new
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "dir", "a.txt"), []byte("one\ntwo\nthree\n"), 0644))
+	runGit(t, src, "commit", "-q", "-am", "3")
+	repo, err := OpenWithGitDir(clone.Path, GitDir)
+	assert.NoError(t, err)
+	res, err = repo.Fetch(context.Background(), DefaultRemote, FetchOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, res.Objects)
+	assert.Equal(t, runGit(t, src, "rev-parse", "HEAD"), runGit(t, clone.Path, "rev-parse", "origin/main"))
+	assert.Equal(t, "", runGit(t, clone.Path, "fsck", "--strict", "--no-dangling"))
+
+	res, err = repo.Fetch(context.Background(), DefaultRemote, FetchOptions{})
+	assert.NoError(t, err)
+	assert.Zero(t, res.Objects)
+
+	_, err = repo.Push(context.Background(), DefaultRemote, PushOptions{})
+	assert.ErrorIs(t, err, errors.ErrUnsupported)
+}
+
+func TestCloneEmptyOverHTTP(t *testing.T) {
+	tmp := t.TempDir()
+	runGit(t, tmp, "init", "-q", "--bare", "-b", "trunk", "empty.git")
+	srv := gitHTTPServer(t, tmp)
+
+	clone := NewRepositoryWithGitDir(filepath.Join(tmp, "clone"), GitDir)
+	res, err := clone.Clone(context.Background(), srv.URL+"/empty.git", CloneOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, res.Updates)
+	head, err := RefInitialize(clone.Refs).HeadRef()
+	assert.NoError(t, err)
+	assert.Equal(t, "refs/heads/trunk", head)
+}
+
+func TestHTTPProtocolErrors(t *testing.T) {
+	ctx := context.Background()
+	oid := "ce013625030ba8dba906f756967f9e9ca394464a"
+	v2 := func(w http.ResponseWriter) {
+		pktline.WriteString(w, "version 2\n")
+		pktline.WriteString(w, "ls-refs\n")
+		pktline.WriteString(w, "fetch\n")
+		pktline.WriteSpecial(w, pktline.Flush)
+	}
+
+	for name, tc := range map[string]struct {
+		handler http.HandlerFunc
+		err     error
+	}{
+		"not found": {
+			handler: func(w http.ResponseWriter, r *http.Request) { http.NotFound(w, r) },
+			err:     ErrNotARepository,
+		},
+		"protocol v0": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				pktline.WriteString(w, "# service=git-upload-pack\n")
+				pktline.WriteSpecial(w, pktline.Flush)
+				pktline.WriteString(w, oid+" HEAD\x00multi_ack\n")
+				pktline.WriteSpecial(w, pktline.Flush)
+			},
+			err: ErrProtocol,
+		},
+		"bad ref": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodGet {
+					v2(w)
+					return
+				}
+				pktline.WriteString(w, "nothex refs/heads/main\n")
+				pktline.WriteSpecial(w, pktline.Flush)
+			},
+			err: ErrProtocol,
+		},
+		"remote error": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodGet {
+					v2(w)
+					return
+				}
+				var req bytes.Buffer
+				req.ReadFrom(r.Body)
+				if bytes.Contains(req.Bytes(), []byte("symrefs")) {
+					pktline.WriteString(w, oid+" HEAD symref-target:refs/heads/main\n")
+					pktline.WriteString(w, oid+" refs/heads/main\n")
+					pktline.WriteSpecial(w, pktline.Flush)
+					return
+				}
+				pktline.WriteString(w, "packfile\n")
+				pktline.Write(w, append([]byte{3}, "out of disk space\n"...))
+			},
+			err: ErrProtocol,
+		},
+	} {
+		srv := httptest.NewServer(tc.handler)
+		clone := NewRepository(filepath.Join(t.TempDir(), "clone"))
+		_, err := clone.Clone(ctx, srv.URL+"/repo", CloneOptions{})
+		assert.ErrorIs(t, err, tc.err, name)
+		srv.Close()
+	}
+}
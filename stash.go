@@ -0,0 +1,593 @@
+package gitgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stashRef points at the latest stash entry, its reflog holding all of
+// them, oldest first.
+const stashRef = "refs/stash"
+
+var ErrNoStash = errors.New("no stash entries found")
+
+// StashOptions are the settings of StashPush.
+type StashOptions struct {
+	// Name, Email and When are the author and committer of the stash
+	// commits and the identity of the reflog entry, When being the
+	// current time when zero.
+	Name, Email string
+	When        time.Time
+	// Message describes the entry instead of the commit HEAD is at.
+	Message string
+	// Untracked stashes the untracked files too, and removes them.
+	Untracked bool
+	// Paths limits the stash to the files under them, relative to the
+	// workspace unless absolute.
+	Paths []string
+}
+
+// StashEntry is a stash entry: its Name, like "stash@{0}" for the
+// latest one, the commit OID of its workspace and its Message.
+type StashEntry struct {
+	Name    string
+	OID     string
+	Message string
+}
+
+// StashConflictError stops applying a stash entry whose changes
+// conflict with the index on Paths. The conflicts are left in the index
+// and the workspace, and the entry is kept.
+type StashConflictError struct {
+	Paths []string
+}
+
+func (e *StashConflictError) Error() string {
+	return "conflicts in " + strings.Join(e.Paths, ", ")
+}
+
+// StashPush saves the changes of the index and of the tracked files of
+// the workspace, and with Untracked the untracked files, as a stash
+// entry, then takes them back to HEAD. The entry is a commit of the
+// workspace whose parents are HEAD, a commit of the index and, when
+// untracked files were saved, a commit of those only, like git makes
+// them. It returns nil when there is nothing to save.
+func (r Repository) StashPush(ctx context.Context, opts StashOptions) (*StashEntry, error) {
+	refs := RefInitialize(r.Refs)
+	head := refs.ReadHead()
+	if head == "" {
+		return nil, errors.New("you do not have the initial commit yet")
+	}
+	var paths []string
+	for _, path := range opts.Paths {
+		rel, err := r.relPath(path)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, rel)
+	}
+
+	status, err := r.StatusContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	headFiles, err := r.commitFiles("HEAD")
+	if err != nil {
+		return nil, err
+	}
+	staged, err := r.indexFiles()
+	if err != nil {
+		return nil, err
+	}
+	var untracked []string
+	if opts.Untracked {
+		if untracked, err = r.untrackedFiles(ctx, status); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkStashPaths(paths, headFiles, staged, status, untracked); err != nil {
+		return nil, err
+	}
+
+	// the files the stash takes back to HEAD, and the workspace
+	// changes it saves
+	changed := make(map[string]bool)
+	var modified []StatusEntry
+	for _, entry := range status.Changed {
+		if entry.Index == Unmerged || entry.Workspace == Unmerged {
+			return nil, ErrUnmergedFiles
+		}
+		for _, name := range []string{entry.Path, entry.From} {
+			if name != "" && stashMatch(paths, name) {
+				changed[name] = true
+			}
+		}
+		if entry.Workspace != Unmodified && stashMatch(paths, entry.Path) {
+			modified = append(modified, entry)
+		}
+	}
+	untracked = slices.DeleteFunc(untracked, func(name string) bool { return !stashMatch(paths, name) })
+	if len(changed) == 0 && len(untracked) == 0 {
+		return nil, nil
+	}
+
+	db := r.ObjectDatabase()
+	commit, err := db.ReadCommit(head)
+	if err != nil {
+		return nil, err
+	}
+	branch := "(no branch)"
+	if name, err := refs.HeadRef(); err != nil {
+		return nil, err
+	} else if name != "" {
+		branch = ShortRefName(name)
+	}
+	onto := fmt.Sprintf("%s: %s %s", branch, head[:7], FirstLine(commit.Message))
+	when := opts.When
+	if when.IsZero() {
+		when = time.Now()
+	}
+	who := AuthorData(opts.Name, opts.Email, when)
+
+	indexOID, err := r.storeStashCommit(ctx, []string{head}, staged, who, "index on "+onto+"\n")
+	if err != nil {
+		return nil, err
+	}
+	parents := []string{head, indexOID}
+	if len(untracked) > 0 {
+		var files []TreeEntry
+		for _, name := range untracked {
+			oid, stat, err := storeFile(ctx, db, filepath.Join(r.Path, name))
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, TreeEntry{Name: name, Mode: modeForStat(stat), OID: oid})
+		}
+		untrackedOID, err := r.storeStashCommit(ctx, nil, files, who, "untracked files on "+onto+"\n")
+		if err != nil {
+			return nil, err
+		}
+		parents = append(parents, untrackedOID)
+	}
+
+	workspace := make(map[string]TreeEntry)
+	for _, file := range staged {
+		workspace[file.Name] = file
+	}
+	for _, entry := range modified {
+		if entry.Workspace == Deleted {
+			delete(workspace, entry.Path)
+			continue
+		}
+		oid, stat, err := storeFile(ctx, db, filepath.Join(r.Path, entry.Path))
+		if err != nil {
+			return nil, err
+		}
+		file := TreeEntry{Name: entry.Path, Mode: modeForStat(stat), OID: oid}
+		if old, ok := workspace[entry.Path]; ok && old.Mode == gitlinkMode {
+			file.Mode = gitlinkMode
+		}
+		workspace[entry.Path] = file
+	}
+	// git writes the message of this commit without a final newline
+	message := "WIP on " + onto
+	if opts.Message != "" {
+		message = "On " + branch + ": " + opts.Message
+	}
+	files := slices.Collect(maps.Values(workspace))
+	oid, err := r.storeStashCommit(ctx, parents, files, who, message)
+	if err != nil {
+		return nil, err
+	}
+	old, err := refs.ReadRef(stashRef)
+	if err != nil {
+		return nil, err
+	}
+	if err := refs.UpdateRef(stashRef, oid); err != nil {
+		return nil, err
+	}
+	if err := refs.AppendReflog(stashRef, old, oid, who, message); err != nil {
+		return nil, err
+	}
+
+	if err := r.resetStashed(headFiles, slices.Sorted(maps.Keys(changed))); err != nil {
+		return nil, err
+	}
+	for _, name := range untracked {
+		removeWorkspaceFile(r.Path, name)
+	}
+	return &StashEntry{Name: "stash@{0}", OID: oid, Message: message}, nil
+}
+
+// stashMatch tells if the file name is under one of paths, any file
+// being when there are none.
+func stashMatch(paths []string, name string) bool {
+	if len(paths) == 0 {
+		return true
+	}
+	for _, path := range paths {
+		if path == "." || name == path || strings.HasPrefix(name, path+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// checkStashPaths fails with ErrPathspecMismatch when one of paths has
+// no file of HEAD, the index, the workspace or untracked under it.
+func checkStashPaths(paths []string, head, staged []TreeEntry, status *Status, untracked []string) error {
+	var known []string
+	for _, files := range [][]TreeEntry{head, staged} {
+		for _, file := range files {
+			known = append(known, file.Name)
+		}
+	}
+	for _, entry := range status.Changed {
+		known = append(known, entry.Path)
+	}
+	known = append(known, untracked...)
+	for _, path := range paths {
+		if !slices.ContainsFunc(known, func(name string) bool { return stashMatch([]string{path}, name) }) {
+			return fmt.Errorf("%w: '%s'", ErrPathspecMismatch, path)
+		}
+	}
+	return nil
+}
+
+// untrackedFiles lists the untracked files of status, those inside
+// untracked directories included, sorted. Nested repositories are left
+// out.
+func (r Repository) untrackedFiles(ctx context.Context, status *Status) ([]string, error) {
+	var files []string
+	for _, path := range status.Untracked {
+		dir, ok := strings.CutSuffix(path, string(filepath.Separator))
+		if !ok {
+			files = append(files, filepath.ToSlash(path))
+			continue
+		}
+		found, err := ListFilesContext(ctx, filepath.Join(r.Path, dir), r.Path)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range found {
+			if !isNestedRepository(filepath.Join(r.Path, name)) {
+				files = append(files, filepath.ToSlash(name))
+			}
+		}
+	}
+	slices.Sort(files)
+	return files, nil
+}
+
+// storeStashCommit stores a tree of files and a commit of it with
+// parents, by who, for message.
+func (r Repository) storeStashCommit(ctx context.Context, parents []string, files []TreeEntry, who, message string) (string, error) {
+	db := r.ObjectDatabase()
+	var entries []Entries
+	for _, file := range files {
+		entries = append(entries, Entries{Path: file.Name, OID: file.OID, Stat: strconv.FormatUint(uint64(file.Mode), 8)})
+	}
+	root, err := TraverseTreeContext(ctx, db, BuildTree(entries), "")
+	if err != nil {
+		return "", err
+	}
+	db.Data(TypeTree, CreateTreeEntry(root))
+	tree, err := db.StoreContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	db.Data(TypeCommit, commitDataParents(parents, tree, who, who, message))
+	return db.StoreContext(ctx)
+}
+
+// resetStashed takes the files names back to their version in head,
+// in the index and in the workspace, removing those head does not
+// have.
+func (r Repository) resetStashed(head []TreeEntry, names []string) error {
+	headFiles := make(map[string]TreeEntry)
+	for _, file := range head {
+		headFiles[file.Name] = file
+	}
+	db := r.ObjectDatabase()
+	_, index, err := r.holdIndex()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		file, ok := headFiles[name]
+		if !ok {
+			removeWorkspaceFile(r.Path, name)
+			index.remove(name)
+			continue
+		}
+		stat, err := checkoutFile(db, r.Path, name, file.OID, file.Mode)
+		if err != nil {
+			index.Release()
+			return fmt.Errorf("checkout %s: %w", name, err)
+		}
+		entry := NewIndexEntry(name, file.OID, stat)
+		entry.Mode = file.Mode
+		index.add(entry)
+	}
+	_, err = index.WriteUpdate()
+	return err
+}
+
+// StashList returns the stash entries, the latest first.
+func (r Repository) StashList() ([]StashEntry, error) {
+	refs := RefInitialize(r.Refs)
+	log, err := refs.ReadReflog(stashRef)
+	if err != nil {
+		return nil, err
+	}
+	if len(log) == 0 {
+		// a stash ref without a reflog is a single entry
+		oid, err := refs.ReadRef(stashRef)
+		if err != nil || oid == "" {
+			return nil, err
+		}
+		commit, err := r.ObjectDatabase().ReadCommit(oid)
+		if err != nil {
+			return nil, err
+		}
+		return []StashEntry{{Name: "stash@{0}", OID: oid, Message: FirstLine(commit.Message)}}, nil
+	}
+	entries := make([]StashEntry, len(log))
+	for i, e := range log {
+		n := len(log) - 1 - i
+		entries[n] = StashEntry{Name: fmt.Sprintf("stash@{%d}", n), OID: e.New, Message: e.Message}
+	}
+	return entries, nil
+}
+
+// stashEntry returns the entry rev names: "stash@{<n>}", or just n,
+// the latest one when empty.
+func (r Repository) stashEntry(rev string) (*StashEntry, int, error) {
+	entries, err := r.StashList()
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(entries) == 0 {
+		return nil, 0, ErrNoStash
+	}
+	if rev == "" {
+		return &entries[0], 0, nil
+	}
+	digits := rev
+	if inner, ok := strings.CutPrefix(rev, "stash@{"); ok {
+		digits, ok = strings.CutSuffix(inner, "}")
+		if !ok {
+			digits = ""
+		}
+	}
+	n, err := strconv.Atoi(digits)
+	if err != nil || n < 0 || n >= len(entries) || strconv.Itoa(n) != digits {
+		return nil, 0, fmt.Errorf("%w: '%s' is not a stash entry", ErrUnknownRevision, rev)
+	}
+	return &entries[n], n, nil
+}
+
+// StashDiff returns the changes the stash entry rev saved to the
+// tracked files, against the commit it was made on.
+func (r Repository) StashDiff(rev string) ([]FileChange, error) {
+	entry, _, err := r.stashEntry(rev)
+	if err != nil {
+		return nil, err
+	}
+	db := r.ObjectDatabase()
+	commit, err := db.ReadCommit(entry.OID)
+	if err != nil {
+		return nil, err
+	}
+	base, err := r.commitFiles(commit.Parent())
+	if err != nil {
+		return nil, err
+	}
+	files, err := db.ListTree(commit.Tree)
+	if err != nil {
+		return nil, err
+	}
+	return detectRenames(diffFiles(base, files), r.RenameConfig("diff.renames"), r.readFileContent)
+}
+
+// StashApply merges the changes of the stash entry rev, the latest when
+// empty, into the index and the workspace, and restores its untracked
+// files. Like git, the changes end up in the workspace only, but for
+// the files the index did not have, which are added to it. Conflicts
+// are left in the index with a *StashConflictError. It fails with
+// ErrLocalChanges, before changing anything, when a file it changes
+// differs from the index or is in the way of an untracked one.
+func (r Repository) StashApply(ctx context.Context, rev string) (*StashEntry, error) {
+	entry, _, err := r.stashEntry(rev)
+	if err != nil {
+		return nil, err
+	}
+	db := r.ObjectDatabase()
+	commit, err := db.ReadCommit(entry.OID)
+	if err != nil {
+		return nil, err
+	}
+	if len(commit.Parents) < 2 {
+		return nil, fmt.Errorf("'%s' is not a stash-like commit", entry.Name)
+	}
+	base, err := r.commitFiles(commit.Parent())
+	if err != nil {
+		return nil, err
+	}
+	theirs, err := db.ListTree(commit.Tree)
+	if err != nil {
+		return nil, err
+	}
+	var untracked []TreeEntry
+	if len(commit.Parents) > 2 {
+		if untracked, err = r.commitFiles(commit.Parents[2]); err != nil {
+			return nil, err
+		}
+	}
+
+	status, err := r.StatusContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ours, err := r.indexFiles()
+	if err != nil {
+		return nil, err
+	}
+	merged, conflicts, err := mergeFiles(db, base, ours, theirs, mergeLabels{ours: "Updated upstream", theirs: "Stashed changes"})
+	if err != nil {
+		return nil, err
+	}
+	conflicted := make(map[string]bool)
+	for _, c := range conflicts {
+		conflicted[c.Path] = true
+	}
+	var changes []FileChange
+	touched := maps.Clone(conflicted)
+	for _, c := range diffFiles(ours, merged) {
+		if !conflicted[c.Path()] {
+			changes = append(changes, c)
+			touched[c.Path()] = true
+		}
+	}
+
+	tracked := make(map[string]bool)
+	for _, file := range ours {
+		tracked[file.Name] = true
+	}
+	var dirty []string
+	for _, e := range status.Changed {
+		if e.Index == Unmerged || e.Workspace == Unmerged {
+			return nil, ErrUnmergedFiles
+		}
+		if touched[e.Path] && e.Workspace != Unmodified {
+			dirty = append(dirty, e.Path)
+		}
+	}
+	inHead := trackedPaths(ours)
+	for _, path := range slices.Sorted(maps.Keys(touched)) {
+		if _, err := os.Lstat(filepath.Join(r.Path, path)); err == nil && !inHead[path] {
+			dirty = append(dirty, path)
+		}
+	}
+	for _, file := range untracked {
+		if _, err := os.Lstat(filepath.Join(r.Path, file.Name)); err == nil {
+			dirty = append(dirty, file.Name)
+		}
+	}
+	if len(dirty) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrLocalChanges, strings.Join(dirty, ", "))
+	}
+
+	_, index, err := r.holdIndex()
+	if err != nil {
+		return nil, err
+	}
+	// as in applyMerge, deletions go first
+	for _, c := range changes {
+		if c.Type == Deleted {
+			removeWorkspaceFile(r.Path, c.Path())
+			// the deletion is only staged along with conflicts
+			if len(conflicts) > 0 {
+				index.remove(c.Path())
+			}
+		}
+	}
+	for _, c := range changes {
+		if c.Type == Deleted {
+			continue
+		}
+		stat, err := checkoutFile(db, r.Path, c.To.Name, c.To.OID, c.To.Mode)
+		if err != nil {
+			index.Release()
+			return nil, fmt.Errorf("checkout %s: %w", c.To.Name, err)
+		}
+		// the index entry of a modified file is kept as is, which its
+		// new stat data would not match
+		if len(conflicts) > 0 || !tracked[c.To.Name] {
+			e := NewIndexEntry(c.To.Name, c.To.OID, stat)
+			e.Mode = c.To.Mode
+			index.add(e)
+		}
+	}
+	for _, c := range conflicts {
+		if err := writeWorkspaceFile(filepath.Join(r.Path, c.Path), c.content, c.mode); err != nil {
+			index.Release()
+			return nil, fmt.Errorf("write %s: %w", c.Path, err)
+		}
+		index.addUnmerged(c.Path, [3]*TreeEntry{c.Base, c.Ours, c.Theirs})
+	}
+	if _, err := index.WriteUpdate(); err != nil {
+		return nil, err
+	}
+	for _, file := range untracked {
+		if _, err := checkoutFile(db, r.Path, file.Name, file.OID, file.Mode); err != nil {
+			return nil, fmt.Errorf("checkout %s: %w", file.Name, err)
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return entry, &StashConflictError{Paths: slices.Sorted(maps.Keys(conflicted))}
+	}
+	return entry, nil
+}
+
+// StashPop applies the stash entry rev, the latest when empty, and
+// drops it unless applying it failed or left conflicts.
+func (r Repository) StashPop(ctx context.Context, rev string) (*StashEntry, error) {
+	entry, err := r.StashApply(ctx, rev)
+	if err != nil {
+		return entry, err
+	}
+	return r.StashDrop(entry.Name)
+}
+
+// StashDrop removes the stash entry rev, the latest when empty, and
+// returns it. Dropping the latest entry moves refs/stash to the one
+// before it, and deletes it when none is left.
+func (r Repository) StashDrop(rev string) (*StashEntry, error) {
+	entry, n, err := r.stashEntry(rev)
+	if err != nil {
+		return nil, err
+	}
+	refs := RefInitialize(r.Refs)
+	log, err := refs.ReadReflog(stashRef)
+	if err != nil {
+		return nil, err
+	}
+	if len(log) > 0 {
+		log = slices.Delete(log, len(log)-1-n, len(log)-n)
+	}
+	// like `git reflog delete --rewrite`, every entry moves from the
+	// one before it
+	for i := range log {
+		log[i].Old = zeroOID
+		if i > 0 {
+			log[i].Old = log[i-1].New
+		}
+	}
+	if len(log) == 0 {
+		if err := refs.DeleteRef(stashRef); err != nil {
+			return nil, err
+		}
+	} else if err := refs.UpdateRef(stashRef, log[len(log)-1].New); err != nil {
+		return nil, err
+	}
+	return entry, refs.WriteReflog(stashRef, log)
+}
+
+// StashClear removes every stash entry.
+func (r Repository) StashClear() error {
+	refs := RefInitialize(r.Refs)
+	if err := refs.DeleteRef(stashRef); err != nil {
+		return err
+	}
+	return refs.WriteReflog(stashRef, nil)
+}
@@ -0,0 +1,221 @@
+package gitgo
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeFile writes content to the workspace file name.
+func writeFile(t *testing.T, repo *Repository, name, content string) {
+	path := filepath.Join(repo.Path, name)
+	assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+}
+
+// shortStatus lists the changes of status as "XY path" lines, like
+// `git status --porcelain`.
+func shortStatus(t *testing.T, repo *Repository) []string {
+	status, err := repo.Status()
+	assert.NoError(t, err)
+	var lines []string
+	for _, e := range status.Changed {
+		lines = append(lines, string([]rune{e.Index.Code(), e.Workspace.Code(), ' '})+e.Path)
+	}
+	for _, path := range status.Untracked {
+		lines = append(lines, "?? "+filepath.ToSlash(path))
+	}
+	return lines
+}
+
+func TestStash(t *testing.T) {
+	ctx := context.Background()
+	repo, err := Init(t.TempDir())
+	assert.NoError(t, err)
+	head := commitFile(t, repo, "a.txt", "a\n")
+
+	writeFile(t, repo, "a.txt", "a!\n")
+	writeFile(t, repo, "new.txt", "new\n")
+	_, err = repo.Add("new.txt")
+	assert.NoError(t, err)
+	writeFile(t, repo, "u/x.txt", "x\n")
+
+	entry, err := repo.StashPush(ctx, StashOptions{Name: "A", Email: "a@example.com", Untracked: true})
+	assert.NoError(t, err)
+	assert.Equal(t, "stash@{0}", entry.Name)
+	assert.Equal(t, "WIP on master: "+head[:7]+" a", entry.Message)
+	assert.Empty(t, shortStatus(t, repo))
+	assert.Equal(t, "a\n", readFile(t, repo, "a.txt"))
+	assert.NoFileExists(t, filepath.Join(repo.Path, "new.txt"))
+	assert.NoDirExists(t, filepath.Join(repo.Path, "u"))
+
+	// the workspace commit has HEAD, the index and the untracked files
+	// as parents
+	db := repo.ObjectDatabase()
+	commit, err := db.ReadCommit(entry.OID)
+	assert.NoError(t, err)
+	assert.Len(t, commit.Parents, 3)
+	assert.Equal(t, head, commit.Parents[0])
+	index, err := db.ReadCommit(commit.Parents[1])
+	assert.NoError(t, err)
+	assert.Equal(t, []string{head}, index.Parents)
+	assert.Equal(t, "index on master: "+head[:7]+" a\n", index.Message)
+	untracked, err := db.ReadCommit(commit.Parents[2])
+	assert.NoError(t, err)
+	assert.Empty(t, untracked.Parents)
+	files, err := db.ListTree(untracked.Tree)
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+	assert.Equal(t, "u/x.txt", files[0].Name)
+
+	changes, err := repo.StashDiff("")
+	assert.NoError(t, err)
+	assert.Len(t, changes, 2)
+	assert.Equal(t, Modified, changes[0].Type)
+	assert.Equal(t, Added, changes[1].Type)
+
+	// nothing left to save
+	entry, err = repo.StashPush(ctx, StashOptions{Untracked: true})
+	assert.NoError(t, err)
+	assert.Nil(t, entry)
+
+	writeFile(t, repo, "a.txt", "a?\n")
+	_, err = repo.StashPush(ctx, StashOptions{Message: "second"})
+	assert.NoError(t, err)
+	entries, err := repo.StashList()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "On master: second", entries[0].Message)
+	assert.Equal(t, "stash@{1}", entries[1].Name)
+
+	// modified files come back unstaged, new ones staged
+	popped, err := repo.StashPop(ctx, "stash@{1}")
+	assert.NoError(t, err)
+	assert.Equal(t, entries[1].OID, popped.OID)
+	assert.Equal(t, []string{" M a.txt", "A  new.txt", "?? u/"}, shortStatus(t, repo))
+	assert.Equal(t, "a!\n", readFile(t, repo, "a.txt"))
+	assert.Equal(t, "x\n", readFile(t, repo, "u/x.txt"))
+	entries, err = repo.StashList()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "On master: second", entries[0].Message)
+	stash, err := RefInitialize(repo.Refs).ReadRef("refs/stash")
+	assert.NoError(t, err)
+	assert.Equal(t, entries[0].OID, stash)
+
+	assert.NoError(t, repo.StashClear())
+	entries, err = repo.StashList()
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+	_, err = repo.StashDrop("")
+	assert.ErrorIs(t, err, ErrNoStash)
+}
+
+func TestStashPaths(t *testing.T) {
+	ctx := context.Background()
+	repo, err := Init(t.TempDir())
+	assert.NoError(t, err)
+	commitFile(t, repo, "a.txt", "a\n")
+	commitFile(t, repo, "b.txt", "b\n")
+
+	writeFile(t, repo, "a.txt", "a!\n")
+	writeFile(t, repo, "b.txt", "b!\n")
+	_, err = repo.StashPush(ctx, StashOptions{Paths: []string{"b.txt"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{" M a.txt"}, shortStatus(t, repo))
+	assert.Equal(t, "b\n", readFile(t, repo, "b.txt"))
+
+	changes, err := repo.StashDiff("stash@{0}")
+	assert.NoError(t, err)
+	assert.Len(t, changes, 1)
+	assert.Equal(t, "b.txt", changes[0].Path())
+
+	_, err = repo.StashPush(ctx, StashOptions{Paths: []string{"c.txt"}})
+	assert.ErrorIs(t, err, ErrPathspecMismatch)
+}
+
+func TestStashPopSwapsFileAndDirectory(t *testing.T) {
+	ctx := context.Background()
+	repo, err := Init(t.TempDir())
+	assert.NoError(t, err)
+	commitFile(t, repo, "a.txt", "a\n")
+	writeFile(t, repo, "d/x", "x\n")
+	_, err = repo.Add("d/x")
+	assert.NoError(t, err)
+	_, err = repo.Commit(CommitOptions{Message: "dir\n"})
+	assert.NoError(t, err)
+
+	assert.NoError(t, os.RemoveAll(filepath.Join(repo.Path, "d")))
+	writeFile(t, repo, "d", "d\n")
+	_, err = repo.AddAll(".")
+	assert.NoError(t, err)
+	_, err = repo.StashPush(ctx, StashOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "x\n", readFile(t, repo, "d/x"))
+
+	_, err = repo.StashPop(ctx, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "d\n", readFile(t, repo, "d"))
+}
+
+func TestStashConflict(t *testing.T) {
+	ctx := context.Background()
+	repo, err := Init(t.TempDir())
+	assert.NoError(t, err)
+	commitFile(t, repo, "a.txt", "a\n")
+
+	writeFile(t, repo, "a.txt", "stashed\n")
+	_, err = repo.StashPush(ctx, StashOptions{})
+	assert.NoError(t, err)
+	commitFile(t, repo, "a.txt", "upstream\n")
+
+	// local changes to the file are not overwritten
+	writeFile(t, repo, "a.txt", "local\n")
+	_, err = repo.StashApply(ctx, "")
+	assert.ErrorIs(t, err, ErrLocalChanges)
+	_, err = repo.Checkout("a.txt")
+	assert.NoError(t, err)
+
+	_, err = repo.StashPop(ctx, "")
+	var cerr *StashConflictError
+	assert.ErrorAs(t, err, &cerr)
+	assert.Equal(t, []string{"a.txt"}, cerr.Paths)
+	assert.Equal(t, "<<<<<<< Updated upstream\nupstream\n=======\nstashed\n>>>>>>> Stashed changes\n", readFile(t, repo, "a.txt"))
+	assert.Equal(t, []string{"UU a.txt"}, shortStatus(t, repo))
+
+	// the entry is kept
+	entries, err := repo.StashList()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	_, err = repo.StashPush(ctx, StashOptions{})
+	assert.ErrorIs(t, err, ErrUnmergedFiles)
+}
+
+func TestStashErrors(t *testing.T) {
+	ctx := context.Background()
+	repo, err := Init(t.TempDir())
+	assert.NoError(t, err)
+	_, err = repo.StashPush(ctx, StashOptions{})
+	assert.Error(t, err)
+
+	commitFile(t, repo, "a.txt", "a\n")
+	_, err = repo.StashApply(ctx, "")
+	assert.ErrorIs(t, err, ErrNoStash)
+
+	writeFile(t, repo, "u.txt", "u\n")
+	_, err = repo.StashPush(ctx, StashOptions{Untracked: true})
+	assert.NoError(t, err)
+	for _, rev := range []string{"stash@{1}", "1", "stash@{x}", "stash@{01}"} {
+		_, err = repo.StashApply(ctx, rev)
+		assert.ErrorIs(t, err, ErrUnknownRevision, rev)
+	}
+
+	// an untracked file in the way of one of the stash
+	writeFile(t, repo, "u.txt", "other\n")
+	_, err = repo.StashApply(ctx, "0")
+	assert.ErrorIs(t, err, ErrLocalChanges)
+	assert.Equal(t, "other\n", readFile(t, repo, "u.txt"))
+}
@@ -0,0 +1,465 @@
+package gitgo
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/Vikuuu/gitgo/internal/datastr"
+	"github.com/Vikuuu/gitgo/internal/workpool"
+)
+
+type ChangeType int
+
+const (
+	Unmodified ChangeType = iota
+	Modified
+	Deleted
+	Added
+	Renamed
+	Copied
+	// Unmerged is the side of a conflict that still has the file, or
+	// both sides when both do.
+	Unmerged
+)
+
+// Code is the letter status prints for the change.
+func (c ChangeType) Code() rune {
+	switch c {
+	case Modified:
+		return 'M'
+	case Deleted:
+		return 'D'
+	case Added:
+		return 'A'
+	case Renamed:
+		return 'R'
+	case Copied:
+		return 'C'
+	case Unmerged:
+		return 'U'
+	}
+	return ' '
+}
+
+// StatusEntry is a file whose index entry differs from HEAD, Index,
+// or whose workspace file differs from the index, Workspace. From is
+// the file of HEAD the index renamed or copied to Path. A conflict left
+// by a merge is Unmerged on the sides that changed the file.
+type StatusEntry struct {
+	Path      string
+	From      string
+	Index     ChangeType
+	Workspace ChangeType
+}
+
+// Status of the workspace against the index. Changed is sorted by
+// path, Untracked lists files and directories holding untracked
+// files, the latter with a trailing separator.
+type Status struct {
+	Changed   []StatusEntry
+	Untracked []string
+}
+
+// Status compares the index to HEAD and the workspace to the index.
+// The files the index removed and added are paired into renames as
+// status.renames, or else diff.renames, asks for. Stat data of files
+// whose content turned out unchanged is written back to the index,
+// unless another process holds the index lock.
+func (r Repository) Status() (*Status, error) {
+	return r.StatusContext(context.Background())
+}
+
+// StatusContext is Status that stops scanning the workspace once ctx
+// is done.
+func (r Repository) StatusContext(ctx context.Context) (*Status, error) {
+	index := NewIndex(r.Path, r.GitPath)
+	if err := index.Load(); err != nil {
+		return nil, err
+	}
+
+	stats := make(map[string]os.FileInfo)
+	untracked := datastr.NewSortedSet()
+	if err := r.scanWorkspace(ctx, untracked, "", index, stats); err != nil {
+		return nil, err
+	}
+
+	changes := make(map[string]ChangeType)
+	if err := r.detectWorkspaceChanges(ctx, changes, index, stats); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	staged, from, err := r.detectIndexChanges(index)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range index.Unmerged() {
+		staged[path], changes[path] = unmergedStatus(index.unmerged[path])
+	}
+
+	// Refreshing the index is only an optimisation, skip it when
+	// someone else holds the lock.
+	if _, err := index.WriteUpdate(); err != nil && !errors.Is(err, ErrLockDenied) {
+		return nil, err
+	}
+
+	paths := slices.Collect(maps.Keys(changes))
+	for path := range staged {
+		if _, ok := changes[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	slices.Sort(paths)
+
+	status := &Status{}
+	for _, path := range paths {
+		status.Changed = append(status.Changed, StatusEntry{
+			Path:      path,
+			From:      from[path],
+			Index:     staged[path],
+			Workspace: changes[path],
+		})
+	}
+	it := untracked.Iterator()
+	for it.Next() {
+		status.Untracked = append(status.Untracked, it.Key())
+	}
+	return status, nil
+}
+
+func (r Repository) scanWorkspace(
+	ctx context.Context,
+	untracked *datastr.SortedSet,
+	prefix string,
+	index *Index,
+	stats map[string]os.FileInfo,
+) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	fileStats, err := listDir(r.Path, prefix)
+	if err != nil {
+		return err
+	}
+	for rel, stat := range fileStats {
+		if index.IsTracked(rel) {
+			_, gitlink := index.entries[rel]
+			if stat.IsDir() && !gitlink {
+				if err := r.scanWorkspace(ctx, untracked, rel, index, stats); err != nil {
+					return err
+				}
+			} else {
+				stats[rel] = stat
+			}
+		} else {
+			trackablefile, err := r.trackableFile(rel, stat, index)
+			if err != nil {
+				return err
+			}
+			if trackablefile {
+				if stat.IsDir() {
+					rel += string(filepath.Separator)
+				}
+				untracked.Add(rel)
+			}
+		}
+	}
+
+	return nil
+}
+
+func listDir(base, dirname string) (map[string]os.FileInfo, error) {
+	path := filepath.Join(base, dirname)
+
+	dirEntries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	stats := make(map[string]os.FileInfo, len(dirEntries))
+
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		if _, skip := G_ignore[name]; skip {
+			continue
+		}
+
+		rel, err := filepath.Rel(base, filepath.Join(path, name))
+		if err != nil {
+			return nil, err
+		}
+
+		info, err := dirEntry.Info()
+		if err != nil {
+			return nil, err
+		}
+		stats[rel] = info
+	}
+
+	return stats, nil
+}
+
+func (r Repository) trackableFile(path string, stat os.FileInfo, index *Index) (bool, error) {
+	if stat == nil {
+		return false, nil
+	}
+
+	if !stat.IsDir() {
+		return !index.IsTracked(path), nil
+	}
+	// a nested repository could be added as a gitlink
+	if isNestedRepository(filepath.Join(r.Path, path)) {
+		return true, nil
+	}
+
+	items, err := listDir(r.Path, path)
+	if err != nil {
+		return false, err
+	}
+	files := make(map[string]os.FileInfo)
+	dirs := make(map[string]os.FileInfo)
+
+	for rel, item := range items {
+		if item.IsDir() {
+			dirs[rel] = item
+		} else {
+			files[rel] = item
+		}
+	}
+
+	for filePath, file := range files {
+		ok, err := r.trackableFile(filePath, file, index)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+
+	for dirPath, dir := range dirs {
+		ok, err := r.trackableFile(dirPath, dir, index)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (r Repository) detectWorkspaceChanges(
+	ctx context.Context,
+	changes map[string]ChangeType,
+	index *Index,
+	stats map[string]os.FileInfo,
+) error {
+	entries := index.IndexEntries()
+	names := slices.Sorted(maps.Keys(entries))
+
+	// Entries whose stat data cannot tell if they changed need
+	// their content hashed, which is done in parallel.
+	var pending []IndexEntry
+	for _, name := range names {
+		entry := entries[name]
+		if entry.SkipWorktree() {
+			continue
+		}
+		if !checkIndexEntryStat(changes, stats, &entry, name) {
+			pending = append(pending, entry)
+		}
+	}
+
+	oids := make([]string, len(pending))
+	progress := newProgressCounter(r.Progress, "Refreshing index", len(pending))
+	err := workpool.RunContext(ctx, workpool.Size(r.Workers), len(pending), func(i int) error {
+		oid, err := workspaceOID(ctx, filepath.Join(r.Path, pending[i].Path), pending[i])
+		oids[i] = oid
+		progress.add()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := range pending {
+		entry := pending[i]
+		if oids[i] == entry.Oid {
+			// If the file content is same, but the file has different
+			// metadata on the disk, update them so that we can
+			// use them next time.
+			index.UpdateEntryStat(&entry, stats[entry.Path])
+		} else {
+			changes[entry.Path] = Modified
+		}
+	}
+	return nil
+}
+
+// detectIndexChanges compares the index entries to the files of the
+// HEAD commit. from maps the files renamed or copied to their source.
+func (r Repository) detectIndexChanges(index *Index) (changes map[string]ChangeType, from map[string]string, err error) {
+	database := r.ObjectDatabase()
+	var head []TreeEntry
+	if oid := RefInitialize(r.Refs).ReadHead(); oid != "" {
+		commit, err := database.ReadCommit(oid)
+		if err != nil {
+			return nil, nil, err
+		}
+		if head, err = database.ListTree(commit.Tree); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// conflicts are shown apart
+	unmerged := index.Unmerged()
+	head = slices.DeleteFunc(head, func(e TreeEntry) bool {
+		_, found := slices.BinarySearch(unmerged, e.Name)
+		return found
+	})
+
+	var staged []TreeEntry
+	for _, name := range slices.Sorted(maps.Keys(index.IndexEntries())) {
+		entry := index.IndexEntries()[name]
+		// an intent-to-add entry is only a workspace addition
+		if !entry.IntentToAdd() {
+			staged = append(staged, TreeEntry{Name: name, Mode: entry.Mode, OID: entry.Oid})
+		}
+	}
+	files, err := detectRenames(diffFiles(head, staged), r.RenameConfig("status.renames", "diff.renames"), func(e TreeEntry) ([]byte, error) {
+		return database.readTyped(e.OID, TypeFile)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	changes = make(map[string]ChangeType)
+	from = make(map[string]string)
+	for _, f := range files {
+		changes[f.Path()] = f.Type
+		if f.Type == Renamed || f.Type == Copied {
+			from[f.Path()] = f.From.Name
+		}
+	}
+	return changes, from, nil
+}
+
+// unmergedStatus is how status shows a conflict from its base, ours
+// and theirs versions: by the sides that have the file, Added when the
+// base did not.
+func unmergedStatus(stages [3]*IndexEntry) (index, workspace ChangeType) {
+	base, ours, theirs := stages[0] != nil, stages[1] != nil, stages[2] != nil
+	switch {
+	case ours && theirs && base:
+		return Unmerged, Unmerged
+	case ours && theirs:
+		return Added, Added
+	case base && theirs:
+		return Deleted, Unmerged
+	case base && ours:
+		return Unmerged, Deleted
+	case ours:
+		return Added, Unmerged
+	case theirs:
+		return Unmerged, Added
+	}
+	return Deleted, Deleted
+}
+
+// RenameConfig is the rename detection the first of the config keys
+// set asks for, true, false or copies, renames being found by default,
+// within diff.renameLimit, where 0 or less is no limit.
+func (r Repository) RenameConfig(keys ...string) RenameOptions {
+	opts := RenameOptions{Renames: true}
+	config, err := r.Config()
+	if err != nil {
+		return opts
+	}
+	if limit, err := config.GetInt("diff.renameLimit", DefaultRenameLimit); err == nil {
+		opts.Limit = limit
+		if limit <= 0 {
+			opts.Limit = -1
+		}
+	}
+	for _, key := range keys {
+		v, ok := config.Get(key)
+		if !ok {
+			continue
+		}
+		if v == "copies" || v == "copy" {
+			opts.Copies = true
+			return opts
+		}
+		opts.Renames, _ = config.GetBool(key, true)
+		return opts
+	}
+	return opts
+}
+
+// checkIndexEntryStat records the entry as changed when its stat data
+// is enough to tell, it returns false when the content has to be
+// compared.
+func checkIndexEntryStat(
+	changes map[string]ChangeType,
+	stats map[string]os.FileInfo,
+	entry *IndexEntry,
+	name string,
+) bool {
+	// Check's if the file size has changed or something
+	// noticable.
+	stat := stats[name]
+	if stat == nil {
+		changes[entry.Path] = Deleted
+		return true
+	}
+
+	// Only the path of an intent-to-add entry is known, the content
+	// was never added.
+	if entry.IntentToAdd() {
+		changes[entry.Path] = Added
+		return true
+	}
+
+	// stat data of a directory does not follow the commit checked
+	// out in it, which is cheap to read anyway
+	if entry.Mode == gitlinkMode && stat.IsDir() {
+		return false
+	}
+
+	if !entry.StatMatch(stat) {
+		changes[entry.Path] = Modified
+		return true
+	}
+
+	// Check with the time stamp
+	return entry.TimeMatch(stat)
+}
+
+// workspaceOID returns the oid the workspace file of entry would have
+// in the index. A submodule that is not checked out is taken as
+// unchanged, like git does.
+func workspaceOID(ctx context.Context, path string, entry IndexEntry) (string, error) {
+	if entry.Mode != gitlinkMode {
+		return hashFile(ctx, path)
+	}
+	oid, err := submoduleHead(path)
+	if errors.Is(err, ErrNotARepository) || errors.Is(err, ErrNoSubmoduleHead) {
+		return entry.Oid, nil
+	}
+	return oid, err
+}
+
+func hashFile(ctx context.Context, path string) (string, error) {
+	r, size, _, err := openBlob(path)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	return HashStreamContext(ctx, TypeFile, size, r)
+}
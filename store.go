@@ -0,0 +1,275 @@
+package gitgo
+
+import (
+	"bufio"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+var (
+	ErrObjectNotFound = errors.New("object not found")
+	ErrInvalidOID     = errors.New("invalid object id")
+)
+
+// ObjectStore is the storage backend behind a Database. Objects are
+// handed over in their raw form, "<type> <size>\0<content>", and are
+// keyed by their hex encoded oid. Compression, if any, is up to the
+// store.
+type ObjectStore interface {
+	Has(oid string) (bool, error)
+	Read(oid string) ([]byte, error)
+	Write(oid string, data []byte) error
+	Iterate(fn func(oid string) error) error
+}
+
+// StreamStore is implemented by stores that can take in and hand out
+// objects without holding them in memory as a whole. The streams carry
+// the raw object, header included.
+type StreamStore interface {
+	ObjectStore
+	WriteStream(r io.Reader) (string, error)
+	Open(oid string) (io.ReadCloser, error)
+}
+
+func validOID(oid string) error {
+	if len(oid) != 40 {
+		return fmt.Errorf("%w: %q", ErrInvalidOID, oid)
+	}
+	if _, err := hex.DecodeString(oid); err != nil {
+		return fmt.Errorf("%w: %q", ErrInvalidOID, oid)
+	}
+	return nil
+}
+
+// LooseStore keeps every object zlib compressed in its own file
+// under `objects/<oid[:2]>/<oid[2:]>`, the way git does.
+type LooseStore struct {
+	path string
+}
+
+func NewLooseStore(path string) *LooseStore {
+	return &LooseStore{path: path}
+}
+
+func (s *LooseStore) objectPath(oid string) string {
+	return filepath.Join(s.path, oid[:2], oid[2:])
+}
+
+func (s *LooseStore) Has(oid string) (bool, error) {
+	if err := validOID(oid); err != nil {
+		return false, err
+	}
+	_, err := os.Stat(s.objectPath(oid))
+	if err == nil {
+		return true, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+func (s *LooseStore) Read(oid string) ([]byte, error) {
+	if err := validOID(oid); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(s.objectPath(oid))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, oid)
+		}
+		return nil, err
+	}
+	return Inflate(data)
+}
+
+func (s *LooseStore) Write(oid string, data []byte) error {
+	ok, err := s.Has(oid)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return nil
+	}
+
+	compressed, err := Compress(data)
+	if err != nil {
+		return err
+	}
+	return StoreObject(compressed, filepath.Join(s.path, oid[:2]), s.objectPath(oid))
+}
+
+// WriteStream hashes and compresses the object in a single pass into a
+// temp file, which is then moved into place under its oid.
+func (s *LooseStore) WriteStream(r io.Reader) (string, error) {
+	if err := os.MkdirAll(s.path, 0755); err != nil {
+		return "", err
+	}
+	tf, err := os.CreateTemp(s.path, "tmp_obj_")
+	if err != nil {
+		return "", fmt.Errorf("creating temp file: %s", err)
+	}
+	tempPath := tf.Name()
+	defer os.Remove(tempPath)
+	defer tf.Close()
+	if err := tf.Chmod(0644); err != nil {
+		return "", err
+	}
+
+	bw := bufio.NewWriter(tf)
+	zw := zlib.NewWriter(bw)
+	h := sha1.New()
+	if _, err := io.Copy(io.MultiWriter(h, zw), r); err != nil {
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", fmt.Errorf("closing zlib writer: %s", err)
+	}
+	if err := bw.Flush(); err != nil {
+		return "", fmt.Errorf("writing to temp file: %s", err)
+	}
+	if err := tf.Close(); err != nil {
+		return "", fmt.Errorf("closing temp file: %s", err)
+	}
+
+	oid := hex.EncodeToString(h.Sum(nil))
+	ok, err := s.Has(oid)
+	if err != nil || ok {
+		return oid, err
+	}
+	if err := os.MkdirAll(filepath.Join(s.path, oid[:2]), 0755); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tempPath, s.objectPath(oid)); err != nil {
+		return "", fmt.Errorf("renaming temp file: %s", err)
+	}
+	return oid, nil
+}
+
+func (s *LooseStore) Open(oid string) (io.ReadCloser, error) {
+	if err := validOID(oid); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(s.objectPath(oid))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, oid)
+		}
+		return nil, err
+	}
+	zr, err := zlib.NewReader(bufio.NewReader(f))
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%w: %s", ErrCorruptObject, err)
+	}
+	return &zlibFile{ReadCloser: zr, file: f}, nil
+}
+
+type zlibFile struct {
+	io.ReadCloser
+	file *os.File
+}
+
+func (z *zlibFile) Close() error {
+	z.ReadCloser.Close()
+	return z.file.Close()
+}
+
+func (s *LooseStore) Iterate(fn func(oid string) error) error {
+	dirs, err := os.ReadDir(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, dir := range dirs {
+		if !dir.IsDir() || len(dir.Name()) != 2 {
+			continue
+		}
+		files, err := os.ReadDir(filepath.Join(s.path, dir.Name()))
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			oid := dir.Name() + file.Name()
+			if validOID(oid) != nil {
+				continue
+			}
+			if err := fn(oid); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// MemoryStore keeps objects in a map, it is meant for tests and for
+// embedding gitgo where nothing should touch the disk.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	objects map[string][]byte
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{objects: make(map[string][]byte)}
+}
+
+func (s *MemoryStore) Has(oid string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.objects[oid]
+	return ok, nil
+}
+
+func (s *MemoryStore) Read(oid string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, ok := s.objects[oid]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, oid)
+	}
+	return slices.Clone(data), nil
+}
+
+func (s *MemoryStore) Write(oid string, data []byte) error {
+	if err := validOID(oid); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.objects[oid]; !ok {
+		s.objects[oid] = slices.Clone(data)
+	}
+	return nil
+}
+
+func (s *MemoryStore) Iterate(fn func(oid string) error) error {
+	s.mu.RLock()
+	oids := make([]string, 0, len(s.objects))
+	for oid := range s.objects {
+		oids = append(oids, oid)
+	}
+	s.mu.RUnlock()
+
+	slices.Sort(oids)
+	for _, oid := range oids {
+		if err := fn(oid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package gitgo
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testStores(t *testing.T) map[string]ObjectStore {
+	return map[string]ObjectStore{
+		"loose":  NewLooseStore(t.TempDir()),
+		"memory": NewMemoryStore(),
+	}
+}
+
+func TestObjectStoreRoundTrip(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			db := NewDatabaseWithStore(store)
+			db.Data(TypeFile, []byte("hello\n"))
+			oid, err := db.Store()
+			assert.NoError(t, err)
+			assert.Equal(t, "ce013625030ba8dba906f756967f9e9ca394464a", oid)
+
+			ok, err := store.Has(oid)
+			assert.NoError(t, err)
+			assert.True(t, ok)
+
+			typ, data, err := db.ReadObject(oid)
+			assert.NoError(t, err)
+			assert.Equal(t, TypeFile, typ)
+			assert.Equal(t, "hello\n", string(data))
+
+			// storing the same object twice is not an error
+			_, err = db.Store()
+			assert.NoError(t, err)
+
+			var oids []string
+			err = store.Iterate(func(oid string) error {
+				oids = append(oids, oid)
+				return nil
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, []string{oid}, oids)
+		})
+	}
+}
+
+func TestObjectStoreMissing(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			oid := randomOID()
+			ok, err := store.Has(oid)
+			assert.NoError(t, err)
+			assert.False(t, ok)
+
+			_, err = store.Read(oid)
+			assert.True(t, errors.Is(err, ErrObjectNotFound))
+		})
+	}
+}
+
+func TestStoreStream(t *testing.T) {
+	content := strings.Repeat("streaming content\n", 4096)
+
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			db := NewDatabaseWithStore(store)
+			oid, err := db.StoreStream(TypeFile, int64(len(content)), strings.NewReader(content))
+			assert.NoError(t, err)
+
+			db.Data(TypeFile, []byte(content))
+			assert.Equal(t, HashHex(db.BlobData), oid)
+
+			hashed, err := HashStream(TypeFile, int64(len(content)), strings.NewReader(content))
+			assert.NoError(t, err)
+			assert.Equal(t, oid, hashed)
+
+			obj, err := db.OpenObject(oid)
+			assert.NoError(t, err)
+			defer obj.Close()
+			assert.Equal(t, TypeFile, obj.Type)
+			assert.Equal(t, int64(len(content)), obj.Size)
+			data, err := io.ReadAll(obj)
+			assert.NoError(t, err)
+			assert.Equal(t, content, string(data))
+		})
+	}
+}
+
+func TestStoreStreamSizeMismatch(t *testing.T) {
+	db := NewDatabaseWithStore(NewLooseStore(t.TempDir()))
+
+	_, err := db.StoreStream(TypeFile, 10, strings.NewReader("short"))
+	assert.ErrorIs(t, err, ErrSizeMismatch)
+
+	_, err = db.StoreStream(TypeFile, 2, strings.NewReader("too long"))
+	assert.ErrorIs(t, err, ErrSizeMismatch)
+}
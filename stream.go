@@ -0,0 +1,180 @@
+package gitgo
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var ErrSizeMismatch = errors.New("content size changed while reading")
+
+// maxHeaderSize is more than enough for "commit <19 digits>\0".
+const maxHeaderSize = 32
+
+// StoreStream stores an object of the given size read from r, without
+// ever holding the whole content in memory when the store supports
+// streaming. The header is written from size, so r must yield exactly
+// size bytes.
+func (d *Database) StoreStream(blobType BlobType, size int64, r io.Reader) (string, error) {
+	return d.StoreStreamContext(context.Background(), blobType, size, r)
+}
+
+// StoreStreamContext is StoreStream that gives up reading r once ctx
+// is done, leaving nothing behind in the store.
+func (d *Database) StoreStreamContext(ctx context.Context, blobType BlobType, size int64, r io.Reader) (string, error) {
+	src := objectStream(blobType, size, &contextReader{ctx: ctx, r: r})
+
+	if s, ok := d.store.(StreamStore); ok {
+		return s.WriteStream(src)
+	}
+
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return "", err
+	}
+	oid := HashHex(data)
+	return oid, d.store.Write(oid, data)
+}
+
+// HashStream computes the oid of an object of the given size read from
+// r without storing it.
+func HashStream(blobType BlobType, size int64, r io.Reader) (string, error) {
+	return HashStreamContext(context.Background(), blobType, size, r)
+}
+
+func HashStreamContext(ctx context.Context, blobType BlobType, size int64, r io.Reader) (string, error) {
+	h := sha1.New()
+	if _, err := io.Copy(h, objectStream(blobType, size, &contextReader{ctx: ctx, r: r})); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func objectStream(blobType BlobType, size int64, r io.Reader) io.Reader {
+	header := append(GetPrefix(blobType, int(size)), 0)
+	return io.MultiReader(bytes.NewReader(header), &exactReader{r: r, remaining: size})
+}
+
+// exactReader yields exactly `remaining` bytes from r and fails if r
+// turns out to be shorter or longer than that.
+type exactReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (e *exactReader) Read(p []byte) (int, error) {
+	if e.remaining <= 0 {
+		var probe [1]byte
+		n, err := e.r.Read(probe[:])
+		if n > 0 {
+			return 0, ErrSizeMismatch
+		}
+		if err == nil || err == io.EOF {
+			return 0, io.EOF
+		}
+		return 0, err
+	}
+
+	if int64(len(p)) > e.remaining {
+		p = p[:e.remaining]
+	}
+	n, err := e.r.Read(p)
+	e.remaining -= int64(n)
+	if err == io.EOF && e.remaining > 0 {
+		return n, ErrSizeMismatch
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+// ObjectReader streams the content of a stored object.
+type ObjectReader struct {
+	Type   BlobType
+	Size   int64
+	r      io.Reader
+	closer io.Closer
+}
+
+func (o *ObjectReader) Read(p []byte) (int, error) { return o.r.Read(p) }
+
+func (o *ObjectReader) Close() error {
+	if o.closer == nil {
+		return nil
+	}
+	return o.closer.Close()
+}
+
+// OpenObject returns a reader over the content of the object, with the
+// header already parsed. The caller has to close it.
+func (d *Database) OpenObject(oid string) (*ObjectReader, error) {
+	s, ok := d.store.(StreamStore)
+	if !ok {
+		typ, data, err := d.ReadObject(oid)
+		if err != nil {
+			return nil, err
+		}
+		return &ObjectReader{
+			Type: typ,
+			Size: int64(len(data)),
+			r:    bytes.NewReader(data),
+		}, nil
+	}
+
+	rc, err := s.Open(oid)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(rc)
+	header, err := readObjectHeader(br)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	typ, size, err := parseObjectHeader(header)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return &ObjectReader{
+		Type:   typ,
+		Size:   size,
+		r:      &exactReader{r: br, remaining: size},
+		closer: rc,
+	}, nil
+}
+
+func readObjectHeader(br *bufio.Reader) (string, error) {
+	var header []byte
+	for len(header) < maxHeaderSize {
+		b, err := br.ReadByte()
+		if err != nil {
+			return "", fmt.Errorf("%w: reading header: %s", ErrCorruptObject, err)
+		}
+		if b == 0 {
+			return string(header), nil
+		}
+		header = append(header, b)
+	}
+	return "", fmt.Errorf("%w: header too long", ErrCorruptObject)
+}
+
+// contextReader fails reads once ctx is done, so that copying a large
+// file stops promptly on cancellation.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
@@ -0,0 +1,267 @@
+package gitgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrNoSubmoduleHead = errors.New("submodule has no commit checked out")
+
+// nestedGitDir returns the repository directory of the workspace at
+// path: its .gitgo or .git directory, or where a "gitdir: <dir>" .git
+// file points to.
+func nestedGitDir(path string) (string, bool) {
+	for _, name := range []string{GitgoDir, GitDir} {
+		gitDir := filepath.Join(path, name)
+		stat, err := os.Stat(gitDir)
+		if err != nil {
+			continue
+		}
+		if stat.IsDir() {
+			return gitDir, true
+		}
+		data, err := os.ReadFile(gitDir)
+		if err != nil {
+			continue
+		}
+		target, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir: ")
+		if !ok {
+			continue
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(path, target)
+		}
+		return target, true
+	}
+	return "", false
+}
+
+func isNestedRepository(path string) bool {
+	_, ok := nestedGitDir(path)
+	return ok
+}
+
+// submoduleHead returns the commit checked out in the nested
+// repository at path.
+func submoduleHead(path string) (string, error) {
+	gitDir, ok := nestedGitDir(path)
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrNotARepository, path)
+	}
+	oid, err := resolveHead(gitDir)
+	if err != nil {
+		return "", err
+	}
+	if oid == "" {
+		return "", fmt.Errorf("%w: %s", ErrNoSubmoduleHead, path)
+	}
+	return oid, nil
+}
+
+// gitlinkOID returns the oid a gitlink entry for path records, ok is
+// false when path is not a directory.
+func gitlinkOID(path string) (oid string, stat os.FileInfo, ok bool, err error) {
+	stat, err = os.Lstat(path)
+	if err != nil || !stat.IsDir() {
+		return "", nil, false, nil
+	}
+	oid, err = submoduleHead(path)
+	return oid, stat, true, err
+}
+
+type Submodule struct {
+	Name string
+	Path string
+	URL  string
+}
+
+// Submodules lists the submodules declared in .gitmodules, in file
+// order. A missing file declares none.
+func (r Repository) Submodules() ([]Submodule, error) {
+	config, err := LoadConfig(filepath.Join(r.Path, ".gitmodules"))
+	if err != nil {
+		return nil, err
+	}
+	var subs []Submodule
+	for _, name := range config.Subsections("submodule") {
+		path, _ := config.Get("submodule." + name + ".path")
+		url, _ := config.Get("submodule." + name + ".url")
+		if path == "" {
+			return nil, fmt.Errorf(".gitmodules: submodule %q has no path", name)
+		}
+		subs = append(subs, Submodule{Name: name, Path: path, URL: url})
+	}
+	return subs, nil
+}
+
+// SubmoduleInit copies the URL of the submodules not registered yet
+// from .gitmodules to the repository config, relative URLs being
+// resolved against the workspace. It returns the submodules it
+// registered.
+func (r Repository) SubmoduleInit() ([]Submodule, error) {
+	subs, err := r.Submodules()
+	if err != nil {
+		return nil, err
+	}
+	config, err := r.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	var registered []Submodule
+	for _, sub := range subs {
+		key := "submodule." + sub.Name + ".url"
+		if _, ok := config.Get(key); ok {
+			continue
+		}
+		if sub.URL == "" {
+			return nil, fmt.Errorf("no url found for submodule path '%s' in .gitmodules", sub.Path)
+		}
+		sub.URL = r.resolveURL(sub.URL)
+		if err := config.Set(key, sub.URL); err != nil {
+			return nil, err
+		}
+		registered = append(registered, sub)
+	}
+	if len(registered) == 0 {
+		return nil, nil
+	}
+	return registered, config.Save()
+}
+
+func (r Repository) resolveURL(url string) string {
+	url = strings.TrimPrefix(url, "file://")
+	if filepath.IsAbs(url) {
+		return url
+	}
+	return filepath.Join(r.Path, url)
+}
+
+// SubmoduleUpdate checks out, in every registered submodule, the
+// commit its gitlink records, cloning the submodules not there yet
+// from their local URL.
+func (r Repository) SubmoduleUpdate(ctx context.Context) ([]SubmoduleStatus, error) {
+	statuses, err := r.SubmoduleStatus()
+	if err != nil {
+		return nil, err
+	}
+	config, err := r.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	var updated []SubmoduleStatus
+	for _, st := range statuses {
+		url, ok := config.Get("submodule." + st.Name + ".url")
+		if !ok || st.OID == "" || st.HEAD == st.OID {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := r.updateSubmodule(ctx, st, url); err != nil {
+			return nil, fmt.Errorf("submodule path '%s': %w", st.Path, err)
+		}
+		st.HEAD = st.OID
+		updated = append(updated, st)
+	}
+	return updated, nil
+}
+
+func (r Repository) updateSubmodule(ctx context.Context, st SubmoduleStatus, url string) error {
+	srcGitDir, ok := nestedGitDir(url)
+	if !ok {
+		if _, err := os.Stat(filepath.Join(url, "objects")); err != nil {
+			return fmt.Errorf("%w: %s", ErrNotARepository, url)
+		}
+		srcGitDir = url // a bare repository
+	}
+
+	dest := filepath.Join(r.Path, st.Path)
+	var sub *Repository
+	if gitDir, ok := nestedGitDir(dest); ok {
+		if filepath.Dir(gitDir) != dest {
+			return fmt.Errorf("submodules kept outside of their directory are not supported, found %s", gitDir)
+		}
+		sub = new(Repository)
+		*sub = NewRepositoryWithGitDir(dest, filepath.Base(gitDir))
+	} else {
+		var err error
+		if sub, err = Init(dest); err != nil {
+			return err
+		}
+	}
+	sub.Workers, sub.Progress = r.Workers, r.Progress
+
+	src := NewLooseStore(filepath.Join(srcGitDir, "objects"))
+	if err := copyObjects(ctx, sub.ObjectDatabase().ObjectStore(), src); err != nil {
+		return err
+	}
+	return sub.CheckoutCommit(ctx, st.OID)
+}
+
+// copyObjects copies to dst every object of src it does not have.
+func copyObjects(ctx context.Context, dst, src ObjectStore) error {
+	return src.Iterate(func(oid string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if ok, err := dst.Has(oid); err != nil || ok {
+			return err
+		}
+		data, err := src.Read(oid)
+		if err != nil {
+			return err
+		}
+		return dst.Write(oid, data)
+	})
+}
+
+// SubmoduleStatus is a submodule with the commit its gitlink records,
+// OID, and the commit checked out in it, HEAD. Either is empty when
+// unknown.
+type SubmoduleStatus struct {
+	Submodule
+	OID  string
+	HEAD string
+}
+
+// State is the character `submodule status` prefixes the submodule
+// with: '-' when not checked out, '+' when another commit than the
+// recorded one is, and ' ' otherwise.
+func (s SubmoduleStatus) State() rune {
+	switch {
+	case s.HEAD == "":
+		return '-'
+	case s.HEAD != s.OID:
+		return '+'
+	}
+	return ' '
+}
+
+func (r Repository) SubmoduleStatus() ([]SubmoduleStatus, error) {
+	subs, err := r.Submodules()
+	if err != nil {
+		return nil, err
+	}
+	index := NewIndex(r.Path, r.GitPath)
+	if err := index.Load(); err != nil {
+		return nil, err
+	}
+	entries := index.IndexEntries()
+
+	statuses := make([]SubmoduleStatus, 0, len(subs))
+	for _, sub := range subs {
+		st := SubmoduleStatus{Submodule: sub}
+		if entry, ok := entries[filepath.Clean(sub.Path)]; ok && entry.Mode == gitlinkMode {
+			st.OID = entry.Oid
+		}
+		st.HEAD, _ = submoduleHead(filepath.Join(r.Path, sub.Path))
+		statuses = append(statuses, st)
+	}
+	return statuses, nil
+}
@@ -0,0 +1,106 @@
+package gitgo
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func commitFile(t *testing.T, repo *Repository, name, content string) string {
+	assert.NoError(t, os.WriteFile(filepath.Join(repo.Path, name), []byte(content), 0644))
+	_, err := repo.Add(name)
+	assert.NoError(t, err)
+	res, err := repo.Commit(CommitOptions{Message: content + "\n"})
+	assert.NoError(t, err)
+	return res.OID
+}
+
+func TestSubmodules(t *testing.T) {
+	tmp := t.TempDir()
+	ctx := context.Background()
+
+	src, err := Init(filepath.Join(tmp, "src"))
+	assert.NoError(t, err)
+	first := commitFile(t, src, "lib.txt", "v1")
+
+	super, err := Init(filepath.Join(tmp, "super"))
+	assert.NoError(t, err)
+	lib, err := Init(filepath.Join(super.Path, "lib"))
+	assert.NoError(t, err)
+	assert.NoError(t, copyObjects(ctx, lib.ObjectDatabase().ObjectStore(), src.ObjectDatabase().ObjectStore()))
+	assert.NoError(t, lib.CheckoutCommit(ctx, first))
+
+	gitmodules := "[submodule \"lib\"]\n\tpath = lib\n\turl = ../src\n"
+	assert.NoError(t, os.WriteFile(filepath.Join(super.Path, ".gitmodules"), []byte(gitmodules), 0644))
+
+	// the nested repository becomes a single gitlink entry
+	added, err := super.Add(".")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{".gitmodules", "lib"}, added)
+
+	res, err := super.Commit(CommitOptions{Message: "add lib\n"})
+	assert.NoError(t, err)
+	tree, err := super.ObjectDatabase().ReadTree(res.Tree)
+	assert.NoError(t, err)
+	assert.Contains(t, tree, TreeEntry{Name: "lib", Mode: gitlinkMode, OID: first})
+
+	status, err := super.Status()
+	assert.NoError(t, err)
+	assert.Empty(t, status.Changed)
+	assert.Empty(t, status.Untracked)
+
+	// moving the submodule to another commit modifies the gitlink
+	second := commitFile(t, src, "lib.txt", "v2")
+	assert.NoError(t, copyObjects(ctx, lib.ObjectDatabase().ObjectStore(), src.ObjectDatabase().ObjectStore()))
+	assert.NoError(t, lib.CheckoutCommit(ctx, second))
+
+	status, err = super.Status()
+	assert.NoError(t, err)
+	assert.Equal(t, []StatusEntry{{Path: "lib", Workspace: Modified}}, status.Changed)
+	statuses, err := super.SubmoduleStatus()
+	assert.NoError(t, err)
+	assert.Len(t, statuses, 1)
+	assert.Equal(t, '+', statuses[0].State())
+
+	// a fresh submodule directory gets cloned from the local url
+	assert.NoError(t, os.RemoveAll(lib.Path))
+	assert.NoError(t, os.Mkdir(lib.Path, 0755))
+	statuses, err = super.SubmoduleStatus()
+	assert.NoError(t, err)
+	assert.Equal(t, '-', statuses[0].State())
+
+	registered, err := super.SubmoduleInit()
+	assert.NoError(t, err)
+	assert.Equal(t, []Submodule{{Name: "lib", Path: "lib", URL: src.Path}}, registered)
+
+	updated, err := super.SubmoduleUpdate(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, updated, 1)
+	data, err := os.ReadFile(filepath.Join(lib.Path, "lib.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", string(data))
+
+	statuses, err = super.SubmoduleStatus()
+	assert.NoError(t, err)
+	assert.Equal(t, ' ', statuses[0].State())
+	assert.Equal(t, first, statuses[0].HEAD)
+}
+
+func TestResolveSymbolicHead(t *testing.T) {
+	gitDir := t.TempDir()
+	oid := "1111111111111111111111111111111111111111"
+	assert.NoError(t, os.WriteFile(filepath.Join(gitDir, "HEAD"), []byte("ref: refs/heads/main\n"), 0644))
+
+	head, err := resolveHead(gitDir)
+	assert.NoError(t, err)
+	assert.Empty(t, head)
+
+	packed := "# pack-refs with: peeled\n" + oid + " refs/heads/main\n"
+	assert.NoError(t, os.WriteFile(filepath.Join(gitDir, "packed-refs"), []byte(packed), 0644))
+	head, err = resolveHead(gitDir)
+	assert.NoError(t, err)
+	assert.Equal(t, oid, head)
+}
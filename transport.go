@@ -0,0 +1,591 @@
+package gitgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrNonFastForward    = errors.New("non-fast-forward")
+	ErrCurrentBranch     = errors.New("branch is currently checked out")
+	ErrNoBranch          = errors.New("not currently on a branch")
+	ErrDestinationExists = errors.New("destination path already exists and is not an empty directory")
+	ErrMissingObjects    = errors.New("remote did not send all necessary objects")
+)
+
+// RefUpdate is a ref moved by a fetch or a push. Old is empty for a
+// new ref and equal to New for one already up to date.
+type RefUpdate struct {
+	// Src is the ref read on the sending side, Dst the one updated on
+	// the receiving side.
+	Src    string
+	Dst    string
+	Old    string
+	New    string
+	Forced bool
+	// Err is why the update was refused, nil when it was made.
+	Err error
+}
+
+func (u RefUpdate) UpToDate() bool { return u.Old == u.New }
+
+type TransferResult struct {
+	URL     string
+	Updates []RefUpdate
+	// Objects is the number of objects copied.
+	Objects int
+}
+
+// advertisement is what a fetch source has to offer.
+type advertisement struct {
+	refs map[string]string
+	// head is the ref HEAD points to, empty when it is detached, and
+	// headOID the commit it resolves to, empty in an empty repository.
+	head    string
+	headOID string
+}
+
+// source is a repository a fetch reads from, on disk or behind a
+// server.
+type source interface {
+	advertise(ctx context.Context) (*advertisement, error)
+	// fetch stores in db the objects req asks for.
+	fetch(ctx context.Context, db *Database, req fetchRequest, progress Progress) (*fetchResponse, error)
+}
+
+// localSource reads straight from a repository on disk.
+type localSource struct {
+	repo *Repository
+}
+
+func (s localSource) advertise(ctx context.Context) (*advertisement, error) {
+	refs := RefInitialize(s.repo.Refs)
+	all, err := refs.ListRefs("refs/")
+	if err != nil {
+		return nil, err
+	}
+	head, err := refs.HeadRef()
+	if err != nil {
+		return nil, err
+	}
+	return &advertisement{refs: all, head: head, headOID: refs.ReadHead()}, nil
+}
+
+func (s localSource) fetch(ctx context.Context, db *Database, req fetchRequest, progress Progress) (*fetchResponse, error) {
+	plan, err := planFetch(s.repo.ObjectDatabase(), req)
+	if err != nil {
+		return nil, err
+	}
+	n, err := sendCommits(ctx, plan.db, db, plan.commits, progress, "Receiving objects")
+	if err != nil {
+		return nil, err
+	}
+	return &fetchResponse{objects: n, shallow: plan.shallow, unshallow: plan.unshallow}, nil
+}
+
+// openSource connects to the repository at url, an http(s) URL, a
+// file:// URL or a path, which can be that of a bundle file.
+func (r Repository) openSource(ctx context.Context, url string) (source, error) {
+	if isHTTPURL(url) {
+		return newHTTPSource(ctx, url, r.RemoteProgress)
+	}
+	if b, err := OpenBundle(r.resolveURL(url)); err == nil {
+		return bundleSource{bundle: b}, nil
+	}
+	repo, err := r.openRemote(url)
+	if err != nil {
+		return nil, err
+	}
+	return localSource{repo: repo}, nil
+}
+
+// FetchOptions pick how much history Fetch gets.
+type FetchOptions struct {
+	// Depth, when set, limits the history fetched to that many commits
+	// from the tip of every branch.
+	Depth int
+	// Deepen fetches that many more commits below the shallow commits
+	// of a shallow repository.
+	Deepen int
+	// Unshallow fetches the whole history of a shallow repository.
+	Unshallow bool
+}
+
+// Fetch copies from the remote name the objects of the refs its fetch
+// refspecs match, then updates the remote-tracking refs they map to.
+// A ref that is not a fast-forward is only updated by a forced
+// refspec.
+func (r Repository) Fetch(ctx context.Context, name string, opts FetchOptions) (*TransferResult, error) {
+	remote, err := r.Remote(name)
+	if err != nil {
+		return nil, err
+	}
+	src, err := r.openSource(ctx, remote.URL)
+	if err != nil {
+		return nil, err
+	}
+	adv, err := src.advertise(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.fetch(ctx, remote, src, adv, opts)
+}
+
+func (r Repository) fetch(ctx context.Context, remote *Remote, src source, adv *advertisement, opts FetchOptions) (*TransferResult, error) {
+	req := fetchRequest{depth: opts.Depth}
+	var err error
+	if req.shallow, err = r.Shallow(); err != nil {
+		return nil, err
+	}
+	switch {
+	case opts.Unshallow && len(req.shallow) == 0:
+		return nil, errors.New("--unshallow on a complete repository does not make sense")
+	case opts.Unshallow:
+		req.depth = infiniteDepth
+	case opts.Deepen > 0:
+		req.depth, req.relative = opts.Deepen, true
+	}
+
+	result := &TransferResult{URL: remote.URL}
+	refs := RefInitialize(r.Refs)
+	var specs []refspec
+	for _, s := range remote.Fetch {
+		specs = append(specs, parseRefspec(s))
+	}
+	database := r.ObjectDatabase()
+	for _, srcRef := range slices.Sorted(maps.Keys(adv.refs)) {
+		for _, spec := range specs {
+			dst, ok := spec.match(srcRef)
+			if !ok {
+				continue
+			}
+			old, err := refs.ReadRef(dst)
+			if err != nil {
+				return nil, err
+			}
+			oid := adv.refs[srcRef]
+			result.Updates = append(result.Updates, RefUpdate{
+				Src: srcRef, Dst: dst, Old: old, New: oid, Forced: spec.force,
+			})
+			// changing the depth takes every ref, even those we have
+			if has, err := database.Has(oid); err != nil {
+				return nil, err
+			} else if (!has || req.depth > 0) && !slices.Contains(req.wants, oid) {
+				req.wants = append(req.wants, oid)
+			}
+			break
+		}
+	}
+
+	if len(req.wants) > 0 {
+		if req.haves, err = r.haves(); err != nil {
+			return nil, err
+		}
+		resp, err := src.fetch(ctx, database, req, r.Progress)
+		if err != nil {
+			return nil, err
+		}
+		result.Objects = resp.objects
+		if err := r.updateShallow(resp.shallow, resp.unshallow); err != nil {
+			return nil, err
+		}
+		database = r.ObjectDatabase()
+
+		// what the remote sent is only trusted once every ref it
+		// moves has its history in the database
+		for _, oid := range req.wants {
+			if !connected(database, oid, req.haves) {
+				return nil, fmt.Errorf("%w: %s", ErrMissingObjects, oid)
+			}
+		}
+	}
+
+	for i := range result.Updates {
+		u := &result.Updates[i]
+		if u.UpToDate() {
+			continue
+		}
+		force := u.Forced
+		u.Forced = false
+		if u.Old != "" {
+			ff, err := isAncestor(database, u.Old, u.New)
+			if err != nil {
+				return nil, err
+			}
+			if !ff && !force {
+				u.Err = ErrNonFastForward
+				continue
+			}
+			u.Forced = !ff
+		}
+		if err := refs.UpdateRef(u.Dst, u.New); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// PushOptions pick what Push sends.
+type PushOptions struct {
+	// Branch is the branch pushed to the branch of the same name, the
+	// current branch when empty.
+	Branch string
+	// Force updates the remote branch even when that loses commits.
+	Force bool
+}
+
+// Push sends a branch and the objects it needs to the remote name. The
+// remote branch is only moved forward unless forced, and the branch
+// checked out in a remote with a workspace is left alone unless its
+// receive.denyCurrentBranch config is "ignore" or "warn". A refused
+// update is reported in the result and as an error.
+func (r Repository) Push(ctx context.Context, name string, opts PushOptions) (*TransferResult, error) {
+	remote, err := r.Remote(name)
+	if err != nil {
+		return nil, err
+	}
+	refs := RefInitialize(r.Refs)
+	branch := "refs/heads/" + opts.Branch
+	if opts.Branch == "" {
+		if branch, err = refs.HeadRef(); err != nil {
+			return nil, err
+		}
+		if branch == "" {
+			return nil, ErrNoBranch
+		}
+	}
+	oid, err := refs.ReadRef(branch)
+	if err != nil {
+		return nil, err
+	}
+	if oid == "" {
+		return nil, fmt.Errorf("%w: src refspec %s does not match any", ErrInvalidRef, ShortRefName(branch))
+	}
+
+	if isHTTPURL(remote.URL) {
+		return nil, fmt.Errorf("%w: pushing to %s", errors.ErrUnsupported, remote.URL)
+	}
+	dst, err := r.openRemote(remote.URL)
+	if err != nil {
+		return nil, err
+	}
+	dstRefs := RefInitialize(dst.Refs)
+	old, err := dstRefs.ReadRef(branch)
+	if err != nil {
+		return nil, err
+	}
+	update := RefUpdate{Src: branch, Dst: branch, Old: old, New: oid}
+	result := &TransferResult{URL: remote.URL, Updates: []RefUpdate{update}}
+	if update.UpToDate() {
+		return result, nil
+	}
+
+	database := r.ObjectDatabase()
+	if old != "" {
+		ff, err := isAncestor(database, old, oid)
+		if err != nil {
+			return nil, err
+		}
+		update.Forced = !ff
+	}
+	switch {
+	case update.Forced && !opts.Force:
+		update.Err = ErrNonFastForward
+	case !dst.bare():
+		update.Err = denyCurrentBranch(dst, branch)
+	}
+	if update.Err != nil {
+		result.Updates[0] = update
+		return result, fmt.Errorf("failed to push some refs to '%s': %w", remote.URL, update.Err)
+	}
+
+	dstAll, err := dstRefs.ListRefs("refs/")
+	if err != nil {
+		return nil, err
+	}
+	result.Objects, err = transferObjects(ctx, database, dst.ObjectDatabase(), []string{oid}, slices.Collect(maps.Values(dstAll)), r.Progress, "Writing objects")
+	if err != nil {
+		return nil, err
+	}
+	if err := dstRefs.UpdateRef(branch, oid); err != nil {
+		return nil, err
+	}
+	result.Updates[0] = update
+
+	// the remote-tracking ref now knows where the branch is
+	for _, s := range remote.Fetch {
+		if tracking, ok := parseRefspec(s).match(branch); ok {
+			if err := refs.UpdateRef(tracking, oid); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	return result, nil
+}
+
+// denyCurrentBranch refuses to move the branch checked out in repo,
+// which would leave its index and workspace out of date.
+func denyCurrentBranch(repo *Repository, branch string) error {
+	head, err := RefInitialize(repo.Refs).HeadRef()
+	if err != nil || head != branch {
+		return err
+	}
+	config, err := repo.Config()
+	if err != nil {
+		return err
+	}
+	switch v, _ := config.Get("receive.denyCurrentBranch"); strings.ToLower(v) {
+	case "ignore", "warn", "false", "no", "off":
+		return nil
+	}
+	return ErrCurrentBranch
+}
+
+// CloneOptions pick what Clone copies.
+type CloneOptions struct {
+	// Depth, when set, makes a shallow clone of that many commits from
+	// the tip of every branch.
+	Depth int
+}
+
+// Clone creates the repository r at its path, which must not exist or
+// be empty, with url as remote DefaultRemote, fetches it and checks
+// out its current branch.
+func (r Repository) Clone(ctx context.Context, url string, opts CloneOptions) (*TransferResult, error) {
+	if !isHTTPURL(url) {
+		abs, err := filepath.Abs(strings.TrimPrefix(url, "file://"))
+		if err != nil {
+			return nil, err
+		}
+		url = abs
+	}
+	src, err := r.openSource(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	adv, err := src.advertise(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if entries, err := os.ReadDir(r.Path); err == nil && len(entries) > 0 {
+		return nil, fmt.Errorf("%w: '%s'", ErrDestinationExists, r.Path)
+	}
+
+	repo, err := InitWithGitDir(r.Path, filepath.Base(r.GitPath))
+	if err != nil {
+		return nil, err
+	}
+	repo.Store, repo.Workers, repo.Progress, repo.RemoteProgress = r.Store, r.Workers, r.Progress, r.RemoteProgress
+	remote, err := repo.AddRemote(DefaultRemote, url)
+	if err != nil {
+		return nil, err
+	}
+	result, err := repo.fetch(ctx, remote, src, adv, FetchOptions{Depth: opts.Depth})
+	if err != nil {
+		return nil, err
+	}
+
+	refs := RefInitialize(repo.Refs)
+	head := adv.head
+	if head == "" {
+		// a detached remote HEAD is cloned detached
+		if adv.headOID != "" {
+			return result, repo.CheckoutCommit(ctx, adv.headOID)
+		}
+		head = "refs/heads/" + DefaultBranch
+	}
+	if err := refs.SetHeadRef(head); err != nil {
+		return nil, err
+	}
+	oid, err := refs.ReadRef("refs/remotes/" + DefaultRemote + "/" + ShortRefName(head))
+	if err != nil || oid == "" {
+		// an empty repository
+		return result, err
+	}
+	if err := refs.UpdateRef(head, oid); err != nil {
+		return nil, err
+	}
+	config, err := repo.Config()
+	if err != nil {
+		return nil, err
+	}
+	config.Set("branch."+ShortRefName(head)+".remote", DefaultRemote)
+	config.Set("branch."+ShortRefName(head)+".merge", head)
+	if err := config.Save(); err != nil {
+		return nil, err
+	}
+	return result, repo.checkoutTree(ctx, oid)
+}
+
+// haves are the commits the refs and HEAD of r point to, all of which
+// r has with their history.
+func (r Repository) haves() ([]string, error) {
+	refs := RefInitialize(r.Refs)
+	all, err := refs.ListRefs("refs/")
+	if err != nil {
+		return nil, err
+	}
+	haves := slices.Collect(maps.Values(all))
+	if head := refs.ReadHead(); head != "" {
+		haves = append(haves, head)
+	}
+	return haves, nil
+}
+
+// transferObjects copies from src to dst the objects reachable from
+// wants but not from haves, the commits at the tip of what dst has.
+func transferObjects(ctx context.Context, src, dst *Database, wants, haves []string, progress Progress, op string) (int, error) {
+	commits, err := negotiate(src, wants, haves)
+	if err != nil {
+		return 0, err
+	}
+	return sendCommits(ctx, src, dst, commits, progress, op)
+}
+
+// sendCommits copies from src to dst the commits and what they hold
+// that dst does not have.
+func sendCommits(ctx context.Context, src, dst *Database, commits []string, progress Progress, op string) (int, error) {
+	oids, err := objectsToSend(src, commits, dst.Has)
+	if err != nil {
+		return 0, err
+	}
+	counter := newProgressCounter(progress, op, len(oids))
+	for _, oid := range oids {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		data, err := src.ObjectStore().Read(oid)
+		if err != nil {
+			return 0, err
+		}
+		if err := dst.ObjectStore().Write(oid, data); err != nil {
+			return 0, err
+		}
+		counter.add()
+	}
+	return len(oids), nil
+}
+
+// negotiate returns the commits of db reachable from wants and not
+// from haves, which the other side has along with their history. Haves
+// db does not know are of no use and skipped.
+func negotiate(db *Database, wants, haves []string) ([]string, error) {
+	common := make(map[string]bool)
+	var known []string
+	for _, oid := range haves {
+		if ok, err := db.Has(oid); err != nil {
+			return nil, err
+		} else if ok {
+			known = append(known, oid)
+		}
+	}
+	if err := walkCommits(db, known, common, nil); err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	err := walkCommits(db, wants, common, func(oid string, _ *Commit) {
+		missing = append(missing, oid)
+	})
+	return missing, err
+}
+
+// walkCommits calls fn for the commits reachable from start that are
+// not in seen, marking them as seen.
+func walkCommits(db *Database, start []string, seen map[string]bool, fn func(oid string, c *Commit)) error {
+	queue := slices.Clone(start)
+	for len(queue) > 0 {
+		oid := queue[0]
+		queue = queue[1:]
+		if seen[oid] {
+			continue
+		}
+		seen[oid] = true
+		commit, err := db.ReadCommit(oid)
+		if err != nil {
+			return err
+		}
+		if fn != nil {
+			fn(oid, commit)
+		}
+		queue = append(queue, commit.Parents...)
+	}
+	return nil
+}
+
+// objectsToSend lists the commits and the trees and blobs they hold
+// that the other side does not have, as told by has. A tree it has
+// comes with everything in it.
+func objectsToSend(src *Database, commits []string, has func(oid string) (bool, error)) ([]string, error) {
+	seen := make(map[string]bool)
+	var oids []string
+	var addTree func(oid string) error
+	addTree = func(oid string) error {
+		if seen[oid] {
+			return nil
+		}
+		seen[oid] = true
+		if ok, err := has(oid); err != nil || ok {
+			return err
+		}
+		oids = append(oids, oid)
+		entries, err := src.ReadTree(oid)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			switch {
+			case e.Mode == gitlinkMode:
+				// the commit is in the submodule, not here
+			case e.IsTree():
+				if err := addTree(e.OID); err != nil {
+					return err
+				}
+			case !seen[e.OID]:
+				seen[e.OID] = true
+				if ok, err := has(e.OID); err != nil {
+					return err
+				} else if !ok {
+					oids = append(oids, e.OID)
+				}
+			}
+		}
+		return nil
+	}
+
+	for _, oid := range commits {
+		if ok, err := has(oid); err != nil {
+			return nil, err
+		} else if !ok {
+			oids = append(oids, oid)
+		}
+		commit, err := src.ReadCommit(oid)
+		if err != nil {
+			return nil, err
+		}
+		if err := addTree(commit.Tree); err != nil {
+			return nil, err
+		}
+	}
+	return oids, nil
+}
+
+// isAncestor tells if the commit ancestor is in the history of
+// descendant, which a fast-forward from one to the other needs.
+func isAncestor(db *Database, ancestor, descendant string) (bool, error) {
+	if ok, err := db.Has(ancestor); err != nil || !ok {
+		return false, err
+	}
+	history := make(map[string]bool)
+	if err := walkCommits(db, []string{descendant}, history, nil); err != nil {
+		return false, err
+	}
+	return history[ancestor], nil
+}
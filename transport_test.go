@@ -0,0 +1,209 @@
+package gitgo
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemoteConfig(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	assert.NoError(t, err)
+
+	_, err = repo.AddRemote("origin", "../upstream")
+	assert.NoError(t, err)
+	_, err = repo.AddRemote("origin", "../other")
+	assert.ErrorIs(t, err, ErrRemoteExists)
+	_, err = repo.AddRemote("bad name", "../other")
+	assert.Error(t, err)
+	_, err = repo.AddRemote("fork", "file:///srv/fork")
+	assert.NoError(t, err)
+
+	remotes, err := repo.Remotes()
+	assert.NoError(t, err)
+	assert.Equal(t, []Remote{
+		{Name: "origin", URL: "../upstream", Fetch: []string{"+refs/heads/*:refs/remotes/origin/*"}},
+		{Name: "fork", URL: "file:///srv/fork", Fetch: []string{"+refs/heads/*:refs/remotes/fork/*"}},
+	}, remotes)
+
+	refs := RefInitialize(repo.Refs)
+	oid := "ce013625030ba8dba906f756967f9e9ca394464a"
+	assert.NoError(t, refs.UpdateRef("refs/remotes/origin/master", oid))
+	assert.NoError(t, refs.UpdateRef("refs/remotes/fork/master", oid))
+	assert.NoError(t, repo.RemoveRemote("origin"))
+	assert.ErrorIs(t, repo.RemoveRemote("origin"), ErrNoSuchRemote)
+
+	left, err := refs.ListRefs("refs/remotes/")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"refs/remotes/fork/master": oid}, left)
+	remotes, err = repo.Remotes()
+	assert.NoError(t, err)
+	assert.Len(t, remotes, 1)
+}
+
+func TestRefspec(t *testing.T) {
+	spec := parseRefspec("+refs/heads/*:refs/remotes/origin/*")
+	assert.True(t, spec.force)
+	dst, ok := spec.match("refs/heads/topic/a")
+	assert.True(t, ok)
+	assert.Equal(t, "refs/remotes/origin/topic/a", dst)
+	_, ok = spec.match("refs/tags/v1")
+	assert.False(t, ok)
+	src, ok := spec.reverse("refs/remotes/origin/master")
+	assert.True(t, ok)
+	assert.Equal(t, "refs/heads/master", src)
+
+	spec = parseRefspec("refs/heads/master:refs/remotes/origin/main")
+	dst, ok = spec.match("refs/heads/master")
+	assert.True(t, ok)
+	assert.Equal(t, "refs/remotes/origin/main", dst)
+}
+
+func TestCloneFetchPush(t *testing.T) {
+	tmp := t.TempDir()
+	ctx := context.Background()
+
+	upstream, err := Init(filepath.Join(tmp, "upstream"))
+	assert.NoError(t, err)
+	assert.NoError(t, os.MkdirAll(filepath.Join(upstream.Path, "dir"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(upstream.Path, "dir", "a.txt"), []byte("a"), 0644))
+	_, err = upstream.Add("dir")
+	assert.NoError(t, err)
+	first := commitFile(t, upstream, "README", "one")
+
+	clone := NewRepository(filepath.Join(tmp, "clone"))
+	res, err := clone.Clone(ctx, "file://"+upstream.Path, CloneOptions{})
+	assert.NoError(t, err)
+	// the commit, two trees and two blobs
+	assert.Equal(t, 5, res.Objects)
+	assert.Equal(t, []RefUpdate{{
+		Src: "refs/heads/master", Dst: "refs/remotes/origin/master", New: first,
+	}}, res.Updates)
+
+	refs := RefInitialize(clone.Refs)
+	head, err := refs.HeadRef()
+	assert.NoError(t, err)
+	assert.Equal(t, "refs/heads/master", head)
+	assert.Equal(t, first, refs.ReadHead())
+	data, err := os.ReadFile(filepath.Join(clone.Path, "dir", "a.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "a", string(data))
+	status, err := clone.Status()
+	assert.NoError(t, err)
+	assert.Empty(t, status.Changed)
+	assert.Empty(t, status.Untracked)
+	config, err := clone.Config()
+	assert.NoError(t, err)
+	v, _ := config.Get("branch.master.remote")
+	assert.Equal(t, "origin", v)
+
+	_, err = clone.Clone(ctx, upstream.Path, CloneOptions{})
+	assert.ErrorIs(t, err, ErrDestinationExists)
+
+	// only what upstream does not have yet is sent
+	second := commitFile(t, &clone, "README", "two")
+	_, err = clone.Push(ctx, "origin", PushOptions{})
+	assert.ErrorIs(t, err, ErrCurrentBranch)
+	upConfig, err := upstream.Config()
+	assert.NoError(t, err)
+	upConfig.Set("receive.denyCurrentBranch", "ignore")
+	assert.NoError(t, upConfig.Save())
+	res, err = clone.Push(ctx, "origin", PushOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, res.Objects)
+	assert.Equal(t, second, RefInitialize(upstream.Refs).ReadHead())
+	tracking, err := refs.ReadRef("refs/remotes/origin/master")
+	assert.NoError(t, err)
+	assert.Equal(t, second, tracking)
+
+	res, err = clone.Push(ctx, "origin", PushOptions{})
+	assert.NoError(t, err)
+	assert.True(t, res.Updates[0].UpToDate())
+
+	// upstream moves on, then the clone rewrites history
+	assert.NoError(t, upstream.checkoutTree(ctx, second))
+	third := commitFile(t, upstream, "README", "three")
+	assert.NoError(t, refs.UpdateRef("refs/heads/master", first))
+	assert.NoError(t, clone.checkoutTree(ctx, first))
+	rewritten := commitFile(t, &clone, "other", "x")
+
+	res, err = clone.Push(ctx, "origin", PushOptions{})
+	assert.ErrorIs(t, err, ErrNonFastForward)
+	assert.ErrorIs(t, res.Updates[0].Err, ErrNonFastForward)
+	assert.Equal(t, third, RefInitialize(upstream.Refs).ReadHead())
+
+	res, err = clone.Fetch(ctx, "origin", FetchOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []RefUpdate{{
+		Src: "refs/heads/master", Dst: "refs/remotes/origin/master", Old: second, New: third,
+	}}, res.Updates)
+
+	res, err = clone.Push(ctx, "origin", PushOptions{Force: true})
+	assert.NoError(t, err)
+	assert.True(t, res.Updates[0].Forced)
+	assert.Equal(t, rewritten, RefInitialize(upstream.Refs).ReadHead())
+
+	res, err = clone.Fetch(ctx, "origin", FetchOptions{})
+	assert.NoError(t, err)
+	assert.True(t, res.Updates[0].UpToDate())
+	assert.Equal(t, 0, res.Objects)
+}
+
+func TestCloneEmptyRepository(t *testing.T) {
+	tmp := t.TempDir()
+	upstream, err := Init(filepath.Join(tmp, "upstream"))
+	assert.NoError(t, err)
+
+	clone := NewRepository(filepath.Join(tmp, "clone"))
+	res, err := clone.Clone(context.Background(), upstream.Path, CloneOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, res.Updates)
+	head, err := RefInitialize(clone.Refs).HeadRef()
+	assert.NoError(t, err)
+	assert.Equal(t, "refs/heads/master", head)
+
+	_, err = NewRepository(filepath.Join(tmp, "none")).Clone(context.Background(), filepath.Join(tmp, "missing"), CloneOptions{})
+	assert.ErrorIs(t, err, ErrNotARepository)
+}
+
+// partialSource sends the commits asked for without their trees.
+type partialSource struct {
+	localSource
+}
+
+func (s partialSource) fetch(ctx context.Context, db *Database, req fetchRequest, progress Progress) (*fetchResponse, error) {
+	for _, oid := range req.wants {
+		typ, data, err := s.repo.ObjectDatabase().ReadObject(oid)
+		if err != nil {
+			return nil, err
+		}
+		db.Data(typ, data)
+		if _, err := db.Store(); err != nil {
+			return nil, err
+		}
+	}
+	return &fetchResponse{objects: len(req.wants)}, nil
+}
+
+func TestFetchChecksConnectivity(t *testing.T) {
+	tmp := t.TempDir()
+	ctx := context.Background()
+	upstream, err := Init(filepath.Join(tmp, "upstream"))
+	assert.NoError(t, err)
+	commitFile(t, upstream, "a.txt", "a")
+
+	repo, err := Init(filepath.Join(tmp, "repo"))
+	assert.NoError(t, err)
+	remote := &Remote{Name: "origin", URL: upstream.Path, Fetch: []string{"+refs/heads/*:refs/remotes/origin/*"}}
+	src := partialSource{localSource{repo: upstream}}
+	adv, err := src.advertise(ctx)
+	assert.NoError(t, err)
+	_, err = repo.fetch(ctx, remote, src, adv, FetchOptions{})
+	assert.ErrorIs(t, err, ErrMissingObjects)
+	tracking, err := RefInitialize(repo.Refs).ReadRef("refs/remotes/origin/master")
+	assert.NoError(t, err)
+	assert.Empty(t, tracking)
+}
@@ -2,6 +2,7 @@ package gitgo
 
 import (
 	"bytes"
+	"context"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -10,6 +11,10 @@ import (
 	"strings"
 )
 
+// mode of a directory entry inside a tree object, git writes it
+// without a leading zero
+const dirMode = "40000"
+
 type Node any
 
 type Tree struct {
@@ -53,21 +58,27 @@ func BuildTree(entries []Entries) *Tree {
 }
 
 func TraverseTree(database *Database, tree *Tree, dbPath string) ([]Entries, error) {
+	return TraverseTreeContext(context.Background(), database, tree, dbPath)
+}
+
+// TraverseTreeContext is TraverseTree that stops storing trees once ctx
+// is done.
+func TraverseTreeContext(ctx context.Context, database *Database, tree *Tree, dbPath string) ([]Entries, error) {
 	entry := []Entries{}
 	for name, node := range tree.Nodes {
 		switch n := node.(type) {
 		case *Tree:
-			e, err := TraverseTree(database, n, dbPath)
+			e, err := TraverseTreeContext(ctx, database, n, dbPath)
 			if err != nil {
 				return nil, err
 			}
 
 			database.Data(TypeTree, CreateTreeEntry(e))
-			hash, err := database.Store()
+			hash, err := database.StoreContext(ctx)
 			if err != nil {
 				return nil, err
 			}
-			ne := Entries{Path: name, OID: hash, Stat: "040000"}
+			ne := Entries{Path: name, OID: hash, Stat: dirMode}
 			entry = append(entry, ne)
 		case *Entries:
 			entry = append(entry, *n)
@@ -80,8 +91,15 @@ func TraverseTree(database *Database, tree *Tree, dbPath string) ([]Entries, err
 
 func CreateTreeEntry(entries []Entries) []byte {
 	var buf bytes.Buffer
+	// git compares directories as if their name ended with a slash
+	sortKey := func(e Entries) string {
+		if e.Stat == dirMode {
+			return e.Path + "/"
+		}
+		return e.Path
+	}
 	sort.Slice(entries, func(i, j int) bool {
-		return entries[i].Path < entries[j].Path
+		return sortKey(entries[i]) < sortKey(entries[j])
 	})
 	for _, entry := range entries {
 		input := fmt.Sprintf("%s %s", entry.Stat, entry.Path)
@@ -33,13 +33,13 @@ func generateGitTempFileName(prefix string) string {
 func getUTCOffset(t time.Time) string {
 	_, offset := t.Zone()
 
-	offsetHour := offset / 3600
-	offsetMin := (offset % 3600) / 60
-
 	sign := "+"
 	if offset < 0 {
 		sign = "-"
+		offset = -offset
 	}
+	offsetHour := offset / 3600
+	offsetMin := (offset % 3600) / 60
 
 	return fmt.Sprintf("%s%02d%02d", sign, offsetHour, offsetMin)
 }
@@ -0,0 +1,37 @@
+package gitgo
+
+import (
+	"io"
+	"os"
+	"strings"
+)
+
+// openBlob opens the content a blob of the workspace file at path
+// holds: the target of a symbolic link, which is never followed, or
+// the content of a regular file. The returned stat is the one of the
+// link itself for symbolic links.
+func openBlob(path string) (io.ReadCloser, int64, os.FileInfo, error) {
+	stat, err := os.Lstat(path)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	if stat.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		return io.NopCloser(strings.NewReader(target)), int64(len(target)), stat, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	// stat the open file, the path may have been replaced since
+	stat, err = f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, nil, err
+	}
+	return f, stat.Size(), stat, nil
+}